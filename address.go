@@ -0,0 +1,47 @@
+package gosender
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+)
+
+// AddressList is a list of email addresses. In JSON it is either an array of
+// addresses or a single string of comma-separated addresses.
+type AddressList []string
+
+// UnmarshalJSON accepts an array of addresses or a comma-separated string.
+// A string is parsed as an RFC 5322 address list, so display names may
+// themselves contain quoted commas.
+func (l *AddressList) UnmarshalJSON(data []byte) error {
+	var list []string
+	if err := json.Unmarshal(data, &list); err == nil {
+		*l = nil
+		for _, address := range list {
+			*l = append(*l, strings.TrimSpace(address))
+		}
+		return nil
+	}
+
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return errors.New("address list must be a string or an array of strings")
+	}
+	if s = strings.TrimSpace(s); s == "" {
+		*l = nil
+		return nil
+	}
+
+	addresses, err := mail.ParseAddressList(s)
+	if err != nil {
+		return fmt.Errorf("invalid address list %q: %v", s, err)
+	}
+	*l = make(AddressList, 0, len(addresses))
+	for _, address := range addresses {
+		*l = append(*l, address.String())
+	}
+
+	return nil
+}
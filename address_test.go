@@ -0,0 +1,76 @@
+package gosender
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+func TestAddressListJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		json    string
+		want    AddressList
+		wantErr bool
+	}{
+		{name: "array", json: `["a@example.com", "B <b@example.com>"]`, want: AddressList{"a@example.com", "B <b@example.com>"}},
+		{name: "array with whitespace", json: `[" a@example.com ", "\tb@example.com\n"]`, want: AddressList{"a@example.com", "b@example.com"}},
+		{name: "comma-separated", json: `"a@example.com,b@example.com"`, want: AddressList{"<a@example.com>", "<b@example.com>"}},
+		{name: "comma-separated with whitespace", json: `"  a@example.com ,\t B <b@example.com>  "`, want: AddressList{"<a@example.com>", `"B" <b@example.com>`}},
+		{name: "quoted comma in a name", json: `"\"Doe, Jane\" <jane@example.com>, c@example.com"`, want: AddressList{`"Doe, Jane" <jane@example.com>`, "<c@example.com>"}},
+		{name: "single address", json: `"a@example.com"`, want: AddressList{"<a@example.com>"}},
+		{name: "empty string", json: `"  "`, want: nil},
+		{name: "malformed string", json: `"a@example.com,,b"`, wantErr: true},
+		{name: "number", json: `42`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got AddressList
+			err := json.Unmarshal([]byte(tt.json), &got)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Unmarshal(%s) error = %v; want an error: %v", tt.json, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") || (got == nil) != (tt.want == nil) {
+				t.Errorf("Unmarshal(%s) = %q; want %q", tt.json, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAddressListSend(t *testing.T) {
+	tests := []struct {
+		name string
+		to   any
+	}{
+		{name: "array", to: []string{"a@example.com", "B <b@example.com>"}},
+		{name: "comma-separated", to: " a@example.com , B <b@example.com> "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newGmailStub(t)
+			h := stub.newServer().Handler()
+			payload := stub.payload(t, map[string]any{"to": tt.to, "cc": "c@example.com", "subject": "Hello", "messageBody": "Hi"})
+
+			rec := postPayload(h, "/send", payload, nil)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("send = %d %s", rec.Code, rec.Body)
+			}
+			msg, err := mail.ReadMessage(strings.NewReader(stub.sent[0]))
+			if err != nil {
+				t.Fatalf("failed to parse sent message: %v", err)
+			}
+			to, err := msg.Header.AddressList("To")
+			if err != nil || len(to) != 2 || to[0].Address != "a@example.com" || to[1].Address != "b@example.com" || to[1].Name != "B" {
+				t.Errorf("To = %q, %v; want a@example.com and B <b@example.com>", msg.Header.Get("To"), err)
+			}
+			if cc, err := msg.Header.AddressList("Cc"); err != nil || len(cc) != 1 || cc[0].Address != "c@example.com" {
+				t.Errorf("Cc = %q, %v; want c@example.com", msg.Header.Get("Cc"), err)
+			}
+		})
+	}
+}
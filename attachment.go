@@ -0,0 +1,288 @@
+package gosender
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultAttachmentFetchTimeout bounds how long fetching a single URL
+	// attachment may take when Config.AttachmentFetchTimeout is unset.
+	defaultAttachmentFetchTimeout = 10 * time.Second
+
+	// defaultAttachmentMaxBytes bounds the size of a single URL attachment when
+	// Config.AttachmentMaxBytes is unset.
+	defaultAttachmentMaxBytes = 10 << 20
+
+	// sniffLen is how much of its content an attachment's type is sniffed from.
+	sniffLen = 512
+)
+
+// Attachment represents a file attached to a structured message.
+// Exactly one of Data (base64-encoded content) or URL must be set.
+type Attachment struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"contentType"`
+	Data        string `json:"data"`
+	URL         string `json:"url"`
+
+	// content is that of a URL attachment, once fetched. Inline attachments
+	// keep theirs in Data, and are only decoded as their part is written.
+	content []byte
+}
+
+// loadAttachments checks inline attachments and fetches URL attachments,
+// leaving the content ready for buildMessage.
+func loadAttachments(ctx context.Context, config *Config, attachments []Attachment) error {
+	for i := range attachments {
+		a := &attachments[i]
+		var head []byte
+		switch {
+		case a.Data != "" && a.URL != "":
+			return fmt.Errorf("attachment %d: data and url are mutually exclusive", i)
+		case a.URL != "":
+			if err := fetchAttachment(ctx, config, a); err != nil {
+				return fmt.Errorf("attachment %d: %v", i, err)
+			}
+			head = a.content
+		default:
+			var err error
+			if head, err = checkAttachmentData(a.Data); err != nil {
+				return fmt.Errorf("attachment %d: failed to decode data: %v", i, err)
+			}
+		}
+
+		if a.ContentType == "" {
+			a.ContentType = detectContentType(a.Filename, head)
+		}
+		if err := checkAttachmentType(config, a); err != nil {
+			return fmt.Errorf("attachment %d: %v", i, err)
+		}
+	}
+
+	return nil
+}
+
+// checkAttachmentData checks that data is valid base64 by decoding it as a
+// stream, so that the decoded content is never held as a whole, and returns
+// its first sniffLen bytes.
+func checkAttachmentData(data string) ([]byte, error) {
+	decoder := base64.NewDecoder(base64.StdEncoding, strings.NewReader(data))
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(decoder, head)
+	switch {
+	case err == io.EOF || err == io.ErrUnexpectedEOF:
+		return head[:n], nil
+	case err != nil:
+		return nil, err
+	}
+	if _, err := io.Copy(io.Discard, decoder); err != nil {
+		return nil, err
+	}
+
+	return head, nil
+}
+
+// detectContentType sniffs the content type of an attachment from its first 512
+// bytes. When sniffing is inconclusive the filename extension is consulted, and
+// application/octet-stream is the final fallback.
+func detectContentType(filename string, content []byte) string {
+	sniffed := http.DetectContentType(content)
+	mediaType, _, _ := mime.ParseMediaType(sniffed)
+	switch mediaType {
+	case "application/octet-stream", "text/plain", "application/zip":
+		// Generic results: many text formats sniff as text/plain and office
+		// documents as zip archives, so a known extension is more precise.
+		if byExtension := mime.TypeByExtension(filepath.Ext(filename)); byExtension != "" {
+			return byExtension
+		}
+	}
+
+	return sniffed
+}
+
+// fetchAttachment downloads the content of a URL attachment. Only the schemes and
+// hosts allowed by the configuration are fetched, including across redirects, to
+// guard against server-side request forgery. The download is bounded by the
+// configured timeout, redirect count and size, and stops as soon as a limit is hit.
+func fetchAttachment(ctx context.Context, config *Config, a *Attachment) error {
+	u, err := attachmentURL(config, a.URL)
+	if err != nil {
+		return err
+	}
+
+	timeout := config.AttachmentFetchTimeout
+	if timeout <= 0 {
+		timeout = defaultAttachmentFetchTimeout
+	}
+	maxBytes := config.AttachmentMaxBytes
+	if maxBytes <= 0 {
+		maxBytes = defaultAttachmentMaxBytes
+	}
+
+	client := &http.Client{
+		Timeout: timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > config.AttachmentMaxRedirects {
+				return fmt.Errorf("stopped after %d redirects", config.AttachmentMaxRedirects)
+			}
+			return checkAttachmentURL(config, req.URL)
+		},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fetchError("failed to fetch url", timeout, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to fetch url: unexpected status %s", resp.Status)
+	}
+	if resp.ContentLength > int64(maxBytes) {
+		return fmt.Errorf("url content exceeds %d bytes", maxBytes)
+	}
+
+	content, err := io.ReadAll(io.LimitReader(resp.Body, int64(maxBytes)+1))
+	if err != nil {
+		return fetchError("failed to read url", timeout, err)
+	}
+	if len(content) > maxBytes {
+		return fmt.Errorf("url content exceeds %d bytes", maxBytes)
+	}
+	a.content = content
+
+	if a.Filename == "" {
+		a.Filename = path.Base(u.Path)
+	}
+	if a.ContentType == "" {
+		if mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type")); err == nil {
+			a.ContentType = mediaType
+		}
+	}
+
+	return nil
+}
+
+// fetchError describes a failed attachment fetch, naming the timeout when that
+// is what stopped it.
+func fetchError(action string, timeout time.Duration, err error) error {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return fmt.Errorf("%s: timed out after %s", action, timeout)
+	}
+	return fmt.Errorf("%s: %v", action, err)
+}
+
+// attachmentURL parses raw, rewriting gs://bucket/object references to their
+// Cloud Storage HTTPS endpoint, and checks the result against the allowlist.
+func attachmentURL(config *Config, raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid url: %v", err)
+	}
+
+	if u.Scheme == "gs" {
+		u = &url.URL{
+			Scheme: "https",
+			Host:   "storage.googleapis.com",
+			Path:   "/" + u.Host + u.Path,
+		}
+	}
+
+	if err := checkAttachmentURL(config, u); err != nil {
+		return nil, err
+	}
+
+	return u, nil
+}
+
+// checkAttachmentURL reports an error unless the URL's scheme and host are allowlisted.
+func checkAttachmentURL(config *Config, u *url.URL) error {
+	if !containsFold(config.AttachmentURLSchemes, u.Scheme) {
+		return fmt.Errorf("url scheme %q is not allowed", u.Scheme)
+	}
+	if !containsFold(config.AttachmentURLHosts, u.Hostname()) {
+		return fmt.Errorf("url host %q is not allowed", u.Hostname())
+	}
+
+	return nil
+}
+
+// checkAttachmentType reports an error unless the attachment's content type
+// matches one of the configured allowed types, which may end in a "/*"
+// wildcard. Every type is allowed when none are configured.
+func checkAttachmentType(config *Config, a *Attachment) error {
+	if len(config.AllowedAttachmentTypes) == 0 {
+		return nil
+	}
+
+	mediaType, _, err := mime.ParseMediaType(a.ContentType)
+	if err != nil {
+		return fmt.Errorf("invalid content type %q for attachment %q: %v", a.ContentType, a.Filename, err)
+	}
+
+	for _, allowed := range config.AllowedAttachmentTypes {
+		if prefix, ok := strings.CutSuffix(allowed, "/*"); ok {
+			if major, _, _ := strings.Cut(mediaType, "/"); strings.EqualFold(major, prefix) {
+				return nil
+			}
+		} else if allowed == "*" || strings.EqualFold(allowed, mediaType) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("content type %q of attachment %q is not allowed", mediaType, a.Filename)
+}
+
+// containsFold reports whether list contains s, ignoring case.
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// attachmentPart renders an attachment as a base64-encoded MIME part. The data
+// of inline attachments is decoded and encoded again as the part is written,
+// into lines of the length MIME requires.
+func attachmentPart(a *Attachment) (mimePart, error) {
+	if a.Filename == "" {
+		return mimePart{}, errors.New("attachment filename is required")
+	}
+
+	mediaType, params, err := mime.ParseMediaType(a.ContentType)
+	if err != nil {
+		return mimePart{}, fmt.Errorf("invalid content type %q for attachment %q: %v", a.ContentType, a.Filename, err)
+	}
+	params["name"] = a.Filename
+	contentType := mime.FormatMediaType(mediaType, params)
+
+	return mimePart{
+		headers: []headerField{
+			{"Content-Type", contentType},
+			{"Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": a.Filename})},
+			{"Content-Transfer-Encoding", "base64"},
+		},
+		base64:  a.content,
+		encoded: a.Data,
+	}, nil
+}
@@ -0,0 +1,215 @@
+package gosender
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestURLAttachments(t *testing.T) {
+	content := "attached from a URL"
+	files := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/report.txt":
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte(content))
+		case "/redirect":
+			http.Redirect(w, r, "http://evil.example.com/report.txt", http.StatusFound)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer files.Close()
+
+	tests := []struct {
+		name       string
+		url        string
+		maxBytes   int
+		wantStatus int
+	}{
+		{name: "allowed host", url: files.URL + "/report.txt", wantStatus: http.StatusOK},
+		{name: "disallowed host", url: strings.Replace(files.URL, "127.0.0.1", "localhost", 1) + "/report.txt", wantStatus: http.StatusBadRequest},
+		{name: "disallowed scheme", url: strings.Replace(files.URL, "http:", "ftp:", 1) + "/report.txt", wantStatus: http.StatusBadRequest},
+		{name: "redirect to a disallowed host", url: files.URL + "/redirect", wantStatus: http.StatusBadRequest},
+		{name: "too large", url: files.URL + "/report.txt", maxBytes: 4, wantStatus: http.StatusBadRequest},
+		{name: "fetch failure", url: files.URL + "/missing.txt", wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newGmailStub(t)
+			h := stub.newServer(func(c *Config) {
+				c.AttachmentURLSchemes = []string{"http"}
+				c.AttachmentURLHosts = []string{"127.0.0.1"}
+				c.AttachmentMaxBytes = tt.maxBytes
+				c.AttachmentMaxRedirects = 1
+			}).Handler()
+			payload := stub.payload(t, map[string]any{
+				"to": "to@example.com", "subject": "Hello", "messageBody": "Hi",
+				"attachments": []map[string]any{{"url": tt.url}},
+			})
+
+			rec := postPayload(h, "/send", payload, nil)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("send = %d %s; want %d", rec.Code, rec.Body, tt.wantStatus)
+			}
+			sent, _, _ := stub.counts()
+			if tt.wantStatus != http.StatusOK {
+				if sent != 0 {
+					t.Errorf("sent %d messages; want none", sent)
+				}
+				return
+			}
+			if sent != 1 {
+				t.Fatalf("sent %d messages; want 1", sent)
+			}
+			raw := stub.sent[0]
+			if !strings.Contains(raw, base64.StdEncoding.EncodeToString([]byte(content))) {
+				t.Errorf("sent message does not carry the fetched content:\n%s", raw)
+			}
+			if !strings.Contains(raw, `filename=report.txt`) || !strings.Contains(raw, "Content-Type: text/plain") {
+				t.Errorf("sent message does not name the fetched file:\n%s", raw)
+			}
+		})
+	}
+}
+
+func TestDetectContentType(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR\x00\x00\x00\x01\x00\x00\x00\x01\x08\x06\x00\x00\x00")
+	pdf := []byte("%PDF-1.7\n1 0 obj\n<< /Type /Catalog >>\nendobj\n")
+	binary := []byte{0x00, 0x01, 0x02, 0x03, 0xfe, 0xff, 0x10, 0x7f}
+	tests := []struct {
+		name     string
+		filename string
+		content  []byte
+		want     string
+	}{
+		{name: "PNG", filename: "image", content: png, want: "image/png"},
+		{name: "PNG named otherwise", filename: "image.pdf", content: png, want: "image/png"},
+		{name: "PDF", filename: "document", content: pdf, want: "application/pdf"},
+		{name: "unknown binary", filename: "blob", content: binary, want: "application/octet-stream"},
+		{name: "unknown binary with a known extension", filename: "module.wasm", content: binary, want: "application/wasm"},
+		{name: "text with a known extension", filename: "data.json", content: []byte(`{"a": 1}`), want: "application/json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := detectContentType(tt.filename, tt.content); got != tt.want {
+				t.Errorf("detectContentType(%q) = %q; want %q", tt.filename, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestURLAttachmentLimits(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	files := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/slow":
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+		case "/loop":
+			http.Redirect(w, r, "/loop", http.StatusFound)
+		case "/large":
+			// Streamed without a Content-Length, so the limit is only hit
+			// while reading.
+			chunk := []byte(strings.Repeat("x", 1024))
+			for i := 0; i < 64; i++ {
+				w.Write(chunk)
+				w.(http.Flusher).Flush()
+			}
+		case "/declared-large":
+			w.Header().Set("Content-Length", "65536")
+			w.Write([]byte(strings.Repeat("x", 65536)))
+		}
+	}))
+	defer files.Close()
+
+	tests := []struct {
+		name    string
+		path    string
+		wantErr string
+	}{
+		{name: "slow server", path: "/slow", wantErr: "timed out after 50ms"},
+		{name: "redirect loop", path: "/loop", wantErr: "stopped after 3 redirects"},
+		{name: "oversized download", path: "/large", wantErr: "exceeds 4096 bytes"},
+		{name: "declared oversized download", path: "/declared-large", wantErr: "exceeds 4096 bytes"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newGmailStub(t)
+			h := stub.newServer(func(c *Config) {
+				c.AttachmentURLSchemes = []string{"http"}
+				c.AttachmentURLHosts = []string{"127.0.0.1"}
+				c.AttachmentFetchTimeout = 50 * time.Millisecond
+				c.AttachmentMaxRedirects = 3
+				c.AttachmentMaxBytes = 4096
+			}).Handler()
+			payload := stub.payload(t, map[string]any{
+				"to": "to@example.com", "subject": "Hello", "messageBody": "Hi",
+				"attachments": []map[string]any{{"url": files.URL + tt.path}},
+			})
+
+			rec := postPayload(h, "/send", payload, nil)
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("send = %d %s; want 400", rec.Code, rec.Body)
+			}
+			var response ErrorResponse
+			decodeJSON(t, rec, &response)
+			if !strings.Contains(response.Error, tt.wantErr) {
+				t.Errorf("error = %q; want it to contain %q", response.Error, tt.wantErr)
+			}
+			if sent, _, _ := stub.counts(); sent != 0 {
+				t.Errorf("sent %d messages; want none", sent)
+			}
+		})
+	}
+}
+
+func TestAllowedAttachmentTypes(t *testing.T) {
+	png := base64.StdEncoding.EncodeToString([]byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"))
+	data := base64.StdEncoding.EncodeToString([]byte("MZ\x90\x00\x03\x00\x00\x00"))
+	tests := []struct {
+		name       string
+		allowed    []string
+		attachment map[string]any
+		wantStatus int
+	}{
+		{name: "allowed image", allowed: []string{"image/png"}, attachment: map[string]any{"filename": "logo.png", "data": png}, wantStatus: http.StatusOK},
+		{name: "sniffed image by wildcard", allowed: []string{"image/*"}, attachment: map[string]any{"filename": "logo", "data": png}, wantStatus: http.StatusOK},
+		{name: "declared image by wildcard", allowed: []string{"application/pdf", "IMAGE/*"}, attachment: map[string]any{"filename": "photo.jpg", "contentType": "image/jpeg", "data": data}, wantStatus: http.StatusOK},
+		{name: "blocked executable", allowed: []string{"image/*", "application/pdf"}, attachment: map[string]any{"filename": "setup.exe", "contentType": "application/x-msdownload", "data": data}, wantStatus: http.StatusBadRequest},
+		{name: "blocked sniffed type", allowed: []string{"application/pdf"}, attachment: map[string]any{"filename": "logo", "data": png}, wantStatus: http.StatusBadRequest},
+		{name: "nothing configured", attachment: map[string]any{"filename": "setup.exe", "contentType": "application/x-msdownload", "data": data}, wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newGmailStub(t)
+			h := stub.newServer(func(c *Config) { c.AllowedAttachmentTypes = tt.allowed }).Handler()
+			payload := stub.payload(t, map[string]any{
+				"to": "to@example.com", "subject": "Hello", "messageBody": "Hi",
+				"attachments": []map[string]any{tt.attachment},
+			})
+
+			rec := postPayload(h, "/send", payload, nil)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("send = %d %s; want %d", rec.Code, rec.Body, tt.wantStatus)
+			}
+			if tt.wantStatus == http.StatusOK {
+				return
+			}
+			var response ErrorResponse
+			decodeJSON(t, rec, &response)
+			if filename := tt.attachment["filename"].(string); !strings.Contains(response.Error, filename) {
+				t.Errorf("error = %q; want it to name %s", response.Error, filename)
+			}
+			if sent, _, _ := stub.counts(); sent != 0 {
+				t.Errorf("sent %d messages; want none", sent)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package gosender
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// batchIDHeader carries the ID of a batch, under which it can be canceled.
+const batchIDHeader = "X-Batch-ID"
+
+// BatchResult represents the outcome of one send of a batch, in the order of
+// the batch's payloads: the send response, or the error and the status it
+// would have been reported with on its own, along with the GmailStatus of the
+// Gmail API call that failed, if any.
+type BatchResult struct {
+	Index       int           `json:"index"`
+	Status      int           `json:"status"`
+	Result      *SendResponse `json:"result,omitempty"`
+	Error       string        `json:"error,omitempty"`
+	GmailStatus int           `json:"gmailStatus,omitempty"`
+}
+
+// handleBatch handles the HTTP request to send a batch of messages, given as
+// a JSON array of payloads in the request body. The array is decoded as a
+// stream, each payload being sent as soon as it is decoded, so that a large
+// batch is never held in memory as a whole. The sends run concurrently, at
+// most Config.BatchWorkers at once, and fail independently of each other; the
+// response lists a BatchResult for every payload. A payload that cannot be
+// decoded fails, and ends the batch, with 400 Bad Request. The batch is given
+// a server-generated ID, sent in the batchIDHeader before any payload is
+// sent, under which it can be canceled through /cancel/{batchId}, after which
+// no more payloads are read and the response lists those sent until then.
+func (s *Server) handleBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, withStatus(http.StatusMethodNotAllowed, errors.New("only POST requests are allowed")))
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	if token, err := decoder.Token(); err != nil || token != json.Delim('[') {
+		writeError(w, withStatus(http.StatusBadRequest, errors.New("failed to decode batch: expected a JSON array of payloads")))
+		return
+	}
+
+	ctx := r.Context()
+	batchID := serverIDFromContext(ctx)
+	canceled, running, finish := s.startCancelable(ctx, batchID)
+	defer finish()
+
+	// The batch ID is sent before the sends start, so that the client can
+	// cancel the batch while it runs. HTTP/1 servers stop reading a request
+	// once its response started unless told otherwise, so the rest of the
+	// batch would be lost.
+	rc := http.NewResponseController(w)
+	rc.EnableFullDuplex()
+	w.Header().Set(batchIDHeader, batchID)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	rc.Flush()
+
+	var mu sync.Mutex
+	results := []BatchResult{}
+	record := func(i int, result BatchResult) {
+		mu.Lock()
+		defer mu.Unlock()
+		results[i] = result
+	}
+
+	slots := make(chan struct{}, max(1, s.config.BatchWorkers))
+	var wg sync.WaitGroup
+	for i := 0; decoder.More(); i++ {
+		select {
+		case slots <- struct{}{}:
+		case <-canceled.Done():
+		}
+		if canceled.Err() != nil {
+			break
+		}
+
+		var payload *Payload
+		err := decoder.Decode(&payload)
+
+		mu.Lock()
+		results = append(results, BatchResult{Index: i})
+		mu.Unlock()
+		if err != nil {
+			record(i, BatchResult{Index: i, Status: http.StatusBadRequest, Error: fmt.Sprintf("failed to decode payload: %v", err)})
+			break
+		}
+
+		wg.Add(1)
+		go func(i int, payload *Payload) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			record(i, s.sendBatchItem(ctx, i, payload))
+			running.completed.Add(1)
+		}(i, payload)
+	}
+	wg.Wait()
+
+	s.writeJSON(w, r, results)
+}
+
+// sendBatchItem sends the i-th payload of a batch. Batch sends carry no
+// idempotency key, so they fail fast rather than being retried. Each send gets
+// an ID of its own, that of the batch followed by /i, so that the messages it
+// trashed can be restored on their own.
+func (s *Server) sendBatchItem(ctx context.Context, i int, payload *Payload) BatchResult {
+	err := errors.New("payload is null")
+	if payload != nil {
+		err = validatePayload(payload)
+	}
+	if err == nil && payload.DryRun {
+		err = errors.New("dryRun is not supported in batches")
+	}
+	if err == nil && payload.SplitRecipients > 0 {
+		err = errors.New("splitRecipients is not supported in batches")
+	}
+	if err != nil {
+		return BatchResult{Index: i, Status: http.StatusBadRequest, Error: err.Error()}
+	}
+
+	ctx = contextWithServerID(ctx, serverIDFromContext(ctx)+"/"+strconv.Itoa(i))
+	response, err := s.send(ctx, payload, nil, nil)
+	if err != nil {
+		return BatchResult{Index: i, Status: errorStatus(err), Error: err.Error(), GmailStatus: upstreamStatus(err)}
+	}
+
+	return BatchResult{Index: i, Status: http.StatusOK, Result: response}
+}
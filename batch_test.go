@@ -0,0 +1,218 @@
+package gosender
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// postBatch serves a POST of the JSON array of payloads to /batch through h.
+func postBatch(h http.Handler, payloads ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/batch", strings.NewReader("["+strings.Join(payloads, ",")+"]"))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestBatch(t *testing.T) {
+	tests := []struct {
+		name        string
+		fields      []map[string]any
+		wantStatus  []int
+		wantSent    int
+		wantTrashed int
+	}{
+		{
+			name:       "all valid",
+			fields:     []map[string]any{{"to": "a@example.com", "subject": "A", "messageBody": "A"}, {"to": "b@example.com", "subject": "B", "messageBody": "B"}},
+			wantStatus: []int{http.StatusOK, http.StatusOK},
+			wantSent:   2, wantTrashed: 2,
+		},
+		{
+			name:       "invalid payload fails alone",
+			fields:     []map[string]any{{"to": "a@example.com", "subject": "A", "messageBody": "A"}, {"to": "b@example.com", "subject": "B", "messageBody": "B", "dryRun": true}},
+			wantStatus: []int{http.StatusOK, http.StatusBadRequest},
+			wantSent:   1, wantTrashed: 2,
+		},
+		{
+			name:       "split rejected",
+			fields:     []map[string]any{{"to": []string{"a@example.com", "b@example.com"}, "subject": "A", "messageBody": "A", "splitRecipients": 1}},
+			wantStatus: []int{http.StatusBadRequest},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newGmailStub(t)
+			stub.setLabel("INBOX", "old-1", "old-2")
+			// One send at a time, so that only the first finds messages to trash.
+			h := stub.newServer(withUndo, func(c *Config) { c.BatchWorkers = 1 }).Handler()
+
+			var payloads []string
+			for _, fields := range tt.fields {
+				payloads = append(payloads, stub.payload(t, fields))
+			}
+			rec := postBatch(h, payloads...)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("batch = %d %s", rec.Code, rec.Body)
+			}
+			var results []BatchResult
+			decodeJSON(t, rec, &results)
+			if len(results) != len(tt.wantStatus) {
+				t.Fatalf("got %d results; want %d", len(results), len(tt.wantStatus))
+			}
+			for i, result := range results {
+				if result.Index != i || result.Status != tt.wantStatus[i] {
+					t.Errorf("result %d = index %d, status %d (%s); want status %d", i, result.Index, result.Status, result.Error, tt.wantStatus[i])
+				}
+			}
+			if sent, _, trashed := stub.counts(); sent != tt.wantSent || trashed != tt.wantTrashed {
+				t.Errorf("sent %d, trashed %d; want %d and %d", sent, trashed, tt.wantSent, tt.wantTrashed)
+			}
+		})
+	}
+}
+
+func TestBatchItemsAreUndoneSeparately(t *testing.T) {
+	stub := newGmailStub(t)
+	stub.release = make(chan struct{})
+	s := stub.newServer(withUndo, func(c *Config) { c.BatchWorkers = 1 })
+	h := s.Handler()
+
+	// The first send trashes old-1 and the second, made once the inbox holds
+	// old-2, trashes that one.
+	stub.setLabel("INBOX", "old-1")
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		done <- postBatch(h,
+			stub.payload(t, map[string]any{"to": "a@example.com", "subject": "A", "messageBody": "A"}),
+			stub.payload(t, map[string]any{"to": "b@example.com", "subject": "B", "messageBody": "B"}))
+	}()
+	stub.release <- struct{}{}
+	waitTrashed(t, stub, 1)
+	stub.setLabel("INBOX", "old-2")
+	close(stub.release)
+
+	rec := <-done
+	var results []BatchResult
+	decodeJSON(t, rec, &results)
+	if len(results) != 2 || results[0].Result == nil || results[1].Result == nil {
+		t.Fatalf("batch = %d %s", rec.Code, rec.Body)
+	}
+	first, second := results[0].Result.UndoID, results[1].Result.UndoID
+	if first == "" || first == second {
+		t.Fatalf("undo IDs %q and %q; want distinct ones", first, second)
+	}
+
+	payload := stub.payload(t, nil)
+	for _, tt := range []struct {
+		undoID string
+		want   []string
+	}{
+		{second, []string{"old-2"}},
+		{first, []string{"old-2", "old-1"}},
+	} {
+		if rec := postPayload(h, "/undo/"+tt.undoID, payload, nil); rec.Code != http.StatusOK {
+			t.Fatalf("undo %s = %d %s", tt.undoID, rec.Code, rec.Body)
+		}
+		stub.mu.Lock()
+		untrashed := strings.Join(stub.untrashed, ",")
+		stub.mu.Unlock()
+		if untrashed != strings.Join(tt.want, ",") {
+			t.Errorf("after undo %s, untrashed %s; want %v", tt.undoID, untrashed, tt.want)
+		}
+	}
+}
+
+func TestStreamedBatch(t *testing.T) {
+	tests := []struct {
+		name       string
+		items      []string
+		wantStatus []int
+		wantSent   int
+	}{
+		{
+			name:     "large array",
+			items:    strings.Fields(strings.Repeat("valid ", 200)),
+			wantSent: 200,
+		},
+		{
+			name:       "undecodable payload ends the batch",
+			items:      []string{"valid", "valid", `"not a payload"`, "valid"},
+			wantStatus: []int{http.StatusOK, http.StatusOK, http.StatusBadRequest},
+			wantSent:   2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newGmailStub(t)
+			h := stub.newServer(func(c *Config) { c.BatchWorkers = 1 }).Handler()
+			payload := stub.payload(t, map[string]any{"to": "to@example.com", "subject": "Hello", "messageBody": "Hi"})
+
+			// Each valid payload is written only once the previous one was
+			// sent, which a server reading the whole array first never does.
+			body, w := io.Pipe()
+			lagging := make(chan int, 1)
+			go func() {
+				defer w.Close()
+				io.WriteString(w, "[")
+				valid := 0
+				for i, item := range tt.items {
+					if i > 0 {
+						io.WriteString(w, ",")
+					}
+					if item != "valid" {
+						io.WriteString(w, item)
+						continue
+					}
+					io.WriteString(w, payload)
+					valid++
+					deadline := time.Now().Add(5 * time.Second)
+					for sent, _, _ := stub.counts(); sent < valid; sent, _, _ = stub.counts() {
+						if time.Now().After(deadline) {
+							lagging <- i
+							return
+						}
+						time.Sleep(time.Millisecond)
+					}
+				}
+				io.WriteString(w, "]")
+			}()
+
+			req := httptest.NewRequest(http.MethodPost, "/batch", body)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			body.Close()
+			if rec.Code != http.StatusOK {
+				t.Fatalf("batch = %d %s", rec.Code, rec.Body)
+			}
+			select {
+			case i := <-lagging:
+				t.Fatalf("payload %d was not sent before the rest of the array was written", i)
+			default:
+			}
+
+			wantStatus := tt.wantStatus
+			if wantStatus == nil {
+				for range tt.items {
+					wantStatus = append(wantStatus, http.StatusOK)
+				}
+			}
+			var results []BatchResult
+			decodeJSON(t, rec, &results)
+			if len(results) != len(wantStatus) {
+				t.Fatalf("got %d results; want %d", len(results), len(wantStatus))
+			}
+			for i, result := range results {
+				if result.Index != i || result.Status != wantStatus[i] {
+					t.Errorf("result %d = index %d, status %d (%s); want status %d", i, result.Index, result.Status, result.Error, wantStatus[i])
+				}
+			}
+			if sent, _, _ := stub.counts(); sent != tt.wantSent {
+				t.Errorf("sent %d messages; want %d", sent, tt.wantSent)
+			}
+		})
+	}
+}
@@ -0,0 +1,27 @@
+package gosender
+
+import "fmt"
+
+const (
+	// buildRaw sends MessageBody as the complete RFC 5322 message.
+	buildRaw = "raw"
+
+	// buildStructured builds the message from the payload's fields, with
+	// MessageBody as the plain-text body.
+	buildStructured = "structured"
+)
+
+// validateBuildMode rejects unknown build modes and a raw build mode combined
+// with structured fields, whose meaning would otherwise be ambiguous.
+func validateBuildMode(p *Payload) error {
+	switch p.BuildMode {
+	case "", buildStructured:
+		return nil
+	case buildRaw:
+		if p.hasStructuredFields() {
+			return fmt.Errorf("buildMode %q cannot be combined with structured fields", buildRaw)
+		}
+		return nil
+	}
+	return fmt.Errorf("invalid buildMode %q: expected %q or %q", p.BuildMode, buildRaw, buildStructured)
+}
@@ -0,0 +1,78 @@
+package gosender
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestBuildMode(t *testing.T) {
+	message := "From: me@example.com\r\nTo: to@example.com\r\nSubject: Raw\r\n\r\nSent as is.\r\n"
+	tests := []struct {
+		name        string
+		fields      map[string]any
+		wantStatus  int
+		wantSubject string
+		wantBody    string
+	}{
+		{
+			name:       "raw guessed without structured fields",
+			fields:     map[string]any{"messageBody": message},
+			wantStatus: http.StatusOK, wantSubject: "Raw", wantBody: "Sent as is.",
+		},
+		{
+			name:       "structured guessed from the fields",
+			fields:     map[string]any{"to": "to@example.com", "subject": "Hello", "messageBody": "Hi"},
+			wantStatus: http.StatusOK, wantSubject: "Hello", wantBody: "Hi",
+		},
+		{
+			name:       "explicit raw",
+			fields:     map[string]any{"buildMode": "raw", "messageBody": message},
+			wantStatus: http.StatusOK, wantSubject: "Raw", wantBody: "Sent as is.",
+		},
+		{
+			name:       "explicit structured keeps a message-like body",
+			fields:     map[string]any{"buildMode": "structured", "to": "to@example.com", "messageBody": message},
+			wantStatus: http.StatusOK, wantBody: "Subject: Raw",
+		},
+		{
+			name:       "raw with structured fields is ambiguous",
+			fields:     map[string]any{"buildMode": "raw", "subject": "Hello", "messageBody": message},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "unknown build mode",
+			fields:     map[string]any{"buildMode": "mime", "messageBody": message},
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newGmailStub(t)
+			h := stub.newServer().Handler()
+
+			rec := postPayload(h, "/send", stub.payload(t, tt.fields), nil)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("send = %d %s; want %d", rec.Code, rec.Body, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				var response ErrorResponse
+				decodeJSON(t, rec, &response)
+				if response.Code != ErrBadPayload {
+					t.Errorf("code = %q; want %q", response.Code, ErrBadPayload)
+				}
+				if sent, _, _ := stub.counts(); sent != 0 {
+					t.Errorf("%d messages sent; want none", sent)
+				}
+				return
+			}
+			m := parseRawMessage([]byte(stub.sent[0]))
+			if subject := m.value("Subject"); subject != tt.wantSubject {
+				t.Errorf("Subject = %q; want %q", subject, tt.wantSubject)
+			}
+			if !strings.Contains(stub.sent[0], tt.wantBody) {
+				t.Errorf("sent %q; want the body to contain %q", stub.sent[0], tt.wantBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package gosender
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// calendarPart renders an iCalendar invite (RFC 5545) as a text/calendar part
+// with method REQUEST, for use as an alternative body that mail clients
+// render with RSVP buttons. The invite must hold a VEVENT and declare
+// METHOD:REQUEST itself, as the iTIP method (RFC 5546) must match the part's.
+func calendarPart(ics string) (mimePart, error) {
+	// iCalendar requires CRLF line endings, which the quoted-printable
+	// encoding will keep as hard line breaks.
+	ics = strings.ReplaceAll(strings.ReplaceAll(ics, "\r\n", "\n"), "\n", "\r\n")
+	if err := validateCalendar(ics); err != nil {
+		return mimePart{}, fmt.Errorf("invalid calendarInvite: %v", err)
+	}
+
+	return textPart("text/calendar; method=REQUEST", ics)
+}
+
+// validateCalendar checks the basic structure of an iCalendar object with
+// CRLF line endings: a single VCALENDAR holding METHOD:REQUEST and at least
+// one VEVENT, with balanced BEGIN and END lines.
+func validateCalendar(ics string) error {
+	// Unfold continuation lines before looking at the properties.
+	ics = strings.NewReplacer("\r\n ", "", "\r\n\t", "").Replace(ics)
+	lines := strings.Split(strings.TrimRight(ics, "\r\n"), "\r\n")
+	if !strings.EqualFold(lines[0], "BEGIN:VCALENDAR") || !strings.EqualFold(lines[len(lines)-1], "END:VCALENDAR") {
+		return errors.New("expected a BEGIN:VCALENDAR ... END:VCALENDAR object")
+	}
+
+	var open []string
+	var method string
+	events := 0
+	for i, line := range lines {
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return fmt.Errorf("line %d: expected a NAME:value property", i+1)
+		}
+		name, _, _ = strings.Cut(name, ";")
+		switch strings.ToUpper(name) {
+		case "BEGIN":
+			open = append(open, strings.ToUpper(value))
+			if strings.EqualFold(value, "VEVENT") {
+				events++
+			}
+		case "END":
+			if len(open) == 0 || open[len(open)-1] != strings.ToUpper(value) {
+				return fmt.Errorf("line %d: unexpected END:%s", i+1, value)
+			}
+			open = open[:len(open)-1]
+			if len(open) == 0 && i != len(lines)-1 {
+				return fmt.Errorf("line %d: content after END:VCALENDAR", i+2)
+			}
+		case "METHOD":
+			if len(open) == 1 {
+				method = strings.ToUpper(value)
+			}
+		}
+	}
+
+	switch {
+	case events == 0:
+		return errors.New("expected a VEVENT")
+	case method != "REQUEST":
+		return errors.New("expected METHOD:REQUEST")
+	}
+
+	return nil
+}
@@ -0,0 +1,78 @@
+package gosender
+
+import (
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCalendarInvite(t *testing.T) {
+	const invite = "BEGIN:VCALENDAR\nVERSION:2.0\nPRODID:-//Example//Mailer//EN\nMETHOD:REQUEST\nBEGIN:VEVENT\nUID:standup-1@example.com\nDTSTART:20260101T090000Z\nSUMMARY:Daily\n standup\nEND:VEVENT\nEND:VCALENDAR\n"
+	tests := []struct {
+		name      string
+		html      string
+		invite    string
+		wantTypes []string
+		wantError string
+	}{
+		{name: "alongside html", html: "<p>Join us</p>", invite: invite, wantTypes: []string{"text/plain", "text/html", "text/calendar"}},
+		{name: "without html", invite: invite, wantTypes: []string{"text/plain", "text/calendar"}},
+		{name: "not a calendar", invite: "BEGIN:VEVENT\nEND:VEVENT\n", wantError: "expected a BEGIN:VCALENDAR ... END:VCALENDAR object"},
+		{name: "without an event", invite: "BEGIN:VCALENDAR\nMETHOD:REQUEST\nEND:VCALENDAR\n", wantError: "expected a VEVENT"},
+		{name: "published", invite: strings.Replace(invite, "METHOD:REQUEST", "METHOD:PUBLISH", 1), wantError: "expected METHOD:REQUEST"},
+		{name: "unbalanced", invite: strings.Replace(invite, "END:VEVENT", "END:VTODO", 1), wantError: "unexpected END:VTODO"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw, err := buildMessage(&Payload{To: AddressList{"to@example.com"}, Subject: "Standup", MessageBody: "Join us", HTMLBody: tt.html, CalendarInvite: tt.invite}, time.Now())
+			if tt.wantError != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantError) {
+					t.Errorf("buildMessage error = %v; want %q", err, tt.wantError)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("buildMessage: %v", err)
+			}
+
+			msg, err := mail.ReadMessage(strings.NewReader(string(raw)))
+			if err != nil {
+				t.Fatalf("failed to parse message: %v", err)
+			}
+			mediaType, params, _ := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+			if mediaType != "multipart/alternative" {
+				t.Fatalf("Content-Type = %q; want multipart/alternative", mediaType)
+			}
+			var types []string
+			reader := multipart.NewReader(msg.Body, params["boundary"])
+			for {
+				part, err := reader.NextPart()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatalf("failed to read part: %v", err)
+				}
+				partType, partParams, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+				types = append(types, partType)
+				if partType != "text/calendar" {
+					continue
+				}
+				if partParams["method"] != "REQUEST" {
+					t.Errorf("calendar part Content-Type = %q; want method=REQUEST", part.Header.Get("Content-Type"))
+				}
+				body, _ := io.ReadAll(part)
+				if want := strings.ReplaceAll(invite, "\n", "\r\n"); string(body) != want {
+					t.Errorf("calendar part = %q; want %q", body, want)
+				}
+			}
+			if strings.Join(types, ", ") != strings.Join(tt.wantTypes, ", ") {
+				t.Errorf("alternatives %q; want %q", types, tt.wantTypes)
+			}
+		})
+	}
+}
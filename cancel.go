@@ -0,0 +1,83 @@
+package gosender
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// CancelResponse represents the result of canceling a batch or asynchronous
+// send: the number of sends that completed before it was canceled.
+type CancelResponse struct {
+	ID        string `json:"id"`
+	Completed int    `json:"completed"`
+}
+
+// cancelable is a batch or asynchronous send running on this server, which
+// /cancel/{id} can stop.
+type cancelable struct {
+	cancel    context.CancelFunc
+	completed atomic.Int64
+}
+
+// runningKey returns the key in Server.running of the work the given tenant
+// runs under id, so that tenants neither see nor cancel each other's work.
+func runningKey(tenant, id string) string {
+	return tenant + ":" + id
+}
+
+// startCancelable registers the work identified by id, generated by the
+// server so that no other work runs under it, as cancelable and returns a
+// context canceled by /cancel/{id}, along with the function to call once the
+// work can no longer be canceled, which reports whether it was. The context
+// only signals the cancellation; the sends themselves must not be made with
+// it, so that those under way are not interrupted.
+func (s *Server) startCancelable(ctx context.Context, id string) (context.Context, *cancelable, func() bool) {
+	key := runningKey(tenantID(ctx), id)
+	ctx, cancel := context.WithCancel(ctx)
+	c := &cancelable{cancel: cancel}
+
+	s.runningMu.Lock()
+	s.running[key] = c
+	s.runningMu.Unlock()
+
+	return ctx, c, func() bool {
+		s.runningMu.Lock()
+		delete(s.running, key)
+		canceled := ctx.Err() != nil
+		s.runningMu.Unlock()
+		cancel()
+		return canceled
+	}
+}
+
+// handleCancel handles the HTTP request to cancel a running batch, identified
+// by its batch ID, or pending asynchronous send, identified by its job ID, in
+// the path /cancel/{id}. Sends already under way are left to finish; the
+// others are not made. Only work of the request's tenant running on this
+// server instance can be canceled.
+func (s *Server) handleCancel(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, withStatus(http.StatusMethodNotAllowed, errors.New("only POST requests are allowed")))
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/cancel/")
+	// Canceling under the lock guarantees that work finding itself still
+	// registered, and not canceled, is not canceled afterwards.
+	s.runningMu.Lock()
+	c, ok := s.running[runningKey(tenantID(r.Context()), id)]
+	if ok {
+		c.cancel()
+	}
+	s.runningMu.Unlock()
+	if id == "" || !ok {
+		writeError(w, withStatus(http.StatusNotFound, errors.New("no such batch or pending job")))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	s.writeJSON(w, r, CancelResponse{ID: id, Completed: int(c.completed.Load())})
+}
@@ -0,0 +1,170 @@
+package gosender
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// waitRunning waits until work of tenant runs under id on s.
+func waitRunning(t *testing.T, s *Server, tenant, id string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		s.runningMu.Lock()
+		_, running := s.running[runningKey(tenant, id)]
+		s.runningMu.Unlock()
+		if running {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("nothing running under %q", id)
+}
+
+// postCancel serves a POST to /cancel/{id} through h as the given tenant.
+func postCancel(h http.Handler, id, tenant string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/cancel/"+id, nil)
+	req.Header.Set("X-Tenant-ID", tenant)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+// startBatch posts the JSON array of payloads to /batch on server as tenant,
+// under the given X-Request-ID, returning the response as soon as its header
+// arrives, before the batch is done.
+func startBatch(t *testing.T, server *httptest.Server, tenant, requestID string, payloads ...string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, server.URL+"/batch", strings.NewReader("["+strings.Join(payloads, ",")+"]"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Tenant-ID", tenant)
+	req.Header.Set("X-Request-ID", requestID)
+	resp, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatalf("batch failed: %v", err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestCancelBatch(t *testing.T) {
+	tests := []struct {
+		name          string
+		tenant        string
+		envelope      bool
+		wantStatus    int
+		wantCompleted int
+		wantSent      int
+	}{
+		{name: "own batch", tenant: "acme", wantStatus: http.StatusOK, wantCompleted: 0, wantSent: 1},
+		{name: "own batch in envelope", tenant: "acme", envelope: true, wantStatus: http.StatusOK, wantCompleted: 0, wantSent: 1},
+		{name: "other tenant", tenant: "globex", wantStatus: http.StatusNotFound, wantSent: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newGmailStub(t)
+			stub.release = make(chan struct{})
+			s := stub.newServer(stub.withTenants("acme", "globex"), func(c *Config) {
+				c.BatchWorkers = 1
+				c.ResponseEnvelope = tt.envelope
+			})
+			h := s.Handler()
+			server := httptest.NewServer(h)
+			defer server.Close()
+
+			payload := stub.payload(t, map[string]any{"to": "to@example.com", "subject": "Hello", "messageBody": "Hi"})
+			resp := startBatch(t, server, "acme", "request-id", payload, payload, payload)
+			batchID := resp.Header.Get(batchIDHeader)
+			if batchID == "" || batchID == "request-id" {
+				t.Fatalf("batch ID = %q; want one generated by the server", batchID)
+			}
+
+			// Cancel while the first send is being delivered.
+			waitAttempts(t, stub, 1)
+			rec := postCancel(h, batchID, tt.tenant)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("cancel = %d %s; want %d", rec.Code, rec.Body, tt.wantStatus)
+			}
+			if rec.Code == http.StatusOK {
+				var response CancelResponse
+				decodeJSON(t, rec, &response)
+				if tt.envelope {
+					var envelope Envelope
+					decodeJSON(t, rec, &envelope)
+					json.Unmarshal(envelope.Data, &response)
+				}
+				if response.ID != batchID || response.Completed != tt.wantCompleted {
+					t.Errorf("cancel = %+v; want %d completed", response, tt.wantCompleted)
+				}
+			}
+			close(stub.release)
+
+			var results []BatchResult
+			if tt.envelope {
+				var envelope Envelope
+				json.NewDecoder(resp.Body).Decode(&envelope)
+				json.Unmarshal(envelope.Data, &results)
+			} else if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
+				t.Fatalf("failed to decode results: %v", err)
+			}
+			if sent, _, _ := stub.counts(); sent != tt.wantSent || len(results) != tt.wantSent {
+				t.Errorf("sent %d messages with %d results; want %d", sent, len(results), tt.wantSent)
+			}
+		})
+	}
+}
+
+func TestCancelPendingJob(t *testing.T) {
+	stub := newGmailStub(t)
+	stub.release = make(chan struct{})
+	defer close(stub.release)
+	s := stub.newServer(stub.withTenants("acme", "globex"), func(c *Config) { c.AsyncWorkers = 1 })
+	h := s.Handler()
+	payload := stub.payload(t, map[string]any{"to": "to@example.com", "subject": "Hello", "messageBody": "Hi"})
+	header := map[string]string{"X-Tenant-ID": "acme"}
+
+	// The first job holds the only worker, so the second stays pending.
+	postPayload(h, "/send?async=true", payload, header)
+	waitAttempts(t, stub, 1)
+	var pending Job
+	decodeJSON(t, postPayload(h, "/send?async=true", payload, header), &pending)
+
+	if rec := postCancel(h, pending.ID, "globex"); rec.Code != http.StatusNotFound {
+		t.Errorf("cancel by another tenant = %d %s; want %d", rec.Code, rec.Body, http.StatusNotFound)
+	}
+	if rec := postCancel(h, pending.ID, "acme"); rec.Code != http.StatusOK {
+		t.Fatalf("cancel = %d %s", rec.Code, rec.Body)
+	}
+	if job := waitJob(t, h, "/status/"+pending.ID, "acme"); job.Status != jobCanceled {
+		t.Errorf("job = %+v; want it canceled", job)
+	}
+}
+
+func TestBatchesShareRequestID(t *testing.T) {
+	stub := newGmailStub(t)
+	stub.release = make(chan struct{})
+	s := stub.newServer(stub.withTenants("acme"))
+	server := httptest.NewServer(s.Handler())
+	defer server.Close()
+	payload := stub.payload(t, map[string]any{"to": "to@example.com", "subject": "Hello", "messageBody": "Hi"})
+
+	// Batches sharing a request ID, as those of one trace do, run side by side.
+	first := startBatch(t, server, "acme", "trace-id", payload)
+	second := startBatch(t, server, "acme", "trace-id", payload)
+	close(stub.release)
+	for _, resp := range []*http.Response{first, second} {
+		var results []BatchResult
+		if err := json.NewDecoder(resp.Body).Decode(&results); err != nil || len(results) != 1 || results[0].Status != http.StatusOK {
+			t.Errorf("batch = %d %+v, %v; want one send", resp.StatusCode, results, err)
+		}
+	}
+	if first.Header.Get(batchIDHeader) == second.Header.Get(batchIDHeader) {
+		t.Errorf("both batches got the ID %q", first.Header.Get(batchIDHeader))
+	}
+}
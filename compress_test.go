@@ -0,0 +1,73 @@
+package gosender
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCompression(t *testing.T) {
+	tests := []struct {
+		name           string
+		batch          int
+		minBytes       int
+		acceptEncoding string
+		wantGzip       bool
+	}{
+		{name: "large batch", batch: 20, minBytes: 1024, acceptEncoding: "gzip", wantGzip: true},
+		{name: "small send", minBytes: 1024, acceptEncoding: "gzip"},
+		{name: "send above a low threshold", minBytes: 64, acceptEncoding: "gzip, deflate", wantGzip: true},
+		{name: "gzip not accepted", batch: 20, minBytes: 1024, acceptEncoding: "deflate"},
+		{name: "gzip refused", batch: 20, minBytes: 1024, acceptEncoding: "gzip;q=0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newGmailStub(t)
+			h := stub.newServer(func(c *Config) {
+				c.Compress = true
+				c.CompressMinBytes = tt.minBytes
+			}).Handler()
+			fields := map[string]any{"to": "to@example.com", "subject": "Hello", "messageBody": "Hi"}
+
+			var rec *httptest.ResponseRecorder
+			if tt.batch > 0 {
+				payloads := make([]string, tt.batch)
+				for i := range payloads {
+					payloads[i] = stub.payload(t, fields)
+				}
+				req := httptest.NewRequest(http.MethodPost, "/batch", strings.NewReader("["+strings.Join(payloads, ",")+"]"))
+				req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+				rec = httptest.NewRecorder()
+				h.ServeHTTP(rec, req)
+			} else {
+				rec = postPayload(h, "/send", stub.payload(t, fields), map[string]string{"Accept-Encoding": tt.acceptEncoding})
+			}
+			if rec.Code != http.StatusOK {
+				t.Fatalf("request = %d %s", rec.Code, rec.Body)
+			}
+
+			body := io.Reader(rec.Body)
+			if gzipped := rec.Header().Get("Content-Encoding") == "gzip"; gzipped != tt.wantGzip {
+				t.Fatalf("Content-Encoding = %q; want gzip: %v", rec.Header().Get("Content-Encoding"), tt.wantGzip)
+			}
+			if tt.wantGzip {
+				gz, err := gzip.NewReader(rec.Body)
+				if err != nil {
+					t.Fatalf("failed to read gzip stream: %v", err)
+				}
+				body = gz
+			}
+			if !strings.Contains(rec.Header().Get("Vary"), "Accept-Encoding") {
+				t.Errorf("Vary = %q; want Accept-Encoding", rec.Header().Get("Vary"))
+			}
+			var response any
+			if err := json.NewDecoder(body).Decode(&response); err != nil {
+				t.Errorf("failed to decode response: %v", err)
+			}
+		})
+	}
+}
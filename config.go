@@ -0,0 +1,759 @@
+package gosender
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/mail"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/gmail/v1"
+)
+
+// Config holds the server configuration.
+type Config struct {
+	// IncludeToken returns the (possibly refreshed) token in SendResponse.
+	// It is off by default because the token is a secret.
+	IncludeToken bool
+
+	// RefreshOnUnauthorized refreshes a token that Google rejects before it
+	// expired, when the payload's token carries a refresh token, and makes
+	// the rejected request once more with the new token.
+	RefreshOnUnauthorized bool
+
+	// Credentials are the OAuth client credentials used when a request supplies
+	// only a token, keeping the shared client secret out of requests.
+	Credentials json.RawMessage
+
+	// ReceiptKey, when set, is the HMAC-SHA256 key signing the receipts of
+	// sent messages; see Receipt.
+	ReceiptKey []byte
+
+	// CredentialProvider, when set, supplies the server's credentials in
+	// place of Credentials, fetching them anew for every send.
+	CredentialProvider CredentialProvider
+
+	// AllowDelegation passes a request's userId naming another mailbox on to
+	// Gmail, for credentials with delegated access. Without it, a userId must
+	// name the authenticated account.
+	AllowDelegation bool
+
+	// NormalizeLineEndings turns the bare LF line endings of raw messages
+	// into the CRLF required by RFC 5322. Structured messages are always
+	// built with CRLF.
+	NormalizeLineEndings bool
+
+	// SkipSent keeps the sent copy of every message out of the Sent folder,
+	// as if each payload set SkipSent.
+	SkipSent bool
+
+	// FromProfile fills in the From of structured messages sent without one
+	// from the authenticated account's address, as reported by
+	// Users.GetProfile. Profiles are cached for ProfileCacheTTL.
+	FromProfile     bool
+	ProfileCacheTTL time.Duration
+
+	// Scopes are the OAuth scopes the credentials are used with, by default
+	// https://mail.google.com/.
+	Scopes []string
+
+	// GmailEndpoint overrides the base URL of the Gmail API, such as to point
+	// the server at a mock in integration tests.
+	GmailEndpoint string
+
+	// ServiceFactory, when set, creates the Gmail services requests act
+	// through in place of the default, which uses GmailEndpoint.
+	ServiceFactory ServiceFactory
+
+	// TokenInfoEndpoint overrides the URL of Google's OAuth 2.0 token
+	// information endpoint, through which token scopes are checked, such as
+	// to point the server at a mock along with GmailEndpoint.
+	TokenInfoEndpoint string
+
+	// Proxy routes the outbound requests to Gmail and Google's OAuth
+	// endpoints through an HTTP, HTTPS or SOCKS5 proxy. When nil, the
+	// HTTPS_PROXY and NO_PROXY environment variables apply.
+	Proxy *url.URL
+
+	// TLSConfig configures the TLS of the outbound connections to Gmail and
+	// Google's OAuth endpoints. InsecureSkipVerify, for talking to a mock with
+	// a self-signed certificate, is rejected by Validate and ignored by the
+	// server unless built with the gosendertest build tag.
+	TLSConfig *tls.Config
+
+	// AttachmentURLSchemes and AttachmentURLHosts allowlist the URLs that
+	// attachments may be fetched from. URL attachments are rejected when no
+	// hosts are configured.
+	AttachmentURLSchemes []string
+	AttachmentURLHosts   []string
+
+	// AllowedAttachmentTypes lists the content types attachments may have,
+	// such as "application/pdf" or "image/*". Any type is allowed when empty.
+	AllowedAttachmentTypes []string
+
+	// AttachmentFetchTimeout and AttachmentMaxBytes bound the time taken and
+	// the size downloaded for each URL attachment; zero selects 10 seconds and
+	// 10 MiB. AttachmentMaxRedirects is how many redirects a fetch may follow.
+	AttachmentFetchTimeout time.Duration
+	AttachmentMaxBytes     int
+	AttachmentMaxRedirects int
+
+	// SendRetries is how many times a failed send is retried. Retries only
+	// happen for requests carrying an Idempotency-Key header.
+	SendRetries int
+
+	// RetryBaseDelay is the delay before the first retry, multiplied by
+	// RetryMultiplier for each further one up to RetryMaxDelay. Zero values
+	// select 500ms, 10s and 2.
+	RetryBaseDelay  time.Duration
+	RetryMaxDelay   time.Duration
+	RetryMultiplier float64
+
+	// RetryBudget and RetryBudgetTime cap the retries of a request as a
+	// whole, across all of its sends: at most RetryBudget retries, none of
+	// them starting RetryBudgetTime or more after the request did. Zero
+	// values leave the respective cap off.
+	RetryBudget     int
+	RetryBudgetTime time.Duration
+
+	// IdempotencyTTL is how long an idempotency key is remembered,
+	// defaultIdempotencyTTL when zero.
+	IdempotencyTTL time.Duration
+
+	// MessageIDDedupWindow is how long a sent Message-ID is remembered; a
+	// second message with the same Message-ID from the same account of the
+	// same tenant within the window is rejected as a likely duplicate.
+	// Deduplication is disabled when zero.
+	MessageIDDedupWindow time.Duration
+
+	// HTMLWarnBytes is the HTML body size above which the send response carries
+	// a warning, since Gmail clips messages at about 102KB. Zero disables it.
+	HTMLWarnBytes int
+
+	// AlwaysBcc is an archive address, or list of addresses, invisibly added
+	// to the Bcc of every message, for compliance archiving.
+	AlwaysBcc string
+
+	// OrgHeaderName and OrgHeaderValue are a header field, such as
+	// Organization, set on every message in place of any of the same name.
+	OrgHeaderName  string
+	OrgHeaderValue string
+
+	// SubjectPrefix is prepended to the subject of every message, structured
+	// or raw, such as "[STAGING] " to mark messages sent from non-production
+	// environments.
+	SubjectPrefix string
+
+	// MaxSubjectLength bounds the length, once RFC 2047 encoded, of the
+	// subject of structured messages, 255 characters when unset.
+	MaxSubjectLength int
+
+	// DefaultReplyTo is the Reply-To address of every message that does not
+	// set its own, such as a support address.
+	DefaultReplyTo string
+
+	// RedirectTo, for staging environments, replaces every recipient of
+	// every message with this address, so that no real user is emailed. The
+	// original recipients are kept in X-Original-To, X-Original-Cc and
+	// X-Original-Bcc headers.
+	RedirectTo string
+
+	// TrackingPixelURL is the base URL of the open-tracking pixel injected
+	// into the HTML body of messages setting TrackOpens, with the message's
+	// token added as the "token" query parameter. Tracking is disabled when
+	// empty, so recipients are not tracked unless the operator opts in.
+	TrackingPixelURL string
+
+	// Suppressions lists the addresses that are never sent to, such as for
+	// unsubscribe compliance. Suppressed recipients are dropped from each
+	// message, which is not sent at all when none are left. Only the message's
+	// own recipients are checked, before AlwaysBcc and RedirectTo apply.
+	Suppressions SuppressionList
+
+	// DedupRecipients removes the addresses repeated across To, Cc and Bcc,
+	// keeping each in the most visible of them, so no one receives a message
+	// twice.
+	DedupRecipients bool
+
+	// FooterText and FooterHTML are appended to the plain-text and HTML
+	// bodies of every structured message, such as for a compliance notice.
+	FooterText string
+	FooterHTML string
+
+	// SendTimeout bounds each send as a whole, from authenticating to
+	// trashing the existing messages, unless they are trashed after the
+	// response. Zero means no limit.
+	SendTimeout time.Duration
+
+	// RouteTimeouts bounds the requests of individual routes, keyed by route
+	// name: send, batch, undo, trash, status, cancel, quota or metrics.
+	// Requests exceeding their route's timeout are answered with 503 Service
+	// Unavailable.
+	RouteTimeouts map[string]time.Duration
+
+	// TrashTimeout bounds the cleanup phase trashing the existing messages
+	// after a send, independently of the send itself. Zero means no limit of
+	// its own.
+	TrashTimeout time.Duration
+
+	// TrashAfterResponse answers a send as soon as the message is sent and
+	// trashes the existing messages in the background. Streamed and
+	// asynchronous sends, which report the trash progress, always trash first.
+	TrashAfterResponse bool
+
+	// TrashableLabels lists the labels whose messages may be trashed: those a
+	// send cleans up, all of them unless the payload picks some, and those
+	// /trash matches its query in. When empty, sends clean up INBOX and SPAM
+	// and /trash matches the whole mailbox.
+	TrashableLabels []string
+
+	// TrashOlderThan limits the cleanup phase to the messages received more
+	// than this long before the send, sparing freshly arrived mail. Zero
+	// trashes every message of the cleanup labels.
+	TrashOlderThan time.Duration
+
+	// UndoTTL is how long the messages trashed by a send can be restored through
+	// /undo/{undoId}. Undo is disabled when zero.
+	UndoTTL time.Duration
+
+	// AsyncWorkers caps how many asynchronous sends run at once, and
+	// BatchWorkers how many sends of a batch; defaultWorkers each when zero.
+	AsyncWorkers int
+	BatchWorkers int
+
+	// JobTTL is how long the state of an asynchronous send can be polled
+	// through /status/{id}, defaultJobTTL when zero.
+	JobTTL time.Duration
+
+	// MetricsMaxDomains caps how many recipient domains get their own metrics
+	// label, defaultMetricsMaxDomains when zero and none when negative. The
+	// first domains seen keep their label for the life of the server and any
+	// later domain is reported as "other", however many sends it gets.
+	MetricsMaxDomains int
+
+	// DomainRateLimits paces sends per recipient domain; the "*" entry applies
+	// to every domain without its own limit.
+	DomainRateLimits map[string]RateLimit
+
+	// Tenants maps tenant IDs to the OAuth client credentials stored for them.
+	// When set, every request must identify a known tenant.
+	Tenants map[string]json.RawMessage
+
+	// TenantHeader names the request header identifying the tenant.
+	TenantHeader string
+
+	// TenantRateLimits and TenantDailyQuotas cap the sends of each tenant,
+	// keyed by tenant ID with "*" applying to every tenant without its own
+	// entry. Sends over either are rejected with 429 Too Many Requests.
+	TenantRateLimits  map[string]RateLimit
+	TenantDailyQuotas map[string]int
+
+	// Compress enables gzip compression of responses of at least
+	// CompressMinBytes for clients accepting it.
+	Compress         bool
+	CompressMinBytes int
+
+	// ResponseEnvelope wraps every JSON response in an Envelope, as if each
+	// request accepted the envelope media type.
+	ResponseEnvelope bool
+
+	// Debug indents the JSON responses for human readers.
+	Debug bool
+
+	// BoundaryPrefix starts the boundaries of the multipart messages built,
+	// for gateways expecting a specific boundary format; random characters
+	// follow it. It may be up to 22 characters allowed in boundaries by
+	// RFC 2046, other than space. Empty means "=_".
+	BoundaryPrefix string
+
+	// Hooks run, in order, on every message before it is built; see Hook.
+	Hooks []Hook
+
+	// Clock returns the current time, as used for the Date header of built
+	// messages, Thread-Index timestamps, receipts and the trash cutoff, so
+	// that tests can make them deterministic. Nil means time.Now.
+	Clock func() time.Time
+
+	// Logger receives the request logs. slog.Default is used when nil.
+	Logger *slog.Logger
+
+	// Store holds server-side state. An in-memory store is used when nil.
+	Store Store
+}
+
+// LoadConfig reads the configuration from GOSENDER_* environment variables.
+func LoadConfig() (*Config, error) {
+	config := &Config{}
+
+	var err error
+	if config.IncludeToken, err = envBool("GOSENDER_INCLUDE_TOKEN", false); err != nil {
+		return nil, err
+	}
+	if config.RefreshOnUnauthorized, err = envBool("GOSENDER_REFRESH_ON_UNAUTHORIZED", false); err != nil {
+		return nil, err
+	}
+	if key := envString("GOSENDER_RECEIPT_KEY", ""); key != "" {
+		config.ReceiptKey = []byte(key)
+	}
+	if config.AllowDelegation, err = envBool("GOSENDER_ALLOW_DELEGATION", false); err != nil {
+		return nil, err
+	}
+	if config.NormalizeLineEndings, err = envBool("GOSENDER_NORMALIZE_LINE_ENDINGS", false); err != nil {
+		return nil, err
+	}
+	if config.SkipSent, err = envBool("GOSENDER_SKIP_SENT", false); err != nil {
+		return nil, err
+	}
+	if config.FromProfile, err = envBool("GOSENDER_FROM_PROFILE", false); err != nil {
+		return nil, err
+	}
+	if config.ProfileCacheTTL, err = envDuration("GOSENDER_PROFILE_CACHE_TTL", 10*time.Minute); err != nil {
+		return nil, err
+	}
+
+	if config.Debug, err = envBool("GOSENDER_DEBUG", false); err != nil {
+		return nil, err
+	}
+	if config.ResponseEnvelope, err = envBool("GOSENDER_RESPONSE_ENVELOPE", false); err != nil {
+		return nil, err
+	}
+
+	if config.SendRetries, err = envInt("GOSENDER_SEND_RETRIES", 3); err != nil {
+		return nil, err
+	}
+	if config.RetryBaseDelay, err = envDuration("GOSENDER_RETRY_BASE_DELAY", defaultRetryBaseDelay); err != nil {
+		return nil, err
+	}
+	if config.RetryMaxDelay, err = envDuration("GOSENDER_RETRY_MAX_DELAY", defaultRetryMaxDelay); err != nil {
+		return nil, err
+	}
+	if config.RetryMultiplier, err = envFloat("GOSENDER_RETRY_MULTIPLIER", defaultRetryMultiplier); err != nil {
+		return nil, err
+	}
+	if config.RetryBudget, err = envInt("GOSENDER_RETRY_BUDGET", 0); err != nil {
+		return nil, err
+	}
+	if config.RetryBudgetTime, err = envDuration("GOSENDER_RETRY_BUDGET_TIME", 0); err != nil {
+		return nil, err
+	}
+	if config.IdempotencyTTL, err = envDuration("GOSENDER_IDEMPOTENCY_TTL", defaultIdempotencyTTL); err != nil {
+		return nil, err
+	}
+	if config.MessageIDDedupWindow, err = envDuration("GOSENDER_MESSAGE_ID_DEDUP_WINDOW", 0); err != nil {
+		return nil, err
+	}
+	if config.HTMLWarnBytes, err = envInt("GOSENDER_HTML_WARN_BYTES", 100*1024); err != nil {
+		return nil, err
+	}
+	if config.DedupRecipients, err = envBool("GOSENDER_DEDUP_RECIPIENTS", false); err != nil {
+		return nil, err
+	}
+	if config.SendTimeout, err = envDuration("GOSENDER_SEND_TIMEOUT", 0); err != nil {
+		return nil, err
+	}
+	if config.TrashTimeout, err = envDuration("GOSENDER_TRASH_TIMEOUT", 0); err != nil {
+		return nil, err
+	}
+	if config.TrashAfterResponse, err = envBool("GOSENDER_TRASH_AFTER_RESPONSE", false); err != nil {
+		return nil, err
+	}
+	config.TrashableLabels = envList("GOSENDER_TRASHABLE_LABELS", nil)
+	if config.TrashOlderThan, err = envDuration("GOSENDER_TRASH_OLDER_THAN", 0); err != nil {
+		return nil, err
+	}
+	if config.UndoTTL, err = envDuration("GOSENDER_UNDO_TTL", 0); err != nil {
+		return nil, err
+	}
+	if config.AsyncWorkers, err = envInt("GOSENDER_ASYNC_WORKERS", defaultWorkers); err != nil {
+		return nil, err
+	}
+	if config.BatchWorkers, err = envInt("GOSENDER_BATCH_WORKERS", defaultWorkers); err != nil {
+		return nil, err
+	}
+	if config.JobTTL, err = envDuration("GOSENDER_JOB_TTL", defaultJobTTL); err != nil {
+		return nil, err
+	}
+	if config.Compress, err = envBool("GOSENDER_COMPRESS", true); err != nil {
+		return nil, err
+	}
+	if config.CompressMinBytes, err = envInt("GOSENDER_COMPRESS_MIN_BYTES", 1024); err != nil {
+		return nil, err
+	}
+	if config.MetricsMaxDomains, err = envInt("GOSENDER_METRICS_MAX_DOMAINS", defaultMetricsMaxDomains); err != nil {
+		return nil, err
+	}
+
+	if config.Credentials, err = loadCredentials(); err != nil {
+		return nil, err
+	}
+
+	if bcc := os.Getenv("GOSENDER_ALWAYS_BCC"); bcc != "" {
+		addr, err := mail.ParseAddress(bcc)
+		if err != nil {
+			return nil, fmt.Errorf("invalid GOSENDER_ALWAYS_BCC: %v", err)
+		}
+		config.AlwaysBcc = addr.String()
+	}
+
+	if header := os.Getenv("GOSENDER_ORG_HEADER"); header != "" {
+		name, value, ok := strings.Cut(header, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid GOSENDER_ORG_HEADER %q: expected Name: value", header)
+		}
+		config.OrgHeaderName, config.OrgHeaderValue = strings.TrimSpace(name), strings.TrimSpace(value)
+	}
+
+	config.SubjectPrefix = os.Getenv("GOSENDER_SUBJECT_PREFIX")
+	if config.MaxSubjectLength, err = envInt("GOSENDER_MAX_SUBJECT_LENGTH", defaultMaxSubjectLength); err != nil {
+		return nil, err
+	}
+	config.BoundaryPrefix = os.Getenv("GOSENDER_BOUNDARY_PREFIX")
+
+	if replyTo := os.Getenv("GOSENDER_DEFAULT_REPLY_TO"); replyTo != "" {
+		addr, err := mail.ParseAddress(replyTo)
+		if err != nil {
+			return nil, fmt.Errorf("invalid GOSENDER_DEFAULT_REPLY_TO: %v", err)
+		}
+		config.DefaultReplyTo = addr.String()
+	}
+
+	if redirect := os.Getenv("GOSENDER_REDIRECT_TO"); redirect != "" {
+		addr, err := mail.ParseAddress(redirect)
+		if err != nil {
+			return nil, fmt.Errorf("invalid GOSENDER_REDIRECT_TO: %v", err)
+		}
+		config.RedirectTo = addr.String()
+	}
+
+	if value := os.Getenv("GOSENDER_TRACKING_PIXEL_URL"); value != "" {
+		u, err := url.Parse(value)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return nil, fmt.Errorf("invalid GOSENDER_TRACKING_PIXEL_URL: %q is not an absolute http(s) URL", value)
+		}
+		config.TrackingPixelURL = value
+	}
+
+	if value := os.Getenv("GOSENDER_PROXY_URL"); value != "" {
+		u, err := url.Parse(value)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "socks5") || u.Host == "" {
+			return nil, fmt.Errorf("invalid GOSENDER_PROXY_URL: %q is not an absolute http(s) or socks5 URL", value)
+		}
+		config.Proxy = u
+	}
+
+	if value := os.Getenv("GOSENDER_ROUTE_TIMEOUTS"); value != "" {
+		if config.RouteTimeouts, err = parseRouteTimeouts(value); err != nil {
+			return nil, fmt.Errorf("invalid GOSENDER_ROUTE_TIMEOUTS: %v", err)
+		}
+	}
+
+	if value := os.Getenv("GOSENDER_DOMAIN_RATE_LIMITS"); value != "" {
+		if config.DomainRateLimits, err = parseRateLimits(value); err != nil {
+			return nil, fmt.Errorf("invalid GOSENDER_DOMAIN_RATE_LIMITS: %v", err)
+		}
+	}
+
+	config.FooterText = os.Getenv("GOSENDER_FOOTER_TEXT")
+	config.FooterHTML = os.Getenv("GOSENDER_FOOTER_HTML")
+
+	config.TenantHeader = envString("GOSENDER_TENANT_HEADER", "X-Tenant-ID")
+	if path := os.Getenv("GOSENDER_TENANTS_FILE"); path != "" {
+		if config.Tenants, err = loadTenants(path); err != nil {
+			return nil, err
+		}
+	}
+	if value := os.Getenv("GOSENDER_TENANT_RATE_LIMITS"); value != "" {
+		if config.TenantRateLimits, err = parseRateLimits(value); err != nil {
+			return nil, fmt.Errorf("invalid GOSENDER_TENANT_RATE_LIMITS: %v", err)
+		}
+	}
+	if value := os.Getenv("GOSENDER_TENANT_DAILY_QUOTAS"); value != "" {
+		if config.TenantDailyQuotas, err = parseQuotas(value); err != nil {
+			return nil, fmt.Errorf("invalid GOSENDER_TENANT_DAILY_QUOTAS: %v", err)
+		}
+	}
+
+	config.Scopes = envList("GOSENDER_SCOPES", nil)
+	if addresses := envList("GOSENDER_SUPPRESSED_ADDRESSES", nil); len(addresses) > 0 {
+		config.Suppressions = NewMemorySuppressionList(addresses...)
+	}
+	config.AttachmentURLSchemes = envList("GOSENDER_ATTACHMENT_URL_SCHEMES", []string{"https"})
+	config.AttachmentURLHosts = envList("GOSENDER_ATTACHMENT_URL_HOSTS", nil)
+	config.AllowedAttachmentTypes = envList("GOSENDER_ALLOWED_ATTACHMENT_TYPES", nil)
+	if config.AttachmentFetchTimeout, err = envDuration("GOSENDER_ATTACHMENT_FETCH_TIMEOUT", defaultAttachmentFetchTimeout); err != nil {
+		return nil, err
+	}
+	if config.AttachmentMaxBytes, err = envInt("GOSENDER_ATTACHMENT_MAX_BYTES", defaultAttachmentMaxBytes); err != nil {
+		return nil, err
+	}
+	if config.AttachmentMaxRedirects, err = envInt("GOSENDER_ATTACHMENT_MAX_REDIRECTS", 5); err != nil {
+		return nil, err
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// Defaults of the settings whose zero value would otherwise turn a feature
+// off: a Config not made by LoadConfig gets them from NewServer.
+const (
+	defaultIdempotencyTTL = 24 * time.Hour
+	defaultJobTTL         = time.Hour
+	defaultWorkers        = 4
+
+	defaultMetricsMaxDomains = 20
+)
+
+// applyDefaults sets the settings left at zero to their defaults.
+func (c *Config) applyDefaults() {
+	if c.IdempotencyTTL == 0 {
+		c.IdempotencyTTL = defaultIdempotencyTTL
+	}
+	if c.JobTTL == 0 {
+		c.JobTTL = defaultJobTTL
+	}
+	if c.AsyncWorkers == 0 {
+		c.AsyncWorkers = defaultWorkers
+	}
+	if c.BatchWorkers == 0 {
+		c.BatchWorkers = defaultWorkers
+	}
+	if c.MetricsMaxDomains == 0 {
+		c.MetricsMaxDomains = defaultMetricsMaxDomains
+	}
+}
+
+// Validate checks that the server-side credentials, if any, can be parsed and
+// that the retry settings are consistent, so that a broken configuration fails
+// at startup rather than on the first request.
+func (c *Config) Validate() error {
+	if err := c.backoff().Validate(); err != nil {
+		return fmt.Errorf("invalid retry configuration: %v", err)
+	}
+
+	if len(c.ReceiptKey) > 0 && len(c.ReceiptKey) < 32 {
+		return errors.New("invalid receipt key: expected at least 32 bytes")
+	}
+
+	if c.OrgHeaderName != "" {
+		if !headerNamePattern.MatchString(c.OrgHeaderName) {
+			return fmt.Errorf("invalid org header name %q", c.OrgHeaderName)
+		}
+		if containsControl(c.OrgHeaderValue) {
+			return errors.New("invalid org header value: control characters are not allowed")
+		}
+	}
+
+	if c.BoundaryPrefix != "" && !boundaryPrefixPattern.MatchString(c.BoundaryPrefix) {
+		return fmt.Errorf("invalid boundary prefix %q: expected up to 22 characters allowed in MIME boundaries", c.BoundaryPrefix)
+	}
+
+	if containsControl(c.SubjectPrefix) {
+		return errors.New("invalid subject prefix: control characters are not allowed")
+	}
+	if err := validateAddressSetting("redirect address", c.RedirectTo); err != nil {
+		return err
+	}
+	if err := validateAddressSetting("always bcc", c.AlwaysBcc); err != nil {
+		return err
+	}
+	if err := validateAddressSetting("default reply-to", c.DefaultReplyTo); err != nil {
+		return err
+	}
+
+	if c.TLSConfig != nil && c.TLSConfig.InsecureSkipVerify && !insecureTLSAllowed {
+		return errors.New("invalid TLS configuration: InsecureSkipVerify is only allowed in builds with the gosendertest tag")
+	}
+
+	if len(c.Credentials) > 0 {
+		if _, err := google.ConfigFromJSON(c.Credentials, gmail.MailGoogleComScope); err != nil {
+			return fmt.Errorf("invalid server credentials: %v", err)
+		}
+	}
+
+	for id, credentials := range c.Tenants {
+		if _, err := google.ConfigFromJSON(credentials, gmail.MailGoogleComScope); err != nil {
+			return fmt.Errorf("invalid credentials for tenant %q: %v", id, err)
+		}
+	}
+
+	return nil
+}
+
+// validateAddressSetting checks that the setting of the given name, which goes
+// into an address header of every message, is empty or a list of addresses.
+func validateAddressSetting(name, value string) error {
+	if value == "" {
+		return nil
+	}
+	if containsControl(value) {
+		return fmt.Errorf("invalid %s: control characters are not allowed", name)
+	}
+	if _, err := mail.ParseAddressList(value); err != nil {
+		return fmt.Errorf("invalid %s %q: %v", name, value, err)
+	}
+	return nil
+}
+
+// now returns the current time according to the configured clock.
+func (c *Config) now() time.Time {
+	if c.Clock == nil {
+		return time.Now()
+	}
+	return c.Clock()
+}
+
+// scopes returns the OAuth scopes the credentials are used with.
+func (c *Config) scopes() []string {
+	if len(c.Scopes) == 0 {
+		return []string{gmail.MailGoogleComScope}
+	}
+	return c.Scopes
+}
+
+// envInt returns the non-negative integer value of the named environment variable, or def when unset.
+func envInt(name string, def int) (int, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok || value == "" {
+		return def, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %v", name, err)
+	}
+	if n < 0 {
+		return 0, fmt.Errorf("invalid %s: must not be negative", name)
+	}
+
+	return n, nil
+}
+
+// envDuration returns the duration value of the named environment variable, or def when unset.
+func envDuration(name string, def time.Duration) (time.Duration, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok || value == "" {
+		return def, nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %v", name, err)
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("invalid %s: must not be negative", name)
+	}
+
+	return d, nil
+}
+
+// envFloat returns the non-negative number value of the named environment variable, or def when unset.
+func envFloat(name string, def float64) (float64, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok || value == "" {
+		return def, nil
+	}
+
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %v", name, err)
+	}
+	if f < 0 {
+		return 0, fmt.Errorf("invalid %s: must not be negative", name)
+	}
+
+	return f, nil
+}
+
+// envList returns the comma-separated values of the named environment variable, or def when unset.
+func envList(name string, def []string) []string {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+
+	return list
+}
+
+// loadCredentials returns the server's OAuth client credentials from
+// GOSENDER_CREDENTIALS, the file named by GOSENDER_CREDENTIALS_FILE or the
+// Secret Manager version named by GOSENDER_CREDENTIALS_SECRET, in that order.
+func loadCredentials() (json.RawMessage, error) {
+	if value := os.Getenv("GOSENDER_CREDENTIALS"); value != "" {
+		return json.RawMessage(value), nil
+	}
+
+	if path := os.Getenv("GOSENDER_CREDENTIALS_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read credentials file: %v", err)
+		}
+		return data, nil
+	}
+
+	if name := os.Getenv("GOSENDER_CREDENTIALS_SECRET"); name != "" {
+		data, err := accessSecret(context.Background(), name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load GOSENDER_CREDENTIALS_SECRET: %v", err)
+		}
+		return data, nil
+	}
+
+	return nil, nil
+}
+
+// loadTenants reads a JSON object mapping tenant IDs to OAuth client credentials.
+func loadTenants(path string) (map[string]json.RawMessage, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tenants file: %v", err)
+	}
+
+	var tenants map[string]json.RawMessage
+	if err := json.Unmarshal(data, &tenants); err != nil {
+		return nil, fmt.Errorf("failed to parse tenants file: %v", err)
+	}
+
+	return tenants, nil
+}
+
+// envString returns the value of the named environment variable, or def when unset.
+func envString(name, def string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return def
+}
+
+// envBool returns the boolean value of the named environment variable, or def when unset.
+func envBool(name string, def bool) (bool, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok || value == "" {
+		return def, nil
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s: %v", name, err)
+	}
+
+	return b, nil
+}
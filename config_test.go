@@ -0,0 +1,113 @@
+package gosender
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewServerDefaults(t *testing.T) {
+	tests := []struct {
+		name   string
+		config *Config
+		want   Config
+	}{
+		{
+			name: "zero values",
+			want: Config{IdempotencyTTL: defaultIdempotencyTTL, JobTTL: defaultJobTTL, AsyncWorkers: defaultWorkers, BatchWorkers: defaultWorkers, MetricsMaxDomains: defaultMetricsMaxDomains},
+		},
+		{
+			name:   "explicit values",
+			config: &Config{IdempotencyTTL: time.Minute, JobTTL: time.Second, AsyncWorkers: 1, BatchWorkers: 2, MetricsMaxDomains: -1},
+			want:   Config{IdempotencyTTL: time.Minute, JobTTL: time.Second, AsyncWorkers: 1, BatchWorkers: 2, MetricsMaxDomains: -1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer(tt.config)
+			got := s.config
+			if got.IdempotencyTTL != tt.want.IdempotencyTTL || got.JobTTL != tt.want.JobTTL ||
+				got.AsyncWorkers != tt.want.AsyncWorkers || got.BatchWorkers != tt.want.BatchWorkers ||
+				got.MetricsMaxDomains != tt.want.MetricsMaxDomains {
+				t.Errorf("got TTLs %s and %s, workers %d and %d; want %s and %s, %d and %d",
+					got.IdempotencyTTL, got.JobTTL, got.AsyncWorkers, got.BatchWorkers,
+					tt.want.IdempotencyTTL, tt.want.JobTTL, tt.want.AsyncWorkers, tt.want.BatchWorkers)
+			}
+			if tt.config != nil && tt.config.JobTTL != tt.want.JobTTL {
+				t.Errorf("NewServer changed the given config")
+			}
+		})
+	}
+}
+
+func TestValidateAddressSettings(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  Config
+		wantErr bool
+	}{
+		{name: "none", config: Config{}},
+		{name: "always bcc", config: Config{AlwaysBcc: "archive@example.com"}},
+		{name: "always bcc list", config: Config{AlwaysBcc: "Archive <archive@example.com>, legal@example.com"}},
+		{name: "always bcc injection", config: Config{AlwaysBcc: "archive@example.com\r\nSubject: spoofed"}, wantErr: true},
+		{name: "always bcc malformed", config: Config{AlwaysBcc: "not an address"}, wantErr: true},
+		{name: "default reply-to", config: Config{DefaultReplyTo: "Support <support@example.com>"}},
+		{name: "default reply-to injection", config: Config{DefaultReplyTo: "support@example.com\nBcc: evil@example.com"}, wantErr: true},
+		{name: "default reply-to malformed", config: Config{DefaultReplyTo: "support"}, wantErr: true},
+		{name: "redirect", config: Config{RedirectTo: "qa@example.com"}},
+		{name: "redirect injection", config: Config{RedirectTo: "qa@example.com\r\nCc: everyone@example.com"}, wantErr: true},
+		{name: "redirect malformed", config: Config{RedirectTo: "qa@"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.config.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() = %v; want an error: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadConfigValidatesCredentials(t *testing.T) {
+	valid := `{"installed":{"client_id":"id.apps.googleusercontent.com","client_secret":"secret","redirect_uris":["http://localhost"]}}`
+	tests := []struct {
+		name        string
+		credentials string
+		tenants     string
+		wantErr     string
+	}{
+		{name: "none"},
+		{name: "valid", credentials: valid},
+		{name: "broken JSON", credentials: `{"installed":`, wantErr: "invalid server credentials"},
+		{name: "not OAuth client credentials", credentials: `{"type":"authorized_user"}`, wantErr: "invalid server credentials"},
+		{name: "broken tenant credentials", tenants: `{"acme":{"web":{}}}`, wantErr: `invalid credentials for tenant "acme"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range []string{"GOSENDER_CREDENTIALS_FILE", "GOSENDER_CREDENTIALS_SECRET"} {
+				t.Setenv(name, "")
+			}
+			t.Setenv("GOSENDER_CREDENTIALS", tt.credentials)
+			tenantsFile := ""
+			if tt.tenants != "" {
+				tenantsFile = filepath.Join(t.TempDir(), "tenants.json")
+				if err := os.WriteFile(tenantsFile, []byte(tt.tenants), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			t.Setenv("GOSENDER_TENANTS_FILE", tenantsFile)
+
+			_, err := LoadConfig()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("LoadConfig() = %v; want no error", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("LoadConfig() = %v; want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
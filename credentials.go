@@ -0,0 +1,52 @@
+package gosender
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+)
+
+// ErrNoCredentials is reported, with 400 Bad Request, by sends for which
+// neither the payload nor the server provided OAuth client credentials.
+var ErrNoCredentials = errors.New("gosender: no credentials provided")
+
+// CredentialProvider supplies OAuth client credentials. It is asked for them
+// on every send, so an implementation backed by a secret manager can rotate
+// the credentials without restarting the server.
+type CredentialProvider interface {
+	GetCredentials(ctx context.Context) (json.RawMessage, error)
+}
+
+// StaticCredentials is a CredentialProvider that always returns the same
+// credentials.
+type StaticCredentials json.RawMessage
+
+// GetCredentials returns c.
+func (c StaticCredentials) GetCredentials(context.Context) (json.RawMessage, error) {
+	return json.RawMessage(c), nil
+}
+
+// credentialProvider returns the provider of the credentials to send the
+// payload with. The credentials stored for the tenant in ctx, if any, take
+// precedence over the credentials of the payload, which in turn take
+// precedence over the server's own.
+func (s *Server) credentialProvider(ctx context.Context, payload *Payload) CredentialProvider {
+	switch tenant, ok := tenantFromContext(ctx); {
+	case ok:
+		return StaticCredentials(tenant.Credentials)
+	case !emptyCredentials(payload.Credentials):
+		return StaticCredentials(payload.Credentials)
+	case s.config.CredentialProvider != nil:
+		return s.config.CredentialProvider
+	default:
+		return StaticCredentials(s.config.Credentials)
+	}
+}
+
+// emptyCredentials reports whether raw holds no credentials: nothing, null
+// or an empty string.
+func emptyCredentials(raw json.RawMessage) bool {
+	raw = bytes.TrimSpace(raw)
+	return len(raw) == 0 || string(raw) == "null" || string(raw) == `""`
+}
@@ -0,0 +1,178 @@
+package gosender
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestServerCredentials(t *testing.T) {
+	tests := []struct {
+		name       string
+		env        string
+		file       bool
+		wantStatus int
+	}{
+		{name: "from GOSENDER_CREDENTIALS", env: "GOSENDER_CREDENTIALS", wantStatus: http.StatusOK},
+		{name: "from GOSENDER_CREDENTIALS_FILE", env: "GOSENDER_CREDENTIALS_FILE", file: true, wantStatus: http.StatusOK},
+		{name: "none", wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newGmailStub(t)
+			for _, name := range []string{"GOSENDER_CREDENTIALS", "GOSENDER_CREDENTIALS_FILE", "GOSENDER_CREDENTIALS_SECRET"} {
+				t.Setenv(name, "")
+			}
+			switch {
+			case tt.file:
+				path := filepath.Join(t.TempDir(), "credentials.json")
+				if err := os.WriteFile(path, stub.credentials(), 0o600); err != nil {
+					t.Fatal(err)
+				}
+				t.Setenv(tt.env, path)
+			case tt.env != "":
+				t.Setenv(tt.env, string(stub.credentials()))
+			}
+
+			config, err := LoadConfig()
+			if err != nil {
+				t.Fatalf("LoadConfig: %v", err)
+			}
+			config.GmailEndpoint, config.TokenInfoEndpoint = stub.config().GmailEndpoint, stub.config().TokenInfoEndpoint
+			h := NewServer(config, WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))).Handler()
+
+			// The payload carries only the user's token.
+			payload := stub.payload(t, map[string]any{"credentials": nil, "to": "to@example.com", "subject": "Hello", "messageBody": "Hi"})
+			rec := postPayload(h, "/send", payload, nil)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("send = %d %s; want %d", rec.Code, rec.Body, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				var response ErrorResponse
+				decodeJSON(t, rec, &response)
+				if !strings.Contains(response.Error, ErrNoCredentials.Error()) || response.Code != ErrBadPayload {
+					t.Errorf("error = %+v; want %q reported", response, ErrNoCredentials)
+				}
+				return
+			}
+			if sent, _, _ := stub.counts(); sent != 1 {
+				t.Errorf("sent %d messages; want 1", sent)
+			}
+		})
+	}
+}
+
+// rotatingCredentials is a CredentialProvider whose every call returns new
+// credentials, each refreshing tokens through a token endpoint URL naming
+// the version of the credentials.
+type rotatingCredentials struct {
+	stub  *gmailStub
+	calls int
+	err   error
+}
+
+func (p *rotatingCredentials) GetCredentials(context.Context) (json.RawMessage, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+	p.calls++
+	return json.RawMessage(fmt.Sprintf(`{"installed":{"client_id":%q,"client_secret":"secret","token_uri":%q,"redirect_uris":["http://localhost"]}}`,
+		stubClientID, fmt.Sprintf("%s/token?tenant=v%d", p.stub.server.URL, p.calls))), nil
+}
+
+func TestCredentialProvider(t *testing.T) {
+	tests := []struct {
+		name          string
+		credentials   bool
+		err           error
+		wantStatus    int
+		wantRefreshes []string
+		wantSent      int
+	}{
+		{name: "rotated on every send", wantStatus: http.StatusOK, wantRefreshes: []string{"v1", "v2"}, wantSent: 2},
+		{name: "payload credentials first", credentials: true, wantStatus: http.StatusOK, wantRefreshes: []string{"", ""}, wantSent: 2},
+		{name: "provider failing", err: errors.New("secret manager unavailable"), wantStatus: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newGmailStub(t)
+			provider := &rotatingCredentials{stub: stub, err: tt.err}
+			h := stub.newServer(func(c *Config) { c.CredentialProvider = provider }).Handler()
+			fields := map[string]any{
+				"to": "to@example.com", "subject": "Hello", "messageBody": "Hi",
+				"token": map[string]any{"access_token": "expired-token", "refresh_token": "refresh-token", "expiry": "2000-01-01T00:00:00Z"},
+			}
+			if !tt.credentials {
+				fields["credentials"] = nil
+			}
+			payload := stub.payload(t, fields)
+
+			for i := 0; i < 2; i++ {
+				if rec := postPayload(h, "/send", payload, nil); rec.Code != tt.wantStatus {
+					t.Fatalf("send %d = %d %s; want %d", i+1, rec.Code, rec.Body, tt.wantStatus)
+				}
+			}
+			stub.mu.Lock()
+			refreshes := append([]string(nil), stub.refreshes...)
+			stub.mu.Unlock()
+			if fmt.Sprint(refreshes) != fmt.Sprint(tt.wantRefreshes) {
+				t.Errorf("token refreshed with the credentials of %q; want those of %q", refreshes, tt.wantRefreshes)
+			}
+			if sent, _, _ := stub.counts(); sent != tt.wantSent {
+				t.Errorf("sent %d messages; want %d", sent, tt.wantSent)
+			}
+		})
+	}
+}
+
+func TestNoCredentials(t *testing.T) {
+	tests := []struct {
+		name        string
+		credentials any
+		server      bool
+		wantStatus  int
+	}{
+		{name: "null", credentials: nil, wantStatus: http.StatusBadRequest},
+		{name: "empty string", credentials: "", wantStatus: http.StatusBadRequest},
+		{name: "empty object", credentials: map[string]any{}, wantStatus: http.StatusInternalServerError},
+		{name: "empty with server credentials", credentials: "", server: true, wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newGmailStub(t)
+			h := stub.newServer(func(c *Config) {
+				if tt.server {
+					c.Credentials = stub.credentials()
+				}
+			}).Handler()
+			payload := stub.payload(t, map[string]any{"credentials": tt.credentials, "to": "to@example.com", "subject": "Hello", "messageBody": "Hi"})
+
+			rec := postPayload(h, "/send", payload, nil)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("send = %d %s; want %d", rec.Code, rec.Body, tt.wantStatus)
+			}
+			if tt.wantStatus == http.StatusOK {
+				return
+			}
+			var response ErrorResponse
+			decodeJSON(t, rec, &response)
+			if reported := strings.Contains(response.Error, ErrNoCredentials.Error()); reported != (tt.wantStatus == http.StatusBadRequest) {
+				t.Errorf("error = %+v; want %q reported only for missing credentials", response, ErrNoCredentials)
+			}
+			if tt.wantStatus == http.StatusBadRequest && response.Code != ErrBadPayload {
+				t.Errorf("code = %q; want %q", response.Code, ErrBadPayload)
+			}
+			if sent, _, _ := stub.counts(); sent != 0 {
+				t.Errorf("sent %d messages; want none", sent)
+			}
+		})
+	}
+}
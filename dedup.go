@@ -0,0 +1,48 @@
+package gosender
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// messageIDOf returns the Message-ID a payload's message will carry, if the
+// sender chose one: the messageId field of a structured message or the
+// Message-ID header of a raw one.
+func messageIDOf(p *Payload) string {
+	if p.isStructured() {
+		return p.MessageID
+	}
+	return strings.TrimSpace(parseRawMessage([]byte(p.MessageBody)).value("Message-ID"))
+}
+
+// messageIDKey returns the store key of the Message-ID claimed by account of
+// tenant, so that senders never block each other's messages. Its parts are
+// quoted, as Message-IDs may hold any separator.
+func messageIDKey(tenant, account, messageID string) string {
+	return fmt.Sprintf("message-id:%q:%q:%q", tenant, account, messageID)
+}
+
+// claimMessageID records that account of tenant is sending a message with the
+// given Message-ID, failing with 409 Conflict when it already sent one within
+// Config.MessageIDDedupWindow. It returns a function releasing the claim,
+// for when the send fails. Nothing is checked when the window is zero or the
+// message has no Message-ID.
+func (s *Server) claimMessageID(tenant, account, messageID string) (release func(), err error) {
+	window := s.config.MessageIDDedupWindow
+	if window <= 0 || messageID == "" {
+		return func() {}, nil
+	}
+
+	key := messageIDKey(tenant, account, messageID)
+	s.dedupMu.Lock()
+	defer s.dedupMu.Unlock()
+
+	if _, ok := s.store.Get(key); ok {
+		return nil, withStatus(http.StatusConflict,
+			fmt.Errorf("a message with Message-ID %s was already sent in the last %s", messageID, window))
+	}
+	s.store.Set(key, []byte{1}, window)
+
+	return func() { s.store.Delete(key) }, nil
+}
@@ -0,0 +1,59 @@
+package gosender
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestMessageIDDedup(t *testing.T) {
+	tests := []struct {
+		name        string
+		firstFails  bool
+		otherTenant bool
+		otherEmail  bool
+		wantStatus  int
+		wantSent    int
+	}{
+		{name: "second rejected", wantStatus: http.StatusConflict, wantSent: 1},
+		{name: "failed send released", firstFails: true, wantStatus: http.StatusOK, wantSent: 1},
+		{name: "other tenant", otherTenant: true, wantStatus: http.StatusOK, wantSent: 2},
+		{name: "other account", otherEmail: true, wantStatus: http.StatusOK, wantSent: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newGmailStub(t)
+			h := stub.newServer(stub.withTenants("acme", "globex"), func(c *Config) { c.MessageIDDedupWindow = time.Minute }).Handler()
+			fields := map[string]any{"to": "to@example.com", "subject": "Hello", "messageBody": "Hi", "messageId": "<stable@example.com>"}
+
+			if tt.firstFails {
+				stub.sendStatus = http.StatusInternalServerError
+			}
+			rec := postPayload(h, "/send", stub.payload(t, fields), map[string]string{"X-Tenant-ID": "acme"})
+			if wantFirst := map[bool]int{false: http.StatusOK, true: http.StatusBadGateway}[tt.firstFails]; rec.Code != wantFirst {
+				t.Fatalf("first send = %d %s; want %d", rec.Code, rec.Body, wantFirst)
+			}
+			stub.sendStatus = 0
+
+			tenant := "acme"
+			if tt.otherTenant {
+				tenant = "globex"
+			}
+			if tt.otherEmail {
+				// Token information is cached per access token.
+				stub.email = "other@example.com"
+				fields["token"] = map[string]any{"access_token": "other-token", "expiry": "2099-01-01T00:00:00Z"}
+			}
+			rec = postPayload(h, "/send", stub.payload(t, fields), map[string]string{"X-Tenant-ID": tenant})
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("second send = %d %s; want %d", rec.Code, rec.Body, tt.wantStatus)
+			}
+			if rec.Code == http.StatusConflict && rec.Header().Get(errorCodeHeader) != string(ErrConflict) {
+				t.Errorf("error code = %q; want %q", rec.Header().Get(errorCodeHeader), ErrConflict)
+			}
+			if sent, _, _ := stub.counts(); sent != tt.wantSent {
+				t.Errorf("sent %d messages; want %d", sent, tt.wantSent)
+			}
+		})
+	}
+}
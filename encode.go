@@ -0,0 +1,68 @@
+package gosender
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// EncodeMessage builds the message m describes and returns it base64url
+// encoded, as gmail.Message.Raw expects, so that library users can build
+// messages offline and send them through their own Gmail client. The payload
+// is validated as for a send, and the fields of m.Header are set on the built
+// message; m itself is left unchanged. Building offline rules out what needs
+// Gmail or the server's configuration: replyToMessageId, forwardMessageId and
+// URL attachments are rejected, and no footer, policy or hook is applied.
+// Subjects are held to defaultMaxSubjectLength.
+func EncodeMessage(m Message) (string, error) {
+	if m.Payload == nil {
+		return "", errors.New("payload is nil")
+	}
+	p := *m.Payload
+	p.Attachments = append([]Attachment(nil), p.Attachments...)
+
+	if err := validatePayload(&p); err != nil {
+		return "", err
+	}
+	if p.RawBase64 != "" {
+		return p.RawBase64, nil
+	}
+
+	switch {
+	case p.ReplyToMessageID != "":
+		return "", errors.New("replyToMessageId needs Gmail; set inReplyTo and references instead")
+	case p.ForwardMessageID != "":
+		return "", errors.New("forwardMessageId needs Gmail and cannot be encoded offline")
+	}
+	for i, a := range p.Attachments {
+		if a.URL != "" {
+			return "", fmt.Errorf("attachment %d: url attachments cannot be encoded offline", i)
+		}
+	}
+	if err := loadAttachments(context.Background(), &Config{}, p.Attachments); err != nil {
+		return "", err
+	}
+	if name, ok := invalidHeader(m.Header); ok {
+		return "", fmt.Errorf("invalid header %q: control characters are not allowed", name)
+	}
+
+	raw, err := buildMessage(&p, time.Now())
+	if err != nil {
+		return "", err
+	}
+	raw = applyHookHeaders(raw, m.Header)
+	if p.isStructured() {
+		if err := validateSubjectLength(raw, 0); err != nil {
+			return "", err
+		}
+	}
+	if !p.internalDate.IsZero() {
+		rm := parseRawMessage(raw)
+		rm.setField("Date", p.internalDate.Format(time.RFC1123Z))
+		raw = rm.bytes()
+	}
+
+	return base64.URLEncoding.EncodeToString(raw), nil
+}
@@ -0,0 +1,141 @@
+package gosender
+
+import (
+	"bytes"
+	"encoding/base64"
+	"net/http"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+func TestSubjectLength(t *testing.T) {
+	tests := []struct {
+		name    string
+		subject string
+		prefix  string
+		wantErr bool
+	}{
+		{name: "at the limit", subject: strings.Repeat("a", defaultMaxSubjectLength)},
+		{name: "above the limit", subject: strings.Repeat("a", defaultMaxSubjectLength+1), wantErr: true},
+		// Each é takes six characters once encoded.
+		{name: "non-ASCII above once encoded", subject: strings.Repeat("é", defaultMaxSubjectLength/4), wantErr: true},
+		{name: "prefix pushes above", subject: strings.Repeat("a", defaultMaxSubjectLength-5), prefix: "[STAGING] ", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fields := map[string]any{"to": "to@example.com", "subject": tt.subject, "messageBody": "Hi"}
+
+			stub := newGmailStub(t)
+			h := stub.newServer(func(c *Config) { c.SubjectPrefix = tt.prefix }).Handler()
+			rec := postPayload(h, "/send", stub.payload(t, fields), nil)
+			if wantStatus := map[bool]int{false: http.StatusOK, true: http.StatusBadRequest}[tt.wantErr]; rec.Code != wantStatus {
+				t.Errorf("send = %d %s; want %d", rec.Code, rec.Body, wantStatus)
+			}
+
+			// Offline encoding applies no prefix.
+			if tt.prefix != "" {
+				return
+			}
+			_, err := EncodeMessage(Message{Payload: &Payload{To: AddressList{"to@example.com"}, Subject: tt.subject, MessageBody: "Hi"}})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("EncodeMessage error = %v; want an error: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEncodeMessage(t *testing.T) {
+	raw := base64.URLEncoding.EncodeToString([]byte("To: to@example.com\r\nSubject: Raw\r\n\r\nSent as is.\r\n"))
+	tests := []struct {
+		name        string
+		m           Message
+		wantSubject string
+		wantHeader  textproto.MIMEHeader
+		wantErr     bool
+	}{
+		{
+			name:        "structured",
+			m:           Message{Payload: &Payload{To: AddressList{"to@example.com"}, Subject: "Hello", MessageBody: "Hi"}},
+			wantSubject: "Hello",
+		},
+		{
+			name: "extra header fields",
+			m: Message{
+				Payload: &Payload{To: AddressList{"to@example.com"}, Subject: "Hello", MessageBody: "Hi"},
+				Header:  textproto.MIMEHeader{"X-Campaign": {"spring"}},
+			},
+			wantSubject: "Hello",
+			wantHeader:  textproto.MIMEHeader{"X-Campaign": {"spring"}},
+		},
+		{name: "raw passed through", m: Message{Payload: &Payload{RawBase64: raw}}, wantSubject: "Raw"},
+		{name: "nil payload", m: Message{}, wantErr: true},
+		{name: "invalid payload", m: Message{Payload: &Payload{To: AddressList{"to@example.com"}, Subject: "Hello\r\nBcc: attacker@evil.com"}}, wantErr: true},
+		{
+			name:    "reply to a Gmail message",
+			m:       Message{Payload: &Payload{To: AddressList{"to@example.com"}, Subject: "Hello", MessageBody: "Hi", ReplyToMessageID: "msg-1"}},
+			wantErr: true,
+		},
+		{
+			name:    "URL attachment",
+			m:       Message{Payload: &Payload{To: AddressList{"to@example.com"}, Subject: "Hello", MessageBody: "Hi", Attachments: []Attachment{{URL: "https://example.com/a.pdf"}}}},
+			wantErr: true,
+		},
+		{
+			name: "header field with control characters",
+			m: Message{
+				Payload: &Payload{To: AddressList{"to@example.com"}, Subject: "Hello", MessageBody: "Hi"},
+				Header:  textproto.MIMEHeader{"X-Campaign": {"spring\r\nBcc: attacker@evil.com"}},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded, err := EncodeMessage(tt.m)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("EncodeMessage error = %v; want an error: %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			decoded, err := base64.URLEncoding.DecodeString(encoded)
+			if err != nil {
+				t.Fatalf("failed to decode message: %v", err)
+			}
+			msg, err := mail.ReadMessage(bytes.NewReader(decoded))
+			if err != nil {
+				t.Fatalf("failed to parse message: %v", err)
+			}
+			if got := msg.Header.Get("To"); !strings.Contains(got, "to@example.com") {
+				t.Errorf("To = %q; want to@example.com", got)
+			}
+			if got := msg.Header.Get("Subject"); got != tt.wantSubject {
+				t.Errorf("Subject = %q; want %q", got, tt.wantSubject)
+			}
+			for name := range tt.wantHeader {
+				if got := msg.Header.Get(name); got != tt.wantHeader.Get(name) {
+					t.Errorf("%s = %q; want %q", name, got, tt.wantHeader.Get(name))
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkEncodeMessage(b *testing.B) {
+	data := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{0, 1, 2, 3, 4, 5, 6, 7}, 1<<20))
+	m := Message{Payload: &Payload{
+		To:          AddressList{"to@example.com"},
+		Subject:     "Report",
+		MessageBody: "Attached.",
+		Attachments: []Attachment{{Filename: "report.bin", ContentType: "application/octet-stream", Data: data}},
+	}}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := EncodeMessage(m); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package gosender
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// envelopeMediaType is the Accept media type asking for enveloped responses.
+const envelopeMediaType = "application/vnd.gosender.envelope+json"
+
+// Envelope wraps a response in envelope mode: successful JSON responses go
+// under Data and errors under Error, along with their Code if classified and
+// the GmailStatus of the Gmail API call that failed, if any.
+type Envelope struct {
+	Data        json.RawMessage `json:"data,omitempty"`
+	Error       string          `json:"error,omitempty"`
+	Code        ErrorCode       `json:"code,omitempty"`
+	GmailStatus int             `json:"gmailStatus,omitempty"`
+}
+
+// withEnvelope wraps JSON and error responses in an Envelope when
+// Config.ResponseEnvelope is set or the request accepts envelopeMediaType.
+// Other responses, such as NDJSON streams and metrics, are written as is.
+func (s *Server) withEnvelope(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.config.ResponseEnvelope && !acceptsEnvelope(r.Header.Get("Accept")) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ew := &envelopeWriter{ResponseWriter: w, indent: s.prettyJSON(r)}
+		defer ew.close()
+		next.ServeHTTP(ew, r)
+	})
+}
+
+// acceptsEnvelope reports whether an Accept header value lists envelopeMediaType.
+func acceptsEnvelope(header string) bool {
+	for _, item := range strings.Split(header, ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(item))
+		if err == nil && mediaType == envelopeMediaType {
+			return true
+		}
+	}
+	return false
+}
+
+// envelopeWriter buffers JSON and error responses so they can be written
+// wrapped in an Envelope once the handler is done.
+type envelopeWriter struct {
+	http.ResponseWriter
+	indent  bool
+	status  int
+	wrap    bool
+	decided bool
+	flushed bool
+	buf     bytes.Buffer
+}
+
+// WriteHeader decides, from the status code and content type, whether the
+// response is wrapped; unwrapped responses are passed through from then on.
+func (w *envelopeWriter) WriteHeader(status int) {
+	if w.decided {
+		return
+	}
+	w.decided = true
+	w.status = status
+
+	mediaType, _, _ := mime.ParseMediaType(w.Header().Get("Content-Type"))
+	w.wrap = status >= http.StatusBadRequest || mediaType == "application/json"
+	if !w.wrap {
+		w.ResponseWriter.WriteHeader(status)
+	}
+}
+
+// Write buffers p when the response is wrapped and passes it through otherwise.
+func (w *envelopeWriter) Write(p []byte) (int, error) {
+	if !w.decided {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.wrap {
+		return w.buf.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Flush flushes passed-through responses. The body of wrapped ones is held
+// until close, but the header of a successful one is sent right away, so that
+// headers such as the batch ID reach the client early.
+func (w *envelopeWriter) Flush() {
+	if w.wrap {
+		if w.status >= http.StatusBadRequest || w.flushed {
+			return
+		}
+		w.flushed = true
+		w.writeHeader()
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap returns the underlying ResponseWriter, for http.ResponseController.
+func (w *envelopeWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// close writes the buffered response wrapped in an Envelope.
+func (w *envelopeWriter) close() {
+	if !w.wrap {
+		return
+	}
+
+	var envelope Envelope
+	body := bytes.TrimSpace(w.buf.Bytes())
+	var response ErrorResponse
+	switch {
+	case w.status >= http.StatusBadRequest && json.Unmarshal(body, &response) == nil && response.Error != "":
+		envelope.Error = response.Error
+		envelope.Code = response.Code
+		envelope.GmailStatus = response.GmailStatus
+	case w.status >= http.StatusBadRequest:
+		// Errors not written by writeError, such as those of http.ServeMux.
+		envelope.Error = string(body)
+		envelope.Code = CodeForStatus(w.status)
+		envelope.GmailStatus, _ = strconv.Atoi(w.Header().Get(gmailStatusHeader))
+	default:
+		envelope.Data = body
+	}
+
+	if !w.flushed {
+		w.writeHeader()
+	}
+
+	encoder := json.NewEncoder(w.ResponseWriter)
+	if w.indent {
+		encoder.SetIndent("", "  ")
+	}
+	encoder.Encode(envelope)
+}
+
+// writeHeader writes the header of a wrapped response.
+func (w *envelopeWriter) writeHeader() {
+	header := w.Header()
+	header.Set("Content-Type", "application/json")
+	header.Del("Content-Length")
+	header.Del("X-Content-Type-Options")
+	w.ResponseWriter.WriteHeader(w.status)
+}
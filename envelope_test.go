@@ -0,0 +1,79 @@
+package gosender
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestEnvelope(t *testing.T) {
+	tests := []struct {
+		name         string
+		config       bool
+		accept       string
+		fields       map[string]any
+		path         string
+		wantStatus   int
+		wantEnvelope bool
+		wantCode     ErrorCode
+	}{
+		{name: "flat response", path: "/send", wantStatus: http.StatusOK},
+		{name: "flat error", path: "/send", fields: map[string]any{"priority": "urgent"}, wantStatus: http.StatusBadRequest, wantCode: ErrBadPayload},
+		{name: "enveloped by config", config: true, path: "/send", wantStatus: http.StatusOK, wantEnvelope: true},
+		{name: "enveloped by Accept", accept: "text/html, " + envelopeMediaType + ";q=0.9", path: "/send", wantStatus: http.StatusOK, wantEnvelope: true},
+		{name: "enveloped error", config: true, path: "/send", fields: map[string]any{"priority": "urgent"}, wantStatus: http.StatusBadRequest, wantEnvelope: true, wantCode: ErrBadPayload},
+		{name: "stream left as is", config: true, path: "/send?progress=ndjson", wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newGmailStub(t)
+			h := stub.newServer(func(c *Config) { c.ResponseEnvelope = tt.config }).Handler()
+			fields := map[string]any{"to": "to@example.com", "subject": "Hello", "messageBody": "Hi"}
+			for k, v := range tt.fields {
+				fields[k] = v
+			}
+			header := map[string]string{}
+			if tt.accept != "" {
+				header["Accept"] = tt.accept
+			}
+
+			rec := postPayload(h, tt.path, stub.payload(t, fields), header)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("send = %d %s; want %d", rec.Code, rec.Body, tt.wantStatus)
+			}
+
+			var body map[string]json.RawMessage
+			if strings.Contains(tt.path, "progress") {
+				line, _, _ := strings.Cut(rec.Body.String(), "\n")
+				if err := json.Unmarshal([]byte(line), &body); err != nil || body["data"] != nil {
+					t.Errorf("first line %s, %v; want a progress event", line, err)
+				}
+				return
+			}
+			decodeJSON(t, rec, &body)
+			if _, enveloped := body["data"]; enveloped && !tt.wantEnvelope {
+				t.Errorf("response %s is enveloped; want it flat", rec.Body)
+			}
+
+			switch {
+			case tt.wantStatus == http.StatusOK && tt.wantEnvelope:
+				var response SendResponse
+				if err := json.Unmarshal(body["data"], &response); err != nil || response.Output == nil || body["error"] != nil {
+					t.Errorf("envelope %s, %v; want the send response under data", rec.Body, err)
+				}
+			case tt.wantStatus == http.StatusOK:
+				if body["output"] == nil {
+					t.Errorf("response %s; want the flat send response", rec.Body)
+				}
+			default:
+				// Errors come as {"error", "code"} in both formats.
+				var envelope Envelope
+				decodeJSON(t, rec, &envelope)
+				if envelope.Code != tt.wantCode || envelope.Error == "" || envelope.Data != nil {
+					t.Errorf("error response %s; want %s under error and code", rec.Body, tt.wantCode)
+				}
+			}
+		})
+	}
+}
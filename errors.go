@@ -0,0 +1,242 @@
+package gosender
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"golang.org/x/oauth2"
+	"google.golang.org/api/googleapi"
+)
+
+// ErrServerClosed is returned by Server.Close, and reported to requests, once
+// the server is closed.
+var ErrServerClosed = errors.New("gosender: server closed")
+
+// ErrRetryBudgetExceeded is reported by requests that gave up retrying a
+// transient failure because their retry budget was spent.
+var ErrRetryBudgetExceeded = errors.New("gosender: retry budget exceeded")
+
+// errorCodeHeader carries the ErrorCode of a failed request, and
+// gmailStatusHeader the HTTP status of the Gmail API call that failed it.
+const (
+	errorCodeHeader   = "X-Error-Code"
+	gmailStatusHeader = "X-Gmail-Status"
+)
+
+// ErrorCode classifies the failures of requests. Error responses carry their
+// code in the X-Error-Code header, and in the envelope when enveloped; each
+// code is also an error that the errors of its class match with errors.Is.
+type ErrorCode string
+
+// The error codes, each reported with the HTTP status given by StatusCode.
+const (
+	ErrBadPayload       ErrorCode = "bad_payload"        // 400: the request or its payload is invalid
+	ErrAuth             ErrorCode = "auth"               // 401 or 403: the credentials or token were rejected
+	ErrNotFound         ErrorCode = "not_found"          // 404: the tenant, job, batch or undo record does not exist
+	ErrMethodNotAllowed ErrorCode = "method_not_allowed" // 405: the endpoint does not serve the request's method
+	ErrConflict         ErrorCode = "conflict"           // 409 or 422: the request conflicts with one already made
+	ErrQuota            ErrorCode = "quota"              // 429: a Gmail quota or rate limit was exhausted
+	ErrInternal         ErrorCode = "internal"           // 500: the server failed unexpectedly
+	ErrGmail            ErrorCode = "gmail"              // 502: Gmail failed the request
+	ErrUnavailable      ErrorCode = "unavailable"        // 503: the server is closed, or could not serve the request in time
+	ErrTimeout          ErrorCode = "timeout"            // 504: the request ran out of time
+)
+
+func (c ErrorCode) Error() string { return "gosender: " + string(c) }
+
+// StatusCode returns the HTTP status the failures of class c are reported
+// with; authentication failures may also come as 403 Forbidden, and conflicts
+// as 422 Unprocessable Entity.
+func (c ErrorCode) StatusCode() int {
+	switch c {
+	case ErrBadPayload:
+		return http.StatusBadRequest
+	case ErrAuth:
+		return http.StatusUnauthorized
+	case ErrNotFound:
+		return http.StatusNotFound
+	case ErrMethodNotAllowed:
+		return http.StatusMethodNotAllowed
+	case ErrConflict:
+		return http.StatusConflict
+	case ErrQuota:
+		return http.StatusTooManyRequests
+	case ErrGmail:
+		return http.StatusBadGateway
+	case ErrUnavailable:
+		return http.StatusServiceUnavailable
+	case ErrTimeout:
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusInternalServerError
+}
+
+// CodeForStatus returns the ErrorCode of the failures reported with status,
+// or "" when the status does not belong to any class.
+func CodeForStatus(status int) ErrorCode {
+	switch status {
+	case http.StatusBadRequest:
+		return ErrBadPayload
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return ErrAuth
+	case http.StatusNotFound:
+		return ErrNotFound
+	case http.StatusMethodNotAllowed:
+		return ErrMethodNotAllowed
+	case http.StatusConflict, http.StatusUnprocessableEntity:
+		return ErrConflict
+	case http.StatusTooManyRequests:
+		return ErrQuota
+	case http.StatusInternalServerError:
+		return ErrInternal
+	case http.StatusBadGateway:
+		return ErrGmail
+	case http.StatusServiceUnavailable:
+		return ErrUnavailable
+	case http.StatusGatewayTimeout:
+		return ErrTimeout
+	}
+	return ""
+}
+
+// statusError is an error reported to the client with a specific HTTP status,
+// along with the status Gmail answered with when a Gmail API call failed.
+type statusError struct {
+	status      int
+	gmailStatus int
+	err         error
+}
+
+func (e *statusError) Error() string { return e.err.Error() }
+
+func (e *statusError) Unwrap() error { return e.err }
+
+// Is reports whether target is the ErrorCode of the error's status.
+func (e *statusError) Is(target error) bool {
+	code, ok := target.(ErrorCode)
+	return ok && code == CodeForStatus(e.status)
+}
+
+// withStatus attaches the HTTP status that err should be reported with.
+func withStatus(status int, err error) error {
+	return &statusError{status: status, err: err}
+}
+
+// withDefaultStatus is like withStatus, but leaves errors that already carry
+// a status alone.
+func withDefaultStatus(status int, err error) error {
+	var se *statusError
+	if errors.As(err, &se) {
+		return err
+	}
+	return withStatus(status, err)
+}
+
+// gmailError describes the failure of a Gmail API call made to perform
+// action, attaching the status it should be reported with.
+func gmailError(action string, err error) error {
+	return &statusError{
+		status:      gmailStatus(err),
+		gmailStatus: upstreamStatus(err),
+		err:         fmt.Errorf("failed to %s: %v", action, err),
+	}
+}
+
+// upstreamStatus returns the HTTP status of the failed Gmail API call behind
+// err, or 0 when err did not come from Gmail.
+func upstreamStatus(err error) int {
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Code
+	}
+
+	var se *statusError
+	for errors.As(err, &se) {
+		if se.gmailStatus != 0 {
+			return se.gmailStatus
+		}
+		err = se.err
+	}
+	return 0
+}
+
+// gmailStatus returns the status a failed Gmail API call is reported with: a
+// token that could not be refreshed as 401, Gmail's own rejection of the
+// credentials or token as 401 or 403, exhausted quota as 429, a missed
+// deadline as 504 and any other failure as 502.
+func gmailStatus(err error) int {
+	var apiErr *googleapi.Error
+	var refreshErr *oauth2.RetrieveError
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	case errors.As(err, &refreshErr):
+		return http.StatusUnauthorized
+	case errors.As(err, &apiErr) && apiErr.Code == http.StatusTooManyRequests:
+		return http.StatusTooManyRequests
+	case errors.As(err, &apiErr) && apiErr.Code == http.StatusForbidden && isRateLimited(apiErr):
+		return http.StatusTooManyRequests
+	case errors.As(err, &apiErr) && (apiErr.Code == http.StatusUnauthorized || apiErr.Code == http.StatusForbidden):
+		return apiErr.Code
+	}
+	return http.StatusBadGateway
+}
+
+// isRateLimited reports whether a 403 from Gmail reports an exhausted quota
+// or rate limit, such as userRateLimitExceeded, rather than a lack of
+// permission.
+func isRateLimited(apiErr *googleapi.Error) bool {
+	for _, item := range apiErr.Errors {
+		if strings.HasSuffix(item.Reason, "LimitExceeded") || item.Reason == "quotaExceeded" {
+			return true
+		}
+	}
+	return false
+}
+
+// errorStatus returns the HTTP status attached to err. Errors of Gmail API
+// calls without one are classified by gmailStatus; anything else is a 500.
+func errorStatus(err error) int {
+	var se *statusError
+	var apiErr *googleapi.Error
+	switch {
+	case errors.As(err, &se):
+		return se.status
+	case errors.As(err, &apiErr), errors.Is(err, context.DeadlineExceeded):
+		return gmailStatus(err)
+	}
+	return http.StatusInternalServerError
+}
+
+// writeError writes err as a JSON ErrorResponse, its message prefixed with the
+// status text as in "Bad request. <error>", along with the ErrorCode of the
+// status and the status of the Gmail API call that failed, if any.
+func writeError(w http.ResponseWriter, err error) {
+	status := errorStatus(err)
+	response := errorResponse(status, err.Error())
+	if code := upstreamStatus(err); code != 0 {
+		response.GmailStatus = code
+		w.Header().Set(gmailStatusHeader, strconv.Itoa(code))
+	}
+
+	header := w.Header()
+	header.Del("Content-Length")
+	header.Set("Content-Type", "application/json")
+	header.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// errorResponse returns the ErrorResponse reporting message with status.
+func errorResponse(status int, message string) ErrorResponse {
+	text := http.StatusText(status)
+	return ErrorResponse{
+		Error: fmt.Sprintf("%s%s. %s", text[:1], strings.ToLower(text[1:]), message),
+		Code:  CodeForStatus(status),
+	}
+}
@@ -0,0 +1,160 @@
+package gosender
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestErrorCodes(t *testing.T) {
+	tests := []struct {
+		status int
+		want   ErrorCode
+	}{
+		{http.StatusBadRequest, ErrBadPayload},
+		{http.StatusUnauthorized, ErrAuth},
+		{http.StatusForbidden, ErrAuth},
+		{http.StatusNotFound, ErrNotFound},
+		{http.StatusMethodNotAllowed, ErrMethodNotAllowed},
+		{http.StatusConflict, ErrConflict},
+		{http.StatusUnprocessableEntity, ErrConflict},
+		{http.StatusTooManyRequests, ErrQuota},
+		{http.StatusInternalServerError, ErrInternal},
+		{http.StatusBadGateway, ErrGmail},
+		{http.StatusServiceUnavailable, ErrUnavailable},
+		{http.StatusGatewayTimeout, ErrTimeout},
+		{http.StatusTeapot, ""},
+	}
+	for _, tt := range tests {
+		t.Run(http.StatusText(tt.status), func(t *testing.T) {
+			code := CodeForStatus(tt.status)
+			if code != tt.want {
+				t.Fatalf("CodeForStatus(%d) = %q; want %q", tt.status, code, tt.want)
+			}
+			if code == "" {
+				return
+			}
+			if tt.status != http.StatusForbidden && tt.status != http.StatusUnprocessableEntity && code.StatusCode() != tt.status {
+				t.Errorf("%s.StatusCode() = %d; want %d", code, code.StatusCode(), tt.status)
+			}
+			if err := withStatus(tt.status, errors.New("failed")); !errors.Is(err, code) {
+				t.Errorf("an error reported with %d does not match %s", tt.status, code)
+			}
+		})
+	}
+}
+
+func TestErrorResponseCodes(t *testing.T) {
+	tests := []struct {
+		name    string
+		tenants []string
+		method  string
+		path    string
+		body    string
+		header  map[string]string
+		want    ErrorCode
+	}{
+		{name: "wrong method", method: http.MethodGet, path: "/send", want: ErrMethodNotAllowed},
+		{name: "no payload", method: http.MethodPost, path: "/send", header: map[string]string{"Content-Type": "application/x-www-form-urlencoded"}, want: ErrBadPayload},
+		{name: "batch not an array", method: http.MethodPost, path: "/batch", body: "{}", want: ErrBadPayload},
+		{name: "unknown job", method: http.MethodGet, path: "/status/unknown", want: ErrNotFound},
+		{name: "status by POST", method: http.MethodPost, path: "/status/unknown", want: ErrMethodNotAllowed},
+		{name: "unknown batch", method: http.MethodPost, path: "/cancel/unknown", want: ErrNotFound},
+		{name: "unknown tenant", tenants: []string{"acme"}, method: http.MethodGet, path: "/status/unknown", header: map[string]string{"X-Tenant-ID": "other"}, want: ErrNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newGmailStub(t)
+			h := stub.newServer(stub.withTenants(tt.tenants...)).Handler()
+
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			for name, value := range tt.header {
+				req.Header.Set(name, value)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.want.StatusCode() || rec.Header().Get(errorCodeHeader) != string(tt.want) {
+				t.Errorf("%s %s = %d with code %q; want %d with %q", tt.method, tt.path, rec.Code, rec.Header().Get(errorCodeHeader), tt.want.StatusCode(), tt.want)
+			}
+		})
+	}
+}
+
+func TestUnavailableResponses(t *testing.T) {
+	tests := []struct {
+		name  string
+		opts  []Option
+		setup func(t *testing.T, stub *gmailStub, s *Server)
+	}{
+		{
+			name:  "closed server",
+			setup: func(_ *testing.T, _ *gmailStub, s *Server) { s.Close() },
+		},
+		{
+			name: "route timeout",
+			opts: []Option{func(c *Config) { c.RouteTimeouts = map[string]time.Duration{"send": 10 * time.Millisecond} }},
+			setup: func(t *testing.T, stub *gmailStub, _ *Server) {
+				stub.release = make(chan struct{})
+				t.Cleanup(func() { close(stub.release) })
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newGmailStub(t)
+			s := stub.newServer(tt.opts...)
+			tt.setup(t, stub, s)
+
+			payload := stub.payload(t, map[string]any{"to": "to@example.com", "subject": "Hello", "messageBody": "Hi"})
+			rec := postPayload(s.Handler(), "/send", payload, nil)
+			if rec.Code != http.StatusServiceUnavailable || rec.Header().Get(errorCodeHeader) != string(ErrUnavailable) {
+				t.Errorf("send = %d with code %q; want %d with %q", rec.Code, rec.Header().Get(errorCodeHeader), http.StatusServiceUnavailable, ErrUnavailable)
+			}
+			var response ErrorResponse
+			decodeJSON(t, rec, &response)
+			if response.Code != ErrUnavailable {
+				t.Errorf("response code = %q; want %q", response.Code, ErrUnavailable)
+			}
+		})
+	}
+}
+
+func TestGmailStatusInErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		gmailStatus int
+		envelope    bool
+		wantStatus  int
+		wantCode    ErrorCode
+	}{
+		{name: "forbidden", gmailStatus: http.StatusForbidden, wantStatus: http.StatusForbidden, wantCode: ErrAuth},
+		{name: "rate limited", gmailStatus: http.StatusTooManyRequests, wantStatus: http.StatusTooManyRequests, wantCode: ErrQuota},
+		{name: "server error", gmailStatus: http.StatusInternalServerError, wantStatus: http.StatusBadGateway, wantCode: ErrGmail},
+		{name: "forbidden in envelope", gmailStatus: http.StatusForbidden, envelope: true, wantStatus: http.StatusForbidden, wantCode: ErrAuth},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newGmailStub(t)
+			stub.sendStatus = tt.gmailStatus
+			h := stub.newServer(func(c *Config) { c.ResponseEnvelope = tt.envelope }).Handler()
+
+			payload := stub.payload(t, map[string]any{"to": "to@example.com", "subject": "Hello", "messageBody": "Hi"})
+			rec := postPayload(h, "/send", payload, nil)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("send = %d %s; want %d", rec.Code, rec.Body, tt.wantStatus)
+			}
+			if got := rec.Header().Get("Content-Type"); got != "application/json" {
+				t.Errorf("Content-Type = %q; want application/json", got)
+			}
+
+			var response ErrorResponse
+			decodeJSON(t, rec, &response)
+			if response.Code != tt.wantCode || response.GmailStatus != tt.gmailStatus || !strings.HasPrefix(response.Error, http.StatusText(tt.wantStatus)[:1]) {
+				t.Errorf("response = %+v; want code %q and gmailStatus %d", response, tt.wantCode, tt.gmailStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package gosender
+
+import (
+	"context"
+	"fmt"
+	"mime"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// expandSent fetches the stored metadata of a sent message, headers included,
+// to stand in for the minimal message returned by Send. The headers are also
+// returned decoded, keyed by name.
+func expandSent(ctx context.Context, service *gmail.Service, sent *gmail.Message) (*gmail.Message, map[string][]string, error) {
+	full, err := service.Users.Messages.Get(gmailUser(ctx), sent.Id).Format("metadata").Context(ctx).Do()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get sent message: %v", err)
+	}
+
+	return full, decodedHeaders(full), nil
+}
+
+// decodedHeaders returns the headers of a fetched message keyed by name, with
+// RFC 2047 encoded words decoded.
+func decodedHeaders(m *gmail.Message) map[string][]string {
+	if m.Payload == nil {
+		return nil
+	}
+
+	return decodeHeaders(m.Payload.Headers)
+}
+
+// decodeHeaders returns the given headers keyed by name, with RFC 2047
+// encoded words decoded. Values that fail to decode are kept as is.
+func decodeHeaders(fields []*gmail.MessagePartHeader) map[string][]string {
+	var decoder mime.WordDecoder
+	headers := make(map[string][]string, len(fields))
+	for _, h := range fields {
+		value, err := decoder.DecodeHeader(h.Value)
+		if err != nil {
+			value = h.Value
+		}
+		headers[h.Name] = append(headers[h.Name], value)
+	}
+
+	return headers
+}
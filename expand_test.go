@@ -0,0 +1,57 @@
+package gosender
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestIncludeHeaders(t *testing.T) {
+	tests := []struct {
+		name        string
+		include     bool
+		wantHeaders map[string]string
+	}{
+		{name: "minimal by default"},
+		{
+			name:    "expanded",
+			include: true,
+			wantHeaders: map[string]string{
+				"Message-ID": "<msg-1@mail.example.com>",
+				"Subject":    "Stored msg-1",
+				"To":         "Jürgen <to@example.com>",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newGmailStub(t)
+			stub.headers = map[string]map[string]string{"msg-1": {"To": "=?utf-8?q?J=C3=BCrgen?= <to@example.com>"}}
+			h := stub.newServer().Handler()
+			payload := stub.payload(t, map[string]any{"to": "to@example.com", "subject": "Hello", "messageBody": "Hi", "includeHeaders": tt.include})
+
+			rec := postPayload(h, "/send", payload, nil)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("send = %d %s", rec.Code, rec.Body)
+			}
+			var response SendResponse
+			decodeJSON(t, rec, &response)
+			if len(response.Warnings) > 0 {
+				t.Errorf("warnings = %q; want none", response.Warnings)
+			}
+			if tt.wantHeaders == nil {
+				if response.Headers != nil || response.Output.Payload != nil {
+					t.Errorf("response carries headers %v; want the minimal message", response.Headers)
+				}
+				return
+			}
+			for name, want := range tt.wantHeaders {
+				if got := response.Headers[name]; len(got) != 1 || got[0] != want {
+					t.Errorf("header %s = %q; want %q", name, got, want)
+				}
+			}
+			if response.Output.Id != "msg-1" || response.Output.Payload == nil {
+				t.Errorf("output = %+v; want the full stored message", response.Output)
+			}
+		})
+	}
+}
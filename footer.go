@@ -0,0 +1,37 @@
+package gosender
+
+import "strings"
+
+// applyFooter appends the configured footers to the bodies of a structured
+// payload: FooterText to the plain-text body and FooterHTML to the HTML body,
+// just before its closing body tag. A plain-text body that was to be derived
+// from the HTML is derived first, so that it carries FooterText rather than a
+// conversion of FooterHTML.
+func (s *Server) applyFooter(p *Payload) {
+	text, html := s.config.FooterText, s.config.FooterHTML
+	if text == "" && html == "" {
+		return
+	}
+
+	if p.HTMLBody != "" && p.MessageBody == "" {
+		p.MessageBody = htmlToText(p.HTMLBody)
+	}
+	if text != "" {
+		p.MessageBody = strings.TrimRight(p.MessageBody, "\r\n") + "\n\n" + text
+	}
+	if html != "" && p.HTMLBody != "" {
+		p.HTMLBody = insertBeforeBodyEnd(p.HTMLBody, html)
+	}
+}
+
+// insertBeforeBodyEnd inserts fragment before the last </body> tag of html,
+// or appends it when there is none.
+func insertBeforeBodyEnd(html, fragment string) string {
+	const tag = "</body"
+	for i := len(html) - len(tag); i >= 0; i-- {
+		if strings.EqualFold(html[i:i+len(tag)], tag) {
+			return html[:i] + fragment + html[i:]
+		}
+	}
+	return html + fragment
+}
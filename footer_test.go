@@ -0,0 +1,68 @@
+package gosender
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestFooter(t *testing.T) {
+	const (
+		footerText = "-- \nSent by Example Corp. Unsubscribe at https://example.com/u"
+		footerHTML = `<p class="footer">Sent by Example Corp.</p>`
+	)
+	tests := []struct {
+		name     string
+		fields   map[string]any
+		wantText string
+		wantHTML string
+	}{
+		{
+			name:     "plain text",
+			fields:   map[string]any{"messageBody": "Hello"},
+			wantText: "Hello\n\n" + footerText,
+		},
+		{
+			name:     "HTML document",
+			fields:   map[string]any{"messageBody": "Hello", "htmlBody": "<html><body><p>Hello</p></BODY></html>"},
+			wantText: "Hello\n\n" + footerText,
+			wantHTML: "<html><body><p>Hello</p>" + footerHTML + "</BODY></html>",
+		},
+		{
+			name:     "HTML fragment with a derived text body",
+			fields:   map[string]any{"htmlBody": "<p>Hello</p>"},
+			wantText: "Hello\n\n" + footerText,
+			wantHTML: "<p>Hello</p>" + footerHTML,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newGmailStub(t)
+			h := stub.newServer(func(c *Config) {
+				c.FooterText = footerText
+				c.FooterHTML = footerHTML
+			}).Handler()
+			fields := map[string]any{"to": "to@example.com", "subject": "Hello"}
+			for k, v := range tt.fields {
+				fields[k] = v
+			}
+
+			rec := postPayload(h, "/send", stub.payload(t, fields), nil)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("send = %d %s", rec.Code, rec.Body)
+			}
+			bodies := messageBodies(t, stub.sent[0])
+			if got := normalizeNewlines(bodies["text/plain"]); got != tt.wantText {
+				t.Errorf("text body = %q; want %q", got, tt.wantText)
+			}
+			if got := bodies["text/html"]; got != tt.wantHTML {
+				t.Errorf("HTML body = %q; want %q", got, tt.wantHTML)
+			}
+		})
+	}
+}
+
+// normalizeNewlines turns the CRLF line endings of a message body into LF.
+func normalizeNewlines(s string) string {
+	return strings.TrimRight(strings.ReplaceAll(s, "\r\n", "\n"), "\n")
+}
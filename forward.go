@@ -0,0 +1,63 @@
+package gosender
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"strings"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// forwardedFilename names the message/rfc822 part of a forwarded message.
+const forwardedFilename = "forwarded.eml"
+
+// loadForward fetches the raw original of the message named by
+// ForwardMessageID so buildMessage can attach it. When the payload has no
+// subject, the original's prefixed with "Fwd: " is used.
+func loadForward(ctx context.Context, service *gmail.Service, p *Payload) error {
+	original, err := service.Users.Messages.Get(gmailUser(ctx), p.ForwardMessageID).Format("raw").Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("failed to get forwarded message: %v", err)
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(original.Raw, "="))
+	if err != nil {
+		return fmt.Errorf("failed to decode forwarded message: %v", err)
+	}
+	p.forwarded = raw
+
+	if p.Subject == "" {
+		var decoder mime.WordDecoder
+		subject := parseRawMessage(raw).value("Subject")
+		if decoded, err := decoder.DecodeHeader(subject); err == nil {
+			subject = decoded
+		}
+		p.Subject = "Fwd: " + subject
+	}
+
+	return nil
+}
+
+// forwardPart renders a forwarded message as a message/rfc822 attachment.
+// RFC 2046 does not allow message/rfc822 to be base64-encoded, so the original
+// is included as is, marked 8bit when it is not plain ASCII.
+func forwardPart(raw []byte) mimePart {
+	encoding := "7bit"
+	for _, b := range raw {
+		if b >= 0x80 {
+			encoding = "8bit"
+			break
+		}
+	}
+
+	return mimePart{
+		headers: []headerField{
+			{"Content-Type", "message/rfc822"},
+			{"Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": forwardedFilename})},
+			{"Content-Transfer-Encoding", encoding},
+		},
+		body: raw,
+	}
+}
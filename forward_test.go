@@ -0,0 +1,84 @@
+package gosender
+
+import (
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+func TestForward(t *testing.T) {
+	const original = "From: sender@example.org\r\nTo: owner@example.com\r\nSubject: =?utf-8?q?Quarterly_r=C3=A9sum=C3=A9?=\r\nMessage-ID: <original@example.org>\r\n\r\nThe original body.\r\n"
+	tests := []struct {
+		name        string
+		fields      map[string]any
+		wantStatus  int
+		wantSubject string
+	}{
+		{name: "subject from the original", fields: map[string]any{"forwardMessageId": "orig"}, wantStatus: http.StatusOK, wantSubject: "Fwd: Quarterly résumé"},
+		{name: "own subject", fields: map[string]any{"forwardMessageId": "orig", "subject": "FYI"}, wantStatus: http.StatusOK, wantSubject: "FYI"},
+		{name: "unknown message", fields: map[string]any{"forwardMessageId": "missing"}, wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newGmailStub(t)
+			stub.originals = map[string]string{"orig": original}
+			h := stub.newServer().Handler()
+			fields := map[string]any{"to": "to@example.com", "messageBody": "See below."}
+			for k, v := range tt.fields {
+				fields[k] = v
+			}
+
+			rec := postPayload(h, "/send", stub.payload(t, fields), nil)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("send = %d %s; want %d", rec.Code, rec.Body, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				if sent, _, _ := stub.counts(); sent != 0 {
+					t.Errorf("sent %d messages; want none", sent)
+				}
+				return
+			}
+
+			msg, err := mail.ReadMessage(strings.NewReader(stub.sent[0]))
+			if err != nil {
+				t.Fatalf("failed to parse sent message: %v", err)
+			}
+			if subject, _ := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject")); subject != tt.wantSubject {
+				t.Errorf("Subject = %q; want %q", subject, tt.wantSubject)
+			}
+			mediaType, params, _ := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+			if mediaType != "multipart/mixed" {
+				t.Fatalf("Content-Type = %q; want multipart/mixed", msg.Header.Get("Content-Type"))
+			}
+			reader := multipart.NewReader(msg.Body, params["boundary"])
+			var forwarded *multipart.Part
+			for {
+				part, err := reader.NextRawPart()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatalf("failed to read part: %v", err)
+				}
+				if strings.HasPrefix(part.Header.Get("Content-Type"), "message/rfc822") {
+					forwarded = part
+					break
+				}
+			}
+			if forwarded == nil {
+				t.Fatal("no message/rfc822 part")
+			}
+			if forwarded.FileName() != forwardedFilename || forwarded.Header.Get("Content-Transfer-Encoding") != "7bit" {
+				t.Errorf("forwarded part headers = %v; want a 7bit attachment named %s", forwarded.Header, forwardedFilename)
+			}
+			body, _ := io.ReadAll(forwarded)
+			if string(body) != original {
+				t.Errorf("forwarded part = %q; want the original %q", body, original)
+			}
+		})
+	}
+}
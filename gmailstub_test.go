@@ -0,0 +1,512 @@
+package gosender
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// stubClientID is the OAuth client ID of the credentials the stub hands out.
+const stubClientID = "stub-client.apps.googleusercontent.com"
+
+// gmailStub is a fake Gmail API, token information endpoint and OAuth token
+// endpoint on an httptest server, recording what requests did to the mailbox.
+type gmailStub struct {
+	server *httptest.Server
+
+	mu sync.Mutex
+
+	// email, audience and scope are reported by the token information
+	// endpoint, which rejects every token when tokenStatus is set.
+	email       string
+	audience    string
+	scope       string
+	tokenStatus int
+
+	// revoked fails token refreshes with invalid_grant, as for a grant the
+	// user revoked, and profileStatus fails profile requests with the given
+	// status when set.
+	revoked       bool
+	profileStatus int
+
+	// sendStatus fails sends with the given status when set, and release, when
+	// non-nil, holds sends until it is closed. attempts counts the sends and
+	// inserts received, held or not. With noMessageID set, sends succeed
+	// without the ID of the message.
+	sendStatus  int
+	noMessageID bool
+	release     chan struct{}
+	attempts    int
+
+	// rejected holds the access tokens sends fail with 401 for, as for a
+	// token revoked before it expired.
+	rejected map[string]bool
+
+	// trashRelease, when non-nil, holds trash requests until it is closed or
+	// the request is canceled, which fails the trash.
+	trashRelease chan struct{}
+
+	// headers holds header fields of stored messages, by message ID, beyond
+	// the Message-ID and Subject every stored message has.
+	headers map[string]map[string]string
+
+	// originals holds the raw messages returned, by message ID, for
+	// format=raw gets.
+	originals map[string]string
+
+	// labels lists the IDs of the messages carrying each label. Listings
+	// come in pages of pageSize messages when it is set, paged through a
+	// copy of the listing taken by its first page.
+	labels   map[string][]string
+	pageSize int
+	listing  []string
+
+	// received holds when stored messages were received, by message ID.
+	// Listings with a "before:seconds" query leave out the messages received
+	// since then; messages without a time count as long received.
+	received map[string]time.Time
+
+	// raws holds the base64url Raw of the messages sent or inserted, as received.
+	raws []string
+
+	// mailboxes holds the user ID every Gmail API request was made for.
+	mailboxes []string
+
+	// refreshes holds the tenant query parameter of every token refresh,
+	// letting tests tell apart credentials whose token_uri sets it.
+	refreshes []string
+
+	sent           []string
+	inserted       []string
+	trashed        []string
+	untrashed      []string
+	modified       []modification
+	tokenInfoCalls int
+	profileCalls   int
+	listCalls      int
+	nextID         int
+}
+
+// modification is a label change the stub received for a message.
+type modification struct {
+	id      string
+	request gmail.ModifyMessageRequest
+}
+
+// newGmailStub starts a gmailStub granting the https://mail.google.com/ scope
+// to the tokens of stubClientID, closed along with the test.
+func newGmailStub(t *testing.T) *gmailStub {
+	t.Helper()
+	return startGmailStub(t, false)
+}
+
+// newTLSGmailStub starts a gmailStub as newGmailStub does, served over HTTPS
+// with a self-signed certificate. Failed handshakes are not logged.
+func newTLSGmailStub(t *testing.T) *gmailStub {
+	t.Helper()
+	return startGmailStub(t, true)
+}
+
+// startGmailStub starts a gmailStub served over HTTPS when useTLS is set.
+func startGmailStub(t *testing.T, useTLS bool) *gmailStub {
+	t.Helper()
+	stub := &gmailStub{
+		email:    "owner@example.com",
+		audience: stubClientID,
+		scope:    "https://mail.google.com/",
+		labels:   make(map[string][]string),
+	}
+	stub.server = httptest.NewUnstartedServer(http.HandlerFunc(stub.serveHTTP))
+	if useTLS {
+		stub.server.Config.ErrorLog = log.New(io.Discard, "", 0)
+		stub.server.StartTLS()
+	} else {
+		stub.server.Start()
+	}
+	t.Cleanup(stub.server.Close)
+	return stub
+}
+
+// config returns a Config pointing the server at the stub.
+func (stub *gmailStub) config() *Config {
+	return &Config{
+		GmailEndpoint:     stub.server.URL + "/",
+		TokenInfoEndpoint: stub.server.URL + "/tokeninfo",
+	}
+}
+
+// newServer returns a Server talking to the stub, with its configuration
+// adjusted by opts, whose logs are discarded.
+func (stub *gmailStub) newServer(opts ...Option) *Server {
+	opts = append([]Option{WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))}, opts...)
+	return NewServer(stub.config(), opts...)
+}
+
+// credentials returns installed-app credentials of stubClientID whose token
+// endpoint is the stub's.
+func (stub *gmailStub) credentials() json.RawMessage {
+	return json.RawMessage(fmt.Sprintf(`{"installed":{"client_id":%q,"client_secret":"secret","token_uri":%q,"redirect_uris":["http://localhost"]}}`,
+		stubClientID, stub.server.URL+"/token"))
+}
+
+// payload returns the JSON of a payload made of fields, along with the stub's
+// credentials and an unexpired access token unless fields set their own.
+func (stub *gmailStub) payload(t *testing.T, fields map[string]any) string {
+	t.Helper()
+	p := map[string]any{
+		"credentials": stub.credentials(),
+		"token":       map[string]any{"access_token": "access-token", "expiry": "2099-01-01T00:00:00Z"},
+	}
+	for k, v := range fields {
+		p[k] = v
+	}
+	value, err := json.Marshal(p)
+	if err != nil {
+		t.Fatalf("failed to marshal payload: %v", err)
+	}
+	return string(value)
+}
+
+// withTenants serves the tenants named by ids, each with the stub's
+// credentials and identified by the X-Tenant-ID header.
+func (stub *gmailStub) withTenants(ids ...string) Option {
+	return func(c *Config) {
+		c.TenantHeader = "X-Tenant-ID"
+		c.Tenants = make(map[string]json.RawMessage)
+		for _, id := range ids {
+			c.Tenants[id] = stub.credentials()
+		}
+	}
+}
+
+// setLabel lists ids as the messages carrying label.
+func (stub *gmailStub) setLabel(label string, ids ...string) {
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+	stub.labels[label] = ids
+}
+
+// counts returns how many messages were sent, inserted and trashed so far.
+func (stub *gmailStub) counts() (sent, inserted, trashed int) {
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+	return len(stub.sent), len(stub.inserted), len(stub.trashed)
+}
+
+// waitAttempts waits until the stub received n sends or inserts.
+func waitAttempts(t *testing.T, stub *gmailStub, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		stub.mu.Lock()
+		attempts := stub.attempts
+		stub.mu.Unlock()
+		if attempts >= n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("the stub did not receive %d sends", n)
+}
+
+// waitTrashed waits until the stub trashed n messages.
+func waitTrashed(t *testing.T, stub *gmailStub, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, _, trashed := stub.counts(); trashed >= n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("the stub did not trash %d messages", n)
+}
+
+func (stub *gmailStub) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	path := r.URL.Path
+	if rest, ok := strings.CutPrefix(path, "/gmail/v1/users/"); ok {
+		var user string
+		user, path, _ = strings.Cut(rest, "/")
+		path = "/" + path
+		stub.mu.Lock()
+		stub.mailboxes = append(stub.mailboxes, user)
+		stub.mu.Unlock()
+	}
+
+	switch {
+	case r.URL.Path == "/tokeninfo":
+		stub.mu.Lock()
+		stub.tokenInfoCalls++
+		status, info := stub.tokenStatus, map[string]string{"scope": stub.scope, "aud": stub.audience, "email": stub.email, "expires_in": "3599"}
+		stub.mu.Unlock()
+		if status != 0 {
+			w.WriteHeader(status)
+			io.WriteString(w, `{"error":"invalid_token"}`)
+			return
+		}
+		json.NewEncoder(w).Encode(info)
+	case r.URL.Path == "/token":
+		stub.mu.Lock()
+		stub.refreshes = append(stub.refreshes, r.URL.Query().Get("tenant"))
+		revoked := stub.revoked
+		stub.mu.Unlock()
+		if revoked {
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, `{"error":"invalid_grant","error_description":"Token has been expired or revoked."}`)
+			return
+		}
+		io.WriteString(w, `{"access_token":"refreshed-token","token_type":"Bearer","expires_in":3600}`)
+	case path == "/profile":
+		stub.mu.Lock()
+		defer stub.mu.Unlock()
+		stub.profileCalls++
+		if stub.profileStatus != 0 {
+			w.WriteHeader(stub.profileStatus)
+			fmt.Fprintf(w, `{"error":{"code":%d,"message":"stubbed failure"}}`, stub.profileStatus)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"emailAddress": stub.email, "messagesTotal": 10, "threadsTotal": 7, "historyId": "12345"})
+	case r.Method == http.MethodPost && (path == "/messages/send" || path == "/messages"):
+		stub.deliver(w, r, path == "/messages")
+	case r.Method == http.MethodGet && path == "/messages":
+		stub.mu.Lock()
+		defer stub.mu.Unlock()
+		stub.listCalls++
+		offset, _ := strconv.Atoi(r.URL.Query().Get("pageToken"))
+		if offset == 0 {
+			stub.listing = nil
+			before, _ := strconv.ParseInt(strings.TrimPrefix(r.URL.Query().Get("q"), "before:"), 10, 64)
+			for _, id := range stub.labels[r.URL.Query().Get("labelIds")] {
+				if before == 0 || stub.received[id].Before(time.Unix(before, 0)) {
+					stub.listing = append(stub.listing, id)
+				}
+			}
+		}
+		ids, next := stub.listing[offset:], ""
+		if stub.pageSize > 0 && len(ids) > stub.pageSize {
+			ids, next = ids[:stub.pageSize], strconv.Itoa(offset+stub.pageSize)
+		}
+		var messages []map[string]string
+		for _, id := range ids {
+			messages = append(messages, map[string]string{"id": id})
+		}
+		json.NewEncoder(w).Encode(map[string]any{"messages": messages, "nextPageToken": next})
+	case r.Method == http.MethodPost && strings.HasPrefix(path, "/messages/"):
+		id, action, _ := strings.Cut(strings.TrimPrefix(path, "/messages/"), "/")
+		stub.mu.Lock()
+		trashRelease := stub.trashRelease
+		stub.mu.Unlock()
+		if action == "trash" && trashRelease != nil {
+			select {
+			case <-trashRelease:
+			case <-r.Context().Done():
+				return
+			}
+		}
+		stub.mu.Lock()
+		defer stub.mu.Unlock()
+		switch action {
+		case "trash":
+			stub.trashed = append(stub.trashed, id)
+			for label, ids := range stub.labels {
+				stub.labels[label] = without(ids, id)
+			}
+		case "untrash":
+			stub.untrashed = append(stub.untrashed, id)
+		case "modify":
+			var request gmail.ModifyMessageRequest
+			json.NewDecoder(r.Body).Decode(&request)
+			stub.modified = append(stub.modified, modification{id: id, request: request})
+			var labels []string
+			for _, label := range append([]string{"SENT"}, request.AddLabelIds...) {
+				if !containsFold(request.RemoveLabelIds, label) {
+					labels = append(labels, label)
+				}
+			}
+			json.NewEncoder(w).Encode(map[string]any{"id": id, "threadId": "thread-" + id, "labelIds": labels})
+			return
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"id": id, "threadId": "thread-" + id})
+	case r.Method == http.MethodGet && strings.HasPrefix(path, "/messages/"):
+		id := strings.TrimPrefix(path, "/messages/")
+		stub.mu.Lock()
+		original, ok := stub.originals[id]
+		stub.mu.Unlock()
+		if r.URL.Query().Get("format") == "raw" {
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]any{"id": id, "threadId": "thread-" + id, "raw": base64.URLEncoding.EncodeToString([]byte(original))})
+			return
+		}
+		headers := []map[string]string{
+			{"name": "Message-ID", "value": "<" + id + "@mail.example.com>"},
+			{"name": "Subject", "value": "Stored " + id},
+		}
+		stub.mu.Lock()
+		for name, value := range stub.headers[id] {
+			headers = append(headers, map[string]string{"name": name, "value": value})
+		}
+		stub.mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]any{
+			"id":       id,
+			"threadId": "thread-" + id,
+			"payload":  map[string]any{"headers": headers},
+		})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// deliver records a sent or inserted message and answers with its new ID.
+func (stub *gmailStub) deliver(w http.ResponseWriter, r *http.Request, insert bool) {
+	stub.mu.Lock()
+	stub.attempts++
+	release, status, noMessageID := stub.release, stub.sendStatus, stub.noMessageID
+	if stub.rejected[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")] {
+		status = http.StatusUnauthorized
+	}
+	stub.mu.Unlock()
+	if release != nil {
+		<-release
+	}
+	if status != 0 {
+		w.WriteHeader(status)
+		fmt.Fprintf(w, `{"error":{"code":%d,"message":"stubbed failure"}}`, status)
+		return
+	}
+
+	var message struct {
+		Raw string `json:"raw"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&message); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	raw, _ := base64.RawURLEncoding.DecodeString(strings.TrimRight(message.Raw, "="))
+
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+	stub.raws = append(stub.raws, message.Raw)
+	stub.nextID++
+	id := fmt.Sprintf("msg-%d", stub.nextID)
+	if insert {
+		stub.inserted = append(stub.inserted, string(raw))
+	} else {
+		stub.sent = append(stub.sent, string(raw))
+	}
+	if noMessageID {
+		io.WriteString(w, `{"labelIds":["SENT"]}`)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]any{"id": id, "threadId": "thread-" + id, "labelIds": []string{"SENT"}})
+}
+
+// without returns ids without id.
+func without(ids []string, id string) []string {
+	var kept []string
+	for _, other := range ids {
+		if other != id {
+			kept = append(kept, other)
+		}
+	}
+	return kept
+}
+
+// postPayload serves a form-encoded POST of the base64-encoded payload to
+// path through h, with the given request headers; "Host" sets the host the
+// request is made to.
+func postPayload(h http.Handler, path, payload string, header map[string]string) *httptest.ResponseRecorder {
+	form := url.Values{"payload": {base64.StdEncoding.EncodeToString([]byte(payload))}}
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	for name, value := range header {
+		if name == "Host" {
+			req.Host = value
+			continue
+		}
+		req.Header.Set(name, value)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+// decodeJSON decodes the body of rec into v, failing the test if it cannot.
+func decodeJSON(t *testing.T, rec *httptest.ResponseRecorder, v any) {
+	t.Helper()
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		t.Fatalf("failed to decode response %q: %v", rec.Body.String(), err)
+	}
+}
+
+// messageBodies parses a raw message and returns the decoded bodies of its
+// leaf parts by media type, walking nested multipart entities.
+func messageBodies(t *testing.T, raw string) map[string]string {
+	t.Helper()
+	msg, err := mail.ReadMessage(strings.NewReader(raw))
+	if err != nil {
+		t.Fatalf("failed to parse message: %v", err)
+	}
+	bodies := make(map[string]string)
+	collectBodies(t, textproto.MIMEHeader(msg.Header), msg.Body, bodies)
+	return bodies
+}
+
+// collectBodies adds the decoded bodies of the entity with the given header
+// and body, or that of its parts, to bodies.
+func collectBodies(t *testing.T, header textproto.MIMEHeader, body io.Reader, bodies map[string]string) {
+	t.Helper()
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		mediaType = "text/plain"
+	}
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		if header.Get("Content-Transfer-Encoding") == "quoted-printable" {
+			body = quotedprintable.NewReader(body)
+		} else if header.Get("Content-Transfer-Encoding") == "base64" {
+			body = base64.NewDecoder(base64.StdEncoding, body)
+		}
+		content, err := io.ReadAll(body)
+		if err != nil {
+			t.Fatalf("failed to read %s body: %v", mediaType, err)
+		}
+		bodies[mediaType] = string(content)
+		return
+	}
+
+	reader := multipart.NewReader(body, params["boundary"])
+	for {
+		part, err := reader.NextRawPart()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			t.Fatalf("failed to read part: %v", err)
+		}
+		collectBodies(t, part.Header, part, bodies)
+	}
+}
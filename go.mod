@@ -3,7 +3,6 @@ module gosender
 go 1.21.3
 
 require (
-	github.com/grokify/html-strip-tags-go v0.0.1
 	golang.org/x/oauth2 v0.13.0
 	google.golang.org/api v0.149.0
 )
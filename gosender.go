@@ -4,10 +4,16 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
+	"log/slog"
+	"mime"
 	"net/http"
+	"strings"
+	"sync"
+	"time"
 
-	strip "github.com/grokify/html-strip-tags-go"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 	"google.golang.org/api/gmail/v1"
@@ -15,99 +21,864 @@ import (
 
 // Payload represents the request payload structure.
 type Payload struct {
-	Credentials json.RawMessage `json:"credentials"`
-	Token       json.RawMessage `json:"token"`
-	MessageBody string          `json:"messageBody"`
+	Credentials        json.RawMessage `json:"credentials"`
+	Token              json.RawMessage `json:"token"`
+	UserID             string          `json:"userId"`
+	MessageBody        string          `json:"messageBody"`
+	RawBase64          string          `json:"rawBase64"`
+	HTMLBody           string          `json:"htmlBody"`
+	From               string          `json:"from"`
+	To                 AddressList     `json:"to"`
+	Cc                 AddressList     `json:"cc"`
+	Bcc                AddressList     `json:"bcc"`
+	ReplyTo            string          `json:"replyTo"`
+	Subject            string          `json:"subject"`
+	MessageID          string          `json:"messageId"`
+	InReplyTo          string          `json:"inReplyTo"`
+	References         []string        `json:"references"`
+	ThreadID           string          `json:"threadId"`
+	ThreadTopic        string          `json:"threadTopic"`
+	ThreadIndex        string          `json:"threadIndex"`
+	ReplyToMessageID   string          `json:"replyToMessageId"`
+	ForwardMessageID   string          `json:"forwardMessageId"`
+	CalendarInvite     string          `json:"calendarInvite"`
+	VCard              string          `json:"vcard"`
+	Priority           string          `json:"priority"`
+	Bulk               bool            `json:"bulk"`
+	RequestReadReceipt bool            `json:"requestReadReceipt"`
+	TrackOpens         bool            `json:"trackOpens"`
+	FeedbackID         string          `json:"feedbackId"`
+	SeparateThread     bool            `json:"separateThread"`
+	EntityRefID        string          `json:"entityRefId"`
+	IncludeHeaders     bool            `json:"includeHeaders"`
+	Attachments        []Attachment    `json:"attachments"`
+	Report             *DeliveryReport `json:"report"`
+	Labels             []string        `json:"labels"`
+	TrashLabels        []string        `json:"trashLabels"`
+	SplitRecipients    int             `json:"splitRecipients"`
+	SkipSent           bool            `json:"skipSent"`
+	AllowEmpty         bool            `json:"allowEmpty"`
+	DryRun             bool            `json:"dryRun"`
+	Mode               string          `json:"mode"`
+	BuildMode          string          `json:"buildMode"`
+	InternalDate       string          `json:"internalDate"`
+	Query              string          `json:"query"`
+
+	internalDate  time.Time
+	forwarded     []byte
+	trackingToken string
+	suppressed    []string
+
+	// boundaryPrefix starts the boundaries of the message's multiparts, in
+	// place of defaultBoundaryPrefix when set.
+	boundaryPrefix string
+
+	// skipCleanup leaves the trashing of existing messages, and the scope
+	// check it needs, to the caller, as for the parts of a split send.
+	skipCleanup bool
 }
 
-// ErrorResponse represents an error response structure.
+// ErrorResponse represents an error response structure: the error message,
+// the ErrorCode classifying it and, when a Gmail API call failed the request,
+// the HTTP status Gmail answered with.
 type ErrorResponse struct {
-	Error string `json:"error"`
+	Error       string    `json:"error"`
+	Code        ErrorCode `json:"code,omitempty"`
+	GmailStatus int       `json:"gmailStatus,omitempty"`
 }
 
 // SendResponse represents a successful send response structure.
+// Token is only populated when Config.IncludeToken is enabled, Headers when
+// the payload sets IncludeHeaders and TrackingToken when it sets TrackOpens.
+// TokenRefreshed reports that the token was refreshed after Google rejected
+// it, so that clients can store the new one.
+// Suppressed lists the recipients left out for being on the suppression list;
+// when that was all of them nothing is sent and Status is "nothing_sent".
+// Receipt is a signed JWT of the Receipt claims, issued with Config.ReceiptKey.
+// Replays of an idempotent send answer with its response, without the Token.
+// UndoID identifies the messages trashed by the send for /undo/{id}, when
+// Config.UndoTTL enables undo.
 type SendResponse struct {
-	Token  string         `json:"token"`
-	Output *gmail.Message `json:"output"`
+	RequestID      string              `json:"requestId"`
+	UndoID         string              `json:"undoId,omitempty"`
+	Status         string              `json:"status,omitempty"`
+	Token          string              `json:"token,omitempty"`
+	TokenRefreshed bool                `json:"tokenRefreshed,omitempty"`
+	Output         *gmail.Message      `json:"output"`
+	Headers        map[string][]string `json:"headers,omitempty"`
+	Suppressed     []string            `json:"suppressed,omitempty"`
+	TrackingToken  string              `json:"trackingToken,omitempty"`
+	Receipt        string              `json:"receipt,omitempty"`
+	Warnings       []string            `json:"warnings,omitempty"`
+}
+
+// ProgressEvent represents a single line of the NDJSON progress stream.
+type ProgressEvent struct {
+	Label       string        `json:"label,omitempty"`
+	Trashed     int           `json:"trashed"`
+	Result      *SendResponse `json:"result,omitempty"`
+	Error       string        `json:"error,omitempty"`
+	GmailStatus int           `json:"gmailStatus,omitempty"`
+}
+
+// Server serves the gosender HTTP endpoints.
+type Server struct {
+	config  *Config
+	store   Store
+	metrics *metrics
+	limiter *domainLimiter
+	tenants *tenantLimiter
+	logger  *slog.Logger
+
+	// transport carries the server's outbound requests to Gmail.
+	transport http.RoundTripper
+
+	// jobSlots bounds the number of asynchronous sends running at once, and
+	// jobs tracks the background work not finished yet.
+	jobSlots chan struct{}
+	jobs     sync.WaitGroup
+
+	mu     sync.Mutex
+	closed bool
+
+	// running holds the batches and asynchronous sends that can be canceled,
+	// by ID.
+	runningMu sync.Mutex
+	running   map[string]*cancelable
+
+	// dedupMu serializes the Message-ID deduplication checks, and
+	// idempotencyMu the claims of idempotency keys.
+	dedupMu       sync.Mutex
+	idempotencyMu sync.Mutex
+
+	// undoMu serializes the updates of undo records.
+	undoMu sync.Mutex
+}
+
+// NewServer returns a Server using the given configuration, as adjusted by
+// opts; config itself is left unchanged and may be nil when opts suffice.
+// An in-memory store is used unless Config.Store is set, and slog.Default
+// unless Config.Logger is set. The logger is always wrapped so that secrets
+// are redacted from its output. Settings left at zero which would otherwise
+// turn a feature off, such as Config.IdempotencyTTL and Config.JobTTL, get
+// the defaults LoadConfig uses. The configuration is not validated: one not
+// made by LoadConfig should be checked with Config.Validate first.
+func NewServer(config *Config, opts ...Option) *Server {
+	effective := Config{}
+	if config != nil {
+		effective = *config
+	}
+	for _, opt := range opts {
+		opt(&effective)
+	}
+	effective.applyDefaults()
+	config = &effective
+
+	store := config.Store
+	if store == nil {
+		store = NewMemoryStore()
+	}
+
+	logger := config.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	logger = slog.New(NewRedactingHandler(logger.Handler()))
+
+	return &Server{
+		config:  config,
+		store:   store,
+		metrics: newMetrics(config.MetricsMaxDomains),
+		limiter: newDomainLimiter(config.DomainRateLimits),
+		tenants: newTenantLimiter(config.TenantRateLimits, config.TenantDailyQuotas),
+		logger:  logger,
+
+		transport: newTransport(config),
+		jobSlots:  make(chan struct{}, max(1, config.AsyncWorkers)),
+		running:   make(map[string]*cancelable),
+	}
+}
+
+// Handler returns the HTTP handler serving all gosender endpoints.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/send", s.withTimeout("send", s.withTenant(s.handleRequest)))
+	mux.Handle("/undo/", s.withTimeout("undo", s.withTenant(s.handleUndo)))
+	mux.Handle("/trash", s.withTimeout("trash", s.withTenant(s.handleTrash)))
+	mux.Handle("/batch", s.withTimeout("batch", s.withTenant(s.handleBatch)))
+	mux.Handle("/status/", s.withTimeout("status", s.withTenant(s.handleStatus)))
+	mux.Handle("/cancel/", s.withTimeout("cancel", s.withTenant(s.handleCancel)))
+	mux.Handle("/quota", s.withTimeout("quota", s.withTenant(s.handleQuota)))
+	mux.Handle("/metrics", s.withTimeout("metrics", s.metrics))
+
+	return s.withRequestID(s.withCompression(s.withEnvelope(s.withOpen(s.withRetryBudget(mux)))))
+}
+
+// Close stops the server from accepting requests, waits for the pending
+// asynchronous sends to finish and closes the idle outbound connections.
+// Requests already being handled are not waited for; shut down the
+// http.Server first for that. Requests made after Close fail with
+// 503 Service Unavailable, and closing again returns ErrServerClosed.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ErrServerClosed
+	}
+	s.closed = true
+	s.mu.Unlock()
+
+	s.jobs.Wait()
+	if t, ok := s.transport.(interface{ CloseIdleConnections() }); ok {
+		t.CloseIdleConnections()
+	}
+
+	return nil
+}
+
+// newTransport returns a transport of the server's own, so that closing it
+// leaves the connections of the rest of the process alone, using the
+// configured proxy and TLS configuration when set. Certificate verification is
+// only ever skipped in builds with the gosendertest tag.
+func newTransport(config *Config) http.RoundTripper {
+	t, ok := http.DefaultTransport.(*http.Transport)
+	if !ok {
+		return http.DefaultTransport
+	}
+
+	t = t.Clone()
+	if config.Proxy != nil {
+		t.Proxy = http.ProxyURL(config.Proxy)
+	}
+	if config.TLSConfig != nil {
+		t.TLSClientConfig = config.TLSConfig.Clone()
+		t.TLSClientConfig.InsecureSkipVerify = config.TLSConfig.InsecureSkipVerify && insecureTLSAllowed
+	}
+	return t
+}
+
+// track runs fn in the background, tracked so that Close waits for it. It
+// returns false without running fn once the server is closed.
+func (s *Server) track(fn func()) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return false
+	}
+	s.jobs.Add(1)
+	go func() {
+		defer s.jobs.Done()
+		fn()
+	}()
+
+	return true
+}
+
+// withOpen rejects requests once the server is closed.
+func (s *Server) withOpen(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		closed := s.closed
+		s.mu.Unlock()
+
+		if closed {
+			writeError(w, withStatus(http.StatusServiceUnavailable, ErrServerClosed))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
 }
 
 // handleRequest handles the HTTP request to send an email.
-func handleRequest(w http.ResponseWriter, r *http.Request) {
+func (s *Server) handleRequest(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed. Only POST requests are allowed.", http.StatusMethodNotAllowed)
+	payload, ok := readPayload(w, r)
+	if !ok {
 		return
 	}
 
-	if err := r.ParseForm(); err != nil {
-		http.Error(w, "Bad request. Failed to parse form.", http.StatusBadRequest)
+	if err := validatePayload(payload); err != nil {
+		writeError(w, withStatus(http.StatusBadRequest, err))
+		return
+	}
+	if _, err := s.cleanupLabels(payload); err != nil {
+		writeError(w, err)
 		return
 	}
 
-	payloadStr := r.FormValue("payload")
-	if payloadStr == "" {
-		http.Error(w, "Bad request. Payload not provided.", http.StatusBadRequest)
+	idempotencyKey := r.Header.Get(idempotencyKeyHeader)
+	if err := validateIdempotencyKey(idempotencyKey); err != nil {
+		writeError(w, withStatus(http.StatusBadRequest, err))
 		return
 	}
 
-	payload, err := decodePayload(payloadStr)
+	backoff, err := s.requestBackoff(r)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Bad request. %s", err.Error()), http.StatusBadRequest)
+		writeError(w, withStatus(http.StatusBadRequest, err))
 		return
 	}
+	r = r.WithContext(contextWithBackoff(r.Context(), backoff))
 
-	client, err := getClient(payload)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Internal server error. %s", err.Error()), http.StatusInternalServerError)
+	ctx, timing := contextWithTiming(r.Context())
+	if payload.DryRun {
+		preview, err := s.preview(ctx, payload)
+		timing.setHeader(w)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		s.writeJSON(w, r, preview)
 		return
 	}
-	ctx := context.Background()
-	service, err := gmail.NewService(ctx)
+
+	if payload.SplitRecipients > 0 {
+		s.sendSplit(ctx, w, r, payload)
+		return
+	}
+
+	// A replay answers with the earlier response as it was, whatever the
+	// request asks for, and does nothing else.
+	claim, previous, err := s.claimIdempotencyKey(ctx, payload, idempotencyKey)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Internal server error. %s", err.Error()), http.StatusInternalServerError)
+		writeError(w, err)
+		return
+	}
+	if previous != nil {
+		w.Header().Set(idempotentReplayedHeader, "true")
+		s.writeJSON(w, r, previous)
 		return
 	}
 
-	message := &gmail.Message{
-		Raw: base64.URLEncoding.EncodeToString([]byte(payload.MessageBody)),
+	query := r.URL.Query()
+	if query.Get("async") == "true" {
+		s.startJob(w, r, payload, claim)
+		return
 	}
 
-	sendResponse, err := service.Users.Messages.Send("me", message).Do()
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Internal server error. %s", err.Error()), http.StatusInternalServerError)
+	if query.Get("progress") == "ndjson" {
+		s.streamSend(ctx, w, payload, claim)
 		return
 	}
 
-	err = trashExistingMessages(service, "INBOX")
+	response, err := s.send(ctx, payload, claim, nil)
+	timing.setHeader(w)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Internal server error. %s", err.Error()), http.StatusInternalServerError)
+		writeError(w, err)
 		return
 	}
 
-	err = trashExistingMessages(service, "SPAM")
+	s.writeJSON(w, r, response)
+}
+
+// validatePayload checks the payload of a send before anything is sent.
+func validatePayload(payload *Payload) error {
+	if err := validateHeaders(payload); err != nil {
+		return err
+	}
+	if err := validateThreading(payload); err != nil {
+		return err
+	}
+	if err := validateBuildMode(payload); err != nil {
+		return err
+	}
+	if err := validateRawBase64(payload); err != nil {
+		return err
+	}
+	if err := validateContent(payload); err != nil {
+		return err
+	}
+	if err := validateRecipients(payload); err != nil {
+		return err
+	}
+	if err := validateSplit(payload); err != nil {
+		return err
+	}
+	return validateMode(payload)
+}
+
+// streamSend sends the payload's message while writing the trash progress as
+// NDJSON. The final line carries either the response or the error that
+// stopped the stream. Errors occurring before any progress was written are
+// reported as a regular error response instead.
+func (s *Server) streamSend(ctx context.Context, w http.ResponseWriter, payload *Payload, claim *idempotencyClaim) {
+	encoder := json.NewEncoder(w)
+	flusher, _ := w.(http.Flusher)
+	streaming := false
+	emit := func(event ProgressEvent) {
+		if !streaming {
+			timingFromContext(ctx).setHeader(w)
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			w.WriteHeader(http.StatusOK)
+			streaming = true
+		}
+		encoder.Encode(event)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	response, err := s.send(ctx, payload, claim, emit)
+	switch {
+	case err != nil && !streaming:
+		timingFromContext(ctx).setHeader(w)
+		writeError(w, err)
+	case err != nil:
+		emit(ProgressEvent{Error: err.Error(), GmailStatus: upstreamStatus(err)})
+	default:
+		emit(ProgressEvent{Result: response})
+	}
+}
+
+// send sends the payload's message, adjusts the labels of the sent copy and
+// trashes the existing messages of the cleanup labels, calling progress (if
+// non-nil) as trashing advances. The outcome is recorded for the idempotency
+// key held by claim, if any, which is released when nothing was sent. Errors
+// carry the HTTP status they should be reported with.
+func (s *Server) send(ctx context.Context, payload *Payload, claim *idempotencyClaim, progress func(ProgressEvent)) (*SendResponse, error) {
+	defer claim.release()
+
+	if s.config.SendTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.config.SendTimeout)
+		defer cancel()
+	}
+
+	var labels []string
+	if payload.cleansUp() {
+		var err error
+		if labels, err = s.cleanupLabels(payload); err != nil {
+			return nil, err
+		}
+	}
+
+	timing := timingFromContext(ctx)
+	start := time.Now()
+	ctx, client, service, err := s.newService(ctx, payload)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Internal server error. %s", err.Error()), http.StatusInternalServerError)
-		return
+		return nil, err
+	}
+
+	var info *tokenInfo
+	if payload.cleansUp() {
+		if info, err = s.checkTrashScope(ctx, client); err != nil {
+			return nil, err
+		}
+	}
+	timing.record("auth", start)
+
+	warnings := s.payloadWarnings(payload)
+	start = time.Now()
+	message, err := s.prepareMessage(ctx, service, payload)
+	if errors.Is(err, errAllSuppressed) {
+		return &SendResponse{
+			RequestID:  requestIDFromContext(ctx),
+			Status:     sendStatusNothingSent,
+			Suppressed: payload.suppressed,
+			Warnings:   warnings,
+		}, nil
+	}
+	if err != nil {
+		return nil, withDefaultStatus(http.StatusBadRequest, err)
+	}
+	timing.record("build", start)
+
+	// Inserted messages reach no recipients, so they are neither paced nor
+	// counted as sends.
+	inserting := payload.Mode == modeInsert
+	release, err := s.claimMessageID(tenantID(ctx), s.senderAccount(ctx, client, info), messageIDOf(payload))
+	if err != nil {
+		return nil, err
+	}
+
+	tenant, hasTenant := tenantFromContext(ctx)
+	if hasTenant && !inserting {
+		releaseQuota, err := s.tenants.claim(tenant.ID)
+		if err != nil {
+			release()
+			s.metrics.recordTenantSend(tenant.ID, "rejected")
+			return nil, err
+		}
+		releaseID := release
+		release = func() {
+			releaseID()
+			releaseQuota()
+		}
+	}
+
+	domains := recipientDomains(payload)
+	if !inserting {
+		if err := s.limiter.wait(ctx, domains); err != nil {
+			release()
+			return nil, withStatus(http.StatusServiceUnavailable, err)
+		}
+	}
+
+	start = time.Now()
+	sent, err := withRetry(ctx, s.sendBackoff(ctx, claim != nil), func() (*gmail.Message, error) {
+		return deliver(ctx, service, payload, message)
+	})
+	if s.refreshRejected(client, err) {
+		sent, err = deliver(ctx, service, payload, message)
+	}
+	timing.record("send", start)
+	if !inserting {
+		s.metrics.recordSend(domains, err)
+		if hasTenant {
+			s.metrics.recordTenantSend(tenant.ID, sendResult(err))
+		}
+	}
+	if err != nil {
+		release()
+		return nil, err
+	}
+	if sent == nil || sent.Id == "" {
+		return nil, withStatus(http.StatusBadGateway, errors.New("gmail returned no message ID for the sent message"))
+	}
+	requestID, undoID := requestIDFromContext(ctx), serverIDFromContext(ctx)
+	claim.sent(requestID, sent)
+
+	skipSent := payload.SkipSent || s.config.SkipSent && !inserting
+	if modified, err := applyLabels(ctx, service, payload, sent, skipSent); err != nil {
+		// The message is already sent; failing the request would invite a duplicate resend.
+		warnings = append(warnings, err.Error())
+	} else {
+		sent = modified
+	}
+
+	var headers map[string][]string
+	if payload.IncludeHeaders {
+		if full, decoded, err := expandSent(ctx, service, sent); err != nil {
+			warnings = append(warnings, err.Error())
+		} else {
+			sent, headers = full, decoded
+		}
+	}
+
+	var receipt string
+	if len(s.config.ReceiptKey) > 0 {
+		raw, err := decodeBase64(message.Raw)
+		if err == nil {
+			receipt, err = s.receipt(ctx, service, undoID, raw, sent)
+		}
+		if err != nil {
+			// The message is already sent; a missing receipt is not worth failing for.
+			warnings = append(warnings, err.Error())
+		}
+	}
+
+	if err := s.cleanUp(ctx, service, undoID, info.account(), labels, progress); err != nil {
+		return nil, err
+	}
+
+	response, err := s.sendResponse(client, requestID, sent)
+	if err != nil {
+		return nil, err
+	}
+	if s.config.UndoTTL > 0 && len(labels) > 0 {
+		response.UndoID = undoID
+	}
+	response.Headers = headers
+	response.TrackingToken = payload.trackingToken
+	response.Receipt = receipt
+	response.Suppressed = payload.suppressed
+	response.Warnings = warnings
+	claim.done(response)
+
+	return response, nil
+}
+
+// senderAccount returns the account the client's token acts for, as reported
+// by info when the token was already inspected, or "" when the token
+// information endpoint cannot tell.
+func (s *Server) senderAccount(ctx context.Context, client *http.Client, info *tokenInfo) string {
+	if info != nil {
+		return info.account()
+	}
+	// The token was inspected when the service was made, so this is cached.
+	info, _ = s.inspectToken(ctx, client)
+	return info.account()
+}
+
+// checkTrashScope checks that the client's token allows the trashing that
+// follows a send, retrying once with a refreshed token if it was rejected, so
+// that sends fail before anything is sent rather than after the message went
+// out.
+func (s *Server) checkTrashScope(ctx context.Context, client *http.Client) (*tokenInfo, error) {
+	info, err := s.requireScope(ctx, client, trashScopes)
+	if s.refreshRejected(client, err) {
+		info, err = s.requireScope(ctx, client, trashScopes)
+	}
+	return info, err
+}
+
+// cleanUp trashes the existing messages of labels once a send is made, under
+// undoID for account. Unless progress is streamed, Config.TrashAfterResponse
+// moves it to the background.
+func (s *Server) cleanUp(ctx context.Context, service *gmail.Service, undoID, account string, labels []string, progress func(ProgressEvent)) error {
+	if progress == nil && s.config.TrashAfterResponse {
+		// Cleanup must not hold up the response, nor be canceled along with
+		// the request; a closed server cleans up inline instead.
+		trashCtx := context.WithoutCancel(ctx)
+		background := s.track(func() {
+			if err := s.trash(trashCtx, service, undoID, account, labels, nil); err != nil {
+				s.logger.Error("trash failed", "request_id", requestIDFromContext(trashCtx), "error", err)
+			}
+		})
+		if background {
+			return nil
+		}
+	}
+
+	start := time.Now()
+	if err := s.trash(ctx, service, undoID, account, labels, progress); err != nil {
+		return err
+	}
+	timingFromContext(ctx).record("trash", start)
+	return nil
+}
+
+// trash runs trashLabels within Config.TrashTimeout, if set.
+func (s *Server) trash(ctx context.Context, service *gmail.Service, undoID, account string, labels []string, progress func(ProgressEvent)) error {
+	if s.config.TrashTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.config.TrashTimeout)
+		defer cancel()
+	}
+
+	return s.trashLabels(ctx, service, undoID, account, labels, progress)
+}
+
+// trashLabels moves the existing messages of the given labels, received
+// before Config.TrashOlderThan ago when set, to the trash. The IDs trashed are
+// recorded under undoID for account so they can be restored with /undo.
+func (s *Server) trashLabels(ctx context.Context, service *gmail.Service, undoID, account string, labels []string, progress func(ProgressEvent)) error {
+	var trashed []string
+	defer func() { s.rememberTrashed(undoID, account, trashed) }()
+
+	var before time.Time
+	if s.config.TrashOlderThan > 0 {
+		before = s.config.now().Add(-s.config.TrashOlderThan)
+	}
+
+	for _, labelID := range labels {
+		ids, err := trashExistingMessages(ctx, service, labelID, before, progress)
+		trashed = append(trashed, ids...)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sendResponse builds the SendResponse for a sent message, attaching the token
+// only when the configuration allows it.
+func (s *Server) sendResponse(client *http.Client, requestID string, message *gmail.Message) (*SendResponse, error) {
+	response := &SendResponse{RequestID: requestID, Output: message}
+	if source, ok := tokenSource(client); ok {
+		response.TokenRefreshed = source.wasRefreshed()
+	}
+	if !s.config.IncludeToken {
+		return response, nil
 	}
 
 	token, err := getToken(client)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Internal server error. %s", err.Error()), http.StatusInternalServerError)
-		return
+		return nil, err
+	}
+	response.Token = token
+
+	return response, nil
+}
+
+// applyLabels adjusts the labels of the sent copy of a message: the payload's
+// labels are added and, when skipSent is set, SENT is removed so the message
+// does not appear in the Sent folder. The sent message is returned unchanged
+// when there is nothing to modify.
+func applyLabels(ctx context.Context, service *gmail.Service, payload *Payload, sent *gmail.Message, skipSent bool) (*gmail.Message, error) {
+	request := &gmail.ModifyMessageRequest{AddLabelIds: payload.Labels}
+	if skipSent {
+		request.RemoveLabelIds = []string{"SENT"}
+	}
+	if len(request.AddLabelIds) == 0 && len(request.RemoveLabelIds) == 0 {
+		return sent, nil
+	}
+
+	modified, err := service.Users.Messages.Modify(gmailUser(ctx), sent.Id, request).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to modify labels of sent message: %v", err)
+	}
+
+	return modified, nil
+}
+
+// payloadWarnings returns non-fatal issues with the payload worth reporting to
+// the sender, such as an HTML body large enough to be clipped by Gmail.
+func (s *Server) payloadWarnings(payload *Payload) []string {
+	var warnings []string
+	if limit := s.config.HTMLWarnBytes; limit > 0 && len(payload.HTMLBody) > limit {
+		warnings = append(warnings, fmt.Sprintf(
+			"htmlBody is %d bytes, above the %d byte threshold; Gmail may clip the message and spam filters may flag it",
+			len(payload.HTMLBody), limit))
+	}
+
+	return warnings
+}
+
+// maxFormMemory is how much of a multipart form is held in memory, the rest
+// being stored in temporary files, as with Request.FormValue.
+const maxFormMemory = 32 << 20
+
+// readPayload decodes the payload form field of a POST request, sent either
+// URL-encoded or as multipart/form-data. It writes the error response and
+// returns false when the request is not acceptable, telling a body of the
+// wrong content type apart from a form missing the payload field.
+func readPayload(w http.ResponseWriter, r *http.Request) (*Payload, bool) {
+	if r.Method != http.MethodPost {
+		writeError(w, withStatus(http.StatusMethodNotAllowed, errors.New("only POST requests are allowed")))
+		return nil, false
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		if err := r.ParseMultipartForm(maxFormMemory); err != nil {
+			writeError(w, withStatus(http.StatusBadRequest, fmt.Errorf("failed to parse multipart form: %v", err)))
+			return nil, false
+		}
+	} else if err := r.ParseForm(); err != nil {
+		writeError(w, withStatus(http.StatusBadRequest, errors.New("failed to parse form")))
+		return nil, false
+	}
+
+	payloadStr := r.FormValue("payload")
+	if payloadStr == "" {
+		switch mediaType {
+		case "application/x-www-form-urlencoded":
+			writeError(w, withStatus(http.StatusBadRequest, errors.New("payload not provided")))
+		case "multipart/form-data":
+			writeError(w, withStatus(http.StatusBadRequest, errors.New("payload not provided: the multipart form has no payload field")))
+		default:
+			writeError(w, withStatus(http.StatusBadRequest, fmt.Errorf("payload not provided: unsupported content type %q, expected application/x-www-form-urlencoded or multipart/form-data", r.Header.Get("Content-Type"))))
+		}
+		return nil, false
+	}
+
+	payload, err := decodePayload(payloadStr)
+	if err != nil {
+		writeError(w, withStatus(http.StatusBadRequest, err))
+		return nil, false
+	}
+
+	return payload, true
+}
+
+// newService returns the authenticated HTTP client and Gmail service for the
+// payload, along with ctx carrying the Gmail user ID to act on. The token is
+// checked to belong to the OAuth client of the credentials first.
+func (s *Server) newService(ctx context.Context, payload *Payload) (context.Context, *http.Client, *gmail.Service, error) {
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, &http.Client{Transport: s.transport})
+	client, err := getClient(ctx, payload, s.credentialProvider(ctx, payload), s.config.scopes())
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if err := s.checkTokenClient(ctx, client); err != nil {
+		return nil, nil, nil, err
+	}
+
+	service, err := s.serviceFactory().NewService(ctx, client)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to create gmail service: %v", err)
+	}
+
+	ctx, err = s.checkUserID(ctx, service, payload)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return ctx, client, service, nil
+}
+
+// prepareMessage resolves reply threading, loads the payload's attachments and
+// forwarded message and builds the Gmail message to send. A RawBase64 message
+// is sent as given, unless the server would have changed or filtered it.
+func (s *Server) prepareMessage(ctx context.Context, service *gmail.Service, payload *Payload) (*gmail.Message, error) {
+	if payload.RawBase64 != "" {
+		if s.rewritesMessages() {
+			return nil, withStatus(http.StatusBadRequest, errRawBase64Rewritten)
+		}
+		return &gmail.Message{Raw: payload.RawBase64, ThreadId: payload.ThreadID}, nil
 	}
 
-	response := SendResponse{
-		Token:  token,
-		Output: sendResponse,
+	if payload.ReplyToMessageID != "" {
+		if err := resolveReply(ctx, service, payload); err != nil {
+			return nil, err
+		}
+	}
+
+	if payload.ForwardMessageID != "" {
+		if err := loadForward(ctx, service, payload); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := loadAttachments(ctx, s.config, payload.Attachments); err != nil {
+		return nil, err
+	}
+
+	if err := s.applyProfileFrom(ctx, service, payload); err != nil {
+		return nil, err
+	}
+	if payload.isStructured() {
+		s.applyFooter(payload)
+	}
+	if err := s.applyTrackingPixel(payload); err != nil {
+		return nil, err
+	}
+	payload.boundaryPrefix = s.config.BoundaryPrefix
+	header, err := s.runHooks(ctx, payload)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := buildMessage(payload, s.config.now())
+	if err != nil {
+		return nil, err
+	}
+	if !payload.isStructured() && s.config.NormalizeLineEndings {
+		raw = normalizeCRLF(raw)
+	}
+	raw = applyHookHeaders(raw, header)
+	// Suppressions apply to the recipients of the message itself, before the
+	// policies add an archive address or redirect it, so that a message whose
+	// every recipient is suppressed is not sent to the archive alone.
+	if payload.Mode != modeInsert {
+		raw, payload.suppressed = s.applySuppressions(raw)
+		if len(payload.suppressed) > 0 && !hasRecipients(raw) {
+			return nil, errAllSuppressed
+		}
+	}
+	raw = s.applyPolicies(raw)
+	if payload.isStructured() {
+		if err := validateSubjectLength(raw, s.config.MaxSubjectLength); err != nil {
+			return nil, withStatus(http.StatusBadRequest, err)
+		}
+	}
+	if !payload.internalDate.IsZero() {
+		m := parseRawMessage(raw)
+		m.setField("Date", payload.internalDate.Format(time.RFC1123Z))
+		raw = m.bytes()
 	}
 
-	json.NewEncoder(w).Encode(response)
+	return &gmail.Message{
+		Raw:      base64.URLEncoding.EncodeToString(raw),
+		ThreadId: payload.ThreadID,
+	}, nil
 }
 
 // decodePayload decodes the payload string and returns a Payload object.
 func decodePayload(payloadStr string) (*Payload, error) {
-	decoded, err := base64.StdEncoding.DecodeString(payloadStr)
+	decoded, err := decodeBase64(payloadStr)
 	if err != nil {
 		return nil, fmt.Errorf("failed to decode payload: %v", err)
 	}
@@ -120,28 +891,65 @@ func decodePayload(payloadStr string) (*Payload, error) {
 	return &payload, nil
 }
 
-// getClient returns an authenticated HTTP client using the provided payload.
-func getClient(payload *Payload) (*http.Client, error) {
-	credentials := strip.StripTags(string(payload.Credentials))
-	token := strip.StripTags(string(payload.Token))
+// decodeBase64 decodes s as either standard or URL-safe base64, with or
+// without padding. When s is neither, the error describes the likely mistake.
+func decodeBase64(s string) ([]byte, error) {
+	std, urlSafe := strings.ContainsAny(s, "+/"), strings.ContainsAny(s, "-_")
+	switch {
+	case strings.ContainsAny(s, " \t\r\n"):
+		return nil, errors.New("base64 contains whitespace; '+' characters may have been turned into spaces, so URL-encode the form value or use URL-safe base64")
+	case std && urlSafe:
+		return nil, errors.New("base64 mixes the standard (+/) and URL-safe (-_) alphabets")
+	case len(strings.TrimRight(s, "="))%4 == 1:
+		return nil, errors.New("base64 is truncated")
+	}
 
-	config, err := google.ConfigFromJSON([]byte(credentials), gmail.MailGoogleComScope)
+	encoding := base64.RawStdEncoding
+	if urlSafe {
+		encoding = base64.RawURLEncoding
+	}
+	decoded, err := encoding.DecodeString(strings.TrimRight(s, "="))
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse credentials: %v", err)
+		return nil, fmt.Errorf("invalid base64: %v", err)
 	}
 
-	tokenSource := config.TokenSource(context.TODO(), &oauth2.Token{
-		AccessToken: strip.StripTags(token),
-	})
+	return decoded, nil
+}
+
+// getClient returns an authenticated HTTP client for the payload's token,
+// using the credentials supplied by provider with the given OAuth scopes. Its
+// requests go through the oauth2.HTTPClient of ctx, if any.
+func getClient(ctx context.Context, payload *Payload, provider CredentialProvider, scopes []string) (*http.Client, error) {
+	rawCredentials, err := provider.GetCredentials(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get credentials: %v", err)
+	}
+	if emptyCredentials(rawCredentials) {
+		return nil, withStatus(http.StatusBadRequest, ErrNoCredentials)
+	}
+
+	config, err := google.ConfigFromJSON(rawCredentials, scopes...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse credentials: %v", err)
+	}
 
-	return oauth2.NewClient(context.Background(), tokenSource), nil
+	// The token source is used as is, without the caching oauth2.NewClient
+	// would wrap it in, so that a token Gmail rejects can be refreshed.
+	base := http.DefaultTransport
+	if c, ok := ctx.Value(oauth2.HTTPClient).(*http.Client); ok && c.Transport != nil {
+		base = c.Transport
+	}
+	return &http.Client{Transport: &oauth2.Transport{
+		Source: newTokenSource(ctx, config, parseToken(payload.Token)),
+		Base:   base,
+	}}, nil
 }
 
 // getToken returns the access token as a string from the HTTP client.
 func getToken(client *http.Client) (string, error) {
 	token, err := client.Transport.(*oauth2.Transport).Source.Token()
 	if err != nil {
-		return "", fmt.Errorf("failed to get token: %v", err)
+		return "", gmailError("get token", err)
 	}
 
 	tokenJSON, err := json.Marshal(token)
@@ -152,25 +960,57 @@ func getToken(client *http.Client) (string, error) {
 	return string(tokenJSON), nil
 }
 
-// trashExistingMessages moves existing messages in the specified label to the trash.
-func trashExistingMessages(service *gmail.Service, labelID string) error {
-	messages, err := service.Users.Messages.List("me").LabelIds(labelID).Do()
-	if err != nil {
-		return fmt.Errorf("failed to list messages: %v", err)
-	}
-
-	for _, message := range messages.Messages {
-		_, err := service.Users.Messages.Trash("me", message.Id).Do()
+// trashExistingMessages moves existing messages in the specified label to the trash,
+// only those received before the given time unless it is zero. It walks every page
+// of the listing, calling progress (if non-nil) after each page, and stops early
+// when ctx is canceled. It returns the IDs of the messages trashed.
+func trashExistingMessages(ctx context.Context, service *gmail.Service, labelID string, before time.Time, progress func(ProgressEvent)) ([]string, error) {
+	var trashed []string
+	pageToken := ""
+	for {
+		call := service.Users.Messages.List(gmailUser(ctx)).LabelIds(labelID).Context(ctx)
+		if !before.IsZero() {
+			// Gmail's before: operator takes seconds since the epoch as well
+			// as dates, unlike older_than: which counts whole days.
+			call = call.Q(fmt.Sprintf("before:%d", before.Unix()))
+		}
+		if pageToken != "" {
+			call = call.PageToken(pageToken)
+		}
+		messages, err := call.Do()
 		if err != nil {
-			return fmt.Errorf("failed to trash message: %v", err)
+			return trashed, gmailError("list messages", err)
 		}
-	}
 
-	return nil
+		for _, message := range messages.Messages {
+			if err := ctx.Err(); err != nil {
+				return trashed, fmt.Errorf("trash canceled: %v", err)
+			}
+			_, err := service.Users.Messages.Trash(gmailUser(ctx), message.Id).Context(ctx).Do()
+			if err != nil {
+				return trashed, gmailError("trash message", err)
+			}
+			trashed = append(trashed, message.Id)
+		}
+
+		if progress != nil {
+			progress(ProgressEvent{Label: labelID, Trashed: len(trashed)})
+		}
+
+		pageToken = messages.NextPageToken
+		if pageToken == "" {
+			return trashed, nil
+		}
+	}
 }
 
 // gosender starts the web server and handles the "/send" endpoint.
 func gosender() {
-	http.HandleFunc("/send", handleRequest)
-	http.ListenAndServe(":8080", nil)
+	config, err := LoadConfig()
+	if err != nil {
+		log.Fatalf("failed to load config: %v", err)
+	}
+
+	server := NewServer(config)
+	http.ListenAndServe(":8080", server.Handler())
 }
@@ -0,0 +1,456 @@
+package gosender
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHTMLSizeWarning(t *testing.T) {
+	tests := []struct {
+		name        string
+		size        int
+		warnBytes   int
+		wantWarning bool
+	}{
+		{name: "below the threshold", size: 1000, warnBytes: 1024},
+		{name: "at the threshold", size: 1024, warnBytes: 1024},
+		{name: "above the threshold", size: 1025, warnBytes: 1024, wantWarning: true},
+		{name: "disabled", size: 200 * 1024},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newGmailStub(t)
+			h := stub.newServer(func(c *Config) { c.HTMLWarnBytes = tt.warnBytes }).Handler()
+			html := "<p>" + strings.Repeat("x", tt.size-len("<p></p>")) + "</p>"
+			payload := stub.payload(t, map[string]any{"to": "to@example.com", "subject": "Hello", "htmlBody": html})
+
+			rec := postPayload(h, "/send", payload, nil)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("send = %d %s; want 200 whatever the size", rec.Code, rec.Body)
+			}
+			var response SendResponse
+			decodeJSON(t, rec, &response)
+			if warned := len(response.Warnings) > 0; warned != tt.wantWarning {
+				t.Fatalf("warnings = %q; want a warning: %v", response.Warnings, tt.wantWarning)
+			}
+			if tt.wantWarning && !strings.Contains(response.Warnings[0], "clip") {
+				t.Errorf("warning %q does not mention clipping", response.Warnings[0])
+			}
+			if sent, _, _ := stub.counts(); sent != 1 {
+				t.Errorf("sent %d messages; want 1", sent)
+			}
+		})
+	}
+}
+
+func TestSentLabels(t *testing.T) {
+	tests := []struct {
+		name       string
+		fields     map[string]any
+		skipSent   bool
+		wantAdd    []string
+		wantRemove []string
+		wantLabels []string
+	}{
+		{name: "left in Sent", fields: map[string]any{}, wantLabels: []string{"SENT"}},
+		{name: "custom labels", fields: map[string]any{"labels": []string{"Label_1", "Label_2"}}, wantAdd: []string{"Label_1", "Label_2"}, wantLabels: []string{"SENT", "Label_1", "Label_2"}},
+		{name: "kept out of Sent", fields: map[string]any{"skipSent": true}, wantRemove: []string{"SENT"}},
+		{name: "labeled out of Sent", fields: map[string]any{"skipSent": true, "labels": []string{"Label_1"}}, wantAdd: []string{"Label_1"}, wantRemove: []string{"SENT"}, wantLabels: []string{"Label_1"}},
+		{name: "kept out of Sent by the server", fields: map[string]any{}, skipSent: true, wantRemove: []string{"SENT"}},
+		{name: "inserted under the server's skipSent", fields: map[string]any{"mode": "insert"}, skipSent: true, wantLabels: []string{"SENT"}},
+		{name: "inserted out of Sent", fields: map[string]any{"mode": "insert", "skipSent": true}, wantRemove: []string{"SENT"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newGmailStub(t)
+			h := stub.newServer(func(c *Config) { c.SkipSent = tt.skipSent }).Handler()
+			fields := map[string]any{"to": "to@example.com", "subject": "Hello", "messageBody": "Hi"}
+			for k, v := range tt.fields {
+				fields[k] = v
+			}
+
+			rec := postPayload(h, "/send", stub.payload(t, fields), nil)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("send = %d %s", rec.Code, rec.Body)
+			}
+			var response SendResponse
+			decodeJSON(t, rec, &response)
+			if got := strings.Join(response.Output.LabelIds, ","); got != strings.Join(tt.wantLabels, ",") {
+				t.Errorf("labels of the sent copy = %q; want %q", got, strings.Join(tt.wantLabels, ","))
+			}
+
+			stub.mu.Lock()
+			modified := append([]modification(nil), stub.modified...)
+			stub.mu.Unlock()
+			if len(tt.wantAdd) == 0 && len(tt.wantRemove) == 0 {
+				if len(modified) != 0 {
+					t.Errorf("modified %+v; want the sent copy left alone", modified)
+				}
+				return
+			}
+			if len(modified) != 1 || modified[0].id != response.Output.Id {
+				t.Fatalf("modified %+v; want the sent copy %s modified once", modified, response.Output.Id)
+			}
+			request := modified[0].request
+			if got := strings.Join(request.AddLabelIds, ","); got != strings.Join(tt.wantAdd, ",") {
+				t.Errorf("added labels %q; want %q", got, strings.Join(tt.wantAdd, ","))
+			}
+			if got := strings.Join(request.RemoveLabelIds, ","); got != strings.Join(tt.wantRemove, ",") {
+				t.Errorf("removed labels %q; want %q", got, strings.Join(tt.wantRemove, ","))
+			}
+		})
+	}
+}
+
+func TestSendWithoutMessageID(t *testing.T) {
+	tests := []struct {
+		name string
+		mode string
+	}{
+		{name: "send"},
+		{name: "insert", mode: "insert"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newGmailStub(t)
+			stub.noMessageID = true
+			stub.setLabel("INBOX", "existing")
+			h := stub.newServer().Handler()
+			payload := stub.payload(t, map[string]any{"to": "to@example.com", "subject": "Hello", "messageBody": "Hi", "mode": tt.mode})
+
+			rec := postPayload(h, "/send", payload, nil)
+			if rec.Code != http.StatusBadGateway {
+				t.Fatalf("send = %d %s; want 502", rec.Code, rec.Body)
+			}
+			var response ErrorResponse
+			decodeJSON(t, rec, &response)
+			if response.Code != ErrGmail || !strings.Contains(response.Error, "no message ID") {
+				t.Errorf("error = %+v; want a gmail error naming the missing message ID", response)
+			}
+			if _, _, trashed := stub.counts(); trashed != 0 {
+				t.Errorf("trashed %d messages; want none", trashed)
+			}
+		})
+	}
+}
+
+func TestClose(t *testing.T) {
+	stub := newGmailStub(t)
+	stub.release = make(chan struct{})
+	s := stub.newServer()
+	h := s.Handler()
+	payload := stub.payload(t, map[string]any{"to": "to@example.com", "subject": "Hello", "messageBody": "Hi"})
+
+	if rec := postPayload(h, "/send?async=true", payload, nil); rec.Code != http.StatusAccepted {
+		t.Fatalf("async send = %d %s", rec.Code, rec.Body)
+	}
+	waitAttempts(t, stub, 1)
+
+	closed := make(chan error, 1)
+	go func() { closed <- s.Close() }()
+	select {
+	case err := <-closed:
+		t.Fatalf("Close returned %v before the pending send finished", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(stub.release)
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Fatalf("Close = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close did not return once the pending send finished")
+	}
+	if sent, _, _ := stub.counts(); sent != 1 {
+		t.Errorf("sent %d messages; want the pending send finished", sent)
+	}
+
+	rec := postPayload(h, "/send", payload, nil)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("send after Close = %d %s; want 503", rec.Code, rec.Body)
+	}
+	if err := s.Close(); !errors.Is(err, ErrServerClosed) {
+		t.Errorf("second Close = %v; want %v", err, ErrServerClosed)
+	}
+	if sent, _, _ := stub.counts(); sent != 1 {
+		t.Errorf("sent %d messages; want nothing sent after Close", sent)
+	}
+}
+
+func TestTrashTimeout(t *testing.T) {
+	tests := []struct {
+		name          string
+		afterResponse bool
+		timeout       time.Duration
+		release       bool
+		wantStatus    int
+		wantTrashed   int
+	}{
+		{name: "trashed after the response", afterResponse: true, timeout: 5 * time.Second, release: true, wantStatus: http.StatusOK, wantTrashed: 1},
+		{name: "background trash timed out", afterResponse: true, timeout: 50 * time.Millisecond, wantStatus: http.StatusOK},
+		{name: "inline trash timed out", timeout: 50 * time.Millisecond, wantStatus: http.StatusGatewayTimeout},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newGmailStub(t)
+			stub.trashRelease = make(chan struct{})
+			stub.setLabel("INBOX", "existing")
+			s := stub.newServer(func(c *Config) {
+				c.TrashAfterResponse = tt.afterResponse
+				c.TrashTimeout = tt.timeout
+			})
+			payload := stub.payload(t, map[string]any{"to": "to@example.com", "subject": "Hello", "messageBody": "Hi"})
+
+			start := time.Now()
+			rec := postPayload(s.Handler(), "/send", payload, nil)
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("send took %s; want it answered without waiting for a slow trash", elapsed)
+			}
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("send = %d %s; want %d", rec.Code, rec.Body, tt.wantStatus)
+			}
+			if sent, _, trashed := stub.counts(); sent != 1 || trashed != 0 {
+				t.Errorf("sent %d and trashed %d messages by the response; want 1 and 0", sent, trashed)
+			}
+
+			if tt.release {
+				close(stub.trashRelease)
+			}
+			// Close waits for the background trash, which must end by
+			// itself within its own timeout.
+			closed := make(chan error, 1)
+			go func() { closed <- s.Close() }()
+			select {
+			case <-closed:
+			case <-time.After(5 * time.Second):
+				t.Fatal("the background trash did not end")
+			}
+			if _, _, trashed := stub.counts(); trashed != tt.wantTrashed {
+				t.Errorf("trashed %d messages; want %d", trashed, tt.wantTrashed)
+			}
+		})
+	}
+}
+
+func TestTrashOlderThan(t *testing.T) {
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name        string
+		olderThan   time.Duration
+		wantTrashed int
+	}{
+		{name: "every message without an age", wantTrashed: 3},
+		{name: "only messages older than the age", olderThan: 24 * time.Hour, wantTrashed: 2},
+		{name: "no message old enough", olderThan: 30 * 24 * time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newGmailStub(t)
+			stub.setLabel("INBOX", "old", "older", "recent")
+			stub.received = map[string]time.Time{
+				"old":    now.Add(-48 * time.Hour),
+				"older":  now.Add(-7 * 24 * time.Hour),
+				"recent": now.Add(-time.Hour),
+			}
+			h := stub.newServer(func(c *Config) {
+				c.Clock = func() time.Time { return now }
+				c.TrashOlderThan = tt.olderThan
+			}).Handler()
+			payload := stub.payload(t, map[string]any{"to": "to@example.com", "subject": "Hello", "messageBody": "Hi"})
+
+			if rec := postPayload(h, "/send", payload, nil); rec.Code != http.StatusOK {
+				t.Fatalf("send = %d %s; want 200", rec.Code, rec.Body)
+			}
+			if _, _, trashed := stub.counts(); trashed != tt.wantTrashed {
+				t.Errorf("trashed %d messages; want %d", trashed, tt.wantTrashed)
+			}
+		})
+	}
+}
+
+func TestPayloadEncoding(t *testing.T) {
+	tests := []struct {
+		name       string
+		encode     func(payload []byte) string
+		wantStatus int
+		wantError  string
+	}{
+		{name: "standard", encode: base64.StdEncoding.EncodeToString, wantStatus: http.StatusOK},
+		{name: "standard unpadded", encode: base64.RawStdEncoding.EncodeToString, wantStatus: http.StatusOK},
+		{name: "URL-safe", encode: base64.URLEncoding.EncodeToString, wantStatus: http.StatusOK},
+		{name: "URL-safe unpadded", encode: base64.RawURLEncoding.EncodeToString, wantStatus: http.StatusOK},
+		{
+			name: "pluses turned into spaces",
+			encode: func(payload []byte) string {
+				return strings.ReplaceAll(base64.StdEncoding.EncodeToString(payload), "+", " ")
+			},
+			wantStatus: http.StatusBadRequest,
+			wantError:  "base64 contains whitespace",
+		},
+		{
+			name: "mixed alphabets",
+			encode: func(payload []byte) string {
+				return strings.Replace(base64.StdEncoding.EncodeToString(payload), "+", "-", 1)
+			},
+			wantStatus: http.StatusBadRequest,
+			wantError:  "base64 mixes the standard (+/) and URL-safe (-_) alphabets",
+		},
+		{
+			name: "truncated",
+			encode: func(payload []byte) string {
+				encoded := base64.RawStdEncoding.EncodeToString(payload)
+				return encoded[:len(encoded)/4*4+1]
+			},
+			wantStatus: http.StatusBadRequest,
+			wantError:  "base64 is truncated",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newGmailStub(t)
+			h := stub.newServer().Handler()
+			// The subject makes the standard encoding use both '+' and '/',
+			// and the payload is not a multiple of three bytes long, so
+			// that it is padded.
+			payload := stub.payload(t, map[string]any{"to": "to@example.com", "subject": "??? ~~~", "messageBody": "Hi"})
+			if encoded := base64.StdEncoding.EncodeToString([]byte(payload)); !strings.ContainsAny(encoded, "+") || !strings.ContainsAny(encoded, "/") || !strings.HasSuffix(encoded, "=") {
+				t.Fatalf("payload encodes to %q; want it using '+', '/' and padding", encoded)
+			}
+
+			form := url.Values{"payload": {tt.encode([]byte(payload))}}
+			req := httptest.NewRequest(http.MethodPost, "/send", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("send = %d %s; want %d", rec.Code, rec.Body, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				var response ErrorResponse
+				decodeJSON(t, rec, &response)
+				if !strings.Contains(response.Error, tt.wantError) || response.Code != ErrBadPayload {
+					t.Errorf("error = %+v; want %q", response, tt.wantError)
+				}
+				return
+			}
+			if messages := stub.sent; len(messages) != 1 || !strings.Contains(messages[0], "Subject: ??? ~~~") {
+				t.Errorf("sent %q; want the decoded payload's message", messages)
+			}
+		})
+	}
+}
+
+// multipartForm encodes fields as a multipart/form-data body, returning its
+// content type and the body.
+func multipartForm(t *testing.T, fields map[string]string) (string, string) {
+	t.Helper()
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	for name, value := range fields {
+		if err := w.WriteField(name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return w.FormDataContentType(), body.String()
+}
+
+func TestReadPayload(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       func(t *testing.T, payload string) (contentType, body string)
+		wantStatus int
+		wantError  string
+	}{
+		{
+			name: "URL-encoded",
+			body: func(t *testing.T, payload string) (string, string) {
+				return "application/x-www-form-urlencoded", url.Values{"payload": {payload}}.Encode()
+			},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "multipart",
+			body: func(t *testing.T, payload string) (string, string) {
+				return multipartForm(t, map[string]string{"payload": payload})
+			},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "URL-encoded without the field",
+			body: func(t *testing.T, payload string) (string, string) {
+				return "application/x-www-form-urlencoded", url.Values{"message": {payload}}.Encode()
+			},
+			wantStatus: http.StatusBadRequest,
+			wantError:  "Bad request. payload not provided",
+		},
+		{
+			name: "multipart without the field",
+			body: func(t *testing.T, payload string) (string, string) {
+				return multipartForm(t, map[string]string{"message": payload})
+			},
+			wantStatus: http.StatusBadRequest,
+			wantError:  "Bad request. payload not provided: the multipart form has no payload field",
+		},
+		{
+			name: "JSON body",
+			body: func(t *testing.T, payload string) (string, string) {
+				return "application/json", `{"payload":"` + payload + `"}`
+			},
+			wantStatus: http.StatusBadRequest,
+			wantError:  `Bad request. payload not provided: unsupported content type "application/json", expected application/x-www-form-urlencoded or multipart/form-data`,
+		},
+		{
+			name: "no content type",
+			body: func(t *testing.T, payload string) (string, string) {
+				return "", url.Values{"payload": {payload}}.Encode()
+			},
+			wantStatus: http.StatusBadRequest,
+			wantError:  `Bad request. payload not provided: unsupported content type "", expected application/x-www-form-urlencoded or multipart/form-data`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newGmailStub(t)
+			h := stub.newServer().Handler()
+			payload := base64.StdEncoding.EncodeToString([]byte(stub.payload(t, map[string]any{"to": "to@example.com", "subject": "Hello", "messageBody": "Hi"})))
+
+			contentType, body := tt.body(t, payload)
+			req := httptest.NewRequest(http.MethodPost, "/send", strings.NewReader(body))
+			if contentType != "" {
+				req.Header.Set("Content-Type", contentType)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("send = %d %s; want %d", rec.Code, rec.Body, tt.wantStatus)
+			}
+			if tt.wantError != "" {
+				var response ErrorResponse
+				decodeJSON(t, rec, &response)
+				if response.Error != tt.wantError || response.Code != ErrBadPayload {
+					t.Errorf("error = %+v; want %q", response, tt.wantError)
+				}
+			}
+			wantSent := 0
+			if tt.wantStatus == http.StatusOK {
+				wantSent = 1
+			}
+			if sent, _, _ := stub.counts(); sent != wantSent {
+				t.Errorf("sent %d messages; want %d", sent, wantSent)
+			}
+		})
+	}
+}
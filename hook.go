@@ -0,0 +1,100 @@
+package gosender
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"net/textproto"
+	"sort"
+)
+
+// Message is a message about to be built, as handed to the hooks in
+// Config.Hooks for custom enrichment.
+type Message struct {
+	// Payload is the payload the message is built from. Hooks may change its
+	// fields, such as to rewrite the links of HTMLBody.
+	Payload *Payload
+
+	// Header holds extra header fields to set on the built message, each
+	// replacing any field of the same name. Values are encoded as needed.
+	Header textproto.MIMEHeader
+}
+
+// Hook inspects or changes a message before it is built and sent. A hook
+// returning an error aborts the send, which fails with 400 Bad Request.
+type Hook func(ctx context.Context, m *Message) error
+
+// runHooks runs the configured hooks on the payload, in registration order,
+// and returns the header fields they added. The payload is validated again
+// afterwards, as are the added fields, so that hooks cannot get past the checks
+// made on the payload as received.
+func (s *Server) runHooks(ctx context.Context, p *Payload) (textproto.MIMEHeader, error) {
+	if len(s.config.Hooks) == 0 {
+		return nil, nil
+	}
+
+	m := &Message{Payload: p, Header: make(textproto.MIMEHeader)}
+	for i, hook := range s.config.Hooks {
+		if err := hook(ctx, m); err != nil {
+			return nil, fmt.Errorf("hook %d: %w", i, err)
+		}
+	}
+
+	for name := range m.Header {
+		if !headerNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("invalid header name %q added by hook", name)
+		}
+	}
+	if name, ok := invalidHeader(m.Header); ok {
+		return nil, fmt.Errorf("invalid header %q added by hook: control characters are not allowed", name)
+	}
+	threading := &Payload{InReplyTo: m.Header.Get("In-Reply-To"), References: m.Header.Values("References")}
+	if err := validateThreading(threading); err != nil {
+		return nil, fmt.Errorf("threading header added by hook: %w", err)
+	}
+
+	if err := validateHeaders(p); err != nil {
+		return nil, fmt.Errorf("payload changed by hook: %w", err)
+	}
+	if err := validateThreading(p); err != nil {
+		return nil, fmt.Errorf("payload changed by hook: %w", err)
+	}
+
+	return m.Header, nil
+}
+
+// invalidHeader returns the name of a field of header whose name or values
+// contain control characters, if any.
+func invalidHeader(header textproto.MIMEHeader) (string, bool) {
+	for name, values := range header {
+		for _, value := range append([]string{name}, values...) {
+			if containsControl(value) {
+				return name, true
+			}
+		}
+	}
+	return "", false
+}
+
+// applyHookHeaders sets the header fields added by the hooks on the raw message.
+func applyHookHeaders(raw []byte, header textproto.MIMEHeader) []byte {
+	if len(header) == 0 {
+		return raw
+	}
+
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	m := parseRawMessage(raw)
+	for _, name := range names {
+		m.deleteField(name)
+		for _, value := range header[name] {
+			m.lines = append(m.lines, name+": "+mime.QEncoding.Encode("utf-8", value)+m.eol)
+		}
+	}
+
+	return m.bytes()
+}
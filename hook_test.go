@@ -0,0 +1,111 @@
+package gosender
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestHooks(t *testing.T) {
+	tests := []struct {
+		name       string
+		hooks      []Hook
+		wantStatus int
+		wantHeader string
+	}{
+		{
+			name: "adds a header",
+			hooks: []Hook{func(_ context.Context, m *Message) error {
+				m.Header.Set("X-Campaign", "spring")
+				return nil
+			}},
+			wantStatus: http.StatusOK,
+			wantHeader: "X-Campaign: spring\r\n",
+		},
+		{
+			name: "run in order",
+			hooks: []Hook{
+				func(_ context.Context, m *Message) error {
+					m.Header.Set("X-Step", "first")
+					return nil
+				},
+				func(_ context.Context, m *Message) error {
+					m.Header.Set("X-Step", m.Header.Get("X-Step")+" then second")
+					return nil
+				},
+			},
+			wantStatus: http.StatusOK,
+			wantHeader: "X-Step: first then second\r\n",
+		},
+		{
+			name: "rewrites the body",
+			hooks: []Hook{func(_ context.Context, m *Message) error {
+				m.Payload.MessageBody = strings.ReplaceAll(m.Payload.MessageBody, "Hi", "Hello")
+				return nil
+			}},
+			wantStatus: http.StatusOK,
+			wantHeader: "Hello",
+		},
+		{
+			name:       "error aborts",
+			hooks:      []Hook{func(context.Context, *Message) error { return errors.New("rejected") }},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "invalid header name",
+			hooks: []Hook{func(_ context.Context, m *Message) error {
+				m.Header["X Bad:Name"] = []string{"value"}
+				return nil
+			}},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "header injection",
+			hooks: []Hook{func(_ context.Context, m *Message) error {
+				m.Header.Set("X-Note", "a\r\nBcc: evil@example.com")
+				return nil
+			}},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "malformed threading header",
+			hooks: []Hook{func(_ context.Context, m *Message) error {
+				m.Header.Set("References", "not-a-message-id")
+				return nil
+			}},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "malformed threading field",
+			hooks: []Hook{func(_ context.Context, m *Message) error {
+				m.Payload.InReplyTo = "<a@example.com>\r\nBcc: evil@example.com"
+				return nil
+			}},
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newGmailStub(t)
+			h := stub.newServer(WithHook(tt.hooks...)).Handler()
+
+			payload := stub.payload(t, map[string]any{"to": "to@example.com", "subject": "Greeting", "messageBody": "Hi"})
+			rec := postPayload(h, "/send", payload, nil)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("send = %d %s; want %d", rec.Code, rec.Body, tt.wantStatus)
+			}
+			sent, _, _ := stub.counts()
+			if tt.wantStatus != http.StatusOK {
+				if sent != 0 {
+					t.Errorf("%d messages sent; want none", sent)
+				}
+				return
+			}
+			if sent != 1 || !strings.Contains(stub.sent[0], tt.wantHeader) {
+				t.Errorf("sent %q; want it to contain %q", stub.sent, tt.wantHeader)
+			}
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package gosender
+
+import (
+	"html"
+	"strings"
+	"unicode"
+)
+
+// blockElements maps the elements rendered on lines of their own to the
+// number of line breaks separating them from the surrounding text.
+var blockElements = map[string]int{
+	"address": 1, "article": 1, "aside": 1, "dd": 1, "div": 1, "dl": 1,
+	"dt": 1, "fieldset": 1, "figcaption": 1, "figure": 1, "footer": 1,
+	"form": 1, "header": 1, "li": 1, "main": 1, "nav": 1, "section": 1,
+	"td": 1, "th": 1, "tr": 1,
+
+	"blockquote": 2, "h1": 2, "h2": 2, "h3": 2, "h4": 2, "h5": 2, "h6": 2,
+	"hr": 2, "ol": 2, "p": 2, "pre": 2, "table": 2, "ul": 2,
+}
+
+// htmlToText converts an HTML body to plain text for the automatic text/plain
+// alternative. Tags and comments are dropped, along with the content of script
+// and style elements; block-level elements are put on lines of their own,
+// other whitespace collapses as a browser would and entities are decoded.
+func htmlToText(s string) string {
+	var b strings.Builder
+	breaks := 0
+	for len(s) > 0 {
+		switch {
+		case strings.HasPrefix(s, "<!--"):
+			s = skipPast(s[4:], "-->")
+		case s[0] == '<' && isTagStart(s[1:]):
+			end := tagEnd(s)
+			name, closing := tagName(s[1:end])
+			s = s[min(end+1, len(s)):]
+
+			if !closing && (name == "script" || name == "style") {
+				s = skipPast(s, "</"+name)
+				s = s[min(tagEnd(s)+1, len(s)):]
+			}
+			if name == "br" {
+				breaks++
+			} else {
+				breaks = max(breaks, blockElements[name])
+			}
+		default:
+			i := strings.IndexByte(s[1:], '<') + 1
+			if i == 0 {
+				i = len(s)
+			}
+			text := collapseSpace(s[:i])
+			s = s[i:]
+
+			if breaks > 0 {
+				if strings.TrimSpace(text) == "" {
+					continue
+				}
+				if b.Len() > 0 {
+					b.WriteString(strings.Repeat("\n", breaks))
+				}
+				breaks = 0
+			}
+			b.WriteString(html.UnescapeString(text))
+		}
+	}
+
+	return trimLines(b.String())
+}
+
+// isTagStart reports whether s, following a '<', begins a tag rather than a
+// literal less-than sign.
+func isTagStart(s string) bool {
+	if s == "" {
+		return false
+	}
+	c := s[0]
+	return c == '/' || c == '!' || c == '?' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// tagEnd returns the index of the '>' closing the tag at the start of s,
+// ignoring any inside quoted attribute values, or len(s) when it is unclosed.
+func tagEnd(s string) int {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '>':
+			return i
+		}
+	}
+	return len(s)
+}
+
+// tagName returns the lower-cased element name of the tag contents s and
+// whether it is a closing tag.
+func tagName(s string) (name string, closing bool) {
+	if strings.HasPrefix(s, "/") {
+		s, closing = s[1:], true
+	}
+	end := strings.IndexFunc(s, func(r rune) bool {
+		return unicode.IsSpace(r) || r == '/' || r == '>'
+	})
+	if end >= 0 {
+		s = s[:end]
+	}
+	return strings.ToLower(s), closing
+}
+
+// skipPast returns the remainder of s after the first occurrence of marker,
+// ignoring ASCII case, or "" when there is none.
+func skipPast(s, marker string) string {
+	for i := 0; i+len(marker) <= len(s); i++ {
+		if strings.EqualFold(s[i:i+len(marker)], marker) {
+			return s[i+len(marker):]
+		}
+	}
+	return ""
+}
+
+// collapseSpace replaces each run of whitespace in s with a single space.
+func collapseSpace(s string) string {
+	var b strings.Builder
+	space := false
+	for _, r := range s {
+		if unicode.IsSpace(r) {
+			space = true
+			continue
+		}
+		if space {
+			b.WriteByte(' ')
+			space = false
+		}
+		b.WriteRune(r)
+	}
+	if space {
+		b.WriteByte(' ')
+	}
+	return b.String()
+}
+
+// trimLines trims the spaces around each line of s.
+func trimLines(s string) string {
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(line)
+	}
+	return strings.Join(lines, "\n")
+}
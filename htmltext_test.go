@@ -0,0 +1,31 @@
+package gosender
+
+import "testing"
+
+func TestHTMLToText(t *testing.T) {
+	tests := []struct {
+		name string
+		html string
+		want string
+	}{
+		{name: "plain text", html: "Hello", want: "Hello"},
+		{name: "nested inline tags", html: "<p>Hello <b>dear <i>friend</i></b>!</p>", want: "Hello dear friend!"},
+		{name: "nested blocks", html: "<div><div><p>One</p><p>Two</p></div><ul><li>a</li><li>b</li></ul></div>", want: "One\n\nTwo\n\na\nb"},
+		{name: "line breaks", html: "one<br>two<br/><br />three", want: "one\ntwo\n\nthree"},
+		{name: "entities", html: "<p>Fish &amp; chips &lt;3 &quot;caf&eacute;&quot; &#8364;5 &#x2764;</p>", want: "Fish & chips <3 \"café\" €5 ❤"},
+		{name: "encoded tag", html: "&lt;b&gt;not a tag&lt;/b&gt;", want: "<b>not a tag</b>"},
+		{name: "collapsed whitespace", html: "<p>  Hello\n\t  world  </p>", want: "Hello world"},
+		{name: "script and style", html: "<style>p { color: red }</style><p>Hi</p><script>alert('<p>x</p>')</script>", want: "Hi"},
+		{name: "comments", html: "Hello<!-- <p>hidden</p> --> world", want: "Hello world"},
+		{name: "attributes with angle brackets", html: `<a title="a > b" href="x">link</a>`, want: "link"},
+		{name: "stray angle bracket", html: "1 < 2 and 3 > 2", want: "1 < 2 and 3 > 2"},
+		{name: "unclosed tag", html: "Hello <b", want: "Hello"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := htmlToText(tt.html); got != tt.want {
+				t.Errorf("htmlToText(%q) = %q; want %q", tt.html, got, tt.want)
+			}
+		})
+	}
+}
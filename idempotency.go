@@ -0,0 +1,209 @@
+package gosender
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/gmail/v1"
+)
+
+const (
+	// idempotencyKeyHeader carries the client-chosen key identifying a send.
+	idempotencyKeyHeader = "Idempotency-Key"
+
+	// idempotentReplayedHeader is set on responses answered from a previous send.
+	idempotentReplayedHeader = "Idempotent-Replayed"
+
+	// maxIdempotencyKeyLength bounds the size of an idempotency key.
+	maxIdempotencyKeyLength = 255
+)
+
+// validateIdempotencyKey rejects keys that are too long or contain control characters.
+// An empty key is valid and disables idempotency for the request.
+func validateIdempotencyKey(key string) error {
+	if len(key) > maxIdempotencyKeyLength {
+		return errors.New("idempotency key is too long")
+	}
+	if containsControl(key) {
+		return errors.New("idempotency key contains control characters")
+	}
+
+	return nil
+}
+
+var (
+	// errIdempotencyKeyInUse is reported, with 409 Conflict, for a send whose
+	// idempotency key is held by another send still under way.
+	errIdempotencyKeyInUse = errors.New("a send with this idempotency key is still in progress")
+
+	// errIdempotencyKeyReused is reported, with 422 Unprocessable Entity, for
+	// a send whose idempotency key was used for another request.
+	errIdempotencyKeyReused = errors.New("this idempotency key was already used for a different request")
+)
+
+// idempotencyRecord is what the store holds under an idempotency key: the
+// fingerprint of the request that used it, along with a pending claim while
+// the send is under way, then the response of the send.
+type idempotencyRecord struct {
+	Fingerprint string        `json:"fingerprint"`
+	Pending     bool          `json:"pending,omitempty"`
+	Response    *SendResponse `json:"response,omitempty"`
+}
+
+// idempotencyClaim is the hold of a send on its idempotency key, taken before
+// anything is sent. A nil claim, for sends without a key, does nothing.
+type idempotencyClaim struct {
+	s           *Server
+	key         string
+	fingerprint string
+}
+
+// idempotencyStoreKey returns the store key of the client's idempotency key.
+// Keys are scoped by tenant, so that tenants cannot hold each other's keys.
+func idempotencyStoreKey(ctx context.Context, key string) string {
+	sum := sha256.Sum256([]byte(tenantID(ctx) + "\x00" + key))
+	return "idempotency:" + hex.EncodeToString(sum[:])
+}
+
+// idempotencyFingerprint returns the hash identifying the payload's send: the
+// OAuth client of the credentials, the token it is made with and the payload
+// itself. A key is only ever answered for requests of the same fingerprint,
+// so that neither another caller nor another message gets an earlier send's
+// response.
+func (s *Server) idempotencyFingerprint(ctx context.Context, payload *Payload) (string, error) {
+	// Credentials that cannot be read fail the send itself, which releases
+	// the claim, so they are not reported here.
+	var clientID string
+	if raw, err := s.credentialProvider(ctx, payload).GetCredentials(ctx); err == nil {
+		if config, err := google.ConfigFromJSON(raw); err == nil {
+			clientID = config.ClientID
+		}
+	}
+
+	// A refresh token identifies the grant across the access tokens it is
+	// refreshed into.
+	token := parseToken(payload.Token)
+	tokenID := token.RefreshToken
+	if tokenID == "" {
+		tokenID = token.AccessToken
+	}
+
+	message := *payload
+	message.Credentials, message.Token = nil, nil
+	content, err := json.Marshal(&message)
+	if err != nil {
+		return "", err
+	}
+	contentHash := sha256.Sum256(content)
+
+	fingerprint, err := json.Marshal([]string{clientID, tokenID, hex.EncodeToString(contentHash[:])})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(fingerprint)
+
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// claimIdempotencyKey claims the idempotency key for the payload's send. When
+// the key was already used for the same send, the response it answered with
+// is returned instead, and nothing is claimed; while that send is still under
+// way, the key is refused with 409 Conflict. A key used for another request
+// is refused with 422 Unprocessable Entity. An empty key claims nothing.
+func (s *Server) claimIdempotencyKey(ctx context.Context, payload *Payload, key string) (*idempotencyClaim, *SendResponse, error) {
+	if key == "" {
+		return nil, nil, nil
+	}
+
+	fingerprint, err := s.idempotencyFingerprint(ctx, payload)
+	if err != nil {
+		return nil, nil, err
+	}
+	storeKey := idempotencyStoreKey(ctx, key)
+
+	s.idempotencyMu.Lock()
+	defer s.idempotencyMu.Unlock()
+
+	if value, ok := s.store.Get(storeKey); ok {
+		var record idempotencyRecord
+		if err := json.Unmarshal(value, &record); err == nil {
+			switch {
+			case record.Fingerprint != fingerprint:
+				return nil, nil, withStatus(http.StatusUnprocessableEntity, errIdempotencyKeyReused)
+			case record.Response != nil:
+				return nil, record.Response, nil
+			case record.Pending:
+				return nil, nil, withStatus(http.StatusConflict, errIdempotencyKeyInUse)
+			}
+		}
+	}
+
+	claim := &idempotencyClaim{s: s, key: storeKey, fingerprint: fingerprint}
+	claim.store(idempotencyRecord{Pending: true})
+
+	return claim, nil, nil
+}
+
+// store records the state of the claimed send for Config.IdempotencyTTL.
+func (c *idempotencyClaim) store(record idempotencyRecord) {
+	record.Fingerprint = c.fingerprint
+	value, err := json.Marshal(record)
+	if err != nil {
+		return
+	}
+
+	c.s.store.Set(c.key, value, c.s.config.IdempotencyTTL)
+}
+
+// sent records the message delivered by the claimed send, so that retries of
+// the request do not send it again even when the rest of the send fails.
+func (c *idempotencyClaim) sent(requestID string, message *gmail.Message) {
+	if c == nil {
+		return
+	}
+
+	c.s.idempotencyMu.Lock()
+	defer c.s.idempotencyMu.Unlock()
+	c.store(idempotencyRecord{Response: &SendResponse{RequestID: requestID, Output: message}})
+}
+
+// done records the response of the claimed send, without its token, as the
+// answer to retries of the request.
+func (c *idempotencyClaim) done(response *SendResponse) {
+	if c == nil {
+		return
+	}
+
+	stored := *response
+	stored.Token = ""
+
+	c.s.idempotencyMu.Lock()
+	defer c.s.idempotencyMu.Unlock()
+	c.store(idempotencyRecord{Response: &stored})
+}
+
+// release gives up the claim of a send that failed before anything was sent,
+// so that the request can be retried. The record of a message already sent is
+// kept.
+func (c *idempotencyClaim) release() {
+	if c == nil {
+		return
+	}
+
+	c.s.idempotencyMu.Lock()
+	defer c.s.idempotencyMu.Unlock()
+
+	value, ok := c.s.store.Get(c.key)
+	if !ok {
+		return
+	}
+	var record idempotencyRecord
+	if json.Unmarshal(value, &record) == nil && record.Response == nil {
+		c.s.store.Delete(c.key)
+	}
+}
@@ -0,0 +1,119 @@
+package gosender
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIdempotencyKey(t *testing.T) {
+	message := map[string]any{"to": "to@example.com", "subject": "Hello", "messageBody": "Hi"}
+	tests := []struct {
+		name         string
+		second       map[string]any
+		path         string
+		tenant       string
+		wantReplayed bool
+		wantStatus   int
+		wantSent     int
+	}{
+		{name: "same send replayed", second: message, path: "/send", wantReplayed: true, wantSent: 1},
+		{name: "async replayed as is", second: message, path: "/send?async=true", wantReplayed: true, wantSent: 1},
+		{name: "other payload", second: map[string]any{"to": "to@example.com", "subject": "Other", "messageBody": "Hi"}, path: "/send", wantStatus: http.StatusUnprocessableEntity, wantSent: 1},
+		{
+			name:       "other token",
+			second:     map[string]any{"to": "to@example.com", "subject": "Hello", "messageBody": "Hi", "token": map[string]any{"access_token": "other-token", "expiry": "2099-01-01T00:00:00Z"}},
+			path:       "/send",
+			wantStatus: http.StatusUnprocessableEntity,
+			wantSent:   1,
+		},
+		{name: "other tenant", second: message, path: "/send", tenant: "globex", wantStatus: http.StatusOK, wantSent: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newGmailStub(t)
+			h := stub.newServer(withUndo, stub.withTenants("acme", "globex")).Handler()
+			header := map[string]string{idempotencyKeyHeader: "key-1", "X-Tenant-ID": "acme"}
+
+			rec := postPayload(h, "/send", stub.payload(t, message), header)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("first send = %d %s", rec.Code, rec.Body)
+			}
+			var first SendResponse
+			decodeJSON(t, rec, &first)
+
+			// A replay must not trash what arrived since.
+			stub.setLabel("INBOX", "new")
+			if tt.tenant != "" {
+				header["X-Tenant-ID"] = tt.tenant
+			}
+			rec = postPayload(h, tt.path, stub.payload(t, tt.second), header)
+			if replayed := rec.Header().Get(idempotentReplayedHeader) == "true"; replayed != tt.wantReplayed {
+				t.Fatalf("replayed = %v; want %v (%d %s)", replayed, tt.wantReplayed, rec.Code, rec.Body)
+			}
+			sent, _, trashed := stub.counts()
+			if sent != tt.wantSent {
+				t.Errorf("sent %d messages; want %d", sent, tt.wantSent)
+			}
+			if !tt.wantReplayed {
+				if rec.Code != tt.wantStatus {
+					t.Errorf("second send = %d %s; want %d", rec.Code, rec.Body, tt.wantStatus)
+				}
+				return
+			}
+			if rec.Code != http.StatusOK || trashed != 0 {
+				t.Errorf("replay = %d, with %d messages trashed; want 200 and none", rec.Code, trashed)
+			}
+			var second SendResponse
+			decodeJSON(t, rec, &second)
+			if second.Output == nil || second.Output.Id != first.Output.Id || second.RequestID != first.RequestID {
+				t.Errorf("replay answered %+v; want the first response %+v", second, first)
+			}
+		})
+	}
+}
+
+func TestIdempotencyKeyClaim(t *testing.T) {
+	stub := newGmailStub(t)
+	stub.release = make(chan struct{})
+	h := stub.newServer().Handler()
+	header := map[string]string{idempotencyKeyHeader: "key-1"}
+	payload := stub.payload(t, map[string]any{"to": "to@example.com", "subject": "Hello", "messageBody": "Hi"})
+
+	first := make(chan *httptest.ResponseRecorder)
+	go func() { first <- postPayload(h, "/send", payload, header) }()
+	// While the first send is being delivered, the key is held.
+	waitAttempts(t, stub, 1)
+	if rec := postPayload(h, "/send", payload, header); rec.Code != http.StatusConflict {
+		t.Errorf("concurrent send = %d %s; want %d", rec.Code, rec.Body, http.StatusConflict)
+	}
+	close(stub.release)
+	if rec := <-first; rec.Code != http.StatusOK {
+		t.Fatalf("first send = %d %s", rec.Code, rec.Body)
+	}
+	if sent, _, _ := stub.counts(); sent != 1 {
+		t.Errorf("sent %d messages; want 1", sent)
+	}
+}
+
+func TestIdempotencyKeyReleasedOnFailure(t *testing.T) {
+	stub := newGmailStub(t)
+	stub.sendStatus = http.StatusBadRequest
+	h := stub.newServer().Handler()
+	header := map[string]string{idempotencyKeyHeader: "key-1"}
+	payload := stub.payload(t, map[string]any{"to": "to@example.com", "subject": "Hello", "messageBody": "Hi"})
+
+	if rec := postPayload(h, "/send", payload, header); rec.Code == http.StatusOK {
+		t.Fatalf("failing send = %d %s", rec.Code, rec.Body)
+	}
+	stub.mu.Lock()
+	stub.sendStatus = 0
+	stub.mu.Unlock()
+	rec := postPayload(h, "/send", payload, header)
+	if rec.Code != http.StatusOK || rec.Header().Get(idempotentReplayedHeader) != "" {
+		t.Fatalf("retry = %d %s; want a send of its own", rec.Code, rec.Body)
+	}
+	if sent, _, _ := stub.counts(); sent != 1 {
+		t.Errorf("sent %d messages; want 1", sent)
+	}
+}
@@ -0,0 +1,7 @@
+//go:build !gosendertest
+
+package gosender
+
+// insecureTLSAllowed reports whether Config.TLSConfig may skip certificate
+// verification. It never may outside of builds with the gosendertest tag.
+const insecureTLSAllowed = false
@@ -0,0 +1,8 @@
+//go:build gosendertest
+
+package gosender
+
+// insecureTLSAllowed reports whether Config.TLSConfig may skip certificate
+// verification, as integration tests against a mock Gmail with a self-signed
+// certificate built with the gosendertest tag may.
+const insecureTLSAllowed = true
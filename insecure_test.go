@@ -0,0 +1,69 @@
+package gosender
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"testing"
+)
+
+func TestMockGmailTLS(t *testing.T) {
+	// Skipping verification only works in builds with the gosendertest tag.
+	insecureStatus := http.StatusBadGateway
+	if insecureTLSAllowed {
+		insecureStatus = http.StatusOK
+	}
+	tests := []struct {
+		name          string
+		tlsConfig     func(stub *gmailStub) *tls.Config
+		wantStatus    int
+		wantValidates bool
+	}{
+		{
+			name: "trusting the mock's certificate",
+			tlsConfig: func(stub *gmailStub) *tls.Config {
+				roots := x509.NewCertPool()
+				roots.AddCert(stub.server.Certificate())
+				return &tls.Config{RootCAs: roots}
+			},
+			wantStatus:    http.StatusOK,
+			wantValidates: true,
+		},
+		{
+			name:          "verifying against the system roots",
+			tlsConfig:     func(*gmailStub) *tls.Config { return nil },
+			wantStatus:    http.StatusBadGateway,
+			wantValidates: true,
+		},
+		{
+			name:          "skipping verification",
+			tlsConfig:     func(*gmailStub) *tls.Config { return &tls.Config{InsecureSkipVerify: true} },
+			wantStatus:    insecureStatus,
+			wantValidates: insecureTLSAllowed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newTLSGmailStub(t)
+			tlsConfig := tt.tlsConfig(stub)
+			if err := (&Config{TLSConfig: tlsConfig}).Validate(); (err == nil) != tt.wantValidates {
+				t.Errorf("Validate = %v; want it to pass: %v", err, tt.wantValidates)
+			}
+			s := stub.newServer(func(c *Config) { c.TLSConfig = tlsConfig })
+			defer s.Close()
+			payload := stub.payload(t, map[string]any{"to": "to@example.com", "subject": "Hello", "messageBody": "Hi"})
+
+			rec := postPayload(s.Handler(), "/send", payload, nil)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("send = %d %s; want %d", rec.Code, rec.Body, tt.wantStatus)
+			}
+			wantSent := 0
+			if tt.wantStatus == http.StatusOK {
+				wantSent = 1
+			}
+			if sent, _, _ := stub.counts(); sent != wantSent {
+				t.Errorf("sent %d messages; want %d", sent, wantSent)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package gosender
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+const (
+	// modeSend sends the message to its recipients. It is the default mode.
+	modeSend = "send"
+
+	// modeInsert inserts the message directly into the mailbox without sending
+	// it, as when importing messages or creating test fixtures.
+	modeInsert = "insert"
+)
+
+// validateMode rejects unknown modes and an internalDate outside insert mode,
+// and parses the internalDate.
+func validateMode(p *Payload) error {
+	switch p.Mode {
+	case "", modeSend, modeInsert:
+	default:
+		return fmt.Errorf("invalid mode %q: expected %q or %q", p.Mode, modeSend, modeInsert)
+	}
+
+	if p.InternalDate == "" {
+		return nil
+	}
+	if p.Mode != modeInsert {
+		return fmt.Errorf("internalDate is only supported in %q mode", modeInsert)
+	}
+
+	date, err := time.Parse(time.RFC3339, p.InternalDate)
+	if err != nil {
+		return fmt.Errorf("invalid internalDate: %v", err)
+	}
+	p.internalDate = date
+
+	return nil
+}
+
+// deliver sends message, or inserts it into the mailbox when the payload
+// selects insert mode. An inserted message with an internalDate takes its
+// timestamp from the Date header, which prepareMessage set to that date.
+func deliver(ctx context.Context, service *gmail.Service, payload *Payload, message *gmail.Message) (*gmail.Message, error) {
+	if payload.Mode != modeInsert {
+		return service.Users.Messages.Send(gmailUser(ctx), message).Context(ctx).Do()
+	}
+
+	call := service.Users.Messages.Insert(gmailUser(ctx), message).Context(ctx)
+	if !payload.internalDate.IsZero() {
+		call = call.InternalDateSource("dateHeader")
+	}
+
+	return call.Do()
+}
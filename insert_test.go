@@ -0,0 +1,72 @@
+package gosender
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestInsertMode(t *testing.T) {
+	tests := []struct {
+		name         string
+		fields       map[string]any
+		wantStatus   int
+		wantSent     int
+		wantInserted int
+		wantTrashed  int
+		wantHeader   string
+	}{
+		{
+			name:       "send cleans up",
+			fields:     map[string]any{"to": "to@example.com", "subject": "Hello", "messageBody": "Hi"},
+			wantStatus: http.StatusOK, wantSent: 1, wantTrashed: 1,
+		},
+		{
+			name:       "insert leaves the mailbox",
+			fields:     map[string]any{"to": "to@example.com", "subject": "Hello", "messageBody": "Hi", "mode": "insert"},
+			wantStatus: http.StatusOK, wantInserted: 1,
+		},
+		{
+			name:       "insert with internal date",
+			fields:     map[string]any{"to": "to@example.com", "subject": "Hello", "messageBody": "Hi", "mode": "insert", "internalDate": "2024-03-01T10:00:00Z"},
+			wantStatus: http.StatusOK, wantInserted: 1,
+			wantHeader: "Date: Fri, 01 Mar 2024 10:00:00 +0000",
+		},
+		{
+			name:       "internal date outside insert",
+			fields:     map[string]any{"to": "to@example.com", "subject": "Hello", "messageBody": "Hi", "internalDate": "2024-03-01T10:00:00Z"},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "unknown mode",
+			fields:     map[string]any{"to": "to@example.com", "subject": "Hello", "messageBody": "Hi", "mode": "draft"},
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newGmailStub(t)
+			stub.setLabel("INBOX", "old")
+			h := stub.newServer(withUndo).Handler()
+
+			rec := postPayload(h, "/send", stub.payload(t, tt.fields), nil)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("send = %d %s; want %d", rec.Code, rec.Body, tt.wantStatus)
+			}
+			sent, inserted, trashed := stub.counts()
+			if sent != tt.wantSent || inserted != tt.wantInserted || trashed != tt.wantTrashed {
+				t.Errorf("sent %d, inserted %d, trashed %d; want %d, %d and %d", sent, inserted, trashed, tt.wantSent, tt.wantInserted, tt.wantTrashed)
+			}
+			if tt.wantHeader != "" && !strings.Contains(stub.inserted[0], tt.wantHeader+"\r\n") {
+				t.Errorf("inserted message lacks %q:\n%s", tt.wantHeader, stub.inserted[0])
+			}
+			if rec.Code == http.StatusOK {
+				var response SendResponse
+				decodeJSON(t, rec, &response)
+				if (response.UndoID != "") != (tt.wantTrashed > 0) {
+					t.Errorf("undoId = %q with %d messages trashed", response.UndoID, tt.wantTrashed)
+				}
+			}
+		})
+	}
+}
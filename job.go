@@ -0,0 +1,151 @@
+package gosender
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// Job statuses, in the order a job goes through them.
+const (
+	jobPending = "pending"
+	jobRunning = "running"
+	jobDone    = "done"
+	jobFailed  = "failed"
+
+	// jobCanceled is the status of a job canceled through /cancel/{id}
+	// before it started running.
+	jobCanceled = "canceled"
+)
+
+// Job represents the state of an asynchronous send, as reported by /status/{id}.
+// A job starts pending, waiting for a worker, and is running once it has one;
+// it then ends either done or failed. A pending job may instead be canceled.
+// Trashed counts the existing messages trashed so far. Once the job is done,
+// Result is the SendResponse the send would have answered with, less the
+// token, which is never stored, and MessageID and ThreadID those of the sent
+// message, if any. Once it failed, Error is set, with the GmailStatus of the
+// failed Gmail API call if any. A job is only reported to its own tenant.
+type Job struct {
+	ID          string        `json:"id"`
+	Status      string        `json:"status"`
+	Trashed     int           `json:"trashed"`
+	MessageID   string        `json:"messageId,omitempty"`
+	ThreadID    string        `json:"threadId,omitempty"`
+	Result      *SendResponse `json:"result,omitempty"`
+	Error       string        `json:"error,omitempty"`
+	GmailStatus int           `json:"gmailStatus,omitempty"`
+
+	tenant string
+}
+
+// jobKey returns the store key of the job of the given tenant and ID.
+func jobKey(tenant, id string) string {
+	return "job:" + tenant + ":" + id
+}
+
+// startJob queues the payload's send to run in the background and responds with
+// 202 Accepted, pointing the Location header at the job's status endpoint. At
+// most Config.AsyncWorkers jobs run at once; the others wait as pending, and
+// may be canceled until they start running.
+func (s *Server) startJob(w http.ResponseWriter, r *http.Request, payload *Payload, claim *idempotencyClaim) {
+	job := &Job{ID: newRequestID(), Status: jobPending, tenant: tenantID(r.Context())}
+	s.saveJob(job)
+	accepted := *job
+
+	// The send outlives the request, so it must not be canceled along with it.
+	// The request ID and tenant carried by its context are kept.
+	ctx := context.WithoutCancel(r.Context())
+	canceled, _, finish := s.startCancelable(ctx, job.ID)
+	started := s.track(func() {
+		acquired := false
+		select {
+		case s.jobSlots <- struct{}{}:
+			acquired = true
+		case <-canceled.Done():
+		}
+		if finish() {
+			if acquired {
+				<-s.jobSlots
+			}
+			claim.release()
+			job.Status = jobCanceled
+			s.saveJob(job)
+			return
+		}
+		defer func() { <-s.jobSlots }()
+
+		job.Status = jobRunning
+		s.saveJob(job)
+
+		trashed := make(map[string]int)
+		response, err := s.send(ctx, payload, claim, func(event ProgressEvent) {
+			trashed[event.Label] = event.Trashed
+			job.Trashed = 0
+			for _, n := range trashed {
+				job.Trashed += n
+			}
+			s.saveJob(job)
+		})
+		if err != nil {
+			job.Status, job.Error, job.GmailStatus = jobFailed, err.Error(), upstreamStatus(err)
+		} else {
+			result := *response
+			result.Token = ""
+			job.Status, job.Result = jobDone, &result
+			if response.Output != nil {
+				job.MessageID, job.ThreadID = response.Output.Id, response.Output.ThreadId
+			}
+		}
+		s.saveJob(job)
+	})
+	if !started {
+		finish()
+		claim.release()
+		s.store.Delete(jobKey(job.tenant, job.ID))
+		writeError(w, withStatus(http.StatusServiceUnavailable, ErrServerClosed))
+		return
+	}
+
+	w.Header().Set("Location", "/status/"+accepted.ID)
+	w.WriteHeader(http.StatusAccepted)
+	s.writeJSON(w, r, accepted)
+}
+
+// saveJob stores the current state of job for Config.JobTTL.
+func (s *Server) saveJob(job *Job) {
+	value, err := json.Marshal(job)
+	if err != nil {
+		return
+	}
+
+	s.store.Set(jobKey(job.tenant, job.ID), value, s.config.JobTTL)
+}
+
+// handleStatus handles the HTTP request for the state of an asynchronous send,
+// identified by the job ID in the path /status/{id}. The jobs of other tenants
+// are not found.
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, withStatus(http.StatusMethodNotAllowed, errors.New("only GET requests are allowed")))
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/status/")
+	value, ok := s.store.Get(jobKey(tenantID(r.Context()), id))
+	if id == "" || !ok {
+		writeError(w, withStatus(http.StatusNotFound, errors.New("no such job")))
+		return
+	}
+
+	var job Job
+	if err := json.Unmarshal(value, &job); err != nil {
+		writeError(w, withStatus(http.StatusInternalServerError, err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	s.writeJSON(w, r, job)
+}
@@ -0,0 +1,146 @@
+package gosender
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// getStatus serves a GET of path through h as the given tenant.
+func getStatus(h http.Handler, path, tenant string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.Header.Set("X-Tenant-ID", tenant)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+// waitJob polls the job at location as tenant until it is no longer pending
+// or running, and returns it.
+func waitJob(t *testing.T, h http.Handler, location, tenant string) Job {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		rec := getStatus(h, location, tenant)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d %s", rec.Code, rec.Body)
+		}
+		var job Job
+		decodeJSON(t, rec, &job)
+		if job.Status != jobPending && job.Status != jobRunning {
+			return job
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("job %s did not finish", location)
+	return Job{}
+}
+
+func TestAsyncSend(t *testing.T) {
+	tests := []struct {
+		name       string
+		sendStatus int
+		wantStatus string
+	}{
+		{name: "done", wantStatus: jobDone},
+		{name: "failed", sendStatus: http.StatusBadRequest, wantStatus: jobFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newGmailStub(t)
+			stub.sendStatus = tt.sendStatus
+			h := stub.newServer(stub.withTenants("acme", "globex"), func(c *Config) { c.IncludeToken = true }).Handler()
+
+			payload := stub.payload(t, map[string]any{"to": "to@example.com", "subject": "Hello", "messageBody": "Hi"})
+			rec := postPayload(h, "/send?async=true", payload, map[string]string{"X-Tenant-ID": "acme"})
+			if rec.Code != http.StatusAccepted {
+				t.Fatalf("send = %d %s; want %d", rec.Code, rec.Body, http.StatusAccepted)
+			}
+			var accepted Job
+			decodeJSON(t, rec, &accepted)
+			location := rec.Header().Get("Location")
+			if accepted.Status != jobPending || location != "/status/"+accepted.ID {
+				t.Fatalf("accepted %+v at %q; want a pending job at its status", accepted, location)
+			}
+
+			job := waitJob(t, h, location, "acme")
+			if job.Status != tt.wantStatus {
+				t.Fatalf("job = %+v; want status %s", job, tt.wantStatus)
+			}
+			switch tt.wantStatus {
+			case jobDone:
+				if job.Result == nil || job.Result.Token != "" || job.MessageID == "" || job.MessageID != job.Result.Output.Id {
+					t.Errorf("done job = %+v; want its message and a result without the token", job)
+				}
+			case jobFailed:
+				if job.Error == "" || job.GmailStatus != tt.sendStatus {
+					t.Errorf("failed job = %+v; want its error and Gmail status", job)
+				}
+			}
+
+			if rec := getStatus(h, location, "globex"); rec.Code != http.StatusNotFound {
+				t.Errorf("status for another tenant = %d %s; want %d", rec.Code, rec.Body, http.StatusNotFound)
+			}
+		})
+	}
+}
+
+func TestAsyncJobStates(t *testing.T) {
+	tests := []struct {
+		name       string
+		sendStatus int
+		wantStatus string
+	}{
+		{name: "pending to done", wantStatus: jobDone},
+		{name: "pending to failed", sendStatus: http.StatusBadRequest, wantStatus: jobFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newGmailStub(t)
+			stub.sendStatus = tt.sendStatus
+			stub.release = make(chan struct{})
+			h := stub.newServer(stub.withTenants("acme"), func(c *Config) { c.AsyncWorkers = 1 }).Handler()
+			payload := stub.payload(t, map[string]any{"to": "to@example.com", "subject": "Hello", "messageBody": "Hi"})
+			start := func() string {
+				rec := postPayload(h, "/send?async=true", payload, map[string]string{"X-Tenant-ID": "acme"})
+				if rec.Code != http.StatusAccepted {
+					t.Fatalf("send = %d %s; want %d", rec.Code, rec.Body, http.StatusAccepted)
+				}
+				return rec.Header().Get("Location")
+			}
+
+			// The first job holds the only worker while the stub holds its
+			// send, leaving the second one pending.
+			first := start()
+			waitAttempts(t, stub, 1)
+			second := start()
+			for _, tc := range []struct {
+				location string
+				want     string
+			}{{first, jobRunning}, {second, jobPending}} {
+				var job Job
+				decodeJSON(t, getStatus(h, tc.location, "acme"), &job)
+				if job.Status != tc.want || job.Result != nil || job.MessageID != "" || job.Error != "" {
+					t.Fatalf("job = %+v; want it %s without a result", job, tc.want)
+				}
+			}
+			close(stub.release)
+
+			job := waitJob(t, h, second, "acme")
+			if job.Status != tt.wantStatus {
+				t.Fatalf("job = %+v; want status %s", job, tt.wantStatus)
+			}
+			switch tt.wantStatus {
+			case jobDone:
+				if job.MessageID == "" || job.ThreadID != "thread-"+job.MessageID || job.Result == nil || job.Result.Output.Id != job.MessageID || job.Error != "" {
+					t.Errorf("done job = %+v; want the sent message's IDs and result", job)
+				}
+			case jobFailed:
+				if job.Error == "" || job.Result != nil || job.MessageID != "" {
+					t.Errorf("failed job = %+v; want its error alone", job)
+				}
+			}
+		})
+	}
+}
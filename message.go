@@ -0,0 +1,616 @@
+package gosender
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"net/mail"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// defaultBoundaryPrefix starts the multipart boundaries of built messages
+// unless Config.BoundaryPrefix is set.
+const defaultBoundaryPrefix = "=_"
+
+// boundaryPrefixPattern matches a boundary prefix leaving room for the 48
+// random characters in the 70 that RFC 2046 allows a boundary, made of the
+// characters it allows other than space.
+var boundaryPrefixPattern = regexp.MustCompile(`^[0-9A-Za-z'()+_,\-./:=?]{1,22}$`)
+
+// messageIDPattern matches an angle-bracketed Message-ID of the form <local@domain>.
+var messageIDPattern = regexp.MustCompile(`^<[^<>@\s]+@[^<>@\s]+>$`)
+
+// feedbackIDPattern matches a Feedback-ID of the form
+// CampaignID:CustomerID:MailType:SenderID, where only SenderID is required.
+var feedbackIDPattern = regexp.MustCompile(`^[^:\s]*:[^:\s]*:[^:\s]*:[^:\s]+$`)
+
+// priorityHeaders maps each priority to the X-Priority value rendered for it;
+// the priority itself is rendered as the Importance header.
+var priorityHeaders = map[string]string{
+	"high":   "1 (Highest)",
+	"normal": "3 (Normal)",
+	"low":    "5 (Lowest)",
+}
+
+// headerField represents a single rendered message header.
+type headerField struct {
+	Name  string
+	Value string
+}
+
+// mimePart represents a MIME entity: its headers and either its encoded body,
+// the parts of a multipart entity or, for large content, the data to encode as
+// base64, given as is or already base64-encoded. Multiparts and base64 content
+// are only rendered when the whole message is written, straight into its
+// buffer, so that no part is copied into a buffer of its own.
+type mimePart struct {
+	headers  []headerField
+	body     []byte
+	parts    []mimePart
+	boundary string
+	base64   []byte
+	encoded  string
+}
+
+// fieldValues pairs a field name with its header-bound values.
+type fieldValues struct {
+	name   string
+	values []string
+}
+
+// isStructured reports whether the payload carries header fields, in which case
+// MessageBody is treated as the plain-text body rather than a complete message.
+// An explicit BuildMode takes precedence.
+func (p *Payload) isStructured() bool {
+	if p.BuildMode != "" {
+		return p.BuildMode == buildStructured
+	}
+	return p.hasStructuredFields()
+}
+
+// hasStructuredFields reports whether any of the fields only structured
+// messages carry is set.
+func (p *Payload) hasStructuredFields() bool {
+	return p.From != "" || len(p.To) > 0 || len(p.Cc) > 0 || len(p.Bcc) > 0 ||
+		p.ReplyTo != "" || p.Subject != "" || p.HTMLBody != "" || len(p.Attachments) > 0 ||
+		p.InReplyTo != "" || len(p.References) > 0 || p.ReplyToMessageID != "" || p.Report != nil ||
+		p.ForwardMessageID != "" || p.CalendarInvite != "" || p.VCard != ""
+}
+
+// validateHeaders rejects header-bound fields containing CR, LF or other control
+// characters, which could otherwise be used to inject additional headers.
+func validateHeaders(p *Payload) error {
+	fields := []fieldValues{
+		{"from", optional(p.From)},
+		{"to", p.To},
+		{"cc", p.Cc},
+		{"bcc", p.Bcc},
+		{"replyTo", optional(p.ReplyTo)},
+		{"subject", optional(p.Subject)},
+		{"messageId", optional(p.MessageID)},
+		{"inReplyTo", optional(p.InReplyTo)},
+		{"references", p.References},
+		{"threadTopic", optional(p.ThreadTopic)},
+		{"threadIndex", optional(p.ThreadIndex)},
+		{"entityRefId", optional(p.EntityRefID)},
+	}
+
+	for i, a := range p.Attachments {
+		fields = append(fields,
+			fieldValues{fmt.Sprintf("attachments[%d].filename", i), optional(a.Filename)},
+			fieldValues{fmt.Sprintf("attachments[%d].contentType", i), optional(a.ContentType)},
+		)
+	}
+
+	if p.Report != nil {
+		fields = append(fields, p.Report.reportFields()...)
+	}
+
+	for _, field := range fields {
+		for _, value := range field.values {
+			if containsControl(value) {
+				return fmt.Errorf("invalid %s: control characters are not allowed", field.name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// validateThreading rejects an In-Reply-To or References that are not
+// angle-bracketed Message-IDs, which would make for broken threading headers.
+// Each References entry may hold several IDs separated by whitespace.
+func validateThreading(p *Payload) error {
+	if p.InReplyTo != "" && !messageIDPattern.MatchString(p.InReplyTo) {
+		return fmt.Errorf("invalid inReplyTo %q: expected <local@domain>", p.InReplyTo)
+	}
+	for i, entry := range p.References {
+		ids := strings.Fields(entry)
+		if len(ids) == 0 {
+			return fmt.Errorf("invalid references[%d]: expected <local@domain>", i)
+		}
+		for _, id := range ids {
+			if !messageIDPattern.MatchString(id) {
+				return fmt.Errorf("invalid references[%d] %q: expected <local@domain>", i, id)
+			}
+		}
+	}
+
+	return nil
+}
+
+// validateContent rejects messages with neither a body nor a subject, which are
+// almost always a bug, unless AllowEmpty is set.
+func validateContent(p *Payload) error {
+	if p.AllowEmpty {
+		return nil
+	}
+	if p.MessageBody == "" && p.HTMLBody == "" && p.Subject == "" && len(p.Attachments) == 0 && p.ForwardMessageID == "" && p.CalendarInvite == "" && p.VCard == "" {
+		return errors.New("message body and subject are both empty; set allowEmpty to send it anyway")
+	}
+
+	return nil
+}
+
+// validateRecipients rejects structured messages to be sent without any
+// recipient, which Gmail could not deliver. Raw messages are left to Gmail,
+// and inserted messages need no recipients.
+func validateRecipients(p *Payload) error {
+	if !p.isStructured() || p.Mode == modeInsert {
+		return nil
+	}
+	if len(p.To) == 0 && len(p.Cc) == 0 && len(p.Bcc) == 0 {
+		return errors.New("no recipients: set at least one of to, cc or bcc")
+	}
+
+	return nil
+}
+
+// defaultMaxSubjectLength bounds the encoded length of subjects when
+// Config.MaxSubjectLength is unset. Clients truncate longer subjects, which
+// also look like spam.
+const defaultMaxSubjectLength = 255
+
+// validateSubjectLength rejects a built message whose Subject header is
+// longer than limit characters, or than defaultMaxSubjectLength when limit is
+// not positive. The header is measured as sent: RFC 2047 encoded, which
+// expands non-ASCII subjects several times over so that they reach the limit
+// much sooner, and with any prefix the server's policies added.
+func validateSubjectLength(raw []byte, limit int) error {
+	if limit <= 0 {
+		limit = defaultMaxSubjectLength
+	}
+	if n := len(parseRawMessage(raw).value("Subject")); n > limit {
+		return fmt.Errorf("subject is %d characters long once encoded, more than the maximum of %d", n, limit)
+	}
+
+	return nil
+}
+
+// containsControl reports whether s contains any control character other than tab.
+func containsControl(s string) bool {
+	return strings.IndexFunc(s, func(r rune) bool {
+		return r != '\t' && unicode.IsControl(r)
+	}) >= 0
+}
+
+// buildMessage returns the raw RFC 5322 message for the payload, dated now.
+// Payloads without header fields are passed through unchanged. Attachments and
+// any forwarded message must already have been loaded with loadAttachments and
+// loadForward.
+func buildMessage(p *Payload, now time.Time) ([]byte, error) {
+	if !p.isStructured() {
+		if p.MessageID != "" {
+			return nil, errors.New("messageId is only supported for structured messages")
+		}
+		if p.Priority != "" {
+			return nil, errors.New("priority is only supported for structured messages")
+		}
+		if p.Bulk {
+			return nil, errors.New("bulk is only supported for structured messages")
+		}
+		if p.RequestReadReceipt {
+			return nil, errors.New("requestReadReceipt is only supported for structured messages")
+		}
+		if p.FeedbackID != "" {
+			return nil, errors.New("feedbackId is only supported for structured messages")
+		}
+		if p.ThreadTopic != "" || p.ThreadIndex != "" {
+			return nil, errors.New("threadTopic and threadIndex are only supported for structured messages")
+		}
+		if p.SeparateThread || p.EntityRefID != "" {
+			return nil, errors.New("separateThread and entityRefId are only supported for structured messages")
+		}
+		return []byte(p.MessageBody), nil
+	}
+
+	headers := []headerField{{"Date", now.Format(time.RFC1123Z)}}
+	for _, list := range []fieldValues{
+		{"From", optional(p.From)},
+		{"To", p.To},
+		{"Cc", p.Cc},
+		{"Bcc", p.Bcc},
+		{"Reply-To", optional(p.ReplyTo)},
+	} {
+		if len(list.values) == 0 {
+			continue
+		}
+		value, err := formatAddressList(list.values)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s address: %v", list.name, err)
+		}
+		headers = append(headers, headerField{list.name, value})
+	}
+	if p.Subject != "" {
+		headers = append(headers, headerField{"Subject", mime.QEncoding.Encode("UTF-8", p.Subject)})
+	}
+	if p.MessageID != "" {
+		if !messageIDPattern.MatchString(p.MessageID) {
+			return nil, fmt.Errorf("invalid messageId %q: expected <local@domain>", p.MessageID)
+		}
+		headers = append(headers, headerField{"Message-ID", p.MessageID})
+	}
+	if p.InReplyTo != "" {
+		headers = append(headers, headerField{"In-Reply-To", p.InReplyTo})
+	}
+	if len(p.References) > 0 {
+		headers = append(headers, headerField{"References", strings.Join(p.References, " ")})
+	}
+	thread, err := threadHeaders(p, now)
+	if err != nil {
+		return nil, err
+	}
+	headers = append(headers, thread...)
+	if p.Priority != "" {
+		xPriority, ok := priorityHeaders[p.Priority]
+		if !ok {
+			return nil, fmt.Errorf("invalid priority %q: expected high, normal or low", p.Priority)
+		}
+		headers = append(headers, headerField{"Importance", p.Priority}, headerField{"X-Priority", xPriority})
+	}
+	if p.Bulk {
+		// Marks the message as bulk mail so that vacation responders and other
+		// automatic replies (RFC 3834) leave it alone.
+		headers = append(headers, headerField{"Precedence", "bulk"}, headerField{"Auto-Submitted", "auto-generated"})
+	}
+	if p.RequestReadReceipt {
+		// Asks for a read receipt (RFC 8098) at the Reply-To address, or the
+		// From address without one. Many clients ignore the request or leave
+		// it to the recipient.
+		address := p.ReplyTo
+		if address == "" {
+			address = p.From
+		}
+		if address == "" {
+			return nil, errors.New("requestReadReceipt requires a from or replyTo address")
+		}
+		value, err := formatAddressList([]string{address})
+		if err != nil {
+			return nil, fmt.Errorf("invalid read receipt address: %v", err)
+		}
+		headers = append(headers, headerField{"Disposition-Notification-To", value}, headerField{"Return-Receipt-To", value})
+	}
+	if p.SeparateThread || p.EntityRefID != "" {
+		if p.ThreadID != "" || p.InReplyTo != "" || p.ReplyToMessageID != "" {
+			return nil, errors.New("separateThread and entityRefId cannot be combined with threadId, inReplyTo or replyToMessageId")
+		}
+		// Gmail threads messages with the same subject together unless they
+		// carry distinct X-Entity-Ref-IDs.
+		ref := p.EntityRefID
+		if ref == "" {
+			ref = newRequestID()
+		}
+		headers = append(headers, headerField{"X-Entity-Ref-ID", ref})
+	}
+	if p.FeedbackID != "" {
+		if !feedbackIDPattern.MatchString(p.FeedbackID) {
+			return nil, fmt.Errorf("invalid feedbackId %q: expected CampaignID:CustomerID:MailType:SenderID", p.FeedbackID)
+		}
+		headers = append(headers, headerField{"Feedback-ID", p.FeedbackID})
+	}
+
+	root, err := bodyPart(p)
+	if err != nil {
+		return nil, err
+	}
+	if len(p.Attachments) > 0 || p.forwarded != nil || p.VCard != "" {
+		parts := []mimePart{root}
+		if p.forwarded != nil {
+			parts = append(parts, forwardPart(p.forwarded))
+		}
+		if p.VCard != "" {
+			vcard, err := vCardPart(p.VCard)
+			if err != nil {
+				return nil, err
+			}
+			parts = append(parts, vcard)
+		}
+		for i := range p.Attachments {
+			part, err := attachmentPart(&p.Attachments[i])
+			if err != nil {
+				return nil, err
+			}
+			parts = append(parts, part)
+		}
+		root = multipartPart(p, "mixed", parts)
+	}
+
+	headers = append(headers, headerField{"MIME-Version", "1.0"})
+	headers = append(headers, root.headers...)
+
+	var buf bytes.Buffer
+	buf.Grow(root.size() + 1024)
+	writeHeaders(&buf, headers)
+	root.writeBody(&buf)
+
+	return buf.Bytes(), nil
+}
+
+// bodyPart renders the message body. Delivery reports are sent as
+// multipart/report. When an HTML body or calendar invite is present it is sent
+// as multipart/alternative together with a plain-text version, derived from
+// the HTML when MessageBody is empty, the invite coming last as the richest.
+func bodyPart(p *Payload) (mimePart, error) {
+	if p.Report != nil {
+		if p.CalendarInvite != "" {
+			return mimePart{}, errors.New("calendarInvite cannot be combined with report")
+		}
+		return reportPart(p)
+	}
+	if p.HTMLBody == "" && p.CalendarInvite == "" {
+		return textPart("text/plain", p.MessageBody)
+	}
+
+	text := p.MessageBody
+	if text == "" {
+		text = htmlToText(p.HTMLBody)
+	}
+
+	plain, err := textPart("text/plain", text)
+	if err != nil {
+		return mimePart{}, err
+	}
+	parts := []mimePart{plain}
+	if p.HTMLBody != "" {
+		html, err := textPart("text/html", p.HTMLBody)
+		if err != nil {
+			return mimePart{}, err
+		}
+		parts = append(parts, html)
+	}
+	if p.CalendarInvite != "" {
+		calendar, err := calendarPart(p.CalendarInvite)
+		if err != nil {
+			return mimePart{}, err
+		}
+		parts = append(parts, calendar)
+	}
+
+	return multipartPart(p, "alternative", parts), nil
+}
+
+// textPart renders body as a quoted-printable UTF-8 part of the given text media type.
+func textPart(mediaType, body string) (mimePart, error) {
+	var buf bytes.Buffer
+	qp := quotedprintable.NewWriter(&buf)
+	if _, err := qp.Write([]byte(body)); err != nil {
+		return mimePart{}, fmt.Errorf("failed to encode body: %v", err)
+	}
+	if err := qp.Close(); err != nil {
+		return mimePart{}, fmt.Errorf("failed to encode body: %v", err)
+	}
+
+	return mimePart{
+		headers: []headerField{
+			{"Content-Type", mediaType + `; charset="UTF-8"`},
+			{"Content-Transfer-Encoding", "quoted-printable"},
+		},
+		body: buf.Bytes(),
+	}, nil
+}
+
+// multipartPart combines parts into a single multipart entity of the given
+// subtype, for the message of payload p.
+func multipartPart(p *Payload, subtype string, parts []mimePart) mimePart {
+	return multipartPartWithParams(p, subtype, nil, parts)
+}
+
+// multipartPartWithParams is like multipartPart but adds params to the Content-Type.
+func multipartPartWithParams(p *Payload, subtype string, params map[string]string, parts []mimePart) mimePart {
+	prefix := p.boundaryPrefix
+	if prefix == "" {
+		prefix = defaultBoundaryPrefix
+	}
+	boundary := newBoundary(prefix, parts)
+	contentParams := map[string]string{"boundary": boundary}
+	for k, v := range params {
+		contentParams[k] = v
+	}
+
+	return mimePart{
+		headers: []headerField{
+			{"Content-Type", mime.FormatMediaType("multipart/"+subtype, contentParams)},
+		},
+		parts:    parts,
+		boundary: boundary,
+	}
+}
+
+// writeBody writes the body of p to buf, rendering nested parts and encoding
+// base64 content on the fly. Bare LF line endings of other content, such as
+// that of forwarded messages and report headers, are written as CRLF.
+func (p mimePart) writeBody(buf *bytes.Buffer) {
+	switch {
+	case p.parts != nil:
+		for _, part := range p.parts {
+			buf.WriteString("--" + p.boundary + "\r\n")
+			writeHeaders(buf, part.headers)
+			part.writeBody(buf)
+			buf.WriteString("\r\n")
+		}
+		buf.WriteString("--" + p.boundary + "--\r\n")
+	case p.base64 != nil:
+		encoder := base64.NewEncoder(base64.StdEncoding, &lineWriter{w: buf})
+		encoder.Write(p.base64)
+		encoder.Close()
+	case p.encoded != "":
+		// The data was checked by loadAttachments, so it decodes cleanly.
+		encoder := base64.NewEncoder(base64.StdEncoding, &lineWriter{w: buf})
+		io.Copy(encoder, base64.NewDecoder(base64.StdEncoding, strings.NewReader(p.encoded)))
+		encoder.Close()
+	default:
+		writeCRLF(buf, p.body)
+	}
+}
+
+// writeCRLF writes data to buf with every bare LF written as CRLF, as RFC 5322
+// requires.
+func writeCRLF(buf *bytes.Buffer, data []byte) {
+	for {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			buf.Write(data)
+			return
+		}
+		if i > 0 && data[i-1] == '\r' {
+			buf.Write(data[:i+1])
+		} else {
+			buf.Write(data[:i])
+			buf.WriteString("\r\n")
+		}
+		data = data[i+1:]
+	}
+}
+
+// normalizeCRLF returns data with every bare LF turned into CRLF.
+func normalizeCRLF(data []byte) []byte {
+	if !bytes.Contains(data, []byte("\n")) {
+		return data
+	}
+
+	var buf bytes.Buffer
+	buf.Grow(len(data) + bytes.Count(data, []byte("\n")))
+	writeCRLF(&buf, data)
+	return buf.Bytes()
+}
+
+// size estimates the length of the rendered body of p, for sizing buffers.
+func (p mimePart) size() int {
+	n := len(p.body)
+	if p.base64 != nil {
+		encoded := base64.StdEncoding.EncodedLen(len(p.base64))
+		n += encoded + encoded/76*2
+	}
+	n += len(p.encoded) + len(p.encoded)/76*2
+	for _, part := range p.parts {
+		n += part.size() + len(p.boundary) + 256
+	}
+	return n
+}
+
+// lineWriter wraps the data written through it in lines of 76 characters, as
+// RFC 2045 requires of base64 content. Each line break is only written once
+// more data follows, so the content does not end with one.
+type lineWriter struct {
+	w io.Writer
+	n int
+}
+
+func (lw *lineWriter) Write(data []byte) (int, error) {
+	written := 0
+	for len(data) > 0 {
+		if lw.n == 76 {
+			if _, err := io.WriteString(lw.w, "\r\n"); err != nil {
+				return written, err
+			}
+			lw.n = 0
+		}
+
+		chunk := min(len(data), 76-lw.n)
+		n, err := lw.w.Write(data[:chunk])
+		written += n
+		lw.n += n
+		if err != nil {
+			return written, err
+		}
+		data = data[chunk:]
+	}
+	return written, nil
+}
+
+// newBoundary returns a crypto-random multipart boundary starting with prefix
+// that does not occur in any of parts. The default "=_" prefix can appear
+// neither in base64 nor in quoted-printable output, so regenerating is only
+// needed for parts carrying arbitrary content; base64 content, which has no
+// "-" to start a delimiter line with, need not be searched whatever the
+// prefix.
+func newBoundary(prefix string, parts []mimePart) string {
+	b := make([]byte, 24)
+	for {
+		if _, err := rand.Read(b); err != nil {
+			panic("gosender: failed to generate boundary: " + err.Error())
+		}
+
+		boundary := prefix + hex.EncodeToString(b)
+		if !boundaryCollides(boundary, parts) {
+			return boundary
+		}
+	}
+}
+
+// boundaryCollides reports whether boundary occurs in the headers or body of any part.
+func boundaryCollides(boundary string, parts []mimePart) bool {
+	for _, part := range parts {
+		if bytes.Contains(part.body, []byte(boundary)) || boundaryCollides(boundary, part.parts) {
+			return true
+		}
+		for _, h := range part.headers {
+			if strings.Contains(h.Value, boundary) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// writeHeaders writes the header fields followed by the blank line separating them from the body.
+func writeHeaders(buf *bytes.Buffer, headers []headerField) {
+	for _, h := range headers {
+		buf.WriteString(h.Name)
+		buf.WriteString(": ")
+		buf.WriteString(h.Value)
+		buf.WriteString("\r\n")
+	}
+	buf.WriteString("\r\n")
+}
+
+// optional returns s as a single-element slice, or nil when s is empty.
+func optional(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return []string{s}
+}
+
+// formatAddressList parses the given addresses and renders them as a single header value.
+func formatAddressList(values []string) (string, error) {
+	formatted := make([]string, 0, len(values))
+	for _, value := range values {
+		addr, err := mail.ParseAddress(value)
+		if err != nil {
+			return "", fmt.Errorf("%q: %v", value, err)
+		}
+		formatted = append(formatted, addr.String())
+	}
+
+	return strings.Join(formatted, ", "), nil
+}
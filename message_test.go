@@ -0,0 +1,744 @@
+package gosender
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/mail"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// attachmentData is large enough to span several lines once encoded.
+var attachmentData = bytes.Repeat([]byte("attachment\x00data\n"), 100)
+
+func TestBuildMessage(t *testing.T) {
+	tests := []struct {
+		name        string
+		payload     Payload
+		wantType    string
+		wantParts   []string
+		wantErr     bool
+		attachments int
+	}{
+		{
+			name:     "plain text",
+			payload:  Payload{To: AddressList{"to@example.com"}, Subject: "Hi", MessageBody: "Hello"},
+			wantType: "text/plain",
+		},
+		{
+			name:      "HTML with a text alternative",
+			payload:   Payload{To: AddressList{"to@example.com"}, Subject: "Hi", HTMLBody: "<p>Hello</p>"},
+			wantType:  "multipart/alternative",
+			wantParts: []string{"text/plain", "text/html"},
+		},
+		{
+			name: "inline attachment",
+			payload: Payload{To: AddressList{"to@example.com"}, Subject: "Hi", MessageBody: "Hello", Attachments: []Attachment{
+				{Filename: "data.bin", ContentType: "application/octet-stream", Data: base64.StdEncoding.EncodeToString(attachmentData)},
+			}},
+			wantType:    "multipart/mixed",
+			wantParts:   []string{"text/plain", "application/octet-stream"},
+			attachments: 1,
+		},
+		{
+			name: "sniffed attachment type",
+			payload: Payload{To: AddressList{"to@example.com"}, Subject: "Hi", MessageBody: "Hello", Attachments: []Attachment{
+				{Filename: "page", Data: base64.StdEncoding.EncodeToString([]byte("<html><body>Hi</body></html>"))},
+			}},
+			wantType:  "multipart/mixed",
+			wantParts: []string{"text/plain", "text/html"},
+		},
+		{
+			name: "invalid attachment data",
+			payload: Payload{To: AddressList{"to@example.com"}, Subject: "Hi", Attachments: []Attachment{
+				{Filename: "data.bin", Data: "not base64!"},
+			}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded, err := EncodeMessage(Message{Payload: &tt.payload})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("EncodeMessage error = %v; want an error: %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			raw, err := base64.URLEncoding.DecodeString(encoded)
+			if err != nil {
+				t.Fatalf("failed to decode message: %v", err)
+			}
+			msg, err := mail.ReadMessage(bytes.NewReader(raw))
+			if err != nil {
+				t.Fatalf("failed to parse message: %v", err)
+			}
+			if got := msg.Header.Get("To"); got != "<to@example.com>" {
+				t.Errorf("To = %q; want <to@example.com>", got)
+			}
+			if got := msg.Header.Get("Subject"); got != "Hi" {
+				t.Errorf("Subject = %q; want Hi", got)
+			}
+			mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+			if err != nil || mediaType != tt.wantType {
+				t.Fatalf("Content-Type = %q, %v; want %s", msg.Header.Get("Content-Type"), err, tt.wantType)
+			}
+			if !strings.HasPrefix(mediaType, "multipart/") {
+				return
+			}
+
+			var types []string
+			reader := multipart.NewReader(msg.Body, params["boundary"])
+			for {
+				part, err := reader.NextRawPart()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatalf("failed to read part: %v", err)
+				}
+				partType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+				types = append(types, partType)
+				if part.FileName() == "" {
+					continue
+				}
+
+				body, _ := io.ReadAll(part)
+				for _, line := range strings.Split(strings.TrimRight(string(body), "\r\n"), "\r\n") {
+					if len(line) > 76 {
+						t.Errorf("attachment line of %d characters; want at most 76", len(line))
+					}
+				}
+				if tt.attachments > 0 {
+					content, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(string(body), "\r\n", ""))
+					if err != nil || !bytes.Equal(content, attachmentData) {
+						t.Errorf("attachment content = %q, %v; want %q", content, err, attachmentData)
+					}
+				}
+			}
+			if strings.Join(types, ",") != strings.Join(tt.wantParts, ",") {
+				t.Errorf("parts = %v; want %v", types, tt.wantParts)
+			}
+		})
+	}
+}
+
+func TestHeaderInjection(t *testing.T) {
+	const injected = "Hello\r\nBcc: attacker@evil.com"
+	tests := []struct {
+		name       string
+		fields     map[string]any
+		wantStatus int
+	}{
+		{name: "plain subject", fields: map[string]any{"subject": "Hello"}, wantStatus: http.StatusOK},
+		{name: "subject", fields: map[string]any{"subject": injected}, wantStatus: http.StatusBadRequest},
+		{name: "to", fields: map[string]any{"subject": "Hello", "to": "to@example.com\r\nBcc: attacker@evil.com"}, wantStatus: http.StatusBadRequest},
+		{name: "replyTo", fields: map[string]any{"subject": "Hello", "replyTo": "reply@example.com\nBcc: attacker@evil.com"}, wantStatus: http.StatusBadRequest},
+		{name: "other control character", fields: map[string]any{"subject": "Hello\x00"}, wantStatus: http.StatusBadRequest},
+		{
+			name: "attachment filename",
+			fields: map[string]any{"subject": "Hello", "attachments": []map[string]any{
+				{"filename": "a.txt\r\nBcc: attacker@evil.com", "data": base64.StdEncoding.EncodeToString([]byte("data"))},
+			}},
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newGmailStub(t)
+			h := stub.newServer().Handler()
+			fields := map[string]any{"to": "to@example.com", "messageBody": "Hi"}
+			for k, v := range tt.fields {
+				fields[k] = v
+			}
+
+			rec := postPayload(h, "/send", stub.payload(t, fields), nil)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("send = %d %s; want %d", rec.Code, rec.Body, tt.wantStatus)
+			}
+			sent, _, _ := stub.counts()
+			if tt.wantStatus != http.StatusOK {
+				if sent != 0 {
+					t.Errorf("sent %d messages; want none", sent)
+				}
+				return
+			}
+			if sent != 1 || strings.Contains(stub.sent[0], "attacker@evil.com") {
+				t.Errorf("sent %q; want one message without the injected header", stub.sent)
+			}
+		})
+	}
+}
+
+func TestMessageID(t *testing.T) {
+	tests := []struct {
+		name       string
+		fields     map[string]any
+		wantStatus int
+	}{
+		{name: "valid", fields: map[string]any{"subject": "Hello", "messageId": "<order-42.1@tracking.example.com>"}, wantStatus: http.StatusOK},
+		{name: "no angle brackets", fields: map[string]any{"subject": "Hello", "messageId": "order-42@tracking.example.com"}, wantStatus: http.StatusBadRequest},
+		{name: "no domain", fields: map[string]any{"subject": "Hello", "messageId": "<order-42>"}, wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newGmailStub(t)
+			h := stub.newServer().Handler()
+			fields := map[string]any{"to": "to@example.com", "messageBody": "Hi"}
+			for k, v := range tt.fields {
+				fields[k] = v
+			}
+
+			rec := postPayload(h, "/send", stub.payload(t, fields), nil)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("send = %d %s; want %d", rec.Code, rec.Body, tt.wantStatus)
+			}
+			sent, _, _ := stub.counts()
+			if tt.wantStatus != http.StatusOK {
+				if sent != 0 {
+					t.Errorf("sent %d messages; want none", sent)
+				}
+				return
+			}
+			msg, err := mail.ReadMessage(strings.NewReader(stub.sent[0]))
+			if err != nil {
+				t.Fatalf("failed to parse sent message: %v", err)
+			}
+			if got := msg.Header.Get("Message-ID"); got != tt.fields["messageId"] {
+				t.Errorf("Message-ID = %q; want %q", got, tt.fields["messageId"])
+			}
+		})
+	}
+}
+
+func TestThreadingMessageIDs(t *testing.T) {
+	tests := []struct {
+		name       string
+		fields     map[string]any
+		wantStatus int
+	}{
+		{name: "valid In-Reply-To", fields: map[string]any{"inReplyTo": "<order-42@mail.example.com>"}, wantStatus: http.StatusOK},
+		{name: "valid References", fields: map[string]any{"references": []string{"<a@mail.example.com> <b@mail.example.com>", "<c@mail.example.com>"}}, wantStatus: http.StatusOK},
+		{name: "In-Reply-To without angle brackets", fields: map[string]any{"inReplyTo": "order-42@mail.example.com"}, wantStatus: http.StatusBadRequest},
+		{name: "In-Reply-To without domain", fields: map[string]any{"inReplyTo": "<order-42>"}, wantStatus: http.StatusBadRequest},
+		{name: "malformed References", fields: map[string]any{"references": []string{"<a@mail.example.com> b@mail.example.com"}}, wantStatus: http.StatusBadRequest},
+		{name: "empty References entry", fields: map[string]any{"references": []string{" "}}, wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newGmailStub(t)
+			h := stub.newServer().Handler()
+			fields := map[string]any{"to": "to@example.com", "subject": "Hello", "messageBody": "Hi"}
+			for k, v := range tt.fields {
+				fields[k] = v
+			}
+
+			rec := postPayload(h, "/send", stub.payload(t, fields), nil)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("send = %d %s; want %d", rec.Code, rec.Body, tt.wantStatus)
+			}
+			sent, _, _ := stub.counts()
+			if tt.wantStatus != http.StatusOK {
+				var response ErrorResponse
+				decodeJSON(t, rec, &response)
+				if response.Code != ErrBadPayload {
+					t.Errorf("code = %q; want %q", response.Code, ErrBadPayload)
+				}
+				if sent != 0 {
+					t.Errorf("sent %d messages; want none", sent)
+				}
+				return
+			}
+			msg, err := mail.ReadMessage(strings.NewReader(stub.sent[0]))
+			if err != nil {
+				t.Fatalf("failed to parse sent message: %v", err)
+			}
+			if want, ok := tt.fields["inReplyTo"]; ok && msg.Header.Get("In-Reply-To") != want {
+				t.Errorf("In-Reply-To = %q; want %q", msg.Header.Get("In-Reply-To"), want)
+			}
+		})
+	}
+}
+
+func TestEmptyMessage(t *testing.T) {
+	tests := []struct {
+		name       string
+		fields     map[string]any
+		wantStatus int
+	}{
+		{name: "empty", fields: map[string]any{}, wantStatus: http.StatusBadRequest},
+		{name: "subject only", fields: map[string]any{"subject": "Hello"}, wantStatus: http.StatusOK},
+		{name: "body only", fields: map[string]any{"messageBody": "Hi"}, wantStatus: http.StatusOK},
+		{name: "allowed empty", fields: map[string]any{"allowEmpty": true}, wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newGmailStub(t)
+			h := stub.newServer().Handler()
+			fields := map[string]any{"to": "to@example.com"}
+			for k, v := range tt.fields {
+				fields[k] = v
+			}
+
+			rec := postPayload(h, "/send", stub.payload(t, fields), nil)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("send = %d %s; want %d", rec.Code, rec.Body, tt.wantStatus)
+			}
+			wantSent := 0
+			if tt.wantStatus == http.StatusOK {
+				wantSent = 1
+			}
+			if sent, _, _ := stub.counts(); sent != wantSent {
+				t.Errorf("sent %d messages; want %d", sent, wantSent)
+			}
+		})
+	}
+}
+
+func TestMissingRecipients(t *testing.T) {
+	tests := []struct {
+		name       string
+		fields     map[string]any
+		wantStatus int
+	}{
+		{name: "all lists empty", fields: map[string]any{"to": []string{}, "cc": "", "bcc": nil, "subject": "Hello"}, wantStatus: http.StatusBadRequest},
+		{name: "no lists", fields: map[string]any{"from": "me@example.com", "subject": "Hello"}, wantStatus: http.StatusBadRequest},
+		{name: "bcc only", fields: map[string]any{"bcc": "archive@example.com", "subject": "Hello"}, wantStatus: http.StatusOK},
+		{name: "raw body", fields: map[string]any{"messageBody": "Subject: Hello\r\n\r\nHi"}, wantStatus: http.StatusOK},
+		{name: "inserted", fields: map[string]any{"subject": "Hello", "mode": "insert"}, wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newGmailStub(t)
+			h := stub.newServer().Handler()
+
+			rec := postPayload(h, "/send", stub.payload(t, tt.fields), nil)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("send = %d %s; want %d", rec.Code, rec.Body, tt.wantStatus)
+			}
+			sent, inserted, _ := stub.counts()
+			if tt.wantStatus == http.StatusOK {
+				if sent+inserted != 1 {
+					t.Errorf("sent %d and inserted %d messages; want 1", sent, inserted)
+				}
+				return
+			}
+			var response ErrorResponse
+			decodeJSON(t, rec, &response)
+			if !strings.Contains(response.Error, "no recipients") || response.Code != ErrBadPayload || sent+inserted != 0 {
+				t.Errorf("error = %+v after %d sends; want no recipients reported and nothing sent", response, sent+inserted)
+			}
+		})
+	}
+}
+
+func TestNewBoundary(t *testing.T) {
+	// wouldBe is a delimiter line such as a message quoting another might carry.
+	wouldBe := "--=_0123456789abcdef0123456789abcdef0123456789abcdef"
+	tests := []struct {
+		name  string
+		parts []mimePart
+	}{
+		{name: "in a body", parts: []mimePart{{body: []byte("quoted:\r\n" + wouldBe + "\r\n")}}},
+		{name: "in a header", parts: []mimePart{{headers: []headerField{{"Content-Description", wouldBe}}}}},
+		{name: "in a nested part", parts: []mimePart{{parts: []mimePart{{body: []byte(wouldBe)}}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !boundaryCollides(strings.TrimPrefix(wouldBe, "--"), tt.parts) {
+				t.Fatalf("the would-be boundary was not found in the parts")
+			}
+
+			seen := make(map[string]bool)
+			for i := 0; i < 100; i++ {
+				boundary := newBoundary(defaultBoundaryPrefix, tt.parts)
+				if !strings.HasPrefix(boundary, defaultBoundaryPrefix) || len(boundary) > 70 {
+					t.Fatalf("boundary %q; want at most 70 characters starting with %q", boundary, defaultBoundaryPrefix)
+				}
+				if boundaryCollides(boundary, tt.parts) {
+					t.Fatalf("boundary %q occurs in the parts", boundary)
+				}
+				if seen[boundary] {
+					t.Fatalf("boundary %q generated twice", boundary)
+				}
+				seen[boundary] = true
+			}
+		})
+	}
+
+	t.Run("message quoting a boundary", func(t *testing.T) {
+		body := "A forwarded message:\r\n" + wouldBe + "\r\nContent-Type: text/plain\r\n\r\nnot a part\r\n" + wouldBe + "--\r\n"
+		payload := Payload{To: AddressList{"to@example.com"}, Subject: "Hi", MessageBody: body, HTMLBody: "<pre>" + body + "</pre>"}
+		raw, err := buildMessage(&payload, time.Now())
+		if err != nil {
+			t.Fatalf("buildMessage: %v", err)
+		}
+		msg, err := mail.ReadMessage(bytes.NewReader(raw))
+		if err != nil {
+			t.Fatalf("failed to parse message: %v", err)
+		}
+		_, params, _ := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+		reader := multipart.NewReader(msg.Body, params["boundary"])
+		parts := 0
+		for {
+			_, err := reader.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("failed to read part: %v", err)
+			}
+			parts++
+		}
+		if parts != 2 {
+			t.Errorf("read %d parts; want the text and HTML alternatives", parts)
+		}
+	})
+}
+
+func TestPriority(t *testing.T) {
+	tests := []struct {
+		priority       string
+		wantImportance string
+		wantXPriority  string
+		wantErr        bool
+	}{
+		{priority: ""},
+		{priority: "high", wantImportance: "high", wantXPriority: "1 (Highest)"},
+		{priority: "normal", wantImportance: "normal", wantXPriority: "3 (Normal)"},
+		{priority: "low", wantImportance: "low", wantXPriority: "5 (Lowest)"},
+		{priority: "urgent", wantErr: true},
+		{priority: "High", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.priority, func(t *testing.T) {
+			payload := Payload{To: AddressList{"to@example.com"}, Subject: "Hi", MessageBody: "Hello", Priority: tt.priority}
+			raw, err := buildMessage(&payload, time.Now())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("buildMessage error = %v; want an error: %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			msg, err := mail.ReadMessage(bytes.NewReader(raw))
+			if err != nil {
+				t.Fatalf("failed to parse message: %v", err)
+			}
+			if got := msg.Header.Get("Importance"); got != tt.wantImportance {
+				t.Errorf("Importance = %q; want %q", got, tt.wantImportance)
+			}
+			if got := msg.Header.Get("X-Priority"); got != tt.wantXPriority {
+				t.Errorf("X-Priority = %q; want %q", got, tt.wantXPriority)
+			}
+		})
+	}
+}
+
+func TestBulk(t *testing.T) {
+	tests := []struct {
+		name              string
+		bulk              bool
+		wantPrecedence    string
+		wantAutoSubmitted string
+	}{
+		{name: "bulk", bulk: true, wantPrecedence: "bulk", wantAutoSubmitted: "auto-generated"},
+		{name: "not bulk"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload := Payload{To: AddressList{"to@example.com"}, Subject: "Newsletter", MessageBody: "Hello", Bulk: tt.bulk}
+			raw, err := buildMessage(&payload, time.Now())
+			if err != nil {
+				t.Fatalf("buildMessage: %v", err)
+			}
+			msg, err := mail.ReadMessage(bytes.NewReader(raw))
+			if err != nil {
+				t.Fatalf("failed to parse message: %v", err)
+			}
+			if got := msg.Header.Get("Precedence"); got != tt.wantPrecedence {
+				t.Errorf("Precedence = %q; want %q", got, tt.wantPrecedence)
+			}
+			if got := msg.Header.Get("Auto-Submitted"); got != tt.wantAutoSubmitted {
+				t.Errorf("Auto-Submitted = %q; want %q", got, tt.wantAutoSubmitted)
+			}
+		})
+	}
+
+	t.Run("raw message", func(t *testing.T) {
+		payload := Payload{MessageBody: "Subject: Hi\r\n\r\nHello", Bulk: true}
+		if _, err := buildMessage(&payload, time.Now()); err == nil {
+			t.Error("buildMessage of a bulk raw message succeeded; want an error")
+		}
+	})
+}
+
+func TestReadReceipt(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload Payload
+		want    string
+		wantErr bool
+	}{
+		{name: "from address", payload: Payload{From: "Sender <me@example.com>", RequestReadReceipt: true}, want: `"Sender" <me@example.com>`},
+		{name: "reply-to preferred", payload: Payload{From: "me@example.com", ReplyTo: "support@example.com", RequestReadReceipt: true}, want: "<support@example.com>"},
+		{name: "not requested", payload: Payload{From: "me@example.com"}},
+		{name: "no address", payload: Payload{RequestReadReceipt: true}, wantErr: true},
+		{name: "invalid address", payload: Payload{From: "not an address", RequestReadReceipt: true}, wantErr: true},
+		{name: "raw message", payload: Payload{MessageBody: "Subject: Hi\r\n\r\nHello", RequestReadReceipt: true}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload := tt.payload
+			if payload.MessageBody == "" {
+				payload.To, payload.Subject, payload.MessageBody = AddressList{"to@example.com"}, "Hello", "Hi"
+			}
+			raw, err := buildMessage(&payload, time.Now())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("buildMessage error = %v; want an error: %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			msg, err := mail.ReadMessage(bytes.NewReader(raw))
+			if err != nil {
+				t.Fatalf("failed to parse message: %v", err)
+			}
+			for _, name := range []string{"Disposition-Notification-To", "Return-Receipt-To"} {
+				if got := msg.Header.Get(name); got != tt.want {
+					t.Errorf("%s = %q; want %q", name, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestFeedbackID(t *testing.T) {
+	tests := []struct {
+		feedbackID string
+		wantErr    bool
+	}{
+		{feedbackID: "spring-sale:customer-42:newsletter:example-mailer"},
+		{feedbackID: ":::example-mailer"},
+		{feedbackID: "campaign::promo:example-mailer"},
+		{feedbackID: "example-mailer", wantErr: true},
+		{feedbackID: "campaign:customer:promo:", wantErr: true},
+		{feedbackID: "a:b:c:d:e", wantErr: true},
+		{feedbackID: "campaign 1:customer:promo:sender", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.feedbackID, func(t *testing.T) {
+			payload := Payload{To: AddressList{"to@example.com"}, Subject: "Hi", MessageBody: "Hello", FeedbackID: tt.feedbackID}
+			raw, err := buildMessage(&payload, time.Now())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("buildMessage error = %v; want an error: %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			msg, err := mail.ReadMessage(bytes.NewReader(raw))
+			if err != nil {
+				t.Fatalf("failed to parse message: %v", err)
+			}
+			got := msg.Header.Get("Feedback-ID")
+			if got != tt.feedbackID || len(strings.Split(got, ":")) != 4 {
+				t.Errorf("Feedback-ID = %q; want %q with four colon-separated fields", got, tt.feedbackID)
+			}
+		})
+	}
+}
+
+func TestEntityRefID(t *testing.T) {
+	tests := []struct {
+		name       string
+		payload    Payload
+		wantRef    string
+		wantUnique bool
+		wantErr    bool
+	}{
+		{name: "generated", payload: Payload{SeparateThread: true}, wantUnique: true},
+		{name: "given", payload: Payload{EntityRefID: "order-1234"}, wantRef: "order-1234"},
+		{name: "disabled", payload: Payload{}},
+		{name: "in a thread", payload: Payload{SeparateThread: true, ThreadID: "thread-1"}, wantErr: true},
+		{name: "in reply", payload: Payload{EntityRefID: "order-1234", InReplyTo: "<parent@example.com>"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			refs := make(map[string]bool)
+			for i := 0; i < 2; i++ {
+				payload := tt.payload
+				payload.To, payload.Subject, payload.MessageBody = AddressList{"to@example.com"}, "Your receipt", "Thanks"
+				raw, err := buildMessage(&payload, time.Now())
+				if (err != nil) != tt.wantErr {
+					t.Fatalf("buildMessage error = %v; want an error: %v", err, tt.wantErr)
+				}
+				if tt.wantErr {
+					return
+				}
+				msg, err := mail.ReadMessage(bytes.NewReader(raw))
+				if err != nil {
+					t.Fatalf("failed to parse message: %v", err)
+				}
+
+				got := msg.Header.Get("X-Entity-Ref-ID")
+				switch {
+				case tt.wantUnique && (got == "" || refs[got]):
+					t.Errorf("X-Entity-Ref-ID = %q; want a new value for every message", got)
+				case !tt.wantUnique && got != tt.wantRef:
+					t.Errorf("X-Entity-Ref-ID = %q; want %q", got, tt.wantRef)
+				}
+				refs[got] = true
+			}
+		})
+	}
+}
+
+func TestLineEndings(t *testing.T) {
+	bareLF := regexp.MustCompile(`(^|[^\r])\n`)
+	tests := []struct {
+		name      string
+		normalize bool
+		fields    map[string]any
+		want      string
+	}{
+		{
+			name:      "raw normalized",
+			normalize: true,
+			fields:    map[string]any{"messageBody": "To: to@example.com\nSubject: Hello\r\n\nHi\nthere\n"},
+			want:      "To: to@example.com\r\nSubject: Hello\r\n\r\nHi\r\nthere\r\n",
+		},
+		{
+			name:   "raw verbatim",
+			fields: map[string]any{"messageBody": "To: to@example.com\nSubject: Hello\n\nHi\n"},
+			want:   "To: to@example.com\nSubject: Hello\n\nHi\n",
+		},
+		{
+			name: "structured",
+			fields: map[string]any{
+				"to": "to@example.com", "subject": "Hello", "messageBody": "Hi\nthere\n", "htmlBody": "<p>Hi</p>\n<p>there</p>\n",
+				"attachments": []map[string]any{{"filename": "data.bin", "contentType": "application/octet-stream", "data": base64.StdEncoding.EncodeToString(attachmentData)}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newGmailStub(t)
+			h := stub.newServer(func(c *Config) { c.NormalizeLineEndings = tt.normalize }).Handler()
+
+			if rec := postPayload(h, "/send", stub.payload(t, tt.fields), nil); rec.Code != http.StatusOK {
+				t.Fatalf("send = %d %s; want %d", rec.Code, rec.Body, http.StatusOK)
+			}
+			if len(stub.sent) != 1 {
+				t.Fatalf("sent %d messages; want 1", len(stub.sent))
+			}
+			raw := stub.sent[0]
+			if tt.want != "" {
+				if raw != tt.want {
+					t.Errorf("sent %q; want %q", raw, tt.want)
+				}
+				return
+			}
+
+			if bareLF.MatchString(raw) {
+				t.Errorf("sent %q; want CRLF line endings only", raw)
+			}
+			bodies := messageBodies(t, raw)
+			if bodies["text/plain"] != "Hi\r\nthere\r\n" || bodies["text/html"] != "<p>Hi</p>\r\n<p>there</p>\r\n" {
+				t.Errorf("bodies %q; want their lines ending in CRLF", bodies)
+			}
+			if bodies["application/octet-stream"] != string(attachmentData) {
+				t.Errorf("attachment = %q; want its bare LFs kept", bodies["application/octet-stream"])
+			}
+		})
+	}
+}
+
+func TestDateHeader(t *testing.T) {
+	now := time.Date(2024, 3, 10, 12, 30, 5, 0, time.UTC)
+	tests := []struct {
+		name   string
+		clock  time.Time
+		fields map[string]any
+		want   string
+	}{
+		{name: "fixed clock", clock: now, fields: map[string]any{"to": "to@example.com", "subject": "Hello"}, want: "Sun, 10 Mar 2024 12:30:05 +0000"},
+		{name: "clock zone kept", clock: now.In(time.FixedZone("CET", 3600)), fields: map[string]any{"to": "to@example.com", "subject": "Hello"}, want: "Sun, 10 Mar 2024 13:30:05 +0100"},
+		{name: "raw left alone", clock: now, fields: map[string]any{"messageBody": "To: to@example.com\r\nSubject: Raw\r\n\r\nHi\r\n"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newGmailStub(t)
+			h := stub.newServer(WithClock(func() time.Time { return tt.clock })).Handler()
+			fields := map[string]any{"messageBody": "Hi"}
+			for k, v := range tt.fields {
+				fields[k] = v
+			}
+
+			for i := 0; i < 2; i++ {
+				if rec := postPayload(h, "/send", stub.payload(t, fields), nil); rec.Code != http.StatusOK {
+					t.Fatalf("send = %d %s; want 200", rec.Code, rec.Body)
+				}
+			}
+			for i, message := range stub.sent {
+				if got := parseRawMessage([]byte(message)).value("Date"); got != tt.want {
+					t.Errorf("message %d Date = %q; want %q", i, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestMultipartMIMEVersion(t *testing.T) {
+	attachment := []map[string]any{{"filename": "data.bin", "data": base64.StdEncoding.EncodeToString(attachmentData)}}
+	tests := []struct {
+		name       string
+		prefix     string
+		fields     map[string]any
+		wantParts  int
+		wantPrefix string
+	}{
+		{name: "alternative", fields: map[string]any{"htmlBody": "<p>Hi</p>"}, wantParts: 1, wantPrefix: defaultBoundaryPrefix},
+		{name: "mixed", fields: map[string]any{"attachments": attachment}, wantParts: 1, wantPrefix: defaultBoundaryPrefix},
+		{name: "nested", fields: map[string]any{"htmlBody": "<p>Hi</p>", "attachments": attachment}, wantParts: 2, wantPrefix: defaultBoundaryPrefix},
+		{name: "nested with a boundary prefix", prefix: "gw-", fields: map[string]any{"htmlBody": "<p>Hi</p>", "attachments": attachment}, wantParts: 2, wantPrefix: "gw-"},
+	}
+	boundaryPattern := regexp.MustCompile(`boundary="?([^";\r\n]+)`)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newGmailStub(t)
+			h := stub.newServer(func(c *Config) { c.BoundaryPrefix = tt.prefix }).Handler()
+			fields := map[string]any{"to": "to@example.com", "subject": "Hello", "messageBody": "Hi"}
+			for k, v := range tt.fields {
+				fields[k] = v
+			}
+
+			if rec := postPayload(h, "/send", stub.payload(t, fields), nil); rec.Code != http.StatusOK {
+				t.Fatalf("send = %d %s; want 200", rec.Code, rec.Body)
+			}
+			msg, err := mail.ReadMessage(strings.NewReader(stub.sent[0]))
+			if err != nil {
+				t.Fatalf("failed to parse sent message: %v", err)
+			}
+			if got := msg.Header["Mime-Version"]; len(got) != 1 || got[0] != "1.0" {
+				t.Errorf("MIME-Version = %q; want exactly 1.0", got)
+			}
+			if mediaType, _, _ := mime.ParseMediaType(msg.Header.Get("Content-Type")); !strings.HasPrefix(mediaType, "multipart/") {
+				t.Errorf("Content-Type = %q; want a multipart message", msg.Header.Get("Content-Type"))
+			}
+			boundaries := boundaryPattern.FindAllStringSubmatch(stub.sent[0], -1)
+			if len(boundaries) != tt.wantParts {
+				t.Fatalf("found %d boundaries; want %d", len(boundaries), tt.wantParts)
+			}
+			for _, boundary := range boundaries {
+				if !strings.HasPrefix(boundary[1], tt.wantPrefix) {
+					t.Errorf("boundary %q; want it to start with %q", boundary[1], tt.wantPrefix)
+				}
+			}
+		})
+	}
+}
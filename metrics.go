@@ -0,0 +1,188 @@
+package gosender
+
+import (
+	"fmt"
+	"net/http"
+	"net/mail"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// otherDomain is the label value used once the number of tracked domains is exhausted.
+const otherDomain = "other"
+
+// metrics holds the counters exposed on /metrics in the Prometheus text format.
+type metrics struct {
+	mu         sync.Mutex
+	maxDomains int
+	domains    map[string]struct{}
+	sends      map[sendKey]uint64
+	tenants    map[tenantSendKey]uint64
+}
+
+// tenantSendKey identifies a series of the gosender_tenant_sends_total counter.
+type tenantSendKey struct {
+	tenant string
+	result string
+}
+
+// sendKey identifies a series of the gosender_sends_total counter.
+type sendKey struct {
+	domain string
+	result string
+}
+
+// newMetrics returns metrics tracking at most maxDomains distinct recipient domains.
+func newMetrics(maxDomains int) *metrics {
+	return &metrics{
+		maxDomains: maxDomains,
+		domains:    make(map[string]struct{}),
+		sends:      make(map[sendKey]uint64),
+		tenants:    make(map[tenantSendKey]uint64),
+	}
+}
+
+// recordSend counts a send attempt once for every recipient domain of the message.
+func (m *metrics) recordSend(domains []string, err error) {
+	result := sendResult(err)
+	if len(domains) == 0 {
+		domains = []string{otherDomain}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, domain := range domains {
+		m.sends[sendKey{m.domainLabel(domain), result}]++
+	}
+}
+
+// recordTenantSend counts a send attempt of tenant with the given result:
+// success, failure or rejected for exceeding the tenant's limits. Tenants are
+// configured, so their number needs no cap.
+func (m *metrics) recordTenantSend(tenant, result string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.tenants[tenantSendKey{tenant, result}]++
+}
+
+// sendResult returns the result label of a send that failed with err.
+func sendResult(err error) string {
+	if err != nil {
+		return "failure"
+	}
+	return "success"
+}
+
+// domainLabel returns the label value for domain. The first maxDomains domains
+// seen keep their own label; any other domain is bucketed as "other" to cap the
+// cardinality of the metric. This is not a top N: counters must only grow, so
+// a domain cannot move out of "other" once counted there, nor a labeled one
+// into it, and the labels are given out as domains first appear. The caller
+// must hold m.mu.
+func (m *metrics) domainLabel(domain string) string {
+	if _, ok := m.domains[domain]; ok {
+		return domain
+	}
+	if len(m.domains) >= m.maxDomains {
+		return otherDomain
+	}
+
+	m.domains[domain] = struct{}{}
+	return domain
+}
+
+// ServeHTTP writes the metrics in the Prometheus text exposition format.
+func (m *metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	keys := make([]sendKey, 0, len(m.sends))
+	for key := range m.sends {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].domain != keys[j].domain {
+			return keys[i].domain < keys[j].domain
+		}
+		return keys[i].result < keys[j].result
+	})
+
+	var b strings.Builder
+	b.WriteString("# HELP gosender_sends_total Send attempts by recipient domain and result.\n")
+	b.WriteString("# TYPE gosender_sends_total counter\n")
+	for _, key := range keys {
+		fmt.Fprintf(&b, "gosender_sends_total{domain=\"%s\",result=\"%s\"} %d\n",
+			escapeLabel(key.domain), key.result, m.sends[key])
+	}
+
+	tenantKeys := make([]tenantSendKey, 0, len(m.tenants))
+	for key := range m.tenants {
+		tenantKeys = append(tenantKeys, key)
+	}
+	sort.Slice(tenantKeys, func(i, j int) bool {
+		if tenantKeys[i].tenant != tenantKeys[j].tenant {
+			return tenantKeys[i].tenant < tenantKeys[j].tenant
+		}
+		return tenantKeys[i].result < tenantKeys[j].result
+	})
+	if len(tenantKeys) > 0 {
+		b.WriteString("# HELP gosender_tenant_sends_total Send attempts by tenant and result.\n")
+		b.WriteString("# TYPE gosender_tenant_sends_total counter\n")
+	}
+	for _, key := range tenantKeys {
+		fmt.Fprintf(&b, "gosender_tenant_sends_total{tenant=\"%s\",result=\"%s\"} %d\n",
+			escapeLabel(key.tenant), key.result, m.tenants[key])
+	}
+	m.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write([]byte(b.String()))
+}
+
+// labelEscaper escapes label values as required by the Prometheus text format.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// escapeLabel escapes a label value for the Prometheus text format.
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
+
+// recipientDomains returns the distinct, lower-cased domains of the payload's
+// recipients. For raw messages the To, Cc and Bcc headers are parsed.
+func recipientDomains(p *Payload) []string {
+	var addresses []string
+	if p.isStructured() {
+		addresses = append(addresses, p.To...)
+		addresses = append(addresses, p.Cc...)
+		addresses = append(addresses, p.Bcc...)
+	} else if msg, err := mail.ReadMessage(strings.NewReader(p.MessageBody)); err == nil {
+		for _, name := range []string{"To", "Cc", "Bcc"} {
+			if value := msg.Header.Get(name); value != "" {
+				addresses = append(addresses, value)
+			}
+		}
+	}
+
+	seen := make(map[string]struct{})
+	var domains []string
+	for _, value := range addresses {
+		list, err := mail.ParseAddressList(value)
+		if err != nil {
+			continue
+		}
+		for _, addr := range list {
+			at := strings.LastIndex(addr.Address, "@")
+			if at < 0 {
+				continue
+			}
+			domain := strings.ToLower(addr.Address[at+1:])
+			if _, ok := seen[domain]; !ok {
+				seen[domain] = struct{}{}
+				domains = append(domains, domain)
+			}
+		}
+	}
+
+	return domains
+}
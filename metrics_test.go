@@ -0,0 +1,71 @@
+package gosender
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDomainMetrics(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxDomains int
+		sendStatus int
+		want       []string
+	}{
+		{
+			name:       "both domains labeled",
+			maxDomains: 2,
+			want: []string{
+				`gosender_sends_total{domain="example.com",result="success"} 2`,
+				`gosender_sends_total{domain="example.org",result="success"} 1`,
+			},
+		},
+		{
+			name:       "later domain bucketed",
+			maxDomains: 1,
+			want: []string{
+				`gosender_sends_total{domain="example.com",result="success"} 2`,
+				`gosender_sends_total{domain="other",result="success"} 1`,
+			},
+		},
+		{
+			name:       "no domain labeled",
+			maxDomains: -1,
+			want:       []string{`gosender_sends_total{domain="other",result="success"} 3`},
+		},
+		{
+			name:       "failures",
+			maxDomains: 2,
+			sendStatus: http.StatusInternalServerError,
+			want: []string{
+				`gosender_sends_total{domain="example.com",result="failure"} 2`,
+				`gosender_sends_total{domain="example.org",result="failure"} 1`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newGmailStub(t)
+			stub.sendStatus = tt.sendStatus
+			h := stub.newServer(func(c *Config) { c.MetricsMaxDomains = tt.maxDomains }).Handler()
+
+			for _, to := range []string{"a@example.com", "b@Example.com", "c@example.org"} {
+				postPayload(h, "/send", stub.payload(t, map[string]any{"to": to, "subject": "Hello", "messageBody": "Hi"}), nil)
+			}
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+			var got []string
+			for _, line := range strings.Split(rec.Body.String(), "\n") {
+				if strings.HasPrefix(line, "gosender_sends_total") {
+					got = append(got, line)
+				}
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("metrics:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
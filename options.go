@@ -0,0 +1,67 @@
+package gosender
+
+import (
+	"log/slog"
+	"time"
+)
+
+// Option adjusts the Config a Server is created with, as an alternative to
+// filling in the struct for the few settings a library user typically needs.
+type Option func(*Config)
+
+// WithTimeout bounds each send as a whole; see Config.SendTimeout.
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Config) {
+		c.SendTimeout = timeout
+	}
+}
+
+// WithRetry sets how failed sends are retried; zero delays and multiplier
+// select the defaults.
+func WithRetry(backoff Backoff) Option {
+	return func(c *Config) {
+		c.SendRetries = backoff.Retries
+		c.RetryBaseDelay = backoff.BaseDelay
+		c.RetryMaxDelay = backoff.MaxDelay
+		c.RetryMultiplier = backoff.Multiplier
+	}
+}
+
+// WithScopes sets the OAuth scopes the credentials are used with; see
+// Config.Scopes.
+func WithScopes(scopes ...string) Option {
+	return func(c *Config) {
+		c.Scopes = scopes
+	}
+}
+
+// WithHook registers hooks to run on every message before it is built,
+// after those already registered.
+func WithHook(hooks ...Hook) Option {
+	return func(c *Config) {
+		c.Hooks = append(c.Hooks[:len(c.Hooks):len(c.Hooks)], hooks...)
+	}
+}
+
+// WithClock sets the clock the server reads the current time from; see
+// Config.Clock.
+func WithClock(now func() time.Time) Option {
+	return func(c *Config) {
+		c.Clock = now
+	}
+}
+
+// WithServiceFactory sets how the Gmail services requests act through are
+// created; see Config.ServiceFactory.
+func WithServiceFactory(factory ServiceFactory) Option {
+	return func(c *Config) {
+		c.ServiceFactory = factory
+	}
+}
+
+// WithLogger sets the logger requests are logged to.
+func WithLogger(logger *slog.Logger) Option {
+	return func(c *Config) {
+		c.Logger = logger
+	}
+}
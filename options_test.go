@@ -0,0 +1,69 @@
+package gosender
+
+import (
+	"io"
+	"log/slog"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestOptions(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	tests := []struct {
+		name   string
+		opts   []Option
+		effect func(*Config) any
+		want   any
+	}{
+		{
+			name:   "timeout",
+			opts:   []Option{WithTimeout(30 * time.Second)},
+			effect: func(c *Config) any { return c.SendTimeout },
+			want:   30 * time.Second,
+		},
+		{
+			name:   "last option wins",
+			opts:   []Option{WithTimeout(30 * time.Second), WithTimeout(time.Second)},
+			effect: func(c *Config) any { return c.SendTimeout },
+			want:   time.Second,
+		},
+		{
+			name: "retry",
+			opts: []Option{WithRetry(Backoff{Retries: 3, BaseDelay: time.Second, MaxDelay: time.Minute, Multiplier: 3})},
+			effect: func(c *Config) any {
+				return Backoff{c.SendRetries, c.RetryBaseDelay, c.RetryMaxDelay, c.RetryMultiplier}
+			},
+			want: Backoff{Retries: 3, BaseDelay: time.Second, MaxDelay: time.Minute, Multiplier: 3},
+		},
+		{
+			name:   "scopes",
+			opts:   []Option{WithScopes("https://www.googleapis.com/auth/gmail.send")},
+			effect: func(c *Config) any { return c.scopes() },
+			want:   []string{"https://www.googleapis.com/auth/gmail.send"},
+		},
+		{
+			name:   "default scopes",
+			effect: func(c *Config) any { return c.scopes() },
+			want:   []string{"https://mail.google.com/"},
+		},
+		{
+			name:   "logger",
+			opts:   []Option{WithLogger(logger)},
+			effect: func(c *Config) any { return c.Logger },
+			want:   logger,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &Config{SendTimeout: time.Minute}
+			s := NewServer(config, tt.opts...)
+			if got := tt.effect(s.config); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v; want %v", got, tt.want)
+			}
+			if config.SendTimeout != time.Minute || config.Logger != nil || config.Scopes != nil || config.SendRetries != 0 {
+				t.Errorf("the given config was changed to %+v", config)
+			}
+		})
+	}
+}
@@ -0,0 +1,222 @@
+package gosender
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// ParsedMessage represents a fetched Gmail message in readable form, with its
+// headers decoded and its bodies and attachments taken out of the MIME tree.
+type ParsedMessage struct {
+	ID          string              `json:"id"`
+	ThreadID    string              `json:"threadId"`
+	LabelIDs    []string            `json:"labelIds,omitempty"`
+	Headers     map[string][]string `json:"headers"`
+	From        string              `json:"from"`
+	To          string              `json:"to"`
+	Cc          string              `json:"cc"`
+	Subject     string              `json:"subject"`
+	Date        time.Time           `json:"date"`
+	Text        string              `json:"text"`
+	HTML        string              `json:"html"`
+	Attachments []ParsedAttachment  `json:"attachments,omitempty"`
+}
+
+// ParsedAttachment represents an attachment of a ParsedMessage. Attachments
+// that Gmail stores separately carry an AttachmentID instead of their Content,
+// for fetching through Users.Messages.Attachments.Get.
+type ParsedAttachment struct {
+	Filename     string `json:"filename"`
+	ContentType  string `json:"contentType"`
+	Content      []byte `json:"content,omitempty"`
+	AttachmentID string `json:"attachmentId,omitempty"`
+	Size         int64  `json:"size"`
+}
+
+// ParseGmailMessage converts a message fetched with Users.Messages.Get into a
+// ParsedMessage. Messages fetched with format "full" are read from their part
+// tree and messages fetched with format "raw" from their base64url-encoded
+// RFC 5322 source; nested multiparts are walked in both cases. The first
+// text/plain and text/html parts that are not attachments become Text and
+// HTML; every other leaf part, forwarded messages included, is an attachment.
+func ParseGmailMessage(m *gmail.Message) (ParsedMessage, error) {
+	parsed := ParsedMessage{ID: m.Id, ThreadID: m.ThreadId, LabelIDs: m.LabelIds}
+
+	switch {
+	case m.Raw != "":
+		raw, err := decodeBase64(m.Raw)
+		if err != nil {
+			return ParsedMessage{}, fmt.Errorf("failed to decode raw message: %v", err)
+		}
+		msg, err := mail.ReadMessage(bytes.NewReader(raw))
+		if err != nil {
+			return ParsedMessage{}, fmt.Errorf("failed to parse raw message: %v", err)
+		}
+
+		var fields []*gmail.MessagePartHeader
+		for name, values := range msg.Header {
+			for _, value := range values {
+				fields = append(fields, &gmail.MessagePartHeader{Name: name, Value: value})
+			}
+		}
+		parsed.setHeaders(decodeHeaders(fields))
+		if err := parsed.addMIMEPart(textproto.MIMEHeader(msg.Header), msg.Body); err != nil {
+			return ParsedMessage{}, err
+		}
+	case m.Payload != nil:
+		parsed.setHeaders(decodedHeaders(m))
+		if err := parsed.addGmailPart(m.Payload); err != nil {
+			return ParsedMessage{}, err
+		}
+	default:
+		return ParsedMessage{}, errors.New("message has neither a payload nor raw content; fetch it with format full or raw")
+	}
+
+	return parsed, nil
+}
+
+// setHeaders sets the decoded headers of the message and the fields derived
+// from them.
+func (pm *ParsedMessage) setHeaders(headers map[string][]string) {
+	pm.Headers = headers
+	first := func(name string) string {
+		for key, values := range headers {
+			if strings.EqualFold(key, name) && len(values) > 0 {
+				return values[0]
+			}
+		}
+		return ""
+	}
+
+	pm.From, pm.To, pm.Cc, pm.Subject = first("From"), first("To"), first("Cc"), first("Subject")
+	if date, err := mail.ParseDate(first("Date")); err == nil {
+		pm.Date = date
+	}
+}
+
+// addGmailPart adds a part of a message fetched with format "full", whose
+// content Gmail has already decoded into base64url body data.
+func (pm *ParsedMessage) addGmailPart(part *gmail.MessagePart) error {
+	if strings.HasPrefix(part.MimeType, "multipart/") {
+		for _, child := range part.Parts {
+			if err := pm.addGmailPart(child); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	header := make(textproto.MIMEHeader, len(part.Headers))
+	for _, h := range part.Headers {
+		header.Add(h.Name, h.Value)
+	}
+	attachment := ParsedAttachment{Filename: part.Filename, ContentType: part.MimeType}
+	if part.Body != nil {
+		attachment.AttachmentID, attachment.Size = part.Body.AttachmentId, part.Body.Size
+		if part.Body.Data != "" {
+			content, err := decodeBase64(part.Body.Data)
+			if err != nil {
+				return fmt.Errorf("failed to decode %s part: %v", part.MimeType, err)
+			}
+			attachment.Content = content
+		}
+	}
+
+	pm.addLeaf(header, attachment)
+	return nil
+}
+
+// addMIMEPart adds a part of a raw message with the given header and body,
+// undoing its content transfer encoding.
+func (pm *ParsedMessage) addMIMEPart(header textproto.MIMEHeader, body io.Reader) error {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		mediaType, params = "text/plain", map[string]string{}
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := reader.NextRawPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("failed to read %s part: %v", mediaType, err)
+			}
+			if err := pm.addMIMEPart(part.Header, part); err != nil {
+				return err
+			}
+		}
+	}
+
+	content, err := decodeTransferEncoding(header.Get("Content-Transfer-Encoding"), body)
+	if err != nil {
+		return fmt.Errorf("failed to decode %s part: %v", mediaType, err)
+	}
+
+	filename := params["name"]
+	if _, dispositionParams, err := mime.ParseMediaType(header.Get("Content-Disposition")); err == nil && dispositionParams["filename"] != "" {
+		filename = dispositionParams["filename"]
+	}
+	pm.addLeaf(header, ParsedAttachment{
+		Filename:    filename,
+		ContentType: mediaType,
+		Content:     content,
+		Size:        int64(len(content)),
+	})
+	return nil
+}
+
+// addLeaf adds a leaf part as the message's text or HTML body, unless that is
+// already set or the part is an attachment, in which case it is an attachment.
+func (pm *ParsedMessage) addLeaf(header textproto.MIMEHeader, part ParsedAttachment) {
+	disposition, _, _ := mime.ParseMediaType(header.Get("Content-Disposition"))
+	if part.Filename == "" && disposition != "attachment" && part.AttachmentID == "" {
+		switch {
+		case part.ContentType == "text/plain" && pm.Text == "":
+			pm.Text = string(part.Content)
+			return
+		case part.ContentType == "text/html" && pm.HTML == "":
+			pm.HTML = string(part.Content)
+			return
+		}
+	}
+
+	pm.Attachments = append(pm.Attachments, part)
+}
+
+// decodeTransferEncoding returns the content of body with the given content
+// transfer encoding undone.
+func decodeTransferEncoding(encoding string, body io.Reader) ([]byte, error) {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		encoded, err := io.ReadAll(body)
+		if err != nil {
+			return nil, err
+		}
+		encoded = bytes.Map(func(r rune) rune {
+			if r == '\r' || r == '\n' || r == ' ' || r == '\t' {
+				return -1
+			}
+			return r
+		}, encoded)
+		return base64.StdEncoding.DecodeString(string(encoded))
+	case "quoted-printable":
+		return io.ReadAll(quotedprintable.NewReader(body))
+	default:
+		return io.ReadAll(body)
+	}
+}
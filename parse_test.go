@@ -0,0 +1,133 @@
+package gosender
+
+import (
+	"encoding/base64"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+func TestParseGmailMessage(t *testing.T) {
+	raw := strings.ReplaceAll(`From: Ann <ann@example.com>
+To: bob@example.com
+Cc: carol@example.com
+Subject: =?UTF-8?q?Caf=C3=A9?=
+Date: Mon, 02 Jan 2006 15:04:05 +0000
+MIME-Version: 1.0
+Content-Type: multipart/mixed; boundary="outer"
+
+--outer
+Content-Type: multipart/alternative; boundary="inner"
+
+--inner
+Content-Type: text/plain; charset=UTF-8
+Content-Transfer-Encoding: quoted-printable
+
+Caf=C3=A9 au lait
+--inner
+Content-Type: text/html; charset=UTF-8
+Content-Transfer-Encoding: base64
+
+PHA+Q2Fmw6k8L3A+
+--inner--
+--outer
+Content-Type: text/csv; name="data.csv"
+Content-Disposition: attachment; filename="data.csv"
+Content-Transfer-Encoding: base64
+
+YSxiDQoxLDINCg==
+--outer
+Content-Type: message/rfc822
+
+Subject: Original
+
+Original body
+--outer--
+`, "\n", "\r\n")
+	headers := func(names ...string) []*gmail.MessagePartHeader {
+		var fields []*gmail.MessagePartHeader
+		for i := 0; i < len(names); i += 2 {
+			fields = append(fields, &gmail.MessagePartHeader{Name: names[i], Value: names[i+1]})
+		}
+		return fields
+	}
+	data := func(s string) *gmail.MessagePartBody {
+		return &gmail.MessagePartBody{Data: base64.URLEncoding.EncodeToString([]byte(s)), Size: int64(len(s))}
+	}
+	envelope := ParsedMessage{
+		ID:       "msg-1",
+		ThreadID: "thread-1",
+		LabelIDs: []string{"INBOX"},
+		From:     "Ann <ann@example.com>",
+		To:       "bob@example.com",
+		Cc:       "carol@example.com",
+		Subject:  "Café",
+		Date:     time.Date(2006, time.January, 2, 15, 4, 5, 0, time.UTC),
+		Text:     "Café au lait",
+		HTML:     "<p>Café</p>",
+	}
+
+	tests := []struct {
+		name            string
+		message         *gmail.Message
+		wantAttachments []ParsedAttachment
+		wantError       bool
+	}{
+		{
+			name:    "raw",
+			message: &gmail.Message{Id: "msg-1", ThreadId: "thread-1", LabelIds: []string{"INBOX"}, Raw: base64.RawURLEncoding.EncodeToString([]byte(raw))},
+			wantAttachments: []ParsedAttachment{
+				{Filename: "data.csv", ContentType: "text/csv", Content: []byte("a,b\r\n1,2\r\n"), Size: 10},
+				{ContentType: "message/rfc822", Content: []byte("Subject: Original\r\n\r\nOriginal body"), Size: 34},
+			},
+		},
+		{
+			name: "full",
+			message: &gmail.Message{Id: "msg-1", ThreadId: "thread-1", LabelIds: []string{"INBOX"}, Payload: &gmail.MessagePart{
+				MimeType: "multipart/mixed",
+				Headers: headers(
+					"From", "Ann <ann@example.com>", "To", "bob@example.com", "Cc", "carol@example.com",
+					"Subject", "=?UTF-8?q?Caf=C3=A9?=", "Date", "Mon, 02 Jan 2006 15:04:05 +0000",
+				),
+				Parts: []*gmail.MessagePart{
+					{MimeType: "multipart/alternative", Parts: []*gmail.MessagePart{
+						{MimeType: "text/plain", Body: data("Café au lait")},
+						{MimeType: "text/html", Body: data("<p>Café</p>")},
+					}},
+					{MimeType: "text/csv", Filename: "data.csv", Headers: headers("Content-Disposition", `attachment; filename="data.csv"`), Body: &gmail.MessagePartBody{AttachmentId: "att-1", Size: 10}},
+				},
+			}},
+			wantAttachments: []ParsedAttachment{{Filename: "data.csv", ContentType: "text/csv", AttachmentID: "att-1", Size: 10}},
+		},
+		{name: "metadata only", message: &gmail.Message{Id: "msg-1"}, wantError: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parsed, err := ParseGmailMessage(tt.message)
+			if tt.wantError {
+				if err == nil {
+					t.Errorf("parsed %+v; want an error", parsed)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseGmailMessage: %v", err)
+			}
+			if subject := parsed.Headers["Subject"]; len(subject) != 1 || subject[0] != "Café" {
+				t.Errorf("Subject header = %q; want it decoded", subject)
+			}
+
+			want := envelope
+			want.Headers, want.Attachments = parsed.Headers, tt.wantAttachments
+			if parsed.Date.Equal(want.Date) {
+				want.Date = parsed.Date
+			}
+			if !reflect.DeepEqual(parsed, want) {
+				t.Errorf("parsed %+v; want %+v", parsed, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package gosender
+
+import (
+	"mime"
+	"net/mail"
+	"regexp"
+	"strings"
+)
+
+// recipientFields lists the recipient header fields from the most to the least
+// visible.
+var recipientFields = []string{"To", "Cc", "Bcc"}
+
+// headerNamePattern matches a valid header field name (RFC 5322 section 3.6.8).
+var headerNamePattern = regexp.MustCompile(`^[!-9;-~]+$`)
+
+// hasPolicies reports whether any server-wide message policy is configured.
+func (s *Server) hasPolicies() bool {
+	return s.config.AlwaysBcc != "" || s.config.DefaultReplyTo != "" || s.config.DedupRecipients || s.config.OrgHeaderName != "" || s.config.SubjectPrefix != "" || s.config.RedirectTo != ""
+}
+
+// rewritesMessages reports whether the server changes or filters the messages
+// it sends, through its policies, suppression list or hooks.
+func (s *Server) rewritesMessages() bool {
+	return s.hasPolicies() || s.config.Suppressions != nil || len(s.config.Hooks) > 0
+}
+
+// applyPolicies applies the server-wide message policies to a built message,
+// whether it was built from structured fields or passed through raw.
+func (s *Server) applyPolicies(raw []byte) []byte {
+	if !s.hasPolicies() {
+		return raw
+	}
+
+	m := parseRawMessage(raw)
+	if s.config.SubjectPrefix != "" {
+		prefixSubject(m, s.config.SubjectPrefix)
+	}
+	if s.config.OrgHeaderName != "" {
+		m.setField(s.config.OrgHeaderName, mime.QEncoding.Encode("utf-8", s.config.OrgHeaderValue))
+	}
+	if s.config.DefaultReplyTo != "" && m.value("Reply-To") == "" {
+		m.setField("Reply-To", s.config.DefaultReplyTo)
+	}
+	if s.config.AlwaysBcc != "" {
+		m.mergeAddress("Bcc", s.config.AlwaysBcc)
+	}
+	if s.config.DedupRecipients {
+		dedupRecipients(m)
+	}
+	if s.config.RedirectTo != "" {
+		redirectRecipients(m, s.config.RedirectTo)
+	}
+
+	return m.bytes()
+}
+
+// prefixSubject prepends prefix to the subject of m, unless it already starts
+// with it. The subject is decoded first and encoded again as a whole, so that
+// the prefix and the subject share one RFC 2047 encoding; a message without a
+// subject gets the prefix alone.
+func prefixSubject(m *rawMessage, prefix string) {
+	var decoder mime.WordDecoder
+	subject, err := decoder.DecodeHeader(m.value("Subject"))
+	if err != nil {
+		subject = m.value("Subject")
+	}
+	if strings.HasPrefix(subject, prefix) {
+		return
+	}
+
+	m.setField("Subject", mime.QEncoding.Encode("UTF-8", strings.TrimSpace(prefix+subject)))
+}
+
+// redirectRecipients replaces the To, Cc and Bcc fields of m with a To of
+// address alone, keeping each original field under an X-Original- prefix. A
+// message without recipients is left as is.
+func redirectRecipients(m *rawMessage, address string) {
+	redirected := false
+	for _, name := range recipientFields {
+		if value := m.value(name); value != "" {
+			m.setField("X-Original-"+name, value)
+			m.deleteField(name)
+			redirected = true
+		}
+	}
+	if redirected {
+		m.setField("To", address)
+	}
+}
+
+// dedupRecipients removes the addresses listed more than once across the To,
+// Cc and Bcc fields of m, keeping each in the most visible field it appears
+// in.
+func dedupRecipients(m *rawMessage) {
+	seen := make(map[string]bool)
+	filterRecipients(m, func(addr *mail.Address) bool {
+		key := strings.ToLower(addr.Address)
+		if seen[key] {
+			return false
+		}
+		seen[key] = true
+		return true
+	})
+}
+
+// filterRecipients keeps the addresses of the To, Cc and Bcc fields of m for
+// which keep returns true, in order from the most to the least visible field,
+// and returns the addresses removed. A field left empty is removed and a
+// field that cannot be parsed is left as is.
+func filterRecipients(m *rawMessage, keep func(addr *mail.Address) bool) (removed []string) {
+	for _, name := range recipientFields {
+		value := m.value(name)
+		if value == "" {
+			continue
+		}
+		addresses, err := mail.ParseAddressList(value)
+		if err != nil {
+			continue
+		}
+
+		var kept []string
+		for _, addr := range addresses {
+			if keep(addr) {
+				kept = append(kept, addr.String())
+			} else {
+				removed = append(removed, addr.Address)
+			}
+		}
+
+		switch {
+		case len(kept) == len(addresses):
+		case len(kept) == 0:
+			m.deleteField(name)
+		default:
+			m.setField(name, strings.Join(kept, ", "))
+		}
+	}
+
+	return removed
+}
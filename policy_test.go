@@ -0,0 +1,193 @@
+package gosender
+
+import (
+	"mime"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestDedupRecipients(t *testing.T) {
+	tests := []struct {
+		name    string
+		dedup   bool
+		fields  map[string]any
+		wantTo  string
+		wantCc  string
+		wantBcc string
+	}{
+		{
+			name:    "kept when disabled",
+			fields:  map[string]any{"to": "a@example.com", "bcc": "a@example.com"},
+			wantTo:  "<a@example.com>",
+			wantBcc: "<a@example.com>",
+		},
+		{
+			name:   "removed from bcc",
+			dedup:  true,
+			fields: map[string]any{"to": "a@example.com", "bcc": "a@example.com"},
+			wantTo: "<a@example.com>",
+		},
+		{
+			name:    "most visible list kept",
+			dedup:   true,
+			fields:  map[string]any{"to": "a@example.com", "cc": []string{"b@example.com", "A@Example.com"}, "bcc": []string{"b@example.com", "c@example.com"}},
+			wantTo:  "<a@example.com>",
+			wantCc:  "<b@example.com>",
+			wantBcc: "<c@example.com>",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newGmailStub(t)
+			h := stub.newServer(func(c *Config) { c.DedupRecipients = tt.dedup }).Handler()
+			fields := map[string]any{"subject": "Hello", "messageBody": "Hi"}
+			for k, v := range tt.fields {
+				fields[k] = v
+			}
+
+			if rec := postPayload(h, "/send", stub.payload(t, fields), nil); rec.Code != http.StatusOK {
+				t.Fatalf("send = %d %s; want %d", rec.Code, rec.Body, http.StatusOK)
+			}
+			if len(stub.sent) != 1 {
+				t.Fatalf("sent %d messages; want 1", len(stub.sent))
+			}
+			m := parseRawMessage([]byte(stub.sent[0]))
+			if to, cc, bcc := m.value("To"), m.value("Cc"), m.value("Bcc"); to != tt.wantTo || cc != tt.wantCc || bcc != tt.wantBcc {
+				t.Errorf("To %q, Cc %q, Bcc %q; want %q, %q, %q", to, cc, bcc, tt.wantTo, tt.wantCc, tt.wantBcc)
+			}
+		})
+	}
+}
+
+func TestOrgHeader(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  string
+		fields map[string]any
+		want   string
+	}{
+		{name: "structured", value: "Example Corp", fields: map[string]any{"to": "to@example.com", "subject": "Hello", "messageBody": "Hi"}, want: "Example Corp"},
+		{name: "raw replaced", value: "Example Corp", fields: map[string]any{"messageBody": "To: to@example.com\r\nOrganization: Other Corp\r\nSubject: Hello\r\n\r\nHi\r\n"}, want: "Example Corp"},
+		{name: "encoded", value: "Société Exemple", fields: map[string]any{"to": "to@example.com", "subject": "Hello", "messageBody": "Hi"}, want: "=?utf-8?q?Soci=C3=A9t=C3=A9_Exemple?="},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newGmailStub(t)
+			h := stub.newServer(func(c *Config) { c.OrgHeaderName, c.OrgHeaderValue = "Organization", tt.value }).Handler()
+
+			if rec := postPayload(h, "/send", stub.payload(t, tt.fields), nil); rec.Code != http.StatusOK {
+				t.Fatalf("send = %d %s; want %d", rec.Code, rec.Body, http.StatusOK)
+			}
+			if len(stub.sent) != 1 {
+				t.Fatalf("sent %d messages; want 1", len(stub.sent))
+			}
+			m := parseRawMessage([]byte(stub.sent[0]))
+			if got := m.value("Organization"); got != tt.want {
+				t.Errorf("Organization = %q; want %q", got, tt.want)
+			}
+			if n := strings.Count(stub.sent[0], "Organization:"); n != 1 {
+				t.Errorf("sent %q; want a single Organization field", stub.sent[0])
+			}
+		})
+	}
+}
+
+func TestSubjectPrefix(t *testing.T) {
+	tests := []struct {
+		name        string
+		fields      map[string]any
+		want        string
+		wantEncoded bool
+	}{
+		{name: "structured", fields: map[string]any{"to": "to@example.com", "subject": "Hello", "messageBody": "Hi"}, want: "[STAGING] Hello"},
+		{name: "raw", fields: map[string]any{"messageBody": "To: to@example.com\r\nSubject: Hello\r\n\r\nHi\r\n"}, want: "[STAGING] Hello"},
+		{name: "encoded together", fields: map[string]any{"to": "to@example.com", "subject": "Grüße", "messageBody": "Hi"}, want: "[STAGING] Grüße", wantEncoded: true},
+		{name: "already prefixed", fields: map[string]any{"to": "to@example.com", "subject": "[STAGING] Hello", "messageBody": "Hi"}, want: "[STAGING] Hello"},
+		{name: "without a subject", fields: map[string]any{"to": "to@example.com", "messageBody": "Hi"}, want: "[STAGING]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newGmailStub(t)
+			h := stub.newServer(func(c *Config) { c.SubjectPrefix = "[STAGING] " }).Handler()
+
+			if rec := postPayload(h, "/send", stub.payload(t, tt.fields), nil); rec.Code != http.StatusOK {
+				t.Fatalf("send = %d %s; want %d", rec.Code, rec.Body, http.StatusOK)
+			}
+			if len(stub.sent) != 1 {
+				t.Fatalf("sent %d messages; want 1", len(stub.sent))
+			}
+			value := parseRawMessage([]byte(stub.sent[0])).value("Subject")
+			if encoded := strings.HasPrefix(value, "=?"); encoded != tt.wantEncoded || strings.Contains(value, "[STAGING] =?") {
+				t.Errorf("Subject = %q; want it encoded as a whole with the prefix: %t", value, tt.wantEncoded)
+			}
+			var decoder mime.WordDecoder
+			if got, err := decoder.DecodeHeader(value); err != nil || got != tt.want {
+				t.Errorf("decoded Subject = %q, %v; want %q", got, err, tt.want)
+			}
+			if n := strings.Count(stub.sent[0], "Subject:"); n != 1 {
+				t.Errorf("sent %q; want a single Subject field", stub.sent[0])
+			}
+		})
+	}
+}
+
+func TestRedirectTo(t *testing.T) {
+	tests := []struct {
+		name      string
+		fields    map[string]any
+		alwaysBcc string
+		want      map[string]string
+	}{
+		{
+			name:   "to",
+			fields: map[string]any{"to": "user@example.com"},
+			want:   map[string]string{"X-Original-To": "<user@example.com>"},
+		},
+		{
+			name:   "every recipient field",
+			fields: map[string]any{"to": []string{"a@example.com", "b@example.com"}, "cc": "c@example.com", "bcc": "d@example.com"},
+			want:   map[string]string{"X-Original-To": "<a@example.com>, <b@example.com>", "X-Original-Cc": "<c@example.com>", "X-Original-Bcc": "<d@example.com>"},
+		},
+		{
+			name:      "always bcc redirected too",
+			fields:    map[string]any{"to": "user@example.com"},
+			alwaysBcc: "archive@example.com",
+			want:      map[string]string{"X-Original-To": "<user@example.com>", "X-Original-Bcc": "archive@example.com"},
+		},
+		{
+			name:   "raw",
+			fields: map[string]any{"messageBody": "To: user@example.com\r\nSubject: Hello\r\n\r\nHi\r\n"},
+			want:   map[string]string{"X-Original-To": "user@example.com"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newGmailStub(t)
+			h := stub.newServer(func(c *Config) { c.RedirectTo, c.AlwaysBcc = "qa@example.com", tt.alwaysBcc }).Handler()
+			fields := map[string]any{"subject": "Hello", "messageBody": "Hi"}
+			if _, ok := tt.fields["messageBody"]; ok {
+				fields = map[string]any{}
+			}
+			for k, v := range tt.fields {
+				fields[k] = v
+			}
+
+			if rec := postPayload(h, "/send", stub.payload(t, fields), nil); rec.Code != http.StatusOK {
+				t.Fatalf("send = %d %s; want %d", rec.Code, rec.Body, http.StatusOK)
+			}
+			if len(stub.sent) != 1 {
+				t.Fatalf("sent %d messages; want 1", len(stub.sent))
+			}
+			m := parseRawMessage([]byte(stub.sent[0]))
+			if got := m.value("To"); got != "qa@example.com" {
+				t.Errorf("To = %q; want the redirect address", got)
+			}
+			for _, name := range []string{"Cc", "Bcc", "X-Original-To", "X-Original-Cc", "X-Original-Bcc"} {
+				if got := m.value(name); got != tt.want[name] {
+					t.Errorf("%s = %q; want %q", name, got, tt.want[name])
+				}
+			}
+		})
+	}
+}
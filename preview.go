@@ -0,0 +1,138 @@
+package gosender
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"strings"
+	"time"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// PreviewResponse represents a dry-run response: the message that would have
+// been sent, without sending it, and its decoded headers.
+type PreviewResponse struct {
+	RequestID  string              `json:"requestId"`
+	Raw        string              `json:"raw"`
+	Headers    map[string][]string `json:"headers,omitempty"`
+	Size       int                 `json:"size"`
+	SizeHuman  string              `json:"sizeHuman"`
+	Suppressed []string            `json:"suppressed,omitempty"`
+	Warnings   []string            `json:"warnings,omitempty"`
+}
+
+// preview builds the payload's message without sending it. Size is the length
+// of the base64url-encoded raw message, which is what counts against Gmail's
+// message size limits.
+func (s *Server) preview(ctx context.Context, payload *Payload) (*PreviewResponse, error) {
+	timing := timingFromContext(ctx)
+	start := time.Now()
+	ctx, _, service, err := s.newService(ctx, payload)
+	if err != nil {
+		return nil, err
+	}
+	timing.record("auth", start)
+
+	start = time.Now()
+	message, err := s.prepareMessage(ctx, service, payload)
+	if errors.Is(err, errAllSuppressed) {
+		return &PreviewResponse{
+			RequestID:  requestIDFromContext(ctx),
+			SizeHuman:  humanSize(0),
+			Suppressed: payload.suppressed,
+			Warnings:   append(s.payloadWarnings(payload), "every recipient is suppressed, so nothing would be sent"),
+		}, nil
+	}
+	if err != nil {
+		return nil, withDefaultStatus(http.StatusBadRequest, err)
+	}
+	timing.record("build", start)
+
+	headers := previewHeaders(message.Raw)
+	warnings := s.payloadWarnings(payload)
+	if warning := s.fromDomainWarning(ctx, service, payload, headers); warning != "" {
+		warnings = append(warnings, warning)
+	}
+
+	return &PreviewResponse{
+		RequestID:  requestIDFromContext(ctx),
+		Raw:        message.Raw,
+		Headers:    headers,
+		Size:       len(message.Raw),
+		SizeHuman:  humanSize(len(message.Raw)),
+		Suppressed: payload.suppressed,
+		Warnings:   warnings,
+	}, nil
+}
+
+// fromDomainWarning warns when the domain of the message's From address
+// differs from the authenticated account's, a common cause of messages
+// failing SPF and DKIM alignment and being flagged as spam. Messages without
+// a From are sent from the account itself.
+func (s *Server) fromDomainWarning(ctx context.Context, service *gmail.Service, payload *Payload, headers map[string][]string) string {
+	if len(headers["From"]) == 0 {
+		return ""
+	}
+	from, err := mail.ParseAddress(headers["From"][0])
+	if err != nil {
+		return ""
+	}
+
+	profile, err := s.profile(ctx, service, payload)
+	if err != nil {
+		return fmt.Sprintf("could not check the From domain against the authenticated account: %v", err)
+	}
+
+	fromDomain, accountDomain := addressDomain(from.Address), addressDomain(profile.EmailAddress)
+	if fromDomain == accountDomain {
+		return ""
+	}
+	return fmt.Sprintf("From domain %q differs from the authenticated account's domain %q; the message may fail SPF and DKIM alignment and be flagged as spam", fromDomain, accountDomain)
+}
+
+// addressDomain returns the lower-cased domain of an email address.
+func addressDomain(address string) string {
+	return strings.ToLower(address[strings.LastIndex(address, "@")+1:])
+}
+
+// previewHeaders returns the headers of a base64url-encoded raw message keyed
+// by their canonical names, with RFC 2047 encoded words decoded, or nil when
+// the message cannot be parsed, as Gmail may still accept it.
+func previewHeaders(raw string) map[string][]string {
+	decoded, err := decodeBase64(raw)
+	if err != nil {
+		return nil
+	}
+	msg, err := mail.ReadMessage(bytes.NewReader(decoded))
+	if err != nil {
+		return nil
+	}
+
+	var fields []*gmail.MessagePartHeader
+	for name, values := range msg.Header {
+		for _, value := range values {
+			fields = append(fields, &gmail.MessagePartHeader{Name: name, Value: value})
+		}
+	}
+	return decodeHeaders(fields)
+}
+
+// humanSize formats a byte count using binary units, such as "1.5 KiB".
+func humanSize(n int) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := unit, 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,161 @@
+package gosender
+
+import (
+	"encoding/base64"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPreviewSize(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields map[string]any
+	}{
+		{name: "short message", fields: map[string]any{"messageBody": "Hi"}},
+		{name: "with an attachment", fields: map[string]any{"messageBody": "Hi", "attachments": []map[string]any{
+			{"filename": "data.bin", "data": base64.StdEncoding.EncodeToString(attachmentData)},
+		}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newGmailStub(t)
+			h := stub.newServer().Handler()
+			fields := map[string]any{"to": "to@example.com", "subject": "Hello", "dryRun": true}
+			for k, v := range tt.fields {
+				fields[k] = v
+			}
+
+			rec := postPayload(h, "/send", stub.payload(t, fields), nil)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("dry run = %d %s", rec.Code, rec.Body)
+			}
+			var response PreviewResponse
+			decodeJSON(t, rec, &response)
+			if response.Raw == "" || response.Size != len(response.Raw) {
+				t.Errorf("size = %d; want the %d bytes of the encoded message", response.Size, len(response.Raw))
+			}
+			if response.SizeHuman != humanSize(len(response.Raw)) {
+				t.Errorf("sizeHuman = %q; want %q", response.SizeHuman, humanSize(len(response.Raw)))
+			}
+			if sent, _, _ := stub.counts(); sent != 0 {
+				t.Errorf("sent %d messages; want none", sent)
+			}
+		})
+	}
+}
+
+func TestPreviewHeaders(t *testing.T) {
+	tests := []struct {
+		name        string
+		fields      map[string]any
+		wantTo      []string
+		wantSubject string
+	}{
+		{
+			name:        "plain headers",
+			fields:      map[string]any{"to": "to@example.com", "subject": "Hello"},
+			wantTo:      []string{"<to@example.com>"},
+			wantSubject: "Hello",
+		},
+		{
+			name:        "several recipients",
+			fields:      map[string]any{"to": []string{"a@example.com", "b@example.com"}, "subject": "Hello"},
+			wantTo:      []string{"<a@example.com>, <b@example.com>"},
+			wantSubject: "Hello",
+		},
+		{
+			name:        "encoded subject decoded",
+			fields:      map[string]any{"to": "to@example.com", "subject": "Grüße"},
+			wantTo:      []string{"<to@example.com>"},
+			wantSubject: "Grüße",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newGmailStub(t)
+			h := stub.newServer().Handler()
+			fields := map[string]any{"from": "me@example.com", "messageBody": "Hi", "dryRun": true}
+			for k, v := range tt.fields {
+				fields[k] = v
+			}
+
+			rec := postPayload(h, "/send", stub.payload(t, fields), nil)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("dry run = %d %s", rec.Code, rec.Body)
+			}
+			var response PreviewResponse
+			decodeJSON(t, rec, &response)
+			if got := response.Headers["From"]; !reflect.DeepEqual(got, []string{"<me@example.com>"}) {
+				t.Errorf("From = %q; want %q", got, "<me@example.com>")
+			}
+			if got := response.Headers["To"]; !reflect.DeepEqual(got, tt.wantTo) {
+				t.Errorf("To = %q; want %q", got, tt.wantTo)
+			}
+			if got := response.Headers["Subject"]; !reflect.DeepEqual(got, []string{tt.wantSubject}) {
+				t.Errorf("Subject = %q; want %q", got, tt.wantSubject)
+			}
+		})
+	}
+}
+
+func TestPreviewFromDomain(t *testing.T) {
+	tests := []struct {
+		name          string
+		from          string
+		profileStatus int
+		wantWarning   string
+	}{
+		{name: "account domain", from: "me@example.com"},
+		{name: "account domain in another case", from: "Me <me@EXAMPLE.com>"},
+		{name: "mismatched domain", from: "me@other.example", wantWarning: `From domain "other.example" differs from the authenticated account's domain "example.com"`},
+		{name: "profile unavailable", from: "me@other.example", profileStatus: http.StatusInternalServerError, wantWarning: "could not check the From domain"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newGmailStub(t)
+			stub.profileStatus = tt.profileStatus
+			h := stub.newServer().Handler()
+
+			rec := postPayload(h, "/send", stub.payload(t, map[string]any{"from": tt.from, "to": "to@example.com", "subject": "Hello", "messageBody": "Hi", "dryRun": true}), nil)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("dry run = %d %s", rec.Code, rec.Body)
+			}
+			var response PreviewResponse
+			decodeJSON(t, rec, &response)
+			var warned []string
+			for _, warning := range response.Warnings {
+				if strings.Contains(warning, "From domain") {
+					warned = append(warned, warning)
+				}
+			}
+			switch {
+			case tt.wantWarning == "" && len(warned) > 0:
+				t.Errorf("warnings = %q; want no From domain warning", warned)
+			case tt.wantWarning != "" && (len(warned) != 1 || !strings.Contains(warned[0], tt.wantWarning)):
+				t.Errorf("warnings = %q; want one containing %q", response.Warnings, tt.wantWarning)
+			}
+		})
+	}
+}
+
+func TestHumanSize(t *testing.T) {
+	tests := []struct {
+		n    int
+		want string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1024, "1.0 KiB"},
+		{1536, "1.5 KiB"},
+		{5 << 20, "5.0 MiB"},
+		{3 << 30, "3.0 GiB"},
+		{1 << 40, "1.0 TiB"},
+	}
+	for _, tt := range tests {
+		if got := humanSize(tt.n); got != tt.want {
+			t.Errorf("humanSize(%d) = %q; want %q", tt.n, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package gosender
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// cancelingRecorder is a ResponseRecorder canceling the request once the
+// given number of flushes went through.
+type cancelingRecorder struct {
+	*httptest.ResponseRecorder
+	cancel  context.CancelFunc
+	flushes int
+	after   int
+}
+
+func (rec *cancelingRecorder) Flush() {
+	rec.ResponseRecorder.Flush()
+	rec.flushes++
+	if rec.after > 0 && rec.flushes == rec.after {
+		rec.cancel()
+	}
+}
+
+func TestTrashProgress(t *testing.T) {
+	tests := []struct {
+		name         string
+		cancelAfter  int
+		wantProgress []ProgressEvent
+		wantTrashed  int
+		wantError    bool
+	}{
+		{
+			name:         "every page reported",
+			wantProgress: []ProgressEvent{{Label: "INBOX", Trashed: 2}, {Label: "INBOX", Trashed: 4}, {Label: "INBOX", Trashed: 5}, {Label: "SPAM", Trashed: 0}},
+			wantTrashed:  5,
+		},
+		{
+			name:         "canceled after the first page",
+			cancelAfter:  1,
+			wantProgress: []ProgressEvent{{Label: "INBOX", Trashed: 2}},
+			wantTrashed:  2,
+			wantError:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newGmailStub(t)
+			stub.pageSize = 2
+			stub.setLabel("INBOX", "a", "b", "c", "d", "e")
+			h := stub.newServer().Handler()
+
+			payload := stub.payload(t, map[string]any{"to": "to@example.com", "subject": "Hello", "messageBody": "Hi"})
+			form := url.Values{"payload": {base64.StdEncoding.EncodeToString([]byte(payload))}}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			req := httptest.NewRequest(http.MethodPost, "/send?progress=ndjson", strings.NewReader(form.Encode())).WithContext(ctx)
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			rec := &cancelingRecorder{ResponseRecorder: httptest.NewRecorder(), cancel: cancel, after: tt.cancelAfter}
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/x-ndjson" {
+				t.Fatalf("send = %d %q %s; want an NDJSON stream", rec.Code, rec.Header().Get("Content-Type"), rec.Body)
+			}
+			var events []ProgressEvent
+			scanner := bufio.NewScanner(rec.Body)
+			for scanner.Scan() {
+				var event ProgressEvent
+				if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+					t.Fatalf("failed to decode line %q: %v", scanner.Text(), err)
+				}
+				events = append(events, event)
+			}
+			if len(events) != len(tt.wantProgress)+1 {
+				t.Fatalf("got %d lines %+v; want %d progress lines and a final one", len(events), events, len(tt.wantProgress))
+			}
+			for i, want := range tt.wantProgress {
+				if got := events[i]; got.Label != want.Label || got.Trashed != want.Trashed || got.Result != nil || got.Error != "" {
+					t.Errorf("line %d = %+v; want %+v", i, got, want)
+				}
+			}
+
+			last := events[len(events)-1]
+			if tt.wantError {
+				if last.Error == "" || last.Result != nil {
+					t.Errorf("final line = %+v; want an error", last)
+				}
+			} else if last.Result == nil || last.Result.Output == nil || last.Error != "" {
+				t.Errorf("final line = %+v; want the result", last)
+			}
+			if _, _, trashed := stub.counts(); trashed != tt.wantTrashed {
+				t.Errorf("trashed %d messages; want %d", trashed, tt.wantTrashed)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package gosender
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+)
+
+// forwardProxy is a mock HTTP forward proxy recording the paths of the
+// requests it relays.
+type forwardProxy struct {
+	mu    sync.Mutex
+	paths []string
+}
+
+func (p *forwardProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	p.paths = append(p.paths, r.URL.Path)
+	p.mu.Unlock()
+
+	out := r.Clone(r.Context())
+	out.RequestURI = ""
+	resp, err := http.DefaultTransport.RoundTrip(out)
+	if err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	for name, values := range resp.Header {
+		w.Header()[name] = values
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+func TestProxy(t *testing.T) {
+	tests := []struct {
+		name      string
+		useProxy  bool
+		wantPaths []string
+	}{
+		{name: "through the proxy", useProxy: true, wantPaths: []string{"/tokeninfo", "/gmail/v1/users/me/messages/send"}},
+		{name: "direct"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newGmailStub(t)
+			proxy := &forwardProxy{}
+			proxyServer := httptest.NewServer(proxy)
+			defer proxyServer.Close()
+			proxyURL, _ := url.Parse(proxyServer.URL)
+
+			s := stub.newServer(func(c *Config) {
+				if tt.useProxy {
+					c.Proxy = proxyURL
+				}
+			})
+			defer s.Close()
+			payload := stub.payload(t, map[string]any{"to": "to@example.com", "subject": "Hello", "messageBody": "Hi"})
+
+			if rec := postPayload(s.Handler(), "/send", payload, nil); rec.Code != http.StatusOK {
+				t.Fatalf("send = %d %s; want %d", rec.Code, rec.Body, http.StatusOK)
+			}
+			proxy.mu.Lock()
+			defer proxy.mu.Unlock()
+			for _, want := range tt.wantPaths {
+				if !containsFold(proxy.paths, want) {
+					t.Errorf("proxied %q; want %s among them", proxy.paths, want)
+				}
+			}
+			if len(tt.wantPaths) == 0 && len(proxy.paths) != 0 {
+				t.Errorf("proxied %q; want nothing", proxy.paths)
+			}
+		})
+	}
+}
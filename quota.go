@@ -0,0 +1,45 @@
+package gosender
+
+import (
+	"net/http"
+)
+
+// QuotaResponse represents what Gmail reports about the authenticated mailbox,
+// which clients can use to gauge their usage.
+type QuotaResponse struct {
+	EmailAddress  string `json:"emailAddress"`
+	MessagesTotal int64  `json:"messagesTotal"`
+	ThreadsTotal  int64  `json:"threadsTotal"`
+	HistoryID     uint64 `json:"historyId"`
+}
+
+// handleQuota handles the HTTP request to report the mailbox profile of the
+// authenticated user.
+func (s *Server) handleQuota(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	payload, ok := readPayload(w, r)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	ctx, _, service, err := s.newService(ctx, payload)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	profile, err := service.Users.GetProfile(gmailUser(ctx)).Context(ctx).Do()
+	if err != nil {
+		writeError(w, gmailError("get profile", err))
+		return
+	}
+
+	s.writeJSON(w, r, QuotaResponse{
+		EmailAddress:  profile.EmailAddress,
+		MessagesTotal: profile.MessagesTotal,
+		ThreadsTotal:  profile.ThreadsTotal,
+		HistoryID:     profile.HistoryId,
+	})
+}
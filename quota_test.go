@@ -0,0 +1,44 @@
+package gosender
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestQuota(t *testing.T) {
+	stub := newGmailStub(t)
+	h := stub.newServer().Handler()
+
+	rec := postPayload(h, "/quota", stub.payload(t, nil), nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("quota = %d %s", rec.Code, rec.Body)
+	}
+	var response QuotaResponse
+	decodeJSON(t, rec, &response)
+	want := QuotaResponse{EmailAddress: "owner@example.com", MessagesTotal: 10, ThreadsTotal: 7, HistoryID: 12345}
+	if response != want {
+		t.Errorf("quota = %+v; want %+v", response, want)
+	}
+	if sent, _, trashed := stub.counts(); sent != 0 || trashed != 0 {
+		t.Errorf("sent %d and trashed %d messages; want nothing done", sent, trashed)
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		wantStatus int
+	}{
+		{name: "GET", method: http.MethodGet, wantStatus: http.StatusMethodNotAllowed},
+		{name: "no payload", method: http.MethodPost, wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(tt.method, "/quota", nil))
+			if rec.Code != tt.wantStatus {
+				t.Errorf("quota = %d %s; want %d", rec.Code, rec.Body, tt.wantStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package gosender
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimit allows Count sends every Per.
+type RateLimit struct {
+	Count int
+	Per   time.Duration
+}
+
+// interval returns the spacing between two sends allowed by the limit.
+func (l RateLimit) interval() time.Duration {
+	return l.Per / time.Duration(l.Count)
+}
+
+// parseRateLimits parses a comma-separated list of domain=count/period entries,
+// such as "gmail.com=10/m,example.com=1/5s". The period is s, m, h or a Go
+// duration. The domain "*" sets the limit applied to every other domain.
+func parseRateLimits(value string) (map[string]RateLimit, error) {
+	limits := make(map[string]RateLimit)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		domain, rate, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid rate limit %q: expected domain=count/period", entry)
+		}
+		countStr, periodStr, ok := strings.Cut(rate, "/")
+		if !ok {
+			return nil, fmt.Errorf("invalid rate limit %q: expected domain=count/period", entry)
+		}
+
+		count, err := strconv.Atoi(countStr)
+		if err != nil || count <= 0 {
+			return nil, fmt.Errorf("invalid rate limit %q: count must be a positive integer", entry)
+		}
+		switch periodStr {
+		case "s", "m", "h":
+			periodStr = "1" + periodStr
+		}
+		period, err := time.ParseDuration(periodStr)
+		if err != nil || period <= 0 {
+			return nil, fmt.Errorf("invalid rate limit %q: period must be a positive duration", entry)
+		}
+
+		limits[strings.ToLower(strings.TrimSpace(domain))] = RateLimit{Count: count, Per: period}
+	}
+
+	return limits, nil
+}
+
+// domainLimiter paces sends per recipient domain so hot domains are not sent to
+// faster than their configured rate. Sends over the rate are delayed, not rejected.
+type domainLimiter struct {
+	mu     sync.Mutex
+	limits map[string]RateLimit
+	next   map[string]time.Time
+}
+
+// newDomainLimiter returns a limiter enforcing the given per-domain limits.
+func newDomainLimiter(limits map[string]RateLimit) *domainLimiter {
+	return &domainLimiter{limits: limits, next: make(map[string]time.Time)}
+}
+
+// wait blocks until a message to all of domains may be sent, or ctx is done.
+func (l *domainLimiter) wait(ctx context.Context, domains []string) error {
+	delay := l.reserve(domains)
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return fmt.Errorf("rate limit wait canceled: %v", ctx.Err())
+	case <-timer.C:
+		return nil
+	}
+}
+
+// reserve claims the next send slot of every rate-limited domain among domains
+// and returns how long to wait until all of them are available.
+func (l *domainLimiter) reserve(domains []string) time.Duration {
+	if len(l.limits) == 0 {
+		return 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	var delay time.Duration
+	for _, domain := range domains {
+		limit, ok := l.limits[domain]
+		if !ok {
+			if limit, ok = l.limits["*"]; !ok {
+				continue
+			}
+		}
+
+		slot := l.next[domain]
+		if slot.Before(now) {
+			slot = now
+		}
+		l.next[domain] = slot.Add(limit.interval())
+		delay = max(delay, slot.Sub(now))
+	}
+
+	return delay
+}
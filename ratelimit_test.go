@@ -0,0 +1,76 @@
+package gosender
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestParseRateLimits(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    map[string]RateLimit
+		wantErr bool
+	}{
+		{value: "gmail.com=10/m, Example.com=1/5s", want: map[string]RateLimit{"gmail.com": {10, time.Minute}, "example.com": {1, 5 * time.Second}}},
+		{value: "*=2/s", want: map[string]RateLimit{"*": {2, time.Second}}},
+		{value: "gmail.com", wantErr: true},
+		{value: "gmail.com=10", wantErr: true},
+		{value: "gmail.com=0/m", wantErr: true},
+		{value: "gmail.com=10/never", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := parseRateLimits(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseRateLimits error = %v; want an error: %v", err, tt.wantErr)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("parseRateLimits = %v; want %v", got, tt.want)
+			}
+			for domain, limit := range tt.want {
+				if got[domain] != limit {
+					t.Errorf("limit of %s = %v; want %v", domain, got[domain], limit)
+				}
+			}
+		})
+	}
+}
+
+func TestDomainRateLimit(t *testing.T) {
+	const interval = 50 * time.Millisecond
+	tests := []struct {
+		name      string
+		to        string
+		wantPaced bool
+	}{
+		{name: "limited domain", to: "to@example.com", wantPaced: true},
+		{name: "limited domain in another case", to: "to@EXAMPLE.com", wantPaced: true},
+		{name: "other domain", to: "to@example.org"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newGmailStub(t)
+			h := stub.newServer(func(c *Config) {
+				c.DomainRateLimits = map[string]RateLimit{"example.com": {Count: 1, Per: interval}}
+			}).Handler()
+			payload := stub.payload(t, map[string]any{"to": tt.to, "subject": "Hello", "messageBody": "Hi"})
+
+			const sends = 4
+			start := time.Now()
+			for i := 0; i < sends; i++ {
+				if rec := postPayload(h, "/send", payload, nil); rec.Code != http.StatusOK {
+					t.Fatalf("send %d = %d %s", i, rec.Code, rec.Body)
+				}
+			}
+			elapsed := time.Since(start)
+
+			if paced := elapsed >= (sends-1)*interval; paced != tt.wantPaced {
+				t.Errorf("%d sends took %s; want them paced %s apart: %v", sends, elapsed, interval, tt.wantPaced)
+			}
+			if sent, _, _ := stub.counts(); sent != sends {
+				t.Errorf("sent %d messages; want %d", sent, sends)
+			}
+		})
+	}
+}
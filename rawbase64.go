@@ -0,0 +1,43 @@
+package gosender
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+// errRawBase64Rewritten rejects a rawBase64 message on a server whose policies,
+// suppression list or hooks it would bypass, since compliance archiving,
+// staging redirects and unsubscribes must hold for every message.
+var errRawBase64Rewritten = errors.New("rawBase64 is sent verbatim, bypassing the server's message policies, suppression list and hooks, so it is not accepted while any of them is configured; send the message as messageBody instead")
+
+// validateRawBase64 checks that a payload's RawBase64, if any, is a base64url
+// message standing on its own, and decodes it into MessageBody so that the
+// recipients and Message-ID are known to the rate limits, metrics and
+// deduplication. The message is sent as given, bypassing every step that
+// would change it, so servers that change or filter messages refuse it with
+// errRawBase64Rewritten.
+func validateRawBase64(p *Payload) error {
+	if p.RawBase64 == "" {
+		return nil
+	}
+
+	switch {
+	case p.MessageBody != "" || p.isStructured():
+		return errors.New("rawBase64 cannot be combined with messageBody or structured message fields")
+	case p.MessageID != "" || p.Priority != "" || p.Bulk || p.RequestReadReceipt || p.FeedbackID != "" || p.ThreadTopic != "" || p.ThreadIndex != "" || p.TrackOpens || p.SeparateThread || p.EntityRefID != "":
+		return errors.New("rawBase64 is sent verbatim and cannot be combined with fields changing the message")
+	case p.InternalDate != "":
+		return errors.New("rawBase64 is sent verbatim and cannot be combined with internalDate; set the Date header instead")
+	case strings.ContainsAny(p.RawBase64, "+/"):
+		return errors.New("invalid rawBase64: expected the URL-safe (-_) base64 alphabet")
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(p.RawBase64, "="))
+	if err != nil {
+		return errors.New("invalid rawBase64: " + err.Error())
+	}
+	p.MessageBody = string(raw)
+
+	return nil
+}
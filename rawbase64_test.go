@@ -0,0 +1,49 @@
+package gosender
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"testing"
+)
+
+func TestRawBase64(t *testing.T) {
+	message := "From: me@example.com\r\nTo: to@example.com\r\nSubject: Raw\r\n\r\nSent as is.\r\n"
+	tests := []struct {
+		name       string
+		raw        string
+		opts       []Option
+		wantStatus int
+	}{
+		{name: "verbatim", raw: base64.URLEncoding.EncodeToString([]byte(message)), wantStatus: http.StatusOK},
+		{name: "unpadded", raw: base64.RawURLEncoding.EncodeToString([]byte(message)), wantStatus: http.StatusOK},
+		{name: "standard alphabet", raw: "+/+/", wantStatus: http.StatusBadRequest},
+		{name: "invalid", raw: "not base64!", wantStatus: http.StatusBadRequest},
+		{name: "always bcc", raw: base64.URLEncoding.EncodeToString([]byte(message)), opts: []Option{func(c *Config) { c.AlwaysBcc = "archive@example.com" }}, wantStatus: http.StatusBadRequest},
+		{name: "redirect", raw: base64.URLEncoding.EncodeToString([]byte(message)), opts: []Option{func(c *Config) { c.RedirectTo = "qa@example.com" }}, wantStatus: http.StatusBadRequest},
+		{name: "suppression list", raw: base64.URLEncoding.EncodeToString([]byte(message)), opts: []Option{func(c *Config) { c.Suppressions = NewMemorySuppressionList() }}, wantStatus: http.StatusBadRequest},
+		{name: "hook", raw: base64.URLEncoding.EncodeToString([]byte(message)), opts: []Option{func(c *Config) {
+			c.Hooks = []Hook{func(context.Context, *Message) error { return nil }}
+		}}, wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newGmailStub(t)
+			h := stub.newServer(tt.opts...).Handler()
+
+			rec := postPayload(h, "/send", stub.payload(t, map[string]any{"rawBase64": tt.raw}), nil)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("send = %d %s; want %d", rec.Code, rec.Body, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				if sent, _, _ := stub.counts(); sent != 0 {
+					t.Errorf("%d messages sent; want none", sent)
+				}
+				return
+			}
+			if len(stub.raws) != 1 || stub.raws[0] != tt.raw || stub.sent[0] != message {
+				t.Errorf("sent %q; want %q verbatim", stub.raws, tt.raw)
+			}
+		})
+	}
+}
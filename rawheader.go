@@ -0,0 +1,136 @@
+package gosender
+
+import (
+	"bytes"
+	"strings"
+)
+
+// rawMessage represents a raw RFC 5322 message split into its header lines
+// (each including its line ending) and body, so individual header fields can
+// be edited without re-encoding the message.
+type rawMessage struct {
+	lines []string
+	body  []byte
+	eol   string
+}
+
+// parseRawMessage splits raw at the first blank line into header lines and body.
+func parseRawMessage(raw []byte) *rawMessage {
+	header, body := raw, []byte(nil)
+	crlf := bytes.Index(raw, []byte("\r\n\r\n"))
+	lf := bytes.Index(raw, []byte("\n\n"))
+	switch {
+	case crlf >= 0 && (lf < 0 || crlf < lf):
+		header, body = raw[:crlf+2], raw[crlf+4:]
+	case lf >= 0:
+		header, body = raw[:lf+1], raw[lf+2:]
+	}
+
+	m := &rawMessage{body: body, eol: "\n"}
+	if bytes.Contains(header, []byte("\r\n")) || len(header) == 0 {
+		m.eol = "\r\n"
+	}
+	for _, line := range strings.SplitAfter(string(header), "\n") {
+		if line != "" {
+			m.lines = append(m.lines, line)
+		}
+	}
+	if n := len(m.lines); n > 0 && !strings.HasSuffix(m.lines[n-1], "\n") {
+		m.lines[n-1] += m.eol
+	}
+
+	return m
+}
+
+// bytes reassembles the message.
+func (m *rawMessage) bytes() []byte {
+	var buf bytes.Buffer
+	for _, line := range m.lines {
+		buf.WriteString(line)
+	}
+	buf.WriteString(m.eol)
+	buf.Write(m.body)
+
+	return buf.Bytes()
+}
+
+// field returns the index of the first and last line of the named header field,
+// or -1 when the field is absent.
+func (m *rawMessage) field(name string) (first, last int) {
+	for i, line := range m.lines {
+		colon := strings.IndexByte(line, ':')
+		if colon < 0 || isContinuation(line) || !strings.EqualFold(strings.TrimSpace(line[:colon]), name) {
+			continue
+		}
+
+		last = i
+		for last+1 < len(m.lines) && isContinuation(m.lines[last+1]) {
+			last++
+		}
+		return i, last
+	}
+
+	return -1, -1
+}
+
+// value returns the unfolded value of the named header field, or "" when the
+// field is absent.
+func (m *rawMessage) value(name string) string {
+	first, last := m.field(name)
+	if first < 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	for i := first; i <= last; i++ {
+		line := strings.TrimRight(m.lines[i], "\r\n")
+		if i == first {
+			line = line[strings.IndexByte(line, ':')+1:]
+		}
+		b.WriteString(line)
+	}
+
+	return strings.TrimSpace(b.String())
+}
+
+// mergeAddress appends address to the comma-separated list of the named header
+// field, adding the field at the end of the header block when absent.
+func (m *rawMessage) mergeAddress(name, address string) {
+	first, last := m.field(name)
+	if first < 0 {
+		m.lines = append(m.lines, name+": "+address+m.eol)
+		return
+	}
+
+	line := strings.TrimRight(m.lines[last], "\r\n")
+	separator := ", "
+	if first == last && strings.TrimSpace(line[strings.IndexByte(line, ':')+1:]) == "" {
+		separator = " "
+	}
+	m.lines[last] = line + separator + address + m.eol
+}
+
+// setField replaces the named header field with a single line holding value,
+// adding the field at the end of the header block when absent.
+func (m *rawMessage) setField(name, value string) {
+	line := name + ": " + value + m.eol
+	first, last := m.field(name)
+	if first < 0 {
+		m.lines = append(m.lines, line)
+		return
+	}
+
+	m.lines = append(m.lines[:first], append([]string{line}, m.lines[last+1:]...)...)
+}
+
+// deleteField removes the named header field, if present.
+func (m *rawMessage) deleteField(name string) {
+	if first, last := m.field(name); first >= 0 {
+		m.lines = append(m.lines[:first], m.lines[last+1:]...)
+	}
+}
+
+// isContinuation reports whether line continues a folded header field.
+func isContinuation(line string) bool {
+	return strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")
+}
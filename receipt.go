@@ -0,0 +1,118 @@
+package gosender
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/mail"
+	"sort"
+	"strings"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// receiptHeader is the JOSE header of every receipt: an HMAC-SHA256 JWT.
+const receiptHeader = `{"alg":"HS256","typ":"JWT"}`
+
+// Receipt holds the claims of a signed send receipt, which clients can keep
+// as verifiable proof of what was sent: the Gmail ID and Message-ID of the
+// message, when it was sent and a hash of its recipients. RecipientHash is
+// the hex SHA-256 of the lower-cased To, Cc and Bcc addresses, sorted and
+// joined by commas.
+type Receipt struct {
+	Issuer        string `json:"iss"`
+	Subject       string `json:"sub"`
+	ID            string `json:"jti"`
+	IssuedAt      int64  `json:"iat"`
+	MessageID     string `json:"messageId,omitempty"`
+	ThreadID      string `json:"threadId,omitempty"`
+	RecipientHash string `json:"recipientHash"`
+}
+
+// VerifyReceipt checks the signature of a receipt JWT against key and returns
+// its claims.
+func VerifyReceipt(token string, key []byte) (*Receipt, error) {
+	header, rest, ok := strings.Cut(token, ".")
+	claims, signature, ok2 := strings.Cut(rest, ".")
+	if !ok || !ok2 {
+		return nil, errors.New("invalid receipt: expected a JWT of three parts")
+	}
+
+	decodedHeader, err := base64.RawURLEncoding.DecodeString(header)
+	if err != nil || string(decodedHeader) != receiptHeader {
+		return nil, errors.New("invalid receipt: expected an HS256 JWT")
+	}
+	expected := signReceipt(header+"."+claims, key)
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return nil, errors.New("invalid receipt: signature mismatch")
+	}
+
+	decodedClaims, err := base64.RawURLEncoding.DecodeString(claims)
+	if err != nil {
+		return nil, fmt.Errorf("invalid receipt: %v", err)
+	}
+	var receipt Receipt
+	if err := json.Unmarshal(decodedClaims, &receipt); err != nil {
+		return nil, fmt.Errorf("invalid receipt: %v", err)
+	}
+
+	return &receipt, nil
+}
+
+// receipt returns the signed receipt for the sent message, built from raw,
+// with Config.ReceiptKey and id as its ID. When raw carries no Message-ID,
+// the one Gmail gave the message is fetched.
+func (s *Server) receipt(ctx context.Context, service *gmail.Service, id string, raw []byte, sent *gmail.Message) (string, error) {
+	m := parseRawMessage(raw)
+	messageID := m.value("Message-ID")
+	if messageID == "" {
+		stored, err := service.Users.Messages.Get(gmailUser(ctx), sent.Id).Format("metadata").MetadataHeaders("Message-ID").Context(ctx).Do()
+		if err != nil {
+			return "", fmt.Errorf("failed to get Message-ID for receipt: %v", err)
+		}
+		if values := decodedHeaders(stored)["Message-ID"]; len(values) > 0 {
+			messageID = values[0]
+		}
+	}
+
+	var recipients []string
+	for _, name := range []string{"To", "Cc", "Bcc"} {
+		list, err := mail.ParseAddressList(m.value(name))
+		if err != nil {
+			continue
+		}
+		for _, addr := range list {
+			recipients = append(recipients, strings.ToLower(addr.Address))
+		}
+	}
+	sort.Strings(recipients)
+	sum := sha256.Sum256([]byte(strings.Join(recipients, ",")))
+
+	claims, err := json.Marshal(Receipt{
+		Issuer:        "gosender",
+		Subject:       sent.Id,
+		ID:            id,
+		IssuedAt:      s.config.now().Unix(),
+		MessageID:     messageID,
+		ThreadID:      sent.ThreadId,
+		RecipientHash: hex.EncodeToString(sum[:]),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal receipt: %v", err)
+	}
+
+	unsigned := base64.RawURLEncoding.EncodeToString([]byte(receiptHeader)) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	return unsigned + "." + signReceipt(unsigned, s.config.ReceiptKey), nil
+}
+
+// signReceipt returns the base64url HMAC-SHA256 signature of unsigned.
+func signReceipt(unsigned string, key []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(unsigned))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,76 @@
+package gosender
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReceipt(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	now := time.Date(2026, time.March, 1, 12, 0, 0, 0, time.UTC)
+	sum := sha256.Sum256([]byte("a@example.com,b@example.com,c@example.com"))
+	tests := []struct {
+		name          string
+		key           []byte
+		messageID     string
+		wantMessageID string
+	}{
+		{name: "generated Message-ID", key: key, wantMessageID: "<msg-1@mail.example.com>"},
+		{name: "given Message-ID", key: key, messageID: "<order-1@example.com>", wantMessageID: "<order-1@example.com>"},
+		{name: "no key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newGmailStub(t)
+			h := stub.newServer(WithClock(func() time.Time { return now }), func(c *Config) { c.ReceiptKey = tt.key }).Handler()
+			payload := stub.payload(t, map[string]any{
+				"to": "B@example.com", "cc": "a@example.com", "bcc": "c@example.com",
+				"subject": "Hello", "messageBody": "Hi", "messageId": tt.messageID,
+			})
+
+			rec := postPayload(h, "/send", payload, nil)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("send = %d %s; want %d", rec.Code, rec.Body, http.StatusOK)
+			}
+			var response SendResponse
+			decodeJSON(t, rec, &response)
+			if tt.key == nil {
+				if response.Receipt != "" {
+					t.Errorf("receipt = %q; want none", response.Receipt)
+				}
+				return
+			}
+
+			receipt, err := VerifyReceipt(response.Receipt, key)
+			if err != nil {
+				t.Fatalf("VerifyReceipt: %v", err)
+			}
+			want := Receipt{
+				Issuer:        "gosender",
+				Subject:       "msg-1",
+				ID:            response.RequestID,
+				IssuedAt:      now.Unix(),
+				MessageID:     tt.wantMessageID,
+				ThreadID:      "thread-msg-1",
+				RecipientHash: hex.EncodeToString(sum[:]),
+			}
+			if *receipt != want {
+				t.Errorf("receipt claims %+v; want %+v", *receipt, want)
+			}
+
+			if _, err := VerifyReceipt(response.Receipt, []byte("fedcba9876543210fedcba9876543210")); err == nil {
+				t.Error("VerifyReceipt accepted the receipt with another key")
+			}
+			header, rest, _ := strings.Cut(response.Receipt, ".")
+			_, signature, _ := strings.Cut(rest, ".")
+			forged := header + "." + "eyJzdWIiOiJtc2ctMiJ9" + "." + signature
+			if _, err := VerifyReceipt(forged, key); err == nil {
+				t.Error("VerifyReceipt accepted the receipt with altered claims")
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package gosender
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+const (
+	// redacted replaces secret values in logs.
+	redacted = "[REDACTED]"
+
+	// maxLoggedBodyLength bounds how much of a message body is logged.
+	maxLoggedBodyLength = 64
+)
+
+var (
+	// secretKeyPattern matches attribute keys whose values are always secret.
+	secretKeyPattern = regexp.MustCompile(`(?i)token|credential|secret|password|authorization|cookie|api[_-]?key`)
+
+	// bodyKeyPattern matches attribute keys holding message bodies, which are truncated.
+	bodyKeyPattern = regexp.MustCompile(`(?i)body|raw|payload`)
+
+	// secretValuePatterns match secrets embedded in otherwise loggable strings:
+	// Google access and refresh tokens, bearer credentials and secret JSON fields.
+	secretValuePatterns = []*regexp.Regexp{
+		regexp.MustCompile(`ya29\.[0-9A-Za-z_\-.]+`),
+		regexp.MustCompile(`1//[0-9A-Za-z_\-]+`),
+		regexp.MustCompile(`(?i)(bearer\s+)[^\s"',]+`),
+		regexp.MustCompile(`(?i)("(?:access_token|refresh_token|id_token|client_secret)"\s*:\s*")[^"]*`),
+	}
+)
+
+// redactingHandler is a slog.Handler masking secrets in every record before
+// handing it to the next handler.
+type redactingHandler struct {
+	next slog.Handler
+}
+
+// NewRedactingHandler returns a slog.Handler that masks credentials, tokens
+// and similar secrets, and truncates message bodies, in every log message and
+// attribute before passing the record on to next.
+func NewRedactingHandler(next slog.Handler) slog.Handler {
+	return &redactingHandler{next: next}
+}
+
+// Enabled reports whether the next handler handles records at level.
+func (h *redactingHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+// Handle redacts the record and passes it on.
+func (h *redactingHandler) Handle(ctx context.Context, record slog.Record) error {
+	redactedRecord := slog.NewRecord(record.Time, record.Level, redactString(record.Message), record.PC)
+	record.Attrs(func(a slog.Attr) bool {
+		redactedRecord.AddAttrs(redactAttr(a))
+		return true
+	})
+
+	return h.next.Handle(ctx, redactedRecord)
+}
+
+// WithAttrs returns a handler whose attributes are redacted once up front.
+func (h *redactingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redactedAttrs := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		redactedAttrs[i] = redactAttr(a)
+	}
+
+	return &redactingHandler{next: h.next.WithAttrs(redactedAttrs)}
+}
+
+// WithGroup returns a handler nesting subsequent attributes under name.
+func (h *redactingHandler) WithGroup(name string) slog.Handler {
+	return &redactingHandler{next: h.next.WithGroup(name)}
+}
+
+// redactAttr masks the value of a secret attribute, truncates message bodies
+// and scrubs embedded secrets from everything else.
+func redactAttr(a slog.Attr) slog.Attr {
+	value := a.Value.Resolve()
+
+	if value.Kind() == slog.KindGroup {
+		group := value.Group()
+		redactedGroup := make([]any, len(group))
+		for i, attr := range group {
+			redactedGroup[i] = redactAttr(attr)
+		}
+		return slog.Group(a.Key, redactedGroup...)
+	}
+
+	if secretKeyPattern.MatchString(a.Key) {
+		return slog.String(a.Key, redacted)
+	}
+
+	switch value.Kind() {
+	case slog.KindString, slog.KindAny:
+		s := redactString(fmt.Sprint(value.Any()))
+		if bodyKeyPattern.MatchString(a.Key) && len(s) > maxLoggedBodyLength {
+			s = fmt.Sprintf("%s... (%d bytes)", strings.ToValidUTF8(s[:maxLoggedBodyLength], ""), len(s))
+		}
+		return slog.String(a.Key, s)
+	}
+
+	return slog.Attr{Key: a.Key, Value: value}
+}
+
+// redactString masks secrets embedded in s.
+func redactString(s string) string {
+	for _, pattern := range secretValuePatterns {
+		if pattern.NumSubexp() > 0 {
+			s = pattern.ReplaceAllString(s, "${1}"+redacted)
+		} else {
+			s = pattern.ReplaceAllString(s, redacted)
+		}
+	}
+
+	return s
+}
@@ -0,0 +1,106 @@
+package gosender
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestRedactingHandler(t *testing.T) {
+	accessToken := "ya29.a0AfH6SMBx-secret_token"
+	tests := []struct {
+		name    string
+		log     func(*slog.Logger)
+		secrets []string
+		want    []string
+	}{
+		{
+			name:    "secret key",
+			log:     func(l *slog.Logger) { l.Info("send", "token", "opaque-value", "clientSecret", "hunter2") },
+			secrets: []string{"opaque-value", "hunter2"},
+			want:    []string{`"token":"[REDACTED]"`, `"clientSecret":"[REDACTED]"`},
+		},
+		{
+			name:    "token in a message",
+			log:     func(l *slog.Logger) { l.Error("refresh failed for " + accessToken) },
+			secrets: []string{accessToken},
+			want:    []string{"refresh failed for [REDACTED]"},
+		},
+		{
+			name: "token in an error",
+			log: func(l *slog.Logger) {
+				l.Error("send failed", "error", `oauth2: "access_token": "`+accessToken+`", Authorization: Bearer abc.def`)
+			},
+			secrets: []string{accessToken, "abc.def"},
+			want:    []string{"Bearer [REDACTED]"},
+		},
+		{
+			name: "refresh token JSON",
+			log: func(l *slog.Logger) {
+				l.Info("stored", "detail", `{"refresh_token":"1//0gLongRefresh","client_secret":"shh"}`)
+			},
+			secrets: []string{"1//0gLongRefresh", "shh"},
+		},
+		{
+			name: "grouped attributes",
+			log: func(l *slog.Logger) {
+				l.Info("request", slog.Group("payload", slog.String("credentials", `{"installed":{}}`), slog.String("to", "to@example.com")))
+			},
+			secrets: []string{"installed"},
+			want:    []string{"to@example.com"},
+		},
+		{
+			name:    "attributes added up front",
+			log:     func(l *slog.Logger) { l.With("authorization", "Basic dXNlcjpwYXNz").Info("request") },
+			secrets: []string{"dXNlcjpwYXNz"},
+		},
+		{
+			name:    "long body",
+			log:     func(l *slog.Logger) { l.Info("built", "messageBody", strings.Repeat("x", 100)+"tail") },
+			secrets: []string{"tail"},
+			want:    []string{strings.Repeat("x", maxLoggedBodyLength) + "... (104 bytes)"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			tt.log(slog.New(NewRedactingHandler(slog.NewJSONHandler(&buf, nil))))
+			out := buf.String()
+			for _, secret := range tt.secrets {
+				if strings.Contains(out, secret) {
+					t.Errorf("log %s contains %q", out, secret)
+				}
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(out, want) {
+					t.Errorf("log %s does not contain %q", out, want)
+				}
+			}
+		})
+	}
+}
+
+func TestServerLogsRedacted(t *testing.T) {
+	stub := newGmailStub(t)
+	stub.sendStatus = http.StatusInternalServerError
+	var buf bytes.Buffer
+	h := stub.newServer(WithLogger(slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))).Handler()
+	payload := stub.payload(t, map[string]any{
+		"to": "to@example.com", "subject": "Hello", "messageBody": "Hi",
+		"token": map[string]any{"access_token": "ya29.logged-access-token", "refresh_token": "1//logged-refresh-token", "expiry": "2099-01-01T00:00:00Z"},
+	})
+
+	if rec := postPayload(h, "/send", payload, nil); rec.Code == http.StatusOK {
+		t.Fatalf("send = %d %s; want a failure to log", rec.Code, rec.Body)
+	}
+	if buf.Len() == 0 {
+		t.Fatal("the failed send logged nothing")
+	}
+	for _, secret := range []string{"ya29.logged-access-token", "1//logged-refresh-token", `"client_secret":"secret"`} {
+		if strings.Contains(buf.String(), secret) {
+			t.Errorf("server logs contain %q:\n%s", secret, buf.String())
+		}
+	}
+}
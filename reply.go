@@ -0,0 +1,78 @@
+package gosender
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"strings"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// resolveReply fills in the threading fields of a reply from the parent message
+// named by ReplyToMessageID. In-Reply-To becomes the parent's Message-ID and
+// References the parent's References followed by its Message-ID, as described
+// in RFC 5322 section 3.6.4. A parent threaded for Outlook passes on its
+// Thread-Topic and Thread-Index too. Fields already set on the payload are
+// kept.
+func resolveReply(ctx context.Context, service *gmail.Service, p *Payload) error {
+	parent, err := service.Users.Messages.Get(gmailUser(ctx), p.ReplyToMessageID).
+		Format("metadata").
+		MetadataHeaders("Message-ID", "References", "In-Reply-To", "Thread-Topic", "Thread-Index").
+		Context(ctx).
+		Do()
+	if err != nil {
+		return fmt.Errorf("failed to get parent message: %v", err)
+	}
+
+	messageID := messageHeader(parent, "Message-ID")
+	if messageID == "" {
+		return fmt.Errorf("parent message %s has no Message-ID", p.ReplyToMessageID)
+	}
+
+	references := strings.Fields(messageHeader(parent, "References"))
+	if len(references) == 0 {
+		// Without References, a parent that is itself a reply to a single
+		// message still contributes its In-Reply-To to the chain.
+		if inReplyTo := strings.Fields(messageHeader(parent, "In-Reply-To")); len(inReplyTo) == 1 {
+			references = inReplyTo
+		}
+	}
+
+	if p.InReplyTo == "" {
+		p.InReplyTo = messageID
+	}
+	if len(p.References) == 0 {
+		p.References = append(references, messageID)
+	}
+	if p.ThreadID == "" {
+		p.ThreadID = parent.ThreadId
+	}
+	if p.ThreadIndex == "" {
+		p.ThreadIndex = messageHeader(parent, "Thread-Index")
+	}
+	if p.ThreadTopic == "" && p.ThreadIndex != "" {
+		topic := messageHeader(parent, "Thread-Topic")
+		var decoder mime.WordDecoder
+		if decoded, err := decoder.DecodeHeader(topic); err == nil {
+			topic = decoded
+		}
+		p.ThreadTopic = topic
+	}
+
+	return nil
+}
+
+// messageHeader returns the value of the named header of a fetched message,
+// matching the name case-insensitively.
+func messageHeader(m *gmail.Message, name string) string {
+	if m.Payload == nil {
+		return ""
+	}
+	for _, h := range m.Payload.Headers {
+		if strings.EqualFold(h.Name, name) {
+			return h.Value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,69 @@
+package gosender
+
+import (
+	"net/http"
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+func TestReplyReferences(t *testing.T) {
+	tests := []struct {
+		name           string
+		parent         map[string]string
+		fields         map[string]any
+		wantInReplyTo  string
+		wantReferences string
+	}{
+		{
+			name:           "parent with references",
+			parent:         map[string]string{"References": "<root@example.com> <second@example.com>", "In-Reply-To": "<second@example.com>"},
+			wantInReplyTo:  "<parent@mail.example.com>",
+			wantReferences: "<root@example.com> <second@example.com> <parent@mail.example.com>",
+		},
+		{
+			name:           "parent replying without references",
+			parent:         map[string]string{"In-Reply-To": "<root@example.com>"},
+			wantInReplyTo:  "<parent@mail.example.com>",
+			wantReferences: "<root@example.com> <parent@mail.example.com>",
+		},
+		{
+			name:           "parent starting the thread",
+			wantInReplyTo:  "<parent@mail.example.com>",
+			wantReferences: "<parent@mail.example.com>",
+		},
+		{
+			name:           "references given by the payload",
+			parent:         map[string]string{"References": "<root@example.com>"},
+			fields:         map[string]any{"references": []string{"<other@example.com>"}},
+			wantInReplyTo:  "<parent@mail.example.com>",
+			wantReferences: "<other@example.com>",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newGmailStub(t)
+			stub.headers = map[string]map[string]string{"parent": tt.parent}
+			h := stub.newServer().Handler()
+			fields := map[string]any{"to": "to@example.com", "subject": "Re: Hello", "messageBody": "Hi", "replyToMessageId": "parent"}
+			for k, v := range tt.fields {
+				fields[k] = v
+			}
+
+			rec := postPayload(h, "/send", stub.payload(t, fields), nil)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("send = %d %s", rec.Code, rec.Body)
+			}
+			msg, err := mail.ReadMessage(strings.NewReader(stub.sent[0]))
+			if err != nil {
+				t.Fatalf("failed to parse sent message: %v", err)
+			}
+			if got := msg.Header.Get("In-Reply-To"); got != tt.wantInReplyTo {
+				t.Errorf("In-Reply-To = %q; want %q", got, tt.wantInReplyTo)
+			}
+			if got := strings.Join(strings.Fields(msg.Header.Get("References")), " "); got != tt.wantReferences {
+				t.Errorf("References = %q; want %q", got, tt.wantReferences)
+			}
+		})
+	}
+}
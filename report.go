@@ -0,0 +1,144 @@
+package gosender
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// statusPattern matches an RFC 3463 enhanced status code such as 5.1.1.
+var statusPattern = regexp.MustCompile(`^[245]\.\d{1,3}\.\d{1,3}$`)
+
+// DeliveryReport describes a delivery status notification (RFC 3464), sent as
+// multipart/report with MessageBody as its human-readable part.
+type DeliveryReport struct {
+	ReportingMTA       string           `json:"reportingMta"`
+	OriginalEnvelopeID string           `json:"originalEnvelopeId"`
+	ArrivalDate        string           `json:"arrivalDate"`
+	Recipients         []DeliveryStatus `json:"recipients"`
+	OriginalHeaders    string           `json:"originalHeaders"`
+}
+
+// DeliveryStatus describes the delivery outcome for a single recipient.
+type DeliveryStatus struct {
+	FinalRecipient    string `json:"finalRecipient"`
+	OriginalRecipient string `json:"originalRecipient"`
+	Action            string `json:"action"`
+	Status            string `json:"status"`
+	RemoteMTA         string `json:"remoteMta"`
+	DiagnosticCode    string `json:"diagnosticCode"`
+	LastAttemptDate   string `json:"lastAttemptDate"`
+}
+
+// reportFields returns the header-bound values of the report for validation.
+func (r *DeliveryReport) reportFields() []fieldValues {
+	fields := []fieldValues{
+		{"report.reportingMta", optional(r.ReportingMTA)},
+		{"report.originalEnvelopeId", optional(r.OriginalEnvelopeID)},
+		{"report.arrivalDate", optional(r.ArrivalDate)},
+	}
+	for i, rcpt := range r.Recipients {
+		name := fmt.Sprintf("report.recipients[%d]", i)
+		fields = append(fields, fieldValues{name, []string{
+			rcpt.FinalRecipient, rcpt.OriginalRecipient, rcpt.Action, rcpt.Status,
+			rcpt.RemoteMTA, rcpt.DiagnosticCode, rcpt.LastAttemptDate,
+		}})
+	}
+
+	return fields
+}
+
+// reportPart renders the payload as a multipart/report of type delivery-status:
+// the human-readable explanation, the machine-readable message/delivery-status
+// part and, when given, the headers of the original message.
+func reportPart(p *Payload) (mimePart, error) {
+	r := p.Report
+	if r.ReportingMTA == "" {
+		return mimePart{}, errors.New("report.reportingMta is required")
+	}
+	if len(r.Recipients) == 0 {
+		return mimePart{}, errors.New("report.recipients must not be empty")
+	}
+
+	var status bytes.Buffer
+	perMessage := []headerField{{"Reporting-MTA", typed("dns", r.ReportingMTA)}}
+	if r.OriginalEnvelopeID != "" {
+		perMessage = append(perMessage, headerField{"Original-Envelope-Id", r.OriginalEnvelopeID})
+	}
+	if r.ArrivalDate != "" {
+		perMessage = append(perMessage, headerField{"Arrival-Date", r.ArrivalDate})
+	}
+	writeHeaders(&status, perMessage)
+
+	for i, rcpt := range r.Recipients {
+		fields, err := recipientStatusFields(rcpt)
+		if err != nil {
+			return mimePart{}, fmt.Errorf("report.recipients[%d]: %v", i, err)
+		}
+		writeHeaders(&status, fields)
+	}
+
+	human, err := textPart("text/plain", p.MessageBody)
+	if err != nil {
+		return mimePart{}, err
+	}
+	parts := []mimePart{
+		human,
+		{headers: []headerField{{"Content-Type", "message/delivery-status"}}, body: status.Bytes()},
+	}
+	if r.OriginalHeaders != "" {
+		parts = append(parts, mimePart{
+			headers: []headerField{{"Content-Type", "text/rfc822-headers"}},
+			body:    []byte(r.OriginalHeaders),
+		})
+	}
+
+	return multipartPartWithParams(p, "report", map[string]string{"report-type": "delivery-status"}, parts), nil
+}
+
+// recipientStatusFields returns the per-recipient fields of a delivery status.
+func recipientStatusFields(rcpt DeliveryStatus) ([]headerField, error) {
+	if rcpt.FinalRecipient == "" {
+		return nil, errors.New("finalRecipient is required")
+	}
+	switch rcpt.Action {
+	case "failed", "delayed", "delivered", "relayed", "expanded":
+	default:
+		return nil, fmt.Errorf("invalid action %q: expected failed, delayed, delivered, relayed or expanded", rcpt.Action)
+	}
+	if !statusPattern.MatchString(rcpt.Status) {
+		return nil, fmt.Errorf("invalid status %q: expected a code such as 5.1.1", rcpt.Status)
+	}
+
+	var fields []headerField
+	if rcpt.OriginalRecipient != "" {
+		fields = append(fields, headerField{"Original-Recipient", typed("rfc822", rcpt.OriginalRecipient)})
+	}
+	fields = append(fields,
+		headerField{"Final-Recipient", typed("rfc822", rcpt.FinalRecipient)},
+		headerField{"Action", rcpt.Action},
+		headerField{"Status", rcpt.Status},
+	)
+	if rcpt.RemoteMTA != "" {
+		fields = append(fields, headerField{"Remote-MTA", typed("dns", rcpt.RemoteMTA)})
+	}
+	if rcpt.DiagnosticCode != "" {
+		fields = append(fields, headerField{"Diagnostic-Code", typed("smtp", rcpt.DiagnosticCode)})
+	}
+	if rcpt.LastAttemptDate != "" {
+		fields = append(fields, headerField{"Last-Attempt-Date", rcpt.LastAttemptDate})
+	}
+
+	return fields, nil
+}
+
+// typed prefixes value with the given type, as in "rfc822; user@example.com",
+// unless it already carries one.
+func typed(kind, value string) string {
+	if strings.Contains(value, ";") {
+		return value
+	}
+	return kind + "; " + value
+}
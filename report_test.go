@@ -0,0 +1,128 @@
+package gosender
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"testing"
+	"time"
+)
+
+func TestDeliveryReport(t *testing.T) {
+	failed := DeliveryStatus{FinalRecipient: "gone@example.org", Action: "failed", Status: "5.1.1", DiagnosticCode: "550 5.1.1 user unknown"}
+	tests := []struct {
+		name       string
+		report     DeliveryReport
+		wantParts  []string
+		wantStatus []map[string]string
+		wantErr    bool
+	}{
+		{
+			name:      "failed recipient",
+			report:    DeliveryReport{ReportingMTA: "mx.example.com", Recipients: []DeliveryStatus{failed}},
+			wantParts: []string{"text/plain", "message/delivery-status"},
+			wantStatus: []map[string]string{
+				{"Reporting-Mta": "dns; mx.example.com"},
+				{"Final-Recipient": "rfc822; gone@example.org", "Action": "failed", "Status": "5.1.1", "Diagnostic-Code": "smtp; 550 5.1.1 user unknown"},
+			},
+		},
+		{
+			name: "several recipients with the original headers",
+			report: DeliveryReport{
+				ReportingMTA: "dns; mx.example.com", OriginalEnvelopeID: "env-1",
+				Recipients: []DeliveryStatus{
+					failed,
+					{FinalRecipient: "slow@example.net", OriginalRecipient: "rfc822; alias@example.net", Action: "delayed", Status: "4.4.7"},
+				},
+				OriginalHeaders: "Subject: Hello\r\nTo: gone@example.org\r\n",
+			},
+			wantParts: []string{"text/plain", "message/delivery-status", "text/rfc822-headers"},
+			wantStatus: []map[string]string{
+				{"Reporting-Mta": "dns; mx.example.com", "Original-Envelope-Id": "env-1"},
+				{"Final-Recipient": "rfc822; gone@example.org", "Action": "failed", "Status": "5.1.1"},
+				{"Final-Recipient": "rfc822; slow@example.net", "Original-Recipient": "rfc822; alias@example.net", "Action": "delayed", "Status": "4.4.7"},
+			},
+		},
+		{name: "no reporting MTA", report: DeliveryReport{Recipients: []DeliveryStatus{failed}}, wantErr: true},
+		{name: "no recipients", report: DeliveryReport{ReportingMTA: "mx.example.com"}, wantErr: true},
+		{
+			name:    "invalid action",
+			report:  DeliveryReport{ReportingMTA: "mx.example.com", Recipients: []DeliveryStatus{{FinalRecipient: "gone@example.org", Action: "bounced", Status: "5.1.1"}}},
+			wantErr: true,
+		},
+		{
+			name:    "invalid status",
+			report:  DeliveryReport{ReportingMTA: "mx.example.com", Recipients: []DeliveryStatus{{FinalRecipient: "gone@example.org", Action: "failed", Status: "550"}}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload := Payload{To: AddressList{"sender@example.com"}, Subject: "Undeliverable: Hello", MessageBody: "Your message could not be delivered.", Report: &tt.report}
+			raw, err := buildMessage(&payload, time.Now())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("buildMessage error = %v; want an error: %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			msg, err := mail.ReadMessage(bytes.NewReader(raw))
+			if err != nil {
+				t.Fatalf("failed to parse message: %v", err)
+			}
+			mediaType, params, _ := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+			if mediaType != "multipart/report" || params["report-type"] != "delivery-status" {
+				t.Fatalf("Content-Type = %q; want multipart/report of type delivery-status", msg.Header.Get("Content-Type"))
+			}
+
+			var types []string
+			reader := multipart.NewReader(msg.Body, params["boundary"])
+			for {
+				part, err := reader.NextPart()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatalf("failed to read part: %v", err)
+				}
+				partType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+				types = append(types, partType)
+				if partType == "message/delivery-status" {
+					checkDeliveryStatus(t, part, tt.wantStatus)
+				}
+			}
+			if len(types) != len(tt.wantParts) {
+				t.Fatalf("parts = %v; want %v", types, tt.wantParts)
+			}
+			for i := range types {
+				if types[i] != tt.wantParts[i] {
+					t.Errorf("part %d = %s; want %s", i, types[i], tt.wantParts[i])
+				}
+			}
+		})
+	}
+}
+
+// checkDeliveryStatus checks that the field groups of a message/delivery-status
+// body, the per-message fields followed by those of each recipient, carry the
+// wanted fields.
+func checkDeliveryStatus(t *testing.T, body io.Reader, want []map[string]string) {
+	t.Helper()
+	reader := textproto.NewReader(bufio.NewReader(body))
+	for i, fields := range want {
+		group, err := reader.ReadMIMEHeader()
+		if err != nil && err != io.EOF {
+			t.Fatalf("failed to read status group %d: %v", i, err)
+		}
+		for name, value := range fields {
+			if got := group.Get(name); got != value {
+				t.Errorf("group %d: %s = %q; want %q", i, name, got, value)
+			}
+		}
+	}
+}
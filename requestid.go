@@ -0,0 +1,141 @@
+package gosender
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+	"time"
+)
+
+const (
+	// requestIDHeader carries the ID identifying a request, both inbound and in responses.
+	requestIDHeader = "X-Request-ID"
+
+	// traceparentHeader carries the W3C Trace Context of a request.
+	traceparentHeader = "traceparent"
+)
+
+var (
+	// requestIDPattern matches the inbound request IDs that are accepted as is.
+	requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+	// traceparentPattern matches a version 00 W3C traceparent, capturing the trace ID.
+	traceparentPattern = regexp.MustCompile(`^00-([0-9a-f]{32})-[0-9a-f]{16}-[0-9a-f]{2}$`)
+)
+
+// requestIDKey is the context key under which the request ID is stored, and
+// serverIDKey the one under which the server-generated ID of the request is.
+type (
+	requestIDKey struct{}
+	serverIDKey  struct{}
+)
+
+// newRequestID returns a random, unguessable request ID.
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic("gosender: failed to generate request id: " + err.Error())
+	}
+	return hex.EncodeToString(b)
+}
+
+// requestIDFromContext returns the request ID stored in ctx, or a new one.
+func requestIDFromContext(ctx context.Context) string {
+	if id, ok := ctx.Value(requestIDKey{}).(string); ok {
+		return id
+	}
+	return newRequestID()
+}
+
+// serverIDFromContext returns the ID the server generated for the request in
+// ctx, or a new one. Unlike the request ID, which the caller may choose and
+// so reuse, it is unique, so the state a request leaves on the server, such
+// as its undo record, is keyed by it. It is the request ID itself when the
+// caller supplied none.
+func serverIDFromContext(ctx context.Context) string {
+	if id, ok := ctx.Value(serverIDKey{}).(string); ok {
+		return id
+	}
+	return newRequestID()
+}
+
+// contextWithServerID returns a copy of ctx carrying id as the server-generated
+// ID of the request.
+func contextWithServerID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, serverIDKey{}, id)
+}
+
+// inboundRequestID returns the request ID supplied by the caller, preferring
+// X-Request-ID over the trace ID of a traceparent header.
+func inboundRequestID(r *http.Request) string {
+	if id := r.Header.Get(requestIDHeader); requestIDPattern.MatchString(id) {
+		return id
+	}
+	if m := traceparentPattern.FindStringSubmatch(r.Header.Get(traceparentHeader)); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// withRequestID assigns every request an ID, reusing the inbound X-Request-ID or
+// traceparent so upstream systems can correlate, echoes it in the response, and
+// logs the outcome of the request. The inbound ID is only used for logging and
+// echoing; every request is also given a server-generated ID, see
+// serverIDFromContext.
+func (s *Server) withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		serverID := newRequestID()
+		id := inboundRequestID(r)
+		if id == "" {
+			id = serverID
+		}
+		w.Header().Set(requestIDHeader, id)
+		if traceparent := r.Header.Get(traceparentHeader); traceparentPattern.MatchString(traceparent) {
+			w.Header().Set(traceparentHeader, traceparent)
+		}
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		ctx := contextWithServerID(context.WithValue(r.Context(), requestIDKey{}, id), serverID)
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+
+		s.logger.Info("request",
+			"request_id", id,
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", recorder.status,
+			"duration", time.Since(start),
+		)
+	})
+}
+
+// statusRecorder records the status code written through a ResponseWriter.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader records the status code before writing it, along with the
+// ErrorCode of an error status.
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	if code := CodeForStatus(status); code != "" {
+		r.Header().Set(errorCodeHeader, string(code))
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Flush flushes the underlying ResponseWriter when it supports flushing.
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap returns the underlying ResponseWriter, for http.ResponseController.
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -0,0 +1,22 @@
+package gosender
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// writeJSON writes v as the JSON response body. The output is indented for
+// human readers when Config.Debug is set or the request asks for ?pretty=true,
+// and compact otherwise.
+func (s *Server) writeJSON(w http.ResponseWriter, r *http.Request, v any) {
+	encoder := json.NewEncoder(w)
+	if s.prettyJSON(r) {
+		encoder.SetIndent("", "  ")
+	}
+	encoder.Encode(v)
+}
+
+// prettyJSON reports whether the JSON responses to r are indented.
+func (s *Server) prettyJSON(r *http.Request) bool {
+	return s.config.Debug || r.URL.Query().Get("pretty") == "true"
+}
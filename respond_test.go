@@ -0,0 +1,45 @@
+package gosender
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestPrettyJSON(t *testing.T) {
+	tests := []struct {
+		name       string
+		debug      bool
+		path       string
+		wantPretty bool
+	}{
+		{name: "compact by default", path: "/send"},
+		{name: "pretty by query", path: "/send?pretty=true", wantPretty: true},
+		{name: "pretty in debug", debug: true, path: "/send", wantPretty: true},
+		{name: "other query value", path: "/send?pretty=1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newGmailStub(t)
+			h := stub.newServer(func(c *Config) { c.Debug = tt.debug }).Handler()
+			payload := stub.payload(t, map[string]any{"to": "to@example.com", "subject": "Hello", "messageBody": "Hi"})
+
+			rec := postPayload(h, tt.path, payload, nil)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("send = %d %s", rec.Code, rec.Body)
+			}
+			body := strings.TrimSuffix(rec.Body.String(), "\n")
+			if pretty := strings.Contains(body, "\n  \"requestId\""); pretty != tt.wantPretty {
+				t.Errorf("response %s is indented: %v; want %v", body, pretty, tt.wantPretty)
+			}
+			if !tt.wantPretty && strings.Contains(body, "\n") {
+				t.Errorf("compact response %s spans several lines", body)
+			}
+			var response SendResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil || response.Output == nil {
+				t.Errorf("failed to decode response %s: %v", body, err)
+			}
+		})
+	}
+}
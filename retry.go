@@ -0,0 +1,240 @@
+package gosender
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"google.golang.org/api/googleapi"
+)
+
+// Headers letting a request tune the retries of its send.
+const (
+	sendRetriesHeader     = "X-Send-Retries"
+	retryBaseDelayHeader  = "X-Retry-Base-Delay"
+	retryMaxDelayHeader   = "X-Retry-Max-Delay"
+	retryMultiplierHeader = "X-Retry-Multiplier"
+)
+
+// Defaults of the Backoff settings.
+const (
+	defaultRetryBaseDelay  = 500 * time.Millisecond
+	defaultRetryMaxDelay   = 10 * time.Second
+	defaultRetryMultiplier = 2
+)
+
+// Backoff configures how transient failures are retried: after the first
+// failed attempt the delay is BaseDelay, growing by Multiplier after each
+// further attempt up to MaxDelay, for at most Retries retries.
+type Backoff struct {
+	Retries    int
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+	Multiplier float64
+}
+
+// backoffKey is the context key for a request's Backoff override.
+type backoffKey struct{}
+
+// retryBudgetKey is the context key for a request's retryBudget.
+type retryBudgetKey struct{}
+
+// retryBudget tracks the retries left to a request, shared by all of its
+// sends; see Config.RetryBudget.
+type retryBudget struct {
+	mu       sync.Mutex
+	retries  int // retries left, or -1 when unlimited
+	deadline time.Time
+}
+
+// Validate checks that the delays are not negative, that BaseDelay does not
+// exceed MaxDelay and that Multiplier is at least 1.
+func (b Backoff) Validate() error {
+	switch {
+	case b.Retries < 0:
+		return errors.New("retries must not be negative")
+	case b.BaseDelay < 0 || b.MaxDelay < 0:
+		return errors.New("retry delays must not be negative")
+	case b.BaseDelay > b.MaxDelay:
+		return fmt.Errorf("retry base delay %s exceeds the max delay %s", b.BaseDelay, b.MaxDelay)
+	case b.Multiplier < 1:
+		return fmt.Errorf("retry multiplier %g must be at least 1", b.Multiplier)
+	}
+	return nil
+}
+
+// delay returns the delay before the given retry, counting from 1.
+func (b Backoff) delay(retry int) time.Duration {
+	delay := float64(b.BaseDelay)
+	for i := 1; i < retry && delay < float64(b.MaxDelay); i++ {
+		delay *= b.Multiplier
+	}
+	return min(time.Duration(delay), b.MaxDelay)
+}
+
+// withRetry calls fn until it succeeds, returns a non-retryable error, or the
+// retries allowed by backoff are exhausted, backing off in between. Each retry
+// is also taken from the request's retry budget, if any; once that is spent
+// the call fails with ErrRetryBudgetExceeded.
+func withRetry[T any](ctx context.Context, backoff Backoff, fn func() (T, error)) (T, error) {
+	for retry := 1; ; retry++ {
+		result, err := fn()
+		if err == nil || retry > backoff.Retries || !isRetryable(err) {
+			return result, err
+		}
+
+		delay := backoff.delay(retry)
+		if budget, ok := ctx.Value(retryBudgetKey{}).(*retryBudget); ok && !budget.spend(delay) {
+			return result, &statusError{
+				status:      gmailStatus(err),
+				gmailStatus: upstreamStatus(err),
+				err:         fmt.Errorf("%w after %d retries (last error: %v)", ErrRetryBudgetExceeded, retry-1, err),
+			}
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return result, fmt.Errorf("retry canceled: %v (last error: %v)", ctx.Err(), err)
+		case <-timer.C:
+		}
+	}
+}
+
+// withRetryBudget attaches a fresh retry budget to every request when
+// Config.RetryBudget or Config.RetryBudgetTime is set.
+func (s *Server) withRetryBudget(next http.Handler) http.Handler {
+	if s.config.RetryBudget <= 0 && s.config.RetryBudgetTime <= 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		budget := &retryBudget{retries: -1}
+		if s.config.RetryBudget > 0 {
+			budget.retries = s.config.RetryBudget
+		}
+		if s.config.RetryBudgetTime > 0 {
+			budget.deadline = time.Now().Add(s.config.RetryBudgetTime)
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), retryBudgetKey{}, budget)))
+	})
+}
+
+// spend takes a retry backing off for delay from the budget, reporting false
+// without taking anything when no retry is left or the retry would start
+// past the budget's deadline.
+func (b *retryBudget) spend(delay time.Duration) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.retries == 0 || !b.deadline.IsZero() && time.Now().Add(delay).After(b.deadline) {
+		return false
+	}
+	if b.retries > 0 {
+		b.retries--
+	}
+	return true
+}
+
+// backoff returns the server's Backoff, with the defaults filled in for unset
+// delays and multiplier.
+func (c *Config) backoff() Backoff {
+	b := Backoff{
+		Retries:    c.SendRetries,
+		BaseDelay:  c.RetryBaseDelay,
+		MaxDelay:   c.RetryMaxDelay,
+		Multiplier: c.RetryMultiplier,
+	}
+	if b.BaseDelay == 0 {
+		b.BaseDelay = defaultRetryBaseDelay
+	}
+	if b.MaxDelay == 0 {
+		b.MaxDelay = max(defaultRetryMaxDelay, b.BaseDelay)
+	}
+	if b.Multiplier == 0 {
+		b.Multiplier = defaultRetryMultiplier
+	}
+	return b
+}
+
+// requestBackoff returns the Backoff for a request: the server's, with the
+// settings of the request's retry headers applied. A request can lower the
+// number of retries and the max delay but not raise them above the server's.
+func (s *Server) requestBackoff(r *http.Request) (Backoff, error) {
+	server := s.config.backoff()
+	b := server
+
+	var err error
+	if value := r.Header.Get(sendRetriesHeader); value != "" {
+		if b.Retries, err = strconv.Atoi(value); err != nil {
+			return Backoff{}, fmt.Errorf("invalid %s: %v", sendRetriesHeader, err)
+		}
+	}
+	if value := r.Header.Get(retryBaseDelayHeader); value != "" {
+		if b.BaseDelay, err = time.ParseDuration(value); err != nil {
+			return Backoff{}, fmt.Errorf("invalid %s: %v", retryBaseDelayHeader, err)
+		}
+	}
+	if value := r.Header.Get(retryMaxDelayHeader); value != "" {
+		if b.MaxDelay, err = time.ParseDuration(value); err != nil {
+			return Backoff{}, fmt.Errorf("invalid %s: %v", retryMaxDelayHeader, err)
+		}
+	}
+	if value := r.Header.Get(retryMultiplierHeader); value != "" {
+		if b.Multiplier, err = strconv.ParseFloat(value, 64); err != nil {
+			return Backoff{}, fmt.Errorf("invalid %s: %v", retryMultiplierHeader, err)
+		}
+	}
+
+	if err := b.Validate(); err != nil {
+		return Backoff{}, err
+	}
+	b.Retries = min(b.Retries, server.Retries)
+	b.MaxDelay = min(b.MaxDelay, server.MaxDelay)
+	b.BaseDelay = min(b.BaseDelay, b.MaxDelay)
+
+	return b, nil
+}
+
+// contextWithBackoff returns a copy of ctx carrying the request's Backoff.
+func contextWithBackoff(ctx context.Context, b Backoff) context.Context {
+	return context.WithValue(ctx, backoffKey{}, b)
+}
+
+// sendBackoff returns the Backoff for a send: the request's from ctx, or the
+// server's. Retries are only safe for idempotent sends, made with an
+// idempotency key; others fail fast and the client decides whether to try
+// again.
+func (s *Server) sendBackoff(ctx context.Context, idempotent bool) Backoff {
+	b, ok := ctx.Value(backoffKey{}).(Backoff)
+	if !ok {
+		b = s.config.backoff()
+	}
+	if !idempotent {
+		b.Retries = 0
+	}
+	return b
+}
+
+// isRetryable reports whether err is a transient failure worth retrying:
+// rate limiting, server-side Gmail errors or network timeouts.
+func isRetryable(err error) bool {
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		switch apiErr.Code {
+		case http.StatusTooManyRequests, http.StatusInternalServerError,
+			http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -0,0 +1,145 @@
+package gosender
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBackoffDelays(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  Config
+		want    []time.Duration
+		wantErr bool
+	}{
+		{
+			name:   "defaults",
+			config: Config{SendRetries: 6},
+			want:   []time.Duration{500 * time.Millisecond, time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second},
+		},
+		{
+			name:   "configured",
+			config: Config{SendRetries: 5, RetryBaseDelay: 100 * time.Millisecond, RetryMaxDelay: time.Second, RetryMultiplier: 3},
+			want:   []time.Duration{100 * time.Millisecond, 300 * time.Millisecond, 900 * time.Millisecond, time.Second, time.Second},
+		},
+		{
+			name:   "constant",
+			config: Config{SendRetries: 3, RetryBaseDelay: 200 * time.Millisecond, RetryMaxDelay: time.Second, RetryMultiplier: 1},
+			want:   []time.Duration{200 * time.Millisecond, 200 * time.Millisecond, 200 * time.Millisecond},
+		},
+		{
+			name:   "base delay above the default max delay",
+			config: Config{SendRetries: 2, RetryBaseDelay: 20 * time.Second},
+			want:   []time.Duration{20 * time.Second, 20 * time.Second},
+		},
+		{name: "negative retries", config: Config{SendRetries: -1}, wantErr: true},
+		{name: "base delay above max delay", config: Config{RetryBaseDelay: 2 * time.Second, RetryMaxDelay: time.Second}, wantErr: true},
+		{name: "shrinking multiplier", config: Config{RetryMultiplier: 0.5}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := tt.config.backoff()
+			if err := b.Validate(); (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() = %v; want an error: %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if b.Retries != len(tt.want) {
+				t.Errorf("retries = %d; want %d", b.Retries, len(tt.want))
+			}
+			for i, want := range tt.want {
+				if got := b.delay(i + 1); got != want {
+					t.Errorf("delay before retry %d = %s; want %s", i+1, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRequestBackoff(t *testing.T) {
+	server := Config{SendRetries: 3, RetryBaseDelay: 100 * time.Millisecond, RetryMaxDelay: time.Second, RetryMultiplier: 2}
+	tests := []struct {
+		name    string
+		header  map[string]string
+		want    Backoff
+		wantErr bool
+	}{
+		{name: "server settings", want: Backoff{Retries: 3, BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second, Multiplier: 2}},
+		{
+			name:   "less aggressive",
+			header: map[string]string{sendRetriesHeader: "1", retryBaseDelayHeader: "50ms", retryMaxDelayHeader: "500ms", retryMultiplierHeader: "1.5"},
+			want:   Backoff{Retries: 1, BaseDelay: 50 * time.Millisecond, MaxDelay: 500 * time.Millisecond, Multiplier: 1.5},
+		},
+		{
+			name:   "capped at the server's settings",
+			header: map[string]string{sendRetriesHeader: "10", retryBaseDelayHeader: "2s", retryMaxDelayHeader: "1m"},
+			want:   Backoff{Retries: 3, BaseDelay: time.Second, MaxDelay: time.Second, Multiplier: 2},
+		},
+		{name: "invalid retries", header: map[string]string{sendRetriesHeader: "many"}, wantErr: true},
+		{name: "invalid delay", header: map[string]string{retryBaseDelayHeader: "soon"}, wantErr: true},
+		{name: "invalid multiplier", header: map[string]string{retryMultiplierHeader: "0.5"}, wantErr: true},
+		{name: "negative retries", header: map[string]string{sendRetriesHeader: "-1"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer(&server)
+			req := httptest.NewRequest(http.MethodPost, "/send", nil)
+			for name, value := range tt.header {
+				req.Header.Set(name, value)
+			}
+
+			got, err := s.requestBackoff(req)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("requestBackoff error = %v; want an error: %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("requestBackoff = %+v; want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryBudget(t *testing.T) {
+	tests := []struct {
+		name         string
+		budget       int
+		budgetTime   time.Duration
+		wantAttempts int
+		wantExceeded bool
+	}{
+		{name: "no budget", wantAttempts: 5},
+		{name: "retries exhausted first", budget: 10, wantAttempts: 5},
+		{name: "retry budget exhausted", budget: 2, wantAttempts: 3, wantExceeded: true},
+		{name: "time budget exhausted", budgetTime: time.Millisecond, wantAttempts: 1, wantExceeded: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newGmailStub(t)
+			stub.sendStatus = http.StatusServiceUnavailable
+			h := stub.newServer(func(c *Config) {
+				c.SendRetries, c.RetryBaseDelay, c.RetryMaxDelay = 4, 5*time.Millisecond, 5*time.Millisecond
+				c.RetryBudget, c.RetryBudgetTime = tt.budget, tt.budgetTime
+			}).Handler()
+			payload := stub.payload(t, map[string]any{"to": "to@example.com", "subject": "Hello", "messageBody": "Hi"})
+
+			rec := postPayload(h, "/send", payload, map[string]string{"Idempotency-Key": "budget"})
+			if rec.Code == http.StatusOK {
+				t.Fatalf("send = %d %s; want a failure", rec.Code, rec.Body)
+			}
+			var response ErrorResponse
+			decodeJSON(t, rec, &response)
+			if exceeded := strings.Contains(response.Error, ErrRetryBudgetExceeded.Error()); exceeded != tt.wantExceeded {
+				t.Errorf("error = %+v; want %q reported: %v", response, ErrRetryBudgetExceeded, tt.wantExceeded)
+			}
+			stub.mu.Lock()
+			defer stub.mu.Unlock()
+			if stub.attempts != tt.wantAttempts {
+				t.Errorf("made %d attempts; want %d", stub.attempts, tt.wantAttempts)
+			}
+		})
+	}
+}
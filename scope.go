@@ -0,0 +1,192 @@
+package gosender
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+	"google.golang.org/api/gmail/v1"
+)
+
+// defaultTokenInfoEndpoint is Google's OAuth 2.0 token information endpoint,
+// used unless Config.TokenInfoEndpoint is set.
+const defaultTokenInfoEndpoint = "https://oauth2.googleapis.com/tokeninfo"
+
+// trashScopes lists the scopes, any one of which allows trashing and untrashing messages.
+var trashScopes = []string{gmail.MailGoogleComScope, gmail.GmailModifyScope}
+
+var (
+	// errScopeNotGranted is returned when the token lacks the scope an operation needs.
+	errScopeNotGranted = errors.New("scope not granted")
+
+	// errTokenRejected is returned when the token information endpoint rejects the token.
+	errTokenRejected = errors.New("token rejected")
+)
+
+// ErrTokenClientMismatch is reported, with 401 Unauthorized, by requests whose
+// token was issued to another OAuth client than that of the credentials.
+var ErrTokenClientMismatch = errors.New("gosender: token does not belong to the credentials' OAuth client")
+
+// tokenInfo is what the token information endpoint reports about an access
+// token.
+type tokenInfo struct {
+	Scope     string      `json:"scope"`
+	Audience  string      `json:"aud"`
+	Subject   string      `json:"sub"`
+	Email     string      `json:"email"`
+	ExpiresIn json.Number `json:"expires_in"`
+}
+
+// account identifies the Google account the token acts for: its email when
+// reported, else its subject, else the client it was issued to. A nil
+// tokenInfo, returned for clients not made by getClient, identifies none.
+func (info *tokenInfo) account() string {
+	switch {
+	case info == nil:
+		return ""
+	case info.Email != "":
+		return info.Email
+	case info.Subject != "":
+		return info.Subject
+	default:
+		return info.Audience
+	}
+}
+
+// requireScope checks, through the token information endpoint, that the
+// client's access token was granted at least one of the given scopes, and
+// that it was issued to the OAuth client of the credentials, returning what
+// the endpoint reported. It lets operations such as trashing fail with a
+// clear error up front instead of a confusing Gmail error part-way through.
+// A token lacking the scopes is reported as 403 Forbidden, a token rejected
+// outright or belonging to another client as 401 Unauthorized. Clients not
+// made by getClient are not checked, and a nil tokenInfo is returned.
+func (s *Server) requireScope(ctx context.Context, client *http.Client, anyOf []string) (*tokenInfo, error) {
+	info, err := s.inspectToken(ctx, client)
+	if info == nil || err != nil {
+		return nil, err
+	}
+
+	granted := strings.Fields(info.Scope)
+	for _, scope := range anyOf {
+		for _, g := range granted {
+			if g == scope {
+				return info, nil
+			}
+		}
+	}
+
+	return nil, withStatus(http.StatusForbidden, fmt.Errorf("%w: the token needs one of %s but was granted %s",
+		errScopeNotGranted, strings.Join(anyOf, ", "), strings.Join(granted, ", ")))
+}
+
+// checkTokenClient checks, where the token information endpoint can tell, that
+// the client's token was issued to the OAuth client of the credentials, so
+// that every request made with a mismatched pair fails with
+// ErrTokenClientMismatch rather than a confusing Gmail error. Failing to ask
+// the endpoint is left for the Gmail calls to report.
+func (s *Server) checkTokenClient(ctx context.Context, client *http.Client) error {
+	if _, err := s.inspectToken(ctx, client); errors.Is(err, ErrTokenClientMismatch) {
+		return err
+	}
+	return nil
+}
+
+// inspectToken returns what the token information endpoint reports about the
+// client's access token, failing with ErrTokenClientMismatch, as 401
+// Unauthorized, when the token was issued to another OAuth client than that
+// of the credentials. Clients not made by getClient are not inspected, and a
+// nil tokenInfo is returned.
+func (s *Server) inspectToken(ctx context.Context, client *http.Client) (*tokenInfo, error) {
+	transport, ok := client.Transport.(*oauth2.Transport)
+	if !ok {
+		return nil, nil
+	}
+	var clientID string
+	if source, ok := tokenSource(client); ok {
+		clientID = source.config.ClientID
+	}
+
+	token, err := transport.Source.Token()
+	var refreshErr *oauth2.RetrieveError
+	if errors.As(err, &refreshErr) && refreshErr.ErrorCode == "unauthorized_client" {
+		// Google refuses to refresh a token on behalf of another client.
+		return nil, withStatus(http.StatusUnauthorized, fmt.Errorf("%w: refreshing the token with client %s failed: %v", ErrTokenClientMismatch, clientID, err))
+	}
+	if err != nil {
+		return nil, gmailError("get token", err)
+	}
+
+	info, err := s.lookupTokenInfo(ctx, transport.Base, token)
+	if err != nil {
+		return nil, err
+	}
+	if clientID != "" && info.Audience != "" && info.Audience != clientID {
+		return nil, withStatus(http.StatusUnauthorized, fmt.Errorf("%w: the token was issued to client %s, but the credentials are those of client %s",
+			ErrTokenClientMismatch, info.Audience, clientID))
+	}
+
+	return info, nil
+}
+
+// lookupTokenInfo asks the token information endpoint about token, over base. The
+// answer is cached in the store until the token expires, keyed by a hash of
+// the access token so that the token itself is never stored.
+func (s *Server) lookupTokenInfo(ctx context.Context, base http.RoundTripper, token *oauth2.Token) (*tokenInfo, error) {
+	sum := sha256.Sum256([]byte(token.AccessToken))
+	key := "tokeninfo:" + hex.EncodeToString(sum[:])
+	if value, ok := s.store.Get(key); ok {
+		var info tokenInfo
+		if err := json.Unmarshal(value, &info); err == nil {
+			return &info, nil
+		}
+	}
+
+	endpoint := s.config.TokenInfoEndpoint
+	if endpoint == "" {
+		endpoint = defaultTokenInfoEndpoint
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		endpoint+"?access_token="+url.QueryEscape(token.AccessToken), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create token info request: %v", err)
+	}
+
+	// The token goes in the query, so the request is made without the OAuth
+	// transport, over the connections it would use.
+	resp, err := (&http.Client{Transport: base}).Do(req)
+	if err != nil {
+		return nil, gmailError("get token info", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, withStatus(http.StatusUnauthorized, fmt.Errorf("%w: token info returned %s", errTokenRejected, resp.Status))
+	}
+
+	var info tokenInfo
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return nil, fmt.Errorf("failed to decode token info: %v", err)
+	}
+
+	ttl := time.Until(token.Expiry)
+	if token.Expiry.IsZero() {
+		seconds, _ := info.ExpiresIn.Int64()
+		ttl = time.Duration(seconds) * time.Second
+	}
+	if ttl > 0 {
+		if value, err := json.Marshal(info); err == nil {
+			s.store.Set(key, value, ttl)
+		}
+	}
+
+	return &info, nil
+}
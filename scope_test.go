@@ -0,0 +1,99 @@
+package gosender
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestRequireScope(t *testing.T) {
+	tests := []struct {
+		name        string
+		scope       string
+		audience    string
+		tokenStatus int
+		wantStatus  int
+		wantErr     error
+	}{
+		{name: "full access", scope: "https://mail.google.com/", audience: stubClientID},
+		{name: "modify among others", scope: "openid https://www.googleapis.com/auth/gmail.modify", audience: stubClientID},
+		{name: "send only", scope: "https://www.googleapis.com/auth/gmail.send", audience: stubClientID, wantStatus: http.StatusForbidden, wantErr: errScopeNotGranted},
+		{name: "rejected token", tokenStatus: http.StatusBadRequest, wantStatus: http.StatusUnauthorized, wantErr: errTokenRejected},
+		{name: "other client", scope: "https://mail.google.com/", audience: "other.apps.googleusercontent.com", wantStatus: http.StatusUnauthorized, wantErr: ErrTokenClientMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newGmailStub(t)
+			stub.scope, stub.audience, stub.tokenStatus = tt.scope, tt.audience, tt.tokenStatus
+			s := stub.newServer()
+
+			client, err := getClient(context.Background(), &Payload{Token: []byte(`"access-token"`)}, StaticCredentials(stub.credentials()), nil)
+			if err != nil {
+				t.Fatalf("getClient: %v", err)
+			}
+			info, err := s.requireScope(context.Background(), client, trashScopes)
+			if tt.wantErr == nil {
+				if err != nil || info == nil {
+					t.Fatalf("requireScope = %v, %v; want the token info", info, err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.wantErr) || errorStatus(err) != tt.wantStatus {
+				t.Fatalf("requireScope error = %v (status %d); want %v with %d", err, errorStatus(err), tt.wantErr, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestRequireScopeCachesTokenInfo(t *testing.T) {
+	stub := newGmailStub(t)
+	s := stub.newServer()
+	client, err := getClient(context.Background(), &Payload{Token: []byte(`{"access_token":"access-token","expiry":"2099-01-01T00:00:00Z"}`)}, StaticCredentials(stub.credentials()), nil)
+	if err != nil {
+		t.Fatalf("getClient: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := s.requireScope(context.Background(), client, trashScopes); err != nil {
+			t.Fatalf("requireScope: %v", err)
+		}
+	}
+	if stub.tokenInfoCalls != 1 {
+		t.Fatalf("token info endpoint called %d times; want 1", stub.tokenInfoCalls)
+	}
+}
+
+func TestTokenClientMismatch(t *testing.T) {
+	tests := []struct {
+		name   string
+		path   string
+		fields map[string]any
+	}{
+		{name: "send", path: "/send", fields: map[string]any{"to": "to@example.com", "subject": "Hello", "messageBody": "Hi"}},
+		{name: "insert", path: "/send", fields: map[string]any{"to": "to@example.com", "subject": "Hello", "messageBody": "Hi", "mode": "insert"}},
+		{name: "dry run", path: "/send", fields: map[string]any{"to": "to@example.com", "subject": "Hello", "messageBody": "Hi", "dryRun": true}},
+		{name: "trash", path: "/trash", fields: map[string]any{"query": "older_than:1y"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newGmailStub(t)
+			stub.audience = "other.apps.googleusercontent.com"
+			h := stub.newServer().Handler()
+
+			rec := postPayload(h, tt.path, stub.payload(t, tt.fields), nil)
+			if rec.Code != http.StatusUnauthorized {
+				t.Fatalf("%s = %d %s; want %d", tt.path, rec.Code, rec.Body, http.StatusUnauthorized)
+			}
+			var response ErrorResponse
+			decodeJSON(t, rec, &response)
+			if !strings.Contains(response.Error, "other.apps.googleusercontent.com") || !strings.Contains(response.Error, stubClientID) || response.Code != ErrAuth {
+				t.Errorf("error = %+v; want it to name both clients", response)
+			}
+			if sent, inserted, trashed := stub.counts(); sent+inserted+trashed != 0 {
+				t.Errorf("sent %d, inserted %d and trashed %d messages; want none", sent, inserted, trashed)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package gosender
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"golang.org/x/oauth2/google"
+)
+
+// secretManagerEndpoint is the base URL of the Secret Manager API.
+var secretManagerEndpoint = "https://secretmanager.googleapis.com/v1/"
+
+// accessSecret returns the payload of a Secret Manager secret version, named as
+// projects/PROJECT/secrets/SECRET/versions/VERSION, using the application
+// default credentials.
+func accessSecret(ctx context.Context, name string) ([]byte, error) {
+	client, err := google.DefaultClient(ctx, "https://www.googleapis.com/auth/cloud-platform")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create secret manager client: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, secretManagerEndpoint+name+":access", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create secret request: %v", err)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to access secret: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secret: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to access secret: unexpected status %s", resp.Status)
+	}
+
+	var version struct {
+		Payload struct {
+			Data string `json:"data"`
+		} `json:"payload"`
+	}
+	if err := json.Unmarshal(body, &version); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal secret: %v", err)
+	}
+
+	data, err := base64.StdEncoding.DecodeString(version.Payload.Data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode secret: %v", err)
+	}
+
+	return data, nil
+}
@@ -0,0 +1,48 @@
+package gosender
+
+import (
+	"context"
+	"net/http"
+
+	"google.golang.org/api/gmail/v1"
+	"google.golang.org/api/option"
+)
+
+// ServiceFactory creates the Gmail service a request acts through, from the
+// HTTP client authenticated with the request's credentials and token. ctx is
+// the request's, carrying its request ID and tenant. A custom factory can set
+// its own client options, endpoint or transport, or return a service backed
+// by a fake Gmail API in tests.
+type ServiceFactory interface {
+	NewService(ctx context.Context, client *http.Client) (*gmail.Service, error)
+}
+
+// ServiceFactoryFunc is a function used as a ServiceFactory.
+type ServiceFactoryFunc func(ctx context.Context, client *http.Client) (*gmail.Service, error)
+
+// NewService calls f.
+func (f ServiceFactoryFunc) NewService(ctx context.Context, client *http.Client) (*gmail.Service, error) {
+	return f(ctx, client)
+}
+
+// endpointServiceFactory is the ServiceFactory used unless Config.ServiceFactory
+// is set, creating services talking to the Gmail API at the given base URL,
+// or at Google's when empty.
+type endpointServiceFactory string
+
+// NewService creates a service using client.
+func (endpoint endpointServiceFactory) NewService(ctx context.Context, client *http.Client) (*gmail.Service, error) {
+	opts := []option.ClientOption{option.WithHTTPClient(client)}
+	if endpoint != "" {
+		opts = append(opts, option.WithEndpoint(string(endpoint)))
+	}
+	return gmail.NewService(ctx, opts...)
+}
+
+// serviceFactory returns the factory of the server's Gmail services.
+func (s *Server) serviceFactory() ServiceFactory {
+	if s.config.ServiceFactory != nil {
+		return s.config.ServiceFactory
+	}
+	return endpointServiceFactory(s.config.GmailEndpoint)
+}
@@ -0,0 +1,62 @@
+package gosender
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"google.golang.org/api/gmail/v1"
+	"google.golang.org/api/option"
+)
+
+func TestServiceFactory(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+		wantFake   int
+	}{
+		{name: "fake service", wantStatus: http.StatusOK, wantFake: 1},
+		{name: "factory failing", err: errors.New("no service today"), wantStatus: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub, fake := newGmailStub(t), newGmailStub(t)
+			var calls int
+			factory := ServiceFactoryFunc(func(ctx context.Context, client *http.Client) (*gmail.Service, error) {
+				calls++
+				if requestIDFromContext(ctx) == "" || client == nil {
+					t.Errorf("factory called with request ID %q and client %v; want the request's", requestIDFromContext(ctx), client)
+				}
+				if tt.err != nil {
+					return nil, tt.err
+				}
+				return gmail.NewService(ctx, option.WithHTTPClient(client), option.WithEndpoint(fake.config().GmailEndpoint))
+			})
+			h := stub.newServer(WithServiceFactory(factory)).Handler()
+
+			rec := postPayload(h, "/send", stub.payload(t, map[string]any{"to": "to@example.com", "subject": "Hello", "messageBody": "Hi"}), nil)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("send = %d %s; want %d", rec.Code, rec.Body, tt.wantStatus)
+			}
+			if calls != 1 {
+				t.Errorf("factory called %d times; want once", calls)
+			}
+			if sent, _, _ := stub.counts(); sent != 0 {
+				t.Errorf("sent %d messages through the configured endpoint; want none", sent)
+			}
+			if sent, _, _ := fake.counts(); sent != tt.wantFake {
+				t.Errorf("sent %d messages through the fake service; want %d", sent, tt.wantFake)
+			}
+			if tt.err != nil {
+				var response ErrorResponse
+				decodeJSON(t, rec, &response)
+				if !strings.Contains(response.Error, tt.err.Error()) {
+					t.Errorf("error = %+v; want the factory's error", response)
+				}
+			}
+		})
+	}
+}
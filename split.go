@@ -0,0 +1,129 @@
+package gosender
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// validateSplit checks SplitRecipients, which only structured messages sent
+// with a Message-ID of Gmail's choosing can use: each part is a message of
+// its own.
+func validateSplit(p *Payload) error {
+	switch {
+	case p.SplitRecipients < 0:
+		return fmt.Errorf("invalid splitRecipients %d: expected a positive number of recipients", p.SplitRecipients)
+	case p.SplitRecipients == 0:
+		return nil
+	case !p.isStructured():
+		return errors.New("splitRecipients is only supported for structured messages")
+	case p.MessageID != "":
+		return errors.New("splitRecipients cannot be combined with messageId, as every part is a message of its own")
+	case p.DryRun:
+		return errors.New("splitRecipients cannot be combined with dryRun")
+	}
+
+	return nil
+}
+
+// splitRecipients returns copies of p addressed to at most size of its
+// recipients each, taken in order from To, then Cc, then Bcc, every recipient
+// keeping its field. A payload with no more than size recipients is returned
+// as is.
+func splitRecipients(p *Payload, size int) []*Payload {
+	if len(p.To)+len(p.Cc)+len(p.Bcc) <= size {
+		return []*Payload{p}
+	}
+
+	var parts []*Payload
+	var part *Payload
+	count := 0
+	for _, field := range []struct {
+		list AddressList
+		set  func(p *Payload, address string)
+	}{
+		{p.To, func(p *Payload, address string) { p.To = append(p.To, address) }},
+		{p.Cc, func(p *Payload, address string) { p.Cc = append(p.Cc, address) }},
+		{p.Bcc, func(p *Payload, address string) { p.Bcc = append(p.Bcc, address) }},
+	} {
+		for _, address := range field.list {
+			if count%size == 0 {
+				copied := *p
+				copied.To, copied.Cc, copied.Bcc = nil, nil, nil
+				copied.SplitRecipients = 0
+				// Attachments are loaded in place, so each part needs its own.
+				copied.Attachments = append([]Attachment(nil), p.Attachments...)
+				part = &copied
+				parts = append(parts, part)
+			}
+			field.set(part, address)
+			count++
+		}
+	}
+
+	return parts
+}
+
+// sendSplit sends the payload as one message per SplitRecipients recipients,
+// one after the other, and responds with the BatchResult of every part, as
+// /batch does. Parts fail independently of each other. Existing messages are
+// trashed once, after the last part, under an undo ID shared by the parts
+// sent. The parts carry no idempotency key, so splitting cannot be combined
+// with one, nor with asynchronous or streamed sends.
+func (s *Server) sendSplit(ctx context.Context, w http.ResponseWriter, r *http.Request, payload *Payload) {
+	query := r.URL.Query()
+	switch {
+	case r.Header.Get(idempotencyKeyHeader) != "":
+		writeError(w, withStatus(http.StatusBadRequest, errors.New("splitRecipients cannot be combined with an Idempotency-Key")))
+		return
+	case query.Get("async") == "true" || query.Get("progress") == "ndjson":
+		writeError(w, withStatus(http.StatusBadRequest, errors.New("splitRecipients cannot be combined with async or progress")))
+		return
+	}
+
+	ctx, client, service, err := s.newService(ctx, payload)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	var labels []string
+	var info *tokenInfo
+	if payload.cleansUp() {
+		if labels, err = s.cleanupLabels(payload); err == nil {
+			info, err = s.checkTrashScope(ctx, client)
+		}
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+
+	parts := splitRecipients(payload, payload.SplitRecipients)
+	results := make([]BatchResult, 0, len(parts))
+	var sent []*SendResponse
+	for i, part := range parts {
+		part.skipCleanup = true
+		result := s.sendBatchItem(ctx, i, part)
+		results = append(results, result)
+		if result.Result != nil && result.Result.Status != sendStatusNothingSent {
+			sent = append(sent, result.Result)
+		}
+	}
+
+	if len(sent) > 0 && len(labels) > 0 {
+		undoID := serverIDFromContext(ctx)
+		if err := s.cleanUp(ctx, service, undoID, info.account(), labels, nil); err != nil {
+			writeError(w, err)
+			return
+		}
+		if s.config.UndoTTL > 0 {
+			for _, response := range sent {
+				response.UndoID = undoID
+			}
+		}
+	}
+
+	timingFromContext(ctx).setHeader(w)
+	s.writeJSON(w, r, results)
+}
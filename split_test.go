@@ -0,0 +1,101 @@
+package gosender
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestSplitRecipients(t *testing.T) {
+	tests := []struct {
+		name      string
+		payload   Payload
+		size      int
+		wantParts []Payload
+	}{
+		{
+			name:      "within size",
+			payload:   Payload{To: AddressList{"a@example.com"}, Cc: AddressList{"b@example.com"}},
+			size:      2,
+			wantParts: []Payload{{To: AddressList{"a@example.com"}, Cc: AddressList{"b@example.com"}}},
+		},
+		{
+			name:    "fields kept across parts",
+			payload: Payload{To: AddressList{"a@example.com", "b@example.com"}, Cc: AddressList{"c@example.com"}, Bcc: AddressList{"d@example.com"}},
+			size:    2,
+			wantParts: []Payload{
+				{To: AddressList{"a@example.com", "b@example.com"}},
+				{Cc: AddressList{"c@example.com"}, Bcc: AddressList{"d@example.com"}},
+			},
+		},
+		{
+			name:    "one each",
+			payload: Payload{To: AddressList{"a@example.com"}, Bcc: AddressList{"b@example.com"}, SplitRecipients: 1},
+			size:    1,
+			wantParts: []Payload{
+				{To: AddressList{"a@example.com"}},
+				{Bcc: AddressList{"b@example.com"}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parts := splitRecipients(&tt.payload, tt.size)
+			if len(parts) != len(tt.wantParts) {
+				t.Fatalf("got %d parts; want %d", len(parts), len(tt.wantParts))
+			}
+			for i, part := range parts {
+				want := tt.wantParts[i]
+				if !reflect.DeepEqual(part.To, want.To) || !reflect.DeepEqual(part.Cc, want.Cc) || !reflect.DeepEqual(part.Bcc, want.Bcc) {
+					t.Errorf("part %d addressed to %v, %v, %v; want %v, %v, %v", i, part.To, part.Cc, part.Bcc, want.To, want.Cc, want.Bcc)
+				}
+				if len(parts) > 1 && part.SplitRecipients != 0 {
+					t.Errorf("part %d keeps splitRecipients %d", i, part.SplitRecipients)
+				}
+			}
+		})
+	}
+}
+
+func TestSendSplitCleansUpOnce(t *testing.T) {
+	stub := newGmailStub(t)
+	stub.setLabel("INBOX", "old")
+	h := stub.newServer(withUndo).Handler()
+	payload := stub.payload(t, map[string]any{
+		"to":              []string{"a@example.com", "b@example.com", "c@example.com"},
+		"subject":         "Hello",
+		"messageBody":     "Hi",
+		"splitRecipients": 1,
+	})
+
+	rec := postPayload(h, "/send", payload, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("send = %d %s", rec.Code, rec.Body)
+	}
+	var results []BatchResult
+	decodeJSON(t, rec, &results)
+	if len(results) != 3 {
+		t.Fatalf("got %d results; want 3", len(results))
+	}
+	undoID := results[0].Result.UndoID
+	for i, result := range results {
+		if result.Status != http.StatusOK || result.Result.UndoID != undoID || undoID == "" {
+			t.Errorf("part %d = status %d, undoId %q; want 200 with the shared undoId", i, result.Status, result.Result.UndoID)
+		}
+	}
+
+	stub.mu.Lock()
+	sent, listCalls, tokenInfoCalls := len(stub.sent), stub.listCalls, stub.tokenInfoCalls
+	stub.mu.Unlock()
+	// INBOX and SPAM are listed once each, and the token checked once.
+	if sent != 3 || listCalls != 2 || tokenInfoCalls != 1 {
+		t.Errorf("sent %d, listed labels %d times, checked the token %d times; want 3, 2 and 1", sent, listCalls, tokenInfoCalls)
+	}
+
+	if rec := postPayload(h, "/undo/"+undoID, stub.payload(t, nil), nil); rec.Code != http.StatusOK {
+		t.Fatalf("undo = %d %s", rec.Code, rec.Body)
+	}
+	if len(stub.untrashed) != 1 || stub.untrashed[0] != "old" {
+		t.Errorf("untrashed %v; want [old]", stub.untrashed)
+	}
+}
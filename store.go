@@ -0,0 +1,80 @@
+package gosender
+
+import (
+	"sync"
+	"time"
+)
+
+// Store is a pluggable key-value store with expiry, used for server-side state
+// such as idempotency records.
+type Store interface {
+	// Get returns the value stored under key, if present and not expired.
+	Get(key string) ([]byte, bool)
+
+	// Set stores value under key until ttl elapses.
+	Set(key string, value []byte, ttl time.Duration)
+
+	// Delete removes the value stored under key.
+	Delete(key string)
+}
+
+// memoryStore is an in-memory Store. Expired entries are dropped lazily.
+type memoryStore struct {
+	mu        sync.Mutex
+	entries   map[string]memoryEntry
+	lastPurge time.Time
+}
+
+// memoryEntry represents a stored value and its expiry time.
+type memoryEntry struct {
+	value   []byte
+	expires time.Time
+}
+
+// NewMemoryStore returns a Store that keeps its entries in memory.
+func NewMemoryStore() Store {
+	return &memoryStore{entries: make(map[string]memoryEntry)}
+}
+
+// Get returns the value stored under key, if present and not expired.
+func (m *memoryStore) Get(key string) ([]byte, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, ok := m.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(entry.expires) {
+		delete(m.entries, key)
+		return nil, false
+	}
+
+	return entry.value, true
+}
+
+// Set stores value under key until ttl elapses.
+func (m *memoryStore) Set(key string, value []byte, ttl time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(m.lastPurge) > time.Minute {
+		for k, entry := range m.entries {
+			if now.After(entry.expires) {
+				delete(m.entries, k)
+			}
+		}
+		m.lastPurge = now
+	}
+
+	m.entries[key] = memoryEntry{value: value, expires: now.Add(ttl)}
+}
+
+// Delete removes the value stored under key.
+func (m *memoryStore) Delete(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.entries, key)
+}
@@ -0,0 +1,92 @@
+package gosender
+
+import (
+	"errors"
+	"net/mail"
+	"strings"
+	"sync"
+)
+
+// SuppressionList holds the addresses that must no longer be sent to, such as
+// recipients who unsubscribed.
+type SuppressionList interface {
+	// IsSuppressed reports whether address, an addr-spec such as
+	// "ann@example.com", is suppressed. Addresses compare case-insensitively.
+	IsSuppressed(address string) bool
+}
+
+// MemorySuppressionList is an in-memory SuppressionList that is safe for
+// concurrent use.
+type MemorySuppressionList struct {
+	mu        sync.RWMutex
+	addresses map[string]struct{}
+}
+
+// NewMemorySuppressionList returns a MemorySuppressionList holding addresses.
+func NewMemorySuppressionList(addresses ...string) *MemorySuppressionList {
+	l := &MemorySuppressionList{addresses: make(map[string]struct{}, len(addresses))}
+	for _, address := range addresses {
+		l.Add(address)
+	}
+	return l
+}
+
+// Add suppresses address.
+func (l *MemorySuppressionList) Add(address string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.addresses[strings.ToLower(address)] = struct{}{}
+}
+
+// Remove lifts the suppression of address.
+func (l *MemorySuppressionList) Remove(address string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.addresses, strings.ToLower(address))
+}
+
+// IsSuppressed reports whether address is suppressed.
+func (l *MemorySuppressionList) IsSuppressed(address string) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	_, ok := l.addresses[strings.ToLower(address)]
+	return ok
+}
+
+// errAllSuppressed reports that every recipient of a message is suppressed,
+// so there is nothing to send.
+var errAllSuppressed = errors.New("every recipient is suppressed")
+
+// sendStatusNothingSent is the SendResponse status of a send that was skipped
+// because every recipient is suppressed.
+const sendStatusNothingSent = "nothing_sent"
+
+// applySuppressions removes the suppressed recipients from the To, Cc and Bcc
+// fields of a built message, returning the message and the addresses removed.
+// When no recipient is left the message must not be sent.
+func (s *Server) applySuppressions(raw []byte) ([]byte, []string) {
+	if s.config.Suppressions == nil {
+		return raw, nil
+	}
+
+	m := parseRawMessage(raw)
+	suppressed := filterRecipients(m, func(addr *mail.Address) bool {
+		return !s.config.Suppressions.IsSuppressed(addr.Address)
+	})
+	if len(suppressed) == 0 {
+		return raw, nil
+	}
+
+	return m.bytes(), suppressed
+}
+
+// hasRecipients reports whether a built message has any recipient left.
+func hasRecipients(raw []byte) bool {
+	m := parseRawMessage(raw)
+	for _, name := range recipientFields {
+		if m.value(name) != "" {
+			return true
+		}
+	}
+	return false
+}
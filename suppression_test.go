@@ -0,0 +1,57 @@
+package gosender
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestSuppressions(t *testing.T) {
+	tests := []struct {
+		name           string
+		to             []string
+		alwaysBcc      string
+		wantStatus     string
+		wantSuppressed []string
+		wantTo         string
+	}{
+		{name: "none suppressed", to: []string{"ann@example.com"}, wantTo: "To: <ann@example.com>"},
+		{name: "partial", to: []string{"ann@example.com", "bob@example.com"}, wantSuppressed: []string{"bob@example.com"}, wantTo: "To: <ann@example.com>\r\n"},
+		{name: "full", to: []string{"bob@example.com", "Carol@Example.com"}, wantStatus: sendStatusNothingSent, wantSuppressed: []string{"bob@example.com", "Carol@Example.com"}},
+		{name: "partial with archive", to: []string{"ann@example.com", "bob@example.com"}, alwaysBcc: "archive@example.com", wantSuppressed: []string{"bob@example.com"}, wantTo: "To: <ann@example.com>\r\n"},
+		{name: "full with archive", to: []string{"bob@example.com"}, alwaysBcc: "archive@example.com", wantStatus: sendStatusNothingSent, wantSuppressed: []string{"bob@example.com"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newGmailStub(t)
+			h := stub.newServer(func(c *Config) {
+				c.Suppressions = NewMemorySuppressionList("bob@example.com", "carol@example.com")
+				c.AlwaysBcc = tt.alwaysBcc
+			}).Handler()
+
+			rec := postPayload(h, "/send", stub.payload(t, map[string]any{"to": tt.to, "subject": "Hello", "messageBody": "Hi"}), nil)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("send = %d %s; want %d", rec.Code, rec.Body, http.StatusOK)
+			}
+			var response SendResponse
+			decodeJSON(t, rec, &response)
+			if response.Status != tt.wantStatus || strings.Join(response.Suppressed, ",") != strings.Join(tt.wantSuppressed, ",") {
+				t.Errorf("response status %q, suppressed %v; want %q, %v", response.Status, response.Suppressed, tt.wantStatus, tt.wantSuppressed)
+			}
+
+			sent, _, trashed := stub.counts()
+			if tt.wantStatus == sendStatusNothingSent {
+				if sent != 0 || trashed != 0 {
+					t.Errorf("sent %d and trashed %d messages; want none", sent, trashed)
+				}
+				return
+			}
+			if sent != 1 || !strings.Contains(stub.sent[0], tt.wantTo) {
+				t.Fatalf("sent %q; want it to contain %q", stub.sent, tt.wantTo)
+			}
+			if tt.alwaysBcc != "" && !strings.Contains(stub.sent[0], "Bcc: "+tt.alwaysBcc) {
+				t.Errorf("sent %q; want it archived to %s", stub.sent[0], tt.alwaysBcc)
+			}
+		})
+	}
+}
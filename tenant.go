@@ -0,0 +1,83 @@
+package gosender
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Tenant represents a tenant of a multi-tenant gateway and the OAuth client
+// credentials stored for it on the server.
+type Tenant struct {
+	ID          string
+	Credentials json.RawMessage
+}
+
+// tenantKey is the context key under which the request's Tenant is stored.
+type tenantKey struct{}
+
+// contextWithTenant returns a copy of ctx carrying tenant.
+func contextWithTenant(ctx context.Context, tenant *Tenant) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// tenantFromContext returns the Tenant stored in ctx, if any.
+func tenantFromContext(ctx context.Context) (*Tenant, bool) {
+	tenant, ok := ctx.Value(tenantKey{}).(*Tenant)
+	return tenant, ok
+}
+
+// tenantID returns the ID of the tenant stored in ctx, or "" without one.
+func tenantID(ctx context.Context) string {
+	if tenant, ok := tenantFromContext(ctx); ok {
+		return tenant.ID
+	}
+	return ""
+}
+
+// withTenant resolves the tenant of each request before calling next. The
+// tenant is taken from Config.TenantHeader or, failing that, the first label of
+// the request's subdomain. Requests without a known tenant are rejected with
+// 404. When no tenants are configured every request is passed through as is.
+func (s *Server) withTenant(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if len(s.config.Tenants) == 0 {
+			next(w, r)
+			return
+		}
+
+		id := r.Header.Get(s.config.TenantHeader)
+		if id == "" {
+			id = subdomain(r.Host)
+		}
+
+		credentials, ok := s.config.Tenants[id]
+		if id == "" || !ok {
+			writeError(w, withStatus(http.StatusNotFound, errors.New("unknown tenant")))
+			return
+		}
+
+		ctx := contextWithTenant(r.Context(), &Tenant{ID: id, Credentials: credentials})
+		next(w, r.WithContext(ctx))
+	}
+}
+
+// subdomain returns the left-most label of host when host has a subdomain.
+func subdomain(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if net.ParseIP(host) != nil {
+		return ""
+	}
+
+	labels := strings.Split(host, ".")
+	if len(labels) < 3 {
+		return ""
+	}
+
+	return labels[0]
+}
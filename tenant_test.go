@@ -0,0 +1,178 @@
+package gosender
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTenantCredentials(t *testing.T) {
+	tests := []struct {
+		name       string
+		header     string
+		host       string
+		wantStatus int
+		wantTenant string
+	}{
+		{name: "first tenant by header", header: "acme", wantStatus: http.StatusOK, wantTenant: "acme"},
+		{name: "second tenant by header", header: "globex", wantStatus: http.StatusOK, wantTenant: "globex"},
+		{name: "tenant by subdomain", host: "globex.mail.example.com", wantStatus: http.StatusOK, wantTenant: "globex"},
+		{name: "header before subdomain", header: "acme", host: "globex.mail.example.com", wantStatus: http.StatusOK, wantTenant: "acme"},
+		{name: "unknown tenant", header: "initech", wantStatus: http.StatusNotFound},
+		{name: "no tenant", wantStatus: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newGmailStub(t)
+			h := stub.newServer(func(c *Config) {
+				// Each tenant's credentials refresh tokens through a token
+				// endpoint URL naming the tenant.
+				c.TenantHeader = "X-Tenant-ID"
+				c.Tenants = make(map[string]json.RawMessage)
+				for _, id := range []string{"acme", "globex"} {
+					c.Tenants[id] = json.RawMessage(fmt.Sprintf(`{"installed":{"client_id":%q,"client_secret":"secret","token_uri":%q,"redirect_uris":["http://localhost"]}}`,
+						stubClientID, stub.server.URL+"/token?tenant="+id))
+				}
+			}).Handler()
+			payload := stub.payload(t, map[string]any{
+				"to": "to@example.com", "subject": "Hello", "messageBody": "Hi",
+				"token": map[string]any{"access_token": "expired-token", "refresh_token": "refresh-token", "expiry": "2000-01-01T00:00:00Z"},
+			})
+			header := map[string]string{}
+			if tt.header != "" {
+				header["X-Tenant-ID"] = tt.header
+			}
+			if tt.host != "" {
+				header["Host"] = tt.host
+			}
+
+			rec := postPayload(h, "/send", payload, header)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("send = %d %s; want %d", rec.Code, rec.Body, tt.wantStatus)
+			}
+			stub.mu.Lock()
+			refreshes := append([]string(nil), stub.refreshes...)
+			stub.mu.Unlock()
+			if tt.wantStatus != http.StatusOK {
+				if sent, _, _ := stub.counts(); sent != 0 || len(refreshes) != 0 {
+					t.Errorf("sent %d messages and refreshed %v; want nothing done", sent, refreshes)
+				}
+				return
+			}
+			if len(refreshes) != 1 || refreshes[0] != tt.wantTenant {
+				t.Errorf("token refreshed with the credentials of %q; want those of %q", refreshes, tt.wantTenant)
+			}
+		})
+	}
+}
+
+func TestParseQuotas(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    map[string]int
+		wantErr bool
+	}{
+		{value: "Acme=500, *=100", want: map[string]int{"acme": 500, "*": 100}},
+		{value: "acme=1,", want: map[string]int{"acme": 1}},
+		{value: "acme", wantErr: true},
+		{value: "acme=0", wantErr: true},
+		{value: "acme=many", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := parseQuotas(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseQuotas error = %v; want an error: %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseQuotas = %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTenantLimits(t *testing.T) {
+	type send struct {
+		tenant     string
+		wantStatus int
+	}
+	tests := []struct {
+		name        string
+		limits      map[string]RateLimit
+		quotas      map[string]int
+		sendStatus  int
+		sends       []send
+		wantMetrics []string
+	}{
+		{
+			name:   "over the daily quota",
+			quotas: map[string]int{"acme": 2},
+			sends:  []send{{"acme", http.StatusOK}, {"acme", http.StatusOK}, {"globex", http.StatusOK}, {"acme", http.StatusTooManyRequests}, {"globex", http.StatusOK}},
+			wantMetrics: []string{
+				`gosender_tenant_sends_total{tenant="acme",result="rejected"} 1`,
+				`gosender_tenant_sends_total{tenant="acme",result="success"} 2`,
+				`gosender_tenant_sends_total{tenant="globex",result="success"} 2`,
+			},
+		},
+		{
+			name:   "over the rate limit",
+			limits: map[string]RateLimit{"acme": {Count: 2, Per: time.Hour}},
+			sends:  []send{{"acme", http.StatusOK}, {"acme", http.StatusOK}, {"acme", http.StatusTooManyRequests}, {"globex", http.StatusOK}},
+			wantMetrics: []string{
+				`gosender_tenant_sends_total{tenant="acme",result="rejected"} 1`,
+				`gosender_tenant_sends_total{tenant="acme",result="success"} 2`,
+				`gosender_tenant_sends_total{tenant="globex",result="success"} 1`,
+			},
+		},
+		{
+			name:   "default quota",
+			quotas: map[string]int{"*": 1, "globex": 2},
+			sends:  []send{{"acme", http.StatusOK}, {"acme", http.StatusTooManyRequests}, {"globex", http.StatusOK}, {"globex", http.StatusOK}},
+			wantMetrics: []string{
+				`gosender_tenant_sends_total{tenant="acme",result="rejected"} 1`,
+				`gosender_tenant_sends_total{tenant="acme",result="success"} 1`,
+				`gosender_tenant_sends_total{tenant="globex",result="success"} 2`,
+			},
+		},
+		{
+			name:        "failed sends given back",
+			quotas:      map[string]int{"acme": 1},
+			sendStatus:  http.StatusInternalServerError,
+			sends:       []send{{"acme", http.StatusBadGateway}, {"acme", http.StatusBadGateway}},
+			wantMetrics: []string{`gosender_tenant_sends_total{tenant="acme",result="failure"} 2`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newGmailStub(t)
+			stub.sendStatus = tt.sendStatus
+			h := stub.newServer(stub.withTenants("acme", "globex"), func(c *Config) {
+				c.TenantRateLimits, c.TenantDailyQuotas = tt.limits, tt.quotas
+			}).Handler()
+			payload := stub.payload(t, map[string]any{"to": "to@example.com", "subject": "Hello", "messageBody": "Hi"})
+
+			for i, send := range tt.sends {
+				if rec := postPayload(h, "/send", payload, map[string]string{"X-Tenant-ID": send.tenant}); rec.Code != send.wantStatus {
+					t.Fatalf("send %d of %s = %d %s; want %d", i+1, send.tenant, rec.Code, rec.Body, send.wantStatus)
+				}
+			}
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+			var got []string
+			for _, line := range strings.Split(rec.Body.String(), "\n") {
+				if strings.HasPrefix(line, "gosender_tenant_sends_total{") {
+					got = append(got, line)
+				}
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.wantMetrics, "\n") {
+				t.Errorf("tenant metrics:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(tt.wantMetrics, "\n"))
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package gosender
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// parseQuotas parses a comma-separated list of tenant=count entries, such as
+// "acme=500,*=100". The tenant "*" sets the quota of every other tenant.
+func parseQuotas(value string) (map[string]int, error) {
+	quotas := make(map[string]int)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		tenant, countStr, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid quota %q: expected tenant=count", entry)
+		}
+		count, err := strconv.Atoi(strings.TrimSpace(countStr))
+		if err != nil || count <= 0 {
+			return nil, fmt.Errorf("invalid quota %q: count must be a positive integer", entry)
+		}
+
+		quotas[strings.ToLower(strings.TrimSpace(tenant))] = count
+	}
+
+	return quotas, nil
+}
+
+// tenantLimiter enforces the per-tenant rate limits and daily quotas. Unlike
+// the per-domain pacing, sends over a tenant's limits are rejected, as the
+// tenant rather than a recipient domain is to slow down. Rates allow bursts
+// of up to their Count sends; quotas reset at midnight UTC.
+type tenantLimiter struct {
+	mu     sync.Mutex
+	limits map[string]RateLimit
+	quotas map[string]int
+	tat    map[string]time.Time // theoretical arrival time of the next send
+	day    string
+	used   map[string]int
+}
+
+// newTenantLimiter returns a limiter enforcing the given per-tenant limits.
+func newTenantLimiter(limits map[string]RateLimit, quotas map[string]int) *tenantLimiter {
+	return &tenantLimiter{
+		limits: limits,
+		quotas: quotas,
+		tat:    make(map[string]time.Time),
+		used:   make(map[string]int),
+	}
+}
+
+// claim takes a send from the tenant's rate limit and daily quota, failing
+// with 429 Too Many Requests when either is exhausted. It returns a function
+// giving the quota back, for when the send fails.
+func (l *tenantLimiter) claim(tenant string) (release func(), err error) {
+	key := strings.ToLower(tenant)
+	limit, limited := lookup(l.limits, key)
+	quota, hasQuota := lookup(l.quotas, key)
+	if !limited && !hasQuota {
+		return func() {}, nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if day := now.UTC().Format(time.DateOnly); day != l.day {
+		l.day, l.used = day, make(map[string]int)
+	}
+	if hasQuota && l.used[key] >= quota {
+		return nil, withStatus(http.StatusTooManyRequests, fmt.Errorf("tenant %q has used its daily quota of %d sends", tenant, quota))
+	}
+	if limited {
+		// Generic cell rate algorithm: a send is allowed unless it would run
+		// more than a burst of Count sends ahead of the rate.
+		tat := l.tat[key]
+		if tat.Before(now) {
+			tat = now
+		}
+		if ahead := tat.Sub(now); ahead > limit.Per-limit.interval() {
+			return nil, withStatus(http.StatusTooManyRequests, fmt.Errorf("tenant %q exceeded its rate limit of %d sends per %s; retry in %s",
+				tenant, limit.Count, limit.Per, (ahead-limit.Per+limit.interval()).Round(time.Millisecond)))
+		}
+		l.tat[key] = tat.Add(limit.interval())
+	}
+
+	l.used[key]++
+	day := l.day
+	return func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if l.day == day && l.used[key] > 0 {
+			l.used[key]--
+		}
+	}, nil
+}
+
+// lookup returns the entry of m for key, or its "*" entry.
+func lookup[V any](m map[string]V, key string) (V, bool) {
+	if v, ok := m[key]; ok {
+		return v, true
+	}
+	v, ok := m["*"]
+	return v, ok
+}
@@ -0,0 +1,118 @@
+package gosender
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"mime"
+	"strings"
+	"time"
+)
+
+// Lengths of the parts of a Thread-Index, as described in [MS-OXOMSG] for
+// PidTagConversationIndex.
+const (
+	threadIndexHeaderLen = 22
+	threadIndexChildLen  = 5
+)
+
+// fileTimeEpochOffset is the number of 100-nanosecond intervals between the
+// Windows FILETIME epoch, 1601-01-01, and the Unix epoch.
+const fileTimeEpochOffset = 116444736000000000
+
+// fileTime returns t as a Windows FILETIME.
+func fileTime(t time.Time) uint64 {
+	return uint64(t.UnixNano()/100 + fileTimeEpochOffset)
+}
+
+// newThreadIndex returns the Thread-Index of the first message of a
+// conversation: the high six bytes of the current FILETIME followed by a
+// random 16-byte GUID, base64-encoded.
+func newThreadIndex(now time.Time) string {
+	index := make([]byte, 8, threadIndexHeaderLen)
+	binary.BigEndian.PutUint64(index, fileTime(now))
+	index = append(index[:6], make([]byte, 16)...)
+	rand.Read(index[6:])
+
+	return base64.StdEncoding.EncodeToString(index)
+}
+
+// childThreadIndex returns the Thread-Index of a reply to the message with the
+// Thread-Index parent: parent followed by a five-byte child block recording the
+// time elapsed since the conversation started.
+func childThreadIndex(parent string, now time.Time) (string, error) {
+	index, err := base64.StdEncoding.DecodeString(parent)
+	if err != nil || len(index) < threadIndexHeaderLen || (len(index)-threadIndexHeaderLen)%threadIndexChildLen != 0 {
+		return "", errors.New("expected the base64 Thread-Index of the parent message")
+	}
+
+	var start [8]byte
+	copy(start[:6], index)
+	var delta uint64
+	if now := fileTime(now); now > binary.BigEndian.Uint64(start[:]) {
+		delta = now - binary.BigEndian.Uint64(start[:])
+	}
+
+	// Deltas under about 1.7 years keep bits 18 to 48; longer ones set the
+	// high bit and keep bits 23 to 53.
+	var block uint32
+	if delta>>49 == 0 {
+		block = uint32(delta>>18) & 0x7fffffff
+	} else {
+		block = 1<<31 | uint32(delta>>23)&0x7fffffff
+	}
+	child := make([]byte, threadIndexChildLen)
+	binary.BigEndian.PutUint32(child, block)
+	rand.Read(child[4:])
+
+	return base64.StdEncoding.EncodeToString(append(index, child...)), nil
+}
+
+// threadHeaders returns the Thread-Topic and Thread-Index header fields that
+// Outlook threads conversations on, when the payload sets a thread topic or
+// the Thread-Index of its parent. The topic defaults to the subject without
+// its reply and forward prefixes, and the index starts a new conversation when
+// no parent index is given.
+func threadHeaders(p *Payload, now time.Time) ([]headerField, error) {
+	if p.ThreadTopic == "" && p.ThreadIndex == "" {
+		return nil, nil
+	}
+
+	topic := p.ThreadTopic
+	if topic == "" {
+		topic = baseSubject(p.Subject)
+	}
+	index := newThreadIndex(now)
+	if p.ThreadIndex != "" {
+		var err error
+		if index, err = childThreadIndex(p.ThreadIndex, now); err != nil {
+			return nil, fmt.Errorf("invalid threadIndex: %v", err)
+		}
+	}
+
+	var headers []headerField
+	if topic != "" {
+		headers = append(headers, headerField{"Thread-Topic", mime.QEncoding.Encode("UTF-8", topic)})
+	}
+	return append(headers, headerField{"Thread-Index", index}), nil
+}
+
+// baseSubject returns subject with any leading "Re:", "Fw:" and "Fwd:"
+// prefixes removed.
+func baseSubject(subject string) string {
+	for {
+		trimmed := strings.TrimSpace(subject)
+		colon := strings.IndexByte(trimmed, ':')
+		if colon < 0 {
+			return trimmed
+		}
+		switch strings.ToLower(trimmed[:colon]) {
+		case "re", "fw", "fwd":
+			subject = trimmed[colon+1:]
+		default:
+			return trimmed
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package gosender
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"testing"
+	"time"
+)
+
+func TestThreadHeaders(t *testing.T) {
+	// The parent's conversation started at the FILETIME 0x01DC000000000000,
+	// in July 2025, with the GUID 01 02 ... 10.
+	const parent = "AdwAAAAAAQIDBAUGBwgJCgsMDQ4PEA=="
+	fromFileTime := func(ft uint64) time.Time {
+		return time.Unix(0, int64(ft-fileTimeEpochOffset)*100)
+	}
+	hourLater := fromFileTime(0x01DC000000000000 + uint64(time.Hour/100))
+	tests := []struct {
+		name       string
+		payload    Payload
+		now        time.Time
+		wantTopic  string
+		wantPrefix string
+		wantLen    int
+		wantError  bool
+	}{
+		{name: "not threaded", payload: Payload{Subject: "Quarterly plan"}, now: hourLater},
+		{
+			name:       "new conversation",
+			payload:    Payload{Subject: "Hello", ThreadTopic: "Quarterly plan"},
+			now:        hourLater,
+			wantTopic:  "Quarterly plan",
+			wantPrefix: "01dc000861c4",
+			wantLen:    threadIndexHeaderLen,
+		},
+		{
+			name:       "reply",
+			payload:    Payload{Subject: "Re: FW: Quarterly plan", ThreadIndex: parent},
+			now:        hourLater,
+			wantTopic:  "Quarterly plan",
+			wantPrefix: "01dc00000000" + "0102030405060708090a0b0c0d0e0f10" + "00021871",
+			wantLen:    threadIndexHeaderLen + threadIndexChildLen,
+		},
+		{
+			name:       "reply after 1.7 years",
+			payload:    Payload{ThreadTopic: "Quarterly plan", ThreadIndex: parent},
+			now:        fromFileTime(0x01DC000000000000 + uint64(3*365*24*time.Hour/100)),
+			wantTopic:  "Quarterly plan",
+			wantPrefix: "01dc00000000" + "0102030405060708090a0b0c0d0e0f10" + "86b8e8d4",
+			wantLen:    threadIndexHeaderLen + threadIndexChildLen,
+		},
+		{
+			name:       "encoded topic",
+			payload:    Payload{ThreadTopic: "Café"},
+			now:        hourLater,
+			wantTopic:  "=?UTF-8?q?Caf=C3=A9?=",
+			wantPrefix: "01dc000861c4",
+			wantLen:    threadIndexHeaderLen,
+		},
+		{name: "invalid parent", payload: Payload{ThreadIndex: "AAAA"}, now: hourLater, wantError: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers, err := threadHeaders(&tt.payload, tt.now)
+			if tt.wantError {
+				if err == nil {
+					t.Errorf("threadHeaders = %v; want an error", headers)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("threadHeaders: %v", err)
+			}
+			if tt.wantLen == 0 {
+				if len(headers) != 0 {
+					t.Errorf("threadHeaders = %v; want none", headers)
+				}
+				return
+			}
+
+			if len(headers) != 2 || headers[0].Name != "Thread-Topic" || headers[1].Name != "Thread-Index" {
+				t.Fatalf("threadHeaders = %v; want Thread-Topic and Thread-Index", headers)
+			}
+			if headers[0].Value != tt.wantTopic {
+				t.Errorf("Thread-Topic = %q; want %q", headers[0].Value, tt.wantTopic)
+			}
+			index, err := base64.StdEncoding.DecodeString(headers[1].Value)
+			if err != nil {
+				t.Fatalf("Thread-Index %q is not base64: %v", headers[1].Value, err)
+			}
+			prefix, _ := hex.DecodeString(tt.wantPrefix)
+			if len(index) != tt.wantLen || !bytes.HasPrefix(index, prefix) {
+				t.Errorf("Thread-Index = %x; want %d bytes starting with %s", index, tt.wantLen, tt.wantPrefix)
+			}
+		})
+	}
+}
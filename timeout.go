@@ -0,0 +1,71 @@
+package gosender
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// routeNames lists the routes that Config.RouteTimeouts may name.
+var routeNames = []string{"send", "batch", "undo", "trash", "status", "cancel", "quota", "metrics"}
+
+// parseRouteTimeouts parses a comma-separated list of route=duration entries,
+// such as "send=30s,trash=2m".
+func parseRouteTimeouts(value string) (map[string]time.Duration, error) {
+	timeouts := make(map[string]time.Duration)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		route, durationStr, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid route timeout %q: expected route=duration", entry)
+		}
+		route = strings.ToLower(strings.TrimSpace(route))
+		if !containsFold(routeNames, route) {
+			return nil, fmt.Errorf("invalid route timeout %q: unknown route %q, expected one of %s", entry, route, strings.Join(routeNames, ", "))
+		}
+		timeout, err := time.ParseDuration(strings.TrimSpace(durationStr))
+		if err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("invalid route timeout %q: timeout must be a positive duration", entry)
+		}
+
+		timeouts[route] = timeout
+	}
+
+	return timeouts, nil
+}
+
+// withTimeout bounds the requests of the named route to its timeout in
+// Config.RouteTimeouts, answering those that take longer with 503 Service
+// Unavailable. The request's context is canceled at the deadline, so the
+// handler stops its work as well. NDJSON progress streams cannot be buffered
+// as http.TimeoutHandler requires, so they only get the context deadline.
+// Routes without a timeout are left unbounded.
+func (s *Server) withTimeout(route string, next http.Handler) http.Handler {
+	timeout := s.config.RouteTimeouts[route]
+	if timeout <= 0 {
+		return next
+	}
+
+	// The handler's headers replace those set here unless it times out, so
+	// the timeout message goes out as JSON like any other error.
+	message, _ := json.Marshal(errorResponse(http.StatusServiceUnavailable, "request timed out"))
+	bounded := http.TimeoutHandler(next, timeout, string(message)+"\n")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("progress") != "ndjson" {
+			w.Header().Set("Content-Type", "application/json")
+			bounded.ServeHTTP(w, r)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,72 @@
+package gosender
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRouteTimeouts(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    map[string]time.Duration
+		wantErr bool
+	}{
+		{value: "send=30s, Trash=2m", want: map[string]time.Duration{"send": 30 * time.Second, "trash": 2 * time.Minute}},
+		{value: "metrics=1s,", want: map[string]time.Duration{"metrics": time.Second}},
+		{value: "send", wantErr: true},
+		{value: "inbox=30s", wantErr: true},
+		{value: "send=0s", wantErr: true},
+		{value: "send=soon", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := parseRouteTimeouts(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseRouteTimeouts error = %v; want an error: %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseRouteTimeouts = %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRouteTimeouts(t *testing.T) {
+	tests := []struct {
+		name       string
+		timeouts   map[string]time.Duration
+		slow       bool
+		wantStatus int
+	}{
+		{name: "slow send on a short timeout", timeouts: map[string]time.Duration{"send": 50 * time.Millisecond}, slow: true, wantStatus: http.StatusServiceUnavailable},
+		{name: "fast send on a short timeout", timeouts: map[string]time.Duration{"send": time.Second}, wantStatus: http.StatusOK},
+		{name: "slow send under another route's timeout", timeouts: map[string]time.Duration{"trash": 50 * time.Millisecond}, slow: true, wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newGmailStub(t)
+			if tt.slow {
+				release := make(chan struct{})
+				stub.release = release
+				if tt.wantStatus == http.StatusOK {
+					time.AfterFunc(100*time.Millisecond, func() { close(release) })
+				} else {
+					t.Cleanup(func() { close(release) })
+				}
+			}
+			h := stub.newServer(func(c *Config) { c.RouteTimeouts = tt.timeouts }).Handler()
+			payload := stub.payload(t, map[string]any{"to": "to@example.com", "subject": "Hello", "messageBody": "Hi"})
+
+			rec := postPayload(h, "/send", payload, nil)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("send = %d %s; want %d", rec.Code, rec.Body, tt.wantStatus)
+			}
+			if tt.wantStatus == http.StatusServiceUnavailable && !strings.Contains(rec.Body.String(), "timed out") {
+				t.Errorf("body = %q; want the timeout explained", rec.Body)
+			}
+		})
+	}
+}
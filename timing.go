@@ -0,0 +1,68 @@
+package gosender
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// serverTiming records how long the phases of a request took, for the
+// Server-Timing response header. A nil *serverTiming records nothing.
+type serverTiming struct {
+	mu      sync.Mutex
+	metrics []timingMetric
+}
+
+// timingMetric is the duration of a single phase.
+type timingMetric struct {
+	name     string
+	duration time.Duration
+}
+
+// timingKey is the context key under which a request's serverTiming is stored.
+type timingKey struct{}
+
+// contextWithTiming returns a copy of ctx carrying a new serverTiming.
+func contextWithTiming(ctx context.Context) (context.Context, *serverTiming) {
+	timing := &serverTiming{}
+	return context.WithValue(ctx, timingKey{}, timing), timing
+}
+
+// timingFromContext returns the serverTiming of ctx, or nil when there is none.
+func timingFromContext(ctx context.Context) *serverTiming {
+	timing, _ := ctx.Value(timingKey{}).(*serverTiming)
+	return timing
+}
+
+// record records the phase name as having run from start until now.
+func (t *serverTiming) record(name string, start time.Time) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.metrics = append(t.metrics, timingMetric{name, time.Since(start)})
+}
+
+// setHeader sets the Server-Timing header of w to the phases recorded so far,
+// with their durations in milliseconds as in "auth;dur=12.503, send;dur=240.118".
+func (t *serverTiming) setHeader(w http.ResponseWriter) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.metrics) == 0 {
+		return
+	}
+
+	entries := make([]string, len(t.metrics))
+	for i, m := range t.metrics {
+		ms := float64(m.duration) / float64(time.Millisecond)
+		entries[i] = m.name + ";dur=" + strconv.FormatFloat(ms, 'f', 3, 64)
+	}
+	w.Header().Set("Server-Timing", strings.Join(entries, ", "))
+}
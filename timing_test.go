@@ -0,0 +1,54 @@
+package gosender
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestServerTiming(t *testing.T) {
+	metric := regexp.MustCompile(`^([a-z]+);dur=[0-9]+\.[0-9]{3}$`)
+	tests := []struct {
+		name        string
+		fields      map[string]any
+		sendStatus  int
+		wantStatus  int
+		wantMetrics []string
+	}{
+		{name: "send", wantStatus: http.StatusOK, wantMetrics: []string{"auth", "build", "send", "trash"}},
+		{name: "dry run", fields: map[string]any{"dryRun": true}, wantStatus: http.StatusOK, wantMetrics: []string{"auth", "build"}},
+		{name: "failed send", sendStatus: http.StatusBadRequest, wantStatus: http.StatusBadGateway, wantMetrics: []string{"auth", "build", "send"}},
+		{name: "invalid message", fields: map[string]any{"priority": "urgent"}, wantStatus: http.StatusBadRequest, wantMetrics: []string{"auth"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newGmailStub(t)
+			stub.sendStatus = tt.sendStatus
+			stub.setLabel("INBOX", "a")
+			h := stub.newServer().Handler()
+			fields := map[string]any{"to": "to@example.com", "subject": "Hello", "messageBody": "Hi"}
+			for k, v := range tt.fields {
+				fields[k] = v
+			}
+
+			rec := postPayload(h, "/send", stub.payload(t, fields), nil)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("send = %d %s; want %d", rec.Code, rec.Body, tt.wantStatus)
+			}
+			header := rec.Header().Get("Server-Timing")
+			var names []string
+			for _, entry := range strings.Split(header, ", ") {
+				m := metric.FindStringSubmatch(entry)
+				if m == nil {
+					t.Fatalf("Server-Timing = %q; want name;dur=milliseconds entries", header)
+				}
+				names = append(names, m[1])
+			}
+			if fmt.Sprint(names) != fmt.Sprint(tt.wantMetrics) {
+				t.Errorf("Server-Timing = %q; want the metrics %q", header, tt.wantMetrics)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package gosender
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+
+	"golang.org/x/oauth2"
+	"google.golang.org/api/googleapi"
+)
+
+// parseToken returns the OAuth token a payload carries: a token JSON object,
+// such as {"access_token": "...", "refresh_token": "...", "expiry": "..."},
+// either inline or as a base64-encoded string, or a bare access token.
+func parseToken(raw json.RawMessage) *oauth2.Token {
+	var token oauth2.Token
+	if json.Unmarshal(raw, &token) == nil && (token.AccessToken != "" || token.RefreshToken != "") {
+		return &token
+	}
+
+	var s string
+	if json.Unmarshal(raw, &s) != nil {
+		return &oauth2.Token{AccessToken: string(raw)}
+	}
+	if decoded, err := decodeBase64(s); err == nil {
+		if json.Unmarshal(decoded, &token) == nil && (token.AccessToken != "" || token.RefreshToken != "") {
+			return &token
+		}
+	}
+	return &oauth2.Token{AccessToken: s}
+}
+
+// refreshableTokenSource is the token source of a send's client. It refreshes
+// expired tokens like the oauth2 package's token sources, and can also be made
+// to refresh a token that Gmail rejected before it expired.
+type refreshableTokenSource struct {
+	ctx    context.Context
+	config *oauth2.Config
+
+	mu           sync.Mutex
+	source       oauth2.TokenSource
+	refreshToken string
+	refreshed    bool
+}
+
+// newTokenSource returns a refreshableTokenSource starting from token.
+func newTokenSource(ctx context.Context, config *oauth2.Config, token *oauth2.Token) *refreshableTokenSource {
+	return &refreshableTokenSource{
+		ctx:          ctx,
+		config:       config,
+		source:       config.TokenSource(ctx, token),
+		refreshToken: token.RefreshToken,
+	}
+}
+
+// Token returns the current token, refreshing it if it expired.
+func (ts *refreshableTokenSource) Token() (*oauth2.Token, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	token, err := ts.source.Token()
+	if err == nil && token.RefreshToken != "" {
+		ts.refreshToken = token.RefreshToken
+	}
+	return token, err
+}
+
+// refresh discards the current access token, so that the next call to Token
+// obtains a new one with the refresh token. It reports false, changing
+// nothing, when there is no refresh token or the token was already refreshed
+// this way, so that a rejected request is made again at most once.
+func (ts *refreshableTokenSource) refresh() bool {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if ts.refreshToken == "" || ts.refreshed {
+		return false
+	}
+	ts.source = ts.config.TokenSource(ts.ctx, &oauth2.Token{RefreshToken: ts.refreshToken})
+	ts.refreshed = true
+	return true
+}
+
+// wasRefreshed reports whether refresh was called.
+func (ts *refreshableTokenSource) wasRefreshed() bool {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	return ts.refreshed
+}
+
+// refreshRejected makes the client refresh its token when err is Google
+// rejecting the token, reporting whether the request should be made once more
+// with the new token. Nothing is refreshed unless
+// Config.RefreshOnUnauthorized is set.
+func (s *Server) refreshRejected(client *http.Client, err error) bool {
+	var apiErr *googleapi.Error
+	rejected := errors.As(err, &apiErr) && apiErr.Code == http.StatusUnauthorized || errors.Is(err, errTokenRejected)
+	if !s.config.RefreshOnUnauthorized || !rejected {
+		return false
+	}
+	source, ok := tokenSource(client)
+	return ok && source.refresh()
+}
+
+// tokenSource returns the refreshableTokenSource of a client made by getClient.
+func tokenSource(client *http.Client) (*refreshableTokenSource, bool) {
+	transport, ok := client.Transport.(*oauth2.Transport)
+	if !ok {
+		return nil, false
+	}
+	source, ok := transport.Source.(*refreshableTokenSource)
+	return source, ok
+}
@@ -0,0 +1,123 @@
+package gosender
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestIncludeToken(t *testing.T) {
+	tests := []struct {
+		name      string
+		include   bool
+		wantToken bool
+	}{
+		{name: "omitted by default", wantToken: false},
+		{name: "included when enabled", include: true, wantToken: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newGmailStub(t)
+			h := stub.newServer(func(c *Config) { c.IncludeToken = tt.include }).Handler()
+			payload := stub.payload(t, map[string]any{"to": "to@example.com", "subject": "Hello", "messageBody": "Hi"})
+
+			rec := postPayload(h, "/send", payload, nil)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("send = %d %s", rec.Code, rec.Body)
+			}
+			var response map[string]json.RawMessage
+			decodeJSON(t, rec, &response)
+			token, ok := response["token"]
+			if ok != tt.wantToken {
+				t.Fatalf("response %s carries a token: %v; want %v", rec.Body, ok, tt.wantToken)
+			}
+			if !tt.wantToken && strings.Contains(rec.Body.String(), "access-token") {
+				t.Errorf("response %s leaks the access token", rec.Body)
+			}
+			if tt.wantToken && !strings.Contains(string(token), "access-token") {
+				t.Errorf("token = %s; want the access token", token)
+			}
+		})
+	}
+}
+
+func TestRefreshOnUnauthorized(t *testing.T) {
+	tests := []struct {
+		name         string
+		enabled      bool
+		refreshToken string
+		rejected     []string
+		wantStatus   int
+		wantAttempts int
+		wantRefresh  bool
+	}{
+		{
+			name: "refreshed and sent again once", enabled: true, refreshToken: "refresh-token", rejected: []string{"access-token"},
+			wantStatus: http.StatusOK, wantAttempts: 2, wantRefresh: true,
+		},
+		{
+			name: "rejected again after the refresh", enabled: true, refreshToken: "refresh-token", rejected: []string{"access-token", "refreshed-token"},
+			wantStatus: http.StatusUnauthorized, wantAttempts: 2, wantRefresh: true,
+		},
+		{
+			name: "without a refresh token", enabled: true, rejected: []string{"access-token"},
+			wantStatus: http.StatusUnauthorized, wantAttempts: 1,
+		},
+		{
+			name: "disabled", refreshToken: "refresh-token", rejected: []string{"access-token"},
+			wantStatus: http.StatusUnauthorized, wantAttempts: 1,
+		},
+		{
+			name: "accepted token", enabled: true, refreshToken: "refresh-token",
+			wantStatus: http.StatusOK, wantAttempts: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newGmailStub(t)
+			stub.rejected = make(map[string]bool)
+			for _, token := range tt.rejected {
+				stub.rejected[token] = true
+			}
+			h := stub.newServer(func(c *Config) {
+				c.RefreshOnUnauthorized = tt.enabled
+				c.IncludeToken = true
+			}).Handler()
+			payload := stub.payload(t, map[string]any{
+				"to": "to@example.com", "subject": "Hello", "messageBody": "Hi",
+				"token": map[string]any{"access_token": "access-token", "refresh_token": tt.refreshToken, "expiry": "2099-01-01T00:00:00Z"},
+			})
+
+			rec := postPayload(h, "/send", payload, nil)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("send = %d %s; want %d", rec.Code, rec.Body, tt.wantStatus)
+			}
+			stub.mu.Lock()
+			attempts, refreshes := stub.attempts, len(stub.refreshes)
+			stub.mu.Unlock()
+			if attempts != tt.wantAttempts {
+				t.Errorf("sent %d times; want %d", attempts, tt.wantAttempts)
+			}
+			if refreshed := refreshes > 0; refreshed != tt.wantRefresh {
+				t.Errorf("token refreshed %d times; want a refresh: %v", refreshes, tt.wantRefresh)
+			}
+			if tt.wantStatus != http.StatusOK {
+				var response ErrorResponse
+				decodeJSON(t, rec, &response)
+				if response.Code != ErrAuth {
+					t.Errorf("code = %q; want %q", response.Code, ErrAuth)
+				}
+				return
+			}
+			var response SendResponse
+			decodeJSON(t, rec, &response)
+			if response.TokenRefreshed != tt.wantRefresh {
+				t.Errorf("tokenRefreshed = %v; want %v", response.TokenRefreshed, tt.wantRefresh)
+			}
+			if want := map[bool]string{false: "access-token", true: "refreshed-token"}[tt.wantRefresh]; !strings.Contains(response.Token, want) {
+				t.Errorf("token = %s; want %s", response.Token, want)
+			}
+		})
+	}
+}
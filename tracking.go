@@ -0,0 +1,45 @@
+package gosender
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"net/url"
+)
+
+// applyTrackingPixel injects an open-tracking pixel into the HTML body of a
+// payload setting TrackOpens. The pixel loads Config.TrackingPixelURL with a
+// token generated for the message, which is kept for the send response so
+// that opens can be correlated with it.
+func (s *Server) applyTrackingPixel(p *Payload) error {
+	if !p.TrackOpens {
+		return nil
+	}
+	switch {
+	case !p.isStructured():
+		return errors.New("trackOpens is only supported for structured messages")
+	case s.config.TrackingPixelURL == "":
+		return errors.New("trackOpens is not enabled on this server")
+	case p.HTMLBody == "":
+		return errors.New("trackOpens requires an htmlBody")
+	}
+
+	u, err := url.Parse(s.config.TrackingPixelURL)
+	if err != nil {
+		return fmt.Errorf("invalid tracking pixel url: %v", err)
+	}
+	token := newRequestID()
+	query := u.Query()
+	query.Set("token", token)
+	u.RawQuery = query.Encode()
+
+	// Derive the plain-text body before the pixel is added, as for footers.
+	if p.MessageBody == "" {
+		p.MessageBody = htmlToText(p.HTMLBody)
+	}
+	pixel := fmt.Sprintf(`<img src="%s" width="1" height="1" alt="" style="display:none">`, html.EscapeString(u.String()))
+	p.HTMLBody = insertBeforeBodyEnd(p.HTMLBody, pixel)
+	p.trackingToken = token
+
+	return nil
+}
@@ -0,0 +1,71 @@
+package gosender
+
+import (
+	"html"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestTrackingPixel(t *testing.T) {
+	const pixelURL = "https://track.example.com/open?campaign=spring"
+	tests := []struct {
+		name       string
+		pixelURL   string
+		fields     map[string]any
+		wantStatus int
+		wantPixel  bool
+	}{
+		{name: "injected", pixelURL: pixelURL, fields: map[string]any{"trackOpens": true, "htmlBody": "<html><body><p>Hi</p></body></html>"}, wantStatus: http.StatusOK, wantPixel: true},
+		{name: "not requested", pixelURL: pixelURL, fields: map[string]any{"htmlBody": "<p>Hi</p>"}, wantStatus: http.StatusOK},
+		{name: "disabled", fields: map[string]any{"trackOpens": true, "htmlBody": "<p>Hi</p>"}, wantStatus: http.StatusBadRequest},
+		{name: "without html", pixelURL: pixelURL, fields: map[string]any{"trackOpens": true, "messageBody": "Hi"}, wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newGmailStub(t)
+			h := stub.newServer(func(c *Config) { c.TrackingPixelURL = tt.pixelURL }).Handler()
+			fields := map[string]any{"to": "to@example.com", "subject": "Hello"}
+			for k, v := range tt.fields {
+				fields[k] = v
+			}
+			payload := stub.payload(t, fields)
+
+			// Sending twice tells whether every message gets its own token.
+			tokens := make(map[string]bool)
+			for i := 0; i < 2; i++ {
+				rec := postPayload(h, "/send", payload, nil)
+				if rec.Code != tt.wantStatus {
+					t.Fatalf("send = %d %s; want %d", rec.Code, rec.Body, tt.wantStatus)
+				}
+				if tt.wantStatus != http.StatusOK {
+					if sent, _, _ := stub.counts(); sent != 0 {
+						t.Errorf("sent %d messages; want none", sent)
+					}
+					return
+				}
+				var response SendResponse
+				decodeJSON(t, rec, &response)
+
+				bodies := messageBodies(t, stub.sent[i])
+				pixel := html.EscapeString(pixelURL + "&token=" + response.TrackingToken)
+				if !tt.wantPixel {
+					if response.TrackingToken != "" || strings.Contains(bodies["text/html"], "<img") {
+						t.Errorf("tracking token %q and HTML %q; want no tracking", response.TrackingToken, bodies["text/html"])
+					}
+					continue
+				}
+				if response.TrackingToken == "" || tokens[response.TrackingToken] {
+					t.Errorf("tracking token %q; want a new one for every message", response.TrackingToken)
+				}
+				tokens[response.TrackingToken] = true
+				if !strings.Contains(bodies["text/html"], `<img src="`+pixel+`"`) || !strings.HasSuffix(strings.TrimSpace(bodies["text/html"]), "</body></html>") {
+					t.Errorf("HTML body %q; want the pixel loading %q inside the body", bodies["text/html"], pixel)
+				}
+				if strings.Contains(bodies["text/plain"], "track.example.com") {
+					t.Errorf("plain-text body %q; want it free of the pixel", bodies["text/plain"])
+				}
+			}
+		})
+	}
+}
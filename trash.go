@@ -0,0 +1,181 @@
+package gosender
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// defaultTrashLabels are the labels whose existing messages a send trashes
+// when neither the payload nor Config.TrashableLabels names any.
+var defaultTrashLabels = []string{"INBOX", "SPAM"}
+
+// cleanupLabels returns the labels whose existing messages the payload's send
+// trashes: those of TrashLabels, which must all be trashable, or else every
+// trashable label. Labels that are not trashable are rejected with 403
+// Forbidden.
+func (s *Server) cleanupLabels(payload *Payload) ([]string, error) {
+	trashable := s.config.TrashableLabels
+	if len(trashable) == 0 {
+		trashable = defaultTrashLabels
+	}
+	if len(payload.TrashLabels) == 0 {
+		return trashable, nil
+	}
+
+	for _, label := range payload.TrashLabels {
+		if !containsFold(trashable, label) {
+			return nil, withStatus(http.StatusForbidden, fmt.Errorf("label %q may not be trashed; trashable labels are %s", label, strings.Join(trashable, ", ")))
+		}
+	}
+	return payload.TrashLabels, nil
+}
+
+// cleansUp reports whether the payload's send trashes existing messages,
+// which inserted messages, placed in the mailbox rather than sent, and the
+// parts of a split send, cleaned up after by the split itself, do not.
+func (p *Payload) cleansUp() bool {
+	return !p.skipCleanup && p.Mode != modeInsert
+}
+
+// TrashResponse represents the result of a trash-by-query request: the
+// messages trashed or, for a dry run, those that would have been.
+type TrashResponse struct {
+	RequestID string           `json:"requestId"`
+	UndoID    string           `json:"undoId,omitempty"`
+	DryRun    bool             `json:"dryRun"`
+	Count     int              `json:"count"`
+	Messages  []MatchedMessage `json:"messages"`
+}
+
+// MatchedMessage identifies a message matched by a trash query.
+type MatchedMessage struct {
+	ID      string `json:"id"`
+	Subject string `json:"subject,omitempty"`
+}
+
+// handleTrash handles the HTTP request to trash the messages matching the
+// payload's Gmail search query. With DryRun set, the matches are listed with
+// their subjects and nothing is trashed.
+func (s *Server) handleTrash(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	payload, ok := readPayload(w, r)
+	if !ok {
+		return
+	}
+
+	if payload.Query == "" {
+		writeError(w, withStatus(http.StatusBadRequest, errors.New("query not provided")))
+		return
+	}
+
+	ctx := r.Context()
+	ctx, client, service, err := s.newService(ctx, payload)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	var info *tokenInfo
+	if !payload.DryRun {
+		if info, err = s.requireScope(ctx, client, trashScopes); err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+
+	ids, err := matchingMessages(ctx, service, payload.Query, s.config.TrashableLabels)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	requestID := requestIDFromContext(ctx)
+	response := TrashResponse{RequestID: requestID, DryRun: payload.DryRun, Messages: []MatchedMessage{}}
+	if payload.DryRun {
+		for _, id := range ids {
+			message, err := service.Users.Messages.Get(gmailUser(ctx), id).
+				Format("metadata").
+				MetadataHeaders("Subject").
+				Context(ctx).
+				Do()
+			if err != nil {
+				writeError(w, gmailError("get message", err))
+				return
+			}
+			response.Messages = append(response.Messages, MatchedMessage{ID: id, Subject: messageHeader(message, "Subject")})
+		}
+	} else {
+		trashed, err := trashMessages(ctx, service, ids)
+		s.rememberTrashed(serverIDFromContext(ctx), info.account(), trashed)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		for _, id := range trashed {
+			response.Messages = append(response.Messages, MatchedMessage{ID: id})
+		}
+		if s.config.UndoTTL > 0 {
+			response.UndoID = serverIDFromContext(ctx)
+		}
+	}
+	response.Count = len(response.Messages)
+
+	s.writeJSON(w, r, response)
+}
+
+// matchingMessages returns the IDs of all messages matching a Gmail search
+// query, walking every page of the listing. When labels are given, only the
+// messages carrying at least one of them match.
+func matchingMessages(ctx context.Context, service *gmail.Service, query string, labels []string) ([]string, error) {
+	var ids []string
+	seen := make(map[string]bool)
+	list := func(call *gmail.UsersMessagesListCall) error {
+		return call.Q(query).Context(ctx).Pages(ctx, func(page *gmail.ListMessagesResponse) error {
+			for _, message := range page.Messages {
+				if !seen[message.Id] {
+					seen[message.Id] = true
+					ids = append(ids, message.Id)
+				}
+			}
+			return nil
+		})
+	}
+
+	if len(labels) == 0 {
+		if err := list(service.Users.Messages.List(gmailUser(ctx))); err != nil {
+			return nil, gmailError("list messages", err)
+		}
+		return ids, nil
+	}
+	// Listing by several label IDs matches the messages carrying all of
+	// them, so each label is listed on its own.
+	for _, label := range labels {
+		if err := list(service.Users.Messages.List(gmailUser(ctx)).LabelIds(label)); err != nil {
+			return nil, gmailError("list messages", err)
+		}
+	}
+	return ids, nil
+}
+
+// trashMessages moves the given messages to the trash and returns the IDs of
+// those trashed. It stops at the first failure or when ctx is canceled.
+func trashMessages(ctx context.Context, service *gmail.Service, ids []string) ([]string, error) {
+	var trashed []string
+	for _, id := range ids {
+		if err := ctx.Err(); err != nil {
+			return trashed, fmt.Errorf("trash canceled: %v", err)
+		}
+		if _, err := service.Users.Messages.Trash(gmailUser(ctx), id).Context(ctx).Do(); err != nil {
+			return trashed, gmailError("trash message", err)
+		}
+		trashed = append(trashed, id)
+	}
+
+	return trashed, nil
+}
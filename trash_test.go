@@ -0,0 +1,114 @@
+package gosender
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestTrashByQuery(t *testing.T) {
+	tests := []struct {
+		name         string
+		fields       map[string]any
+		wantStatus   int
+		wantMessages []MatchedMessage
+		wantTrashed  int
+	}{
+		{
+			name:         "dry run",
+			fields:       map[string]any{"query": "from:newsletter@example.com", "dryRun": true},
+			wantStatus:   http.StatusOK,
+			wantMessages: []MatchedMessage{{ID: "a", Subject: "Stored a"}, {ID: "b", Subject: "Stored b"}, {ID: "c", Subject: "Stored c"}},
+		},
+		{
+			name:         "trash",
+			fields:       map[string]any{"query": "from:newsletter@example.com"},
+			wantStatus:   http.StatusOK,
+			wantMessages: []MatchedMessage{{ID: "a"}, {ID: "b"}, {ID: "c"}},
+			wantTrashed:  3,
+		},
+		{name: "no query", fields: map[string]any{"dryRun": true}, wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newGmailStub(t)
+			stub.pageSize = 2
+			stub.setLabel("INBOX", "a", "b", "c")
+			h := stub.newServer(func(c *Config) { c.TrashableLabels = []string{"INBOX"} }).Handler()
+
+			rec := postPayload(h, "/trash", stub.payload(t, tt.fields), nil)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("trash = %d %s; want %d", rec.Code, rec.Body, tt.wantStatus)
+			}
+			if _, _, trashed := stub.counts(); trashed != tt.wantTrashed {
+				t.Errorf("trashed %d messages; want %d", trashed, tt.wantTrashed)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var response TrashResponse
+			decodeJSON(t, rec, &response)
+			if response.DryRun != (tt.wantTrashed == 0) || response.Count != len(tt.wantMessages) || len(response.Messages) != len(tt.wantMessages) {
+				t.Fatalf("response = %+v; want %d messages", response, len(tt.wantMessages))
+			}
+			for i, want := range tt.wantMessages {
+				if response.Messages[i] != want {
+					t.Errorf("message %d = %+v; want %+v", i, response.Messages[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestTrashableLabels(t *testing.T) {
+	tests := []struct {
+		name        string
+		trashable   []string
+		fields      map[string]any
+		wantStatus  int
+		wantTrashed []string
+	}{
+		{name: "INBOX and SPAM by default", wantStatus: http.StatusOK, wantTrashed: []string{"inbox-1", "inbox-2", "spam-1"}},
+		{name: "every trashable label", trashable: []string{"INBOX"}, wantStatus: http.StatusOK, wantTrashed: []string{"inbox-1", "inbox-2"}},
+		{name: "allowed label", trashable: []string{"INBOX", "SPAM"}, fields: map[string]any{"trashLabels": []string{"SPAM"}}, wantStatus: http.StatusOK, wantTrashed: []string{"spam-1"}},
+		{name: "SENT not allowed", trashable: []string{"INBOX", "SPAM"}, fields: map[string]any{"trashLabels": []string{"SENT"}}, wantStatus: http.StatusForbidden},
+		{name: "custom label not allowed", fields: map[string]any{"trashLabels": []string{"INBOX", "Label_1"}}, wantStatus: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newGmailStub(t)
+			stub.setLabel("INBOX", "inbox-1", "inbox-2")
+			stub.setLabel("SPAM", "spam-1")
+			stub.setLabel("SENT", "sent-1")
+			stub.setLabel("Label_1", "custom-1")
+			h := stub.newServer(func(c *Config) { c.TrashableLabels = tt.trashable }).Handler()
+			fields := map[string]any{"to": "to@example.com", "subject": "Hello", "messageBody": "Hi"}
+			for k, v := range tt.fields {
+				fields[k] = v
+			}
+
+			rec := postPayload(h, "/send", stub.payload(t, fields), nil)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("send = %d %s; want %d", rec.Code, rec.Body, tt.wantStatus)
+			}
+			stub.mu.Lock()
+			trashed := strings.Join(stub.trashed, ",")
+			stub.mu.Unlock()
+			if trashed != strings.Join(tt.wantTrashed, ",") {
+				t.Errorf("trashed %s; want %v", trashed, tt.wantTrashed)
+			}
+			if tt.wantStatus != http.StatusForbidden {
+				return
+			}
+			var response ErrorResponse
+			decodeJSON(t, rec, &response)
+			if response.Code != ErrAuth || !strings.Contains(response.Error, "may not be trashed") {
+				t.Errorf("error = %+v; want the label reported as not trashable", response)
+			}
+			if sent, _, _ := stub.counts(); sent != 0 {
+				t.Errorf("sent %d messages; want none", sent)
+			}
+		})
+	}
+}
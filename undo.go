@@ -0,0 +1,116 @@
+package gosender
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// errNothingToUndo reports an undo ID with nothing recorded for the account.
+var errNothingToUndo = errors.New("nothing to undo for this request")
+
+// UndoResponse represents a successful undo response structure.
+type UndoResponse struct {
+	UndoID   string `json:"undoId"`
+	Restored int    `json:"restored"`
+}
+
+// undoKey returns the store key of the IDs trashed under undoID on behalf of
+// account. Undo IDs hold no colon, so keys of different accounts cannot collide.
+func undoKey(undoID, account string) string {
+	return "undo:" + undoID + ":" + account
+}
+
+// rememberTrashed adds the IDs trashed under undoID on behalf of account to
+// those already recorded, so they can all be restored within Config.UndoTTL
+// of the latest addition by the same account. Nothing is recorded when undo
+// is disabled.
+func (s *Server) rememberTrashed(undoID, account string, ids []string) {
+	if s.config.UndoTTL <= 0 || len(ids) == 0 {
+		return
+	}
+
+	key := undoKey(undoID, account)
+	s.undoMu.Lock()
+	defer s.undoMu.Unlock()
+
+	var recorded []string
+	if value, ok := s.store.Get(key); ok {
+		if err := json.Unmarshal(value, &recorded); err != nil {
+			s.logger.Error("discarding undo record", "undo_id", undoID, "error", err)
+			recorded = nil
+		}
+	}
+	value, err := json.Marshal(append(recorded, ids...))
+	if err != nil {
+		return
+	}
+
+	s.store.Set(key, value, s.config.UndoTTL)
+}
+
+// handleUndo handles the HTTP request to restore the messages trashed by an
+// earlier send or trash request, identified by the undo ID of its response in
+// the path /undo/{undoId}. Only the messages trashed on behalf of the account
+// of the request's token are restored; for any other account, there is
+// nothing to undo.
+func (s *Server) handleUndo(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	payload, ok := readPayload(w, r)
+	if !ok {
+		return
+	}
+
+	undoID := strings.TrimPrefix(r.URL.Path, "/undo/")
+	if undoID == "" {
+		writeError(w, withStatus(http.StatusNotFound, errNothingToUndo))
+		return
+	}
+
+	ctx := r.Context()
+	ctx, client, service, err := s.newService(ctx, payload)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	info, err := s.requireScope(ctx, client, trashScopes)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// Taking the record out before restoring keeps IDs trashed meanwhile, by
+	// cleanup running after the response, for a later undo.
+	key := undoKey(undoID, info.account())
+	s.undoMu.Lock()
+	value, ok := s.store.Get(key)
+	s.store.Delete(key)
+	s.undoMu.Unlock()
+	if !ok {
+		writeError(w, withStatus(http.StatusNotFound, errNothingToUndo))
+		return
+	}
+
+	var ids []string
+	if err := json.Unmarshal(value, &ids); err != nil {
+		writeError(w, withStatus(http.StatusInternalServerError, err))
+		return
+	}
+
+	for i, id := range ids {
+		if _, err := service.Users.Messages.Untrash(gmailUser(ctx), id).Context(ctx).Do(); err != nil {
+			// Those not restored yet can still be undone.
+			s.rememberTrashed(undoID, info.account(), ids[i:])
+			writeError(w, gmailError("untrash message", err))
+			return
+		}
+	}
+
+	s.writeJSON(w, r, UndoResponse{
+		UndoID:   undoID,
+		Restored: len(ids),
+	})
+}
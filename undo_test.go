@@ -0,0 +1,104 @@
+package gosender
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+// withUndo enables undo on the server under test.
+func withUndo(c *Config) { c.UndoTTL = time.Minute }
+
+func TestUndoIsKeyedByServerGeneratedID(t *testing.T) {
+	stub := newGmailStub(t)
+	h := stub.newServer(withUndo).Handler()
+	payload := stub.payload(t, map[string]any{"to": "to@example.com", "subject": "Hello", "messageBody": "Hi"})
+	header := map[string]string{"X-Request-ID": "client-id"}
+
+	var responses []SendResponse
+	for _, inbox := range []string{"a", "b"} {
+		stub.setLabel("INBOX", inbox)
+		rec := postPayload(h, "/send", payload, header)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("send = %d %s", rec.Code, rec.Body)
+		}
+		var response SendResponse
+		decodeJSON(t, rec, &response)
+		if response.RequestID != "client-id" {
+			t.Errorf("requestId = %q; want the inbound client-id", response.RequestID)
+		}
+		if response.UndoID == "" || response.UndoID == "client-id" {
+			t.Fatalf("undoId = %q; want one generated by the server", response.UndoID)
+		}
+		responses = append(responses, response)
+	}
+	if responses[0].UndoID == responses[1].UndoID {
+		t.Fatalf("both sends got undoId %q", responses[0].UndoID)
+	}
+
+	tests := []struct {
+		name       string
+		undoID     string
+		wantStatus int
+		wantUndone []string
+	}{
+		{name: "inbound request ID", undoID: "client-id", wantStatus: http.StatusNotFound},
+		{name: "first send", undoID: responses[0].UndoID, wantStatus: http.StatusOK, wantUndone: []string{"a"}},
+		{name: "second send", undoID: responses[1].UndoID, wantStatus: http.StatusOK, wantUndone: []string{"a", "b"}},
+		{name: "already undone", undoID: responses[0].UndoID, wantStatus: http.StatusNotFound, wantUndone: []string{"a", "b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := postPayload(h, "/undo/"+tt.undoID, payload, nil)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("undo = %d %s; want %d", rec.Code, rec.Body, tt.wantStatus)
+			}
+			stub.mu.Lock()
+			untrashed := append([]string(nil), stub.untrashed...)
+			stub.mu.Unlock()
+			if len(untrashed) != len(tt.wantUndone) {
+				t.Fatalf("untrashed %v; want %v", untrashed, tt.wantUndone)
+			}
+			for i := range untrashed {
+				if untrashed[i] != tt.wantUndone[i] {
+					t.Fatalf("untrashed %v; want %v", untrashed, tt.wantUndone)
+				}
+			}
+		})
+	}
+}
+
+func TestUndoChecksAccount(t *testing.T) {
+	tests := []struct {
+		name       string
+		email      string
+		wantStatus int
+		wantUndone int
+	}{
+		{name: "same account", email: "owner@example.com", wantStatus: http.StatusOK, wantUndone: 3},
+		{name: "other account", email: "intruder@example.com", wantStatus: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newGmailStub(t)
+			s := stub.newServer(withUndo)
+			// Records made under one undo ID by the same account add up.
+			s.rememberTrashed("undo-id", "owner@example.com", []string{"a", "b"})
+			s.rememberTrashed("undo-id", "owner@example.com", []string{"c"})
+			s.rememberTrashed("undo-id", "someone@example.com", []string{"d"})
+
+			stub.email = tt.email
+			payload := stub.payload(t, map[string]any{"token": map[string]any{"access_token": tt.email, "expiry": "2099-01-01T00:00:00Z"}})
+			rec := postPayload(s.Handler(), "/undo/undo-id", payload, nil)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("undo = %d %s; want %d", rec.Code, rec.Body, tt.wantStatus)
+			}
+			if len(stub.untrashed) != tt.wantUndone {
+				t.Fatalf("untrashed %v; want %d messages", stub.untrashed, tt.wantUndone)
+			}
+			if _, ok := s.store.Get(undoKey("undo-id", "someone@example.com")); !ok {
+				t.Errorf("the record of another account was dropped")
+			}
+		})
+	}
+}
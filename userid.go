@@ -0,0 +1,100 @@
+package gosender
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// userIDKey is the context key under which the Gmail user ID of the request
+// is stored.
+type userIDKey struct{}
+
+// contextWithUserID returns a copy of ctx carrying the Gmail user ID id.
+func contextWithUserID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, userIDKey{}, id)
+}
+
+// gmailUser returns the Gmail user ID that API calls made with ctx act on:
+// "me", the authenticated account, unless a delegated mailbox was requested.
+func gmailUser(ctx context.Context) string {
+	if id, ok := ctx.Value(userIDKey{}).(string); ok {
+		return id
+	}
+	return "me"
+}
+
+// checkUserID checks the payload's userId against the authenticated account
+// and returns ctx carrying the user ID to act on. With Config.AllowDelegation
+// the userId is passed on to Gmail, which decides whether the credentials may
+// act for that mailbox; otherwise it must name the authenticated account,
+// which is checked through Users.GetProfile, and anything else fails with 403.
+func (s *Server) checkUserID(ctx context.Context, service *gmail.Service, payload *Payload) (context.Context, error) {
+	if payload.UserID == "" || payload.UserID == "me" {
+		return ctx, nil
+	}
+	if s.config.AllowDelegation {
+		return contextWithUserID(ctx, payload.UserID), nil
+	}
+
+	profile, err := s.profile(ctx, service, payload)
+	if err != nil {
+		return nil, gmailError("verify userId", err)
+	}
+	if !strings.EqualFold(profile.EmailAddress, payload.UserID) {
+		return nil, withStatus(http.StatusForbidden, fmt.Errorf("userId %q does not match the authenticated account %q and delegation is not enabled", payload.UserID, profile.EmailAddress))
+	}
+
+	return ctx, nil
+}
+
+// applyProfileFrom sets the From of a structured payload without one to the
+// address of the authenticated account, with Config.FromProfile, so that the
+// header is not left for Gmail to fill in. Delegated sends are left alone, as
+// the authenticated account is not the mailbox sending.
+func (s *Server) applyProfileFrom(ctx context.Context, service *gmail.Service, payload *Payload) error {
+	if !s.config.FromProfile || payload.From != "" || !payload.isStructured() || gmailUser(ctx) != "me" {
+		return nil
+	}
+
+	profile, err := s.profile(ctx, service, payload)
+	if err != nil {
+		return gmailError("get profile", err)
+	}
+	payload.From = profile.EmailAddress
+
+	return nil
+}
+
+// profile returns the profile of the authenticated account. Profiles are
+// cached in the store for Config.ProfileCacheTTL, keyed by a hash of the
+// payload's token so that the token itself is never stored.
+func (s *Server) profile(ctx context.Context, service *gmail.Service, payload *Payload) (*gmail.Profile, error) {
+	sum := sha256.Sum256(payload.Token)
+	key := "profile:" + hex.EncodeToString(sum[:])
+	cache := s.config.ProfileCacheTTL > 0 && len(payload.Token) > 0
+	if value, ok := s.store.Get(key); cache && ok {
+		var profile gmail.Profile
+		if err := json.Unmarshal(value, &profile); err == nil {
+			return &profile, nil
+		}
+	}
+
+	profile, err := service.Users.GetProfile("me").Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	if cache {
+		if value, err := json.Marshal(profile); err == nil {
+			s.store.Set(key, value, s.config.ProfileCacheTTL)
+		}
+	}
+
+	return profile, nil
+}
@@ -0,0 +1,105 @@
+package gosender
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUserID(t *testing.T) {
+	tests := []struct {
+		name        string
+		userID      string
+		delegation  bool
+		wantStatus  int
+		wantMailbox string
+	}{
+		{name: "me", userID: "me", wantStatus: http.StatusOK, wantMailbox: "me"},
+		{name: "authenticated account", userID: "Owner@Example.com", wantStatus: http.StatusOK, wantMailbox: "me"},
+		{name: "another account", userID: "other@example.com", wantStatus: http.StatusForbidden, wantMailbox: "me"},
+		{name: "delegated", userID: "other@example.com", delegation: true, wantStatus: http.StatusOK, wantMailbox: "other@example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newGmailStub(t)
+			h := stub.newServer(func(c *Config) { c.AllowDelegation = tt.delegation }).Handler()
+			payload := stub.payload(t, map[string]any{"userId": tt.userID, "to": "to@example.com", "subject": "Hello", "messageBody": "Hi"})
+
+			rec := postPayload(h, "/send", payload, nil)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("send = %d %s; want %d", rec.Code, rec.Body, tt.wantStatus)
+			}
+			stub.mu.Lock()
+			mailboxes := append([]string(nil), stub.mailboxes...)
+			stub.mu.Unlock()
+			for _, mailbox := range mailboxes {
+				if mailbox != tt.wantMailbox {
+					t.Errorf("requested mailboxes %q; want only %q", mailboxes, tt.wantMailbox)
+					break
+				}
+			}
+
+			sent, _, _ := stub.counts()
+			if tt.wantStatus != http.StatusOK {
+				var response ErrorResponse
+				decodeJSON(t, rec, &response)
+				if !strings.Contains(response.Error, `userId "other@example.com" does not match the authenticated account "owner@example.com"`) || response.Code != ErrAuth {
+					t.Errorf("error = %+v; want the mismatch explained", response)
+				}
+				if sent != 0 {
+					t.Errorf("sent %d messages; want none", sent)
+				}
+				return
+			}
+			if sent != 1 {
+				t.Errorf("sent %d messages; want 1", sent)
+			}
+		})
+	}
+}
+
+func TestFromProfile(t *testing.T) {
+	tests := []struct {
+		name             string
+		fromProfile      bool
+		cacheTTL         time.Duration
+		fields           map[string]any
+		delegation       bool
+		wantFrom         string
+		wantProfileCalls int
+	}{
+		{name: "from the cached profile", fromProfile: true, cacheTTL: time.Minute, wantFrom: "<owner@example.com>", wantProfileCalls: 1},
+		{name: "from the uncached profile", fromProfile: true, wantFrom: "<owner@example.com>", wantProfileCalls: 2},
+		{name: "explicit from", fromProfile: true, cacheTTL: time.Minute, fields: map[string]any{"from": "Ann <ann@example.com>"}, wantFrom: `"Ann" <ann@example.com>`},
+		{name: "delegated", fromProfile: true, cacheTTL: time.Minute, fields: map[string]any{"userId": "other@example.com"}, delegation: true},
+		{name: "disabled", cacheTTL: time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newGmailStub(t)
+			h := stub.newServer(func(c *Config) {
+				c.FromProfile, c.ProfileCacheTTL, c.AllowDelegation = tt.fromProfile, tt.cacheTTL, tt.delegation
+			}).Handler()
+			fields := map[string]any{"to": "to@example.com", "subject": "Hello", "messageBody": "Hi"}
+			for k, v := range tt.fields {
+				fields[k] = v
+			}
+			payload := stub.payload(t, fields)
+
+			for i := 0; i < 2; i++ {
+				if rec := postPayload(h, "/send", payload, nil); rec.Code != http.StatusOK {
+					t.Fatalf("send %d = %d %s; want %d", i+1, rec.Code, rec.Body, http.StatusOK)
+				}
+				if from := parseRawMessage([]byte(stub.sent[i])).value("From"); from != tt.wantFrom {
+					t.Errorf("send %d From = %q; want %q", i+1, from, tt.wantFrom)
+				}
+			}
+			stub.mu.Lock()
+			defer stub.mu.Unlock()
+			if stub.profileCalls != tt.wantProfileCalls {
+				t.Errorf("got the profile %d times; want %d", stub.profileCalls, tt.wantProfileCalls)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package gosender
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"golang.org/x/oauth2"
+	"google.golang.org/api/gmail/v1"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+)
+
+var (
+	// ErrTokenExpired is returned by ValidateToken when Gmail rejects the
+	// access token, as it does once the token expired.
+	ErrTokenExpired = errors.New("gosender: token expired or invalid")
+
+	// ErrTokenRevoked is returned by ValidateToken when the token can no
+	// longer be refreshed, as once the user revoked the grant.
+	ErrTokenRevoked = errors.New("gosender: token revoked")
+)
+
+// ValidateToken checks, without sending anything, that token can be used
+// with the OAuth client credentials creds, so that clients can pre-flight
+// their authentication. The token is refreshed if needed and tried against
+// Users.GetProfile. Rejected tokens are reported as ErrTokenExpired or
+// ErrTokenRevoked, both of which also match ErrAuth.
+func ValidateToken(ctx context.Context, creds, token json.RawMessage) error {
+	client, err := getClient(ctx, &Payload{Token: token}, StaticCredentials(creds), []string{gmail.MailGoogleComScope})
+	if err != nil {
+		return withStatus(http.StatusBadRequest, err)
+	}
+
+	service, err := gmail.NewService(ctx, option.WithHTTPClient(client))
+	if err != nil {
+		return fmt.Errorf("failed to create gmail service: %v", err)
+	}
+
+	_, err = service.Users.GetProfile("me").Context(ctx).Do()
+	var apiErr *googleapi.Error
+	var refreshErr *oauth2.RetrieveError
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &refreshErr) && refreshErr.ErrorCode == "invalid_grant":
+		return withStatus(http.StatusUnauthorized, fmt.Errorf("%w: %v", ErrTokenRevoked, err))
+	case errors.As(err, &apiErr) && apiErr.Code == http.StatusUnauthorized:
+		return withStatus(http.StatusUnauthorized, fmt.Errorf("%w: %v", ErrTokenExpired, err))
+	}
+	return gmailError("validate token", err)
+}
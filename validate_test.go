@@ -0,0 +1,83 @@
+package gosender
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+// redirectTransport sends every request to target instead, keeping its path.
+type redirectTransport struct {
+	target *url.URL
+}
+
+func (rt redirectTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.URL.Scheme, r.URL.Host = rt.target.Scheme, rt.target.Host
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+func TestValidateToken(t *testing.T) {
+	unexpired := map[string]any{"access_token": "access-token", "expiry": "2099-01-01T00:00:00Z"}
+	expired := map[string]any{"access_token": "expired-token", "refresh_token": "refresh-token", "expiry": "2000-01-01T00:00:00Z"}
+	tests := []struct {
+		name          string
+		token         map[string]any
+		credentials   json.RawMessage
+		revoked       bool
+		profileStatus int
+		wantErr       error
+		wantCode      ErrorCode
+		wantRefresh   bool
+	}{
+		{name: "valid", token: unexpired},
+		{name: "refreshed", token: expired, wantRefresh: true},
+		{name: "expired", token: unexpired, profileStatus: http.StatusUnauthorized, wantErr: ErrTokenExpired, wantCode: ErrAuth},
+		{name: "revoked", token: expired, revoked: true, wantErr: ErrTokenRevoked, wantCode: ErrAuth, wantRefresh: true},
+		{name: "invalid credentials", token: unexpired, credentials: json.RawMessage(`{}`), wantCode: ErrBadPayload},
+		{name: "gmail failing", token: unexpired, profileStatus: http.StatusInternalServerError, wantCode: ErrGmail},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newGmailStub(t)
+			stub.revoked, stub.profileStatus = tt.revoked, tt.profileStatus
+			target, _ := url.Parse(stub.server.URL)
+			ctx := context.WithValue(context.Background(), oauth2.HTTPClient, &http.Client{Transport: redirectTransport{target}})
+			credentials := tt.credentials
+			if credentials == nil {
+				credentials = stub.credentials()
+			}
+			token, _ := json.Marshal(tt.token)
+
+			err := ValidateToken(ctx, credentials, token)
+			switch {
+			case tt.wantCode == "" && err != nil:
+				t.Fatalf("ValidateToken: %v", err)
+			case tt.wantCode != "" && !errors.Is(err, tt.wantCode):
+				t.Errorf("ValidateToken error = %v; want one matching %s", err, tt.wantCode)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("ValidateToken error = %v; want %v", err, tt.wantErr)
+			}
+			for _, other := range []error{ErrTokenExpired, ErrTokenRevoked} {
+				if other != tt.wantErr && errors.Is(err, other) {
+					t.Errorf("ValidateToken error = %v; want it not to match %v", err, other)
+				}
+			}
+
+			stub.mu.Lock()
+			defer stub.mu.Unlock()
+			if refreshed := len(stub.refreshes) > 0; refreshed != tt.wantRefresh {
+				t.Errorf("token refreshed: %v; want %v", refreshed, tt.wantRefresh)
+			}
+			if len(stub.sent) != 0 {
+				t.Errorf("sent %d messages; want none", len(stub.sent))
+			}
+		})
+	}
+}
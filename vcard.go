@@ -0,0 +1,67 @@
+package gosender
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"strings"
+)
+
+// vCardPart renders vCard content (RFC 6350) as a text/vcard attachment
+// named contact.vcf, which mail clients offer to add to the address book.
+func vCardPart(vcf string) (mimePart, error) {
+	// vCard requires CRLF line endings, which the quoted-printable encoding
+	// will keep as hard line breaks.
+	vcf = strings.ReplaceAll(strings.ReplaceAll(vcf, "\r\n", "\n"), "\n", "\r\n")
+	if err := validateVCard(vcf); err != nil {
+		return mimePart{}, fmt.Errorf("invalid vcard: %v", err)
+	}
+
+	part, err := textPart("text/vcard", vcf)
+	if err != nil {
+		return mimePart{}, err
+	}
+	part.headers = append(part.headers, headerField{"Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": "contact.vcf"})})
+	return part, nil
+}
+
+// validateVCard checks the basic structure of vCard content with CRLF line
+// endings: one or more BEGIN:VCARD ... END:VCARD objects holding NAME:value
+// properties, with nothing in between.
+func validateVCard(vcf string) error {
+	// Unfold continuation lines before looking at the properties.
+	vcf = strings.NewReplacer("\r\n ", "", "\r\n\t", "").Replace(vcf)
+	lines := strings.Split(strings.TrimRight(vcf, "\r\n"), "\r\n")
+	if !strings.EqualFold(lines[0], "BEGIN:VCARD") || !strings.EqualFold(lines[len(lines)-1], "END:VCARD") {
+		return errors.New("expected a BEGIN:VCARD ... END:VCARD object")
+	}
+
+	open := false
+	for i, line := range lines {
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return fmt.Errorf("line %d: expected a NAME:value property", i+1)
+		}
+		// Properties may be grouped, as in "item1.EMAIL".
+		name, _, _ = strings.Cut(name, ";")
+		if _, after, ok := strings.Cut(name, "."); ok {
+			name = after
+		}
+		switch {
+		case strings.EqualFold(name, "BEGIN"):
+			if open || !strings.EqualFold(value, "VCARD") {
+				return fmt.Errorf("line %d: unexpected BEGIN:%s", i+1, value)
+			}
+			open = true
+		case strings.EqualFold(name, "END"):
+			if !open || !strings.EqualFold(value, "VCARD") {
+				return fmt.Errorf("line %d: unexpected END:%s", i+1, value)
+			}
+			open = false
+		case !open:
+			return fmt.Errorf("line %d: content outside BEGIN:VCARD ... END:VCARD", i+1)
+		}
+	}
+
+	return nil
+}
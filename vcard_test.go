@@ -0,0 +1,54 @@
+package gosender
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVCard(t *testing.T) {
+	const vcard = "BEGIN:VCARD\nVERSION:4.0\nFN:Jane Doe\nitem1.EMAIL;TYPE=work:jane@example.com\nNOTE:Call\n before noon\nEND:VCARD\n"
+	tests := []struct {
+		name        string
+		vcard       string
+		attachments []Attachment
+		wantError   string
+	}{
+		{name: "contact card", vcard: vcard},
+		{name: "alongside an attachment", vcard: vcard, attachments: []Attachment{{Filename: "notes.pdf", ContentType: "application/pdf", Data: "bm90ZXM="}}},
+		{name: "several cards", vcard: vcard + vcard},
+		{name: "not a vcard", vcard: "FN:Jane Doe\n", wantError: "expected a BEGIN:VCARD ... END:VCARD object"},
+		{name: "unterminated", vcard: strings.Replace(vcard, "END:VCARD", "END:VCALENDAR", 1), wantError: "expected a BEGIN:VCARD ... END:VCARD object"},
+		{name: "not a property", vcard: strings.Replace(vcard, "NOTE:Call", "NOTE Call", 1), wantError: "expected a NAME:value property"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload := &Payload{To: AddressList{"to@example.com"}, Subject: "Contact", MessageBody: "Here it is", VCard: tt.vcard, Attachments: tt.attachments}
+			if err := loadAttachments(context.Background(), &Config{}, payload.Attachments); err != nil {
+				t.Fatalf("loadAttachments: %v", err)
+			}
+			raw, err := buildMessage(payload, time.Now())
+			if tt.wantError != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantError) {
+					t.Errorf("buildMessage error = %v; want %q", err, tt.wantError)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("buildMessage: %v", err)
+			}
+
+			bodies := messageBodies(t, string(raw))
+			if want := strings.ReplaceAll(tt.vcard, "\n", "\r\n"); bodies["text/vcard"] != want {
+				t.Errorf("text/vcard part = %q; want %q", bodies["text/vcard"], want)
+			}
+			if bodies["text/plain"] != "Here it is" {
+				t.Errorf("text/plain part = %q; want the message body", bodies["text/plain"])
+			}
+			if !strings.Contains(string(raw), `Content-Disposition: attachment; filename=contact.vcf`) {
+				t.Errorf("message %q; want the card attached as contact.vcf", raw)
+			}
+		})
+	}
+}